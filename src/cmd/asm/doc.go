@@ -39,6 +39,9 @@ Flags:
 		Generate code that can be linked into a shared library.
 	-trimpath prefix
 		Remove prefix from recorded source file paths.
+		The argument may also be a ;-separated list of rewrites,
+		each of the form "prefix" or "prefix=>replacement",
+		to replace a matching prefix instead of removing it.
 	-gensymabis
 		Write symbol ABI information to output file. Don't assemble.
 Input language:
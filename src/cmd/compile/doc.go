@@ -113,6 +113,9 @@ Flags:
 		Write an execution trace to file.
 	-trimpath prefix
 		Remove prefix from recorded source file paths.
+		The argument may also be a ;-separated list of rewrites,
+		each of the form "prefix" or "prefix=>replacement",
+		to replace a matching prefix instead of removing it.
 
 Flags related to debugging information:
 
@@ -0,0 +1,117 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cmd/internal/objabi"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: go tool covdata <mode> -i=<dir1,dir2,...> [flags]\n")
+	fmt.Fprintf(os.Stderr, "modes: percent, textfmt, merge\n")
+	fmt.Fprintf(os.Stderr, "run 'go doc cmd/covdata' for details\n")
+	os.Exit(2)
+}
+
+var (
+	inputFlag  = flag.String("i", "", "comma-separated list of input directories")
+	outputFlag = flag.String("o", "", "output file (textfmt) or directory (merge)")
+)
+
+func main() {
+	log.SetPrefix("covdata: ")
+	log.SetFlags(0)
+	objabi.AddVersionFlag()
+	flag.Usage = usage
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+	mode := os.Args[1]
+	flag.CommandLine.Parse(os.Args[2:])
+	if flag.NArg() != 0 {
+		usage()
+	}
+	if *inputFlag == "" {
+		log.Fatalf("%s: missing -i flag", mode)
+	}
+
+	var run func(p *profile) error
+	switch mode {
+	case "percent":
+		run = percent
+	case "textfmt":
+		if *outputFlag == "" {
+			log.Fatalf("textfmt: missing -o flag")
+		}
+		run = textfmt
+	case "merge":
+		if *outputFlag == "" {
+			log.Fatalf("merge: missing -o flag")
+		}
+		run = merge
+	default:
+		log.Printf("unknown mode %q", mode)
+		usage()
+	}
+
+	p := newProfile()
+	if err := p.readDirs(strings.Split(*inputFlag, ",")); err != nil {
+		log.Fatal(err)
+	}
+	if err := run(p); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// percent prints the coverage of each package in p.
+func percent(p *profile) error {
+	for _, pp := range p.percents() {
+		pct := 0.0
+		if pp.total > 0 {
+			pct = 100 * float64(pp.covered) / float64(pp.total)
+		}
+		fmt.Printf("\t%s\tcoverage: %.1f%% of statements\n", pp.pkg, pct)
+	}
+	return nil
+}
+
+// textfmt writes p to the -o file in the profile format.
+func textfmt(p *profile) error {
+	f, err := os.Create(*outputFlag)
+	if err != nil {
+		return err
+	}
+	if err := p.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// merge writes p to a new counter file in the -o directory.
+func merge(p *profile) error {
+	if err := os.MkdirAll(*outputFlag, 0777); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("covcounters.%d.%d", os.Getpid(), time.Now().UnixNano())
+	f, err := os.Create(filepath.Join(*outputFlag, name))
+	if err != nil {
+		return err
+	}
+	if err := p.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Covdata reports on and manipulates the coverage data written by programs
+built with 'go build -cover'.
+
+Such a program writes the values of its coverage counters to a new file in
+the directory named by $GOCOVERDIR each time it runs. Covdata combines the
+files found in one or more such directories, as written by many runs of
+one or more programs, and operates on the result.
+
+Usage:
+	go tool covdata <mode> -i=<dir1,dir2,...> [flags]
+
+The modes are:
+
+	percent
+		report the percentage of statements covered in each package.
+	textfmt
+		convert the data to the profile format written by
+		'go test -coverprofile', for use with 'go tool cover'.
+		The -o flag names the output file.
+	merge
+		merge the data into a single counter file in the directory
+		named by the -o flag, which may then be used as input
+		to later invocations.
+
+When merging, the counts for the same block are added, or for programs
+built with -covermode=set, combined so that a block is recorded as covered
+if it was covered in any run. All inputs must use the same coverage mode.
+
+For example, to report on the runs of an instrumented server made by a
+suite of integration tests:
+
+	go build -cover -o server ./cmd/server
+	mkdir covdata
+	GOCOVERDIR=covdata ./run-integration-tests.sh
+	go tool covdata percent -i=covdata
+	go tool covdata textfmt -i=covdata -o=cover.out
+	go tool cover -html=cover.out
+*/
+package main
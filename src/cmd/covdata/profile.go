@@ -0,0 +1,214 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A block is a basic block of a source file, as recorded
+// in one line of a counter file:
+//	name.go:line0.col0,line1.col1 numStmt count
+type block struct {
+	file    string
+	line0   int
+	col0    int
+	line1   int
+	col1    int
+	numStmt int
+	count   int
+}
+
+// A profile is the merged coverage data from a set of counter files.
+type profile struct {
+	mode   string
+	blocks map[block]int // count by block, ignoring the count field
+}
+
+func newProfile() *profile {
+	return &profile{blocks: make(map[block]int)}
+}
+
+// readDirs adds the data in the counter files in each of dirs to p.
+func (p *profile) readDirs(dirs []string) error {
+	for _, dir := range dirs {
+		names, err := filepath.Glob(filepath.Join(dir, "covcounters.*"))
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no counter files found in %s", dir)
+		}
+		for _, name := range names {
+			if err := p.readFile(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readFile adds the data in the named counter file to p.
+func (p *profile) readFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.read(name, f)
+}
+
+// read adds the data in counter file r, named name, to p.
+func (p *profile) read(name string, r io.Reader) error {
+	s := bufio.NewScanner(r)
+	lineno := 0
+	for s.Scan() {
+		lineno++
+		line := s.Text()
+		if lineno == 1 {
+			mode := strings.TrimPrefix(line, "mode: ")
+			if mode == line || mode == "" {
+				return fmt.Errorf("%s:%d: missing mode line", name, lineno)
+			}
+			if p.mode != "" && p.mode != mode {
+				return fmt.Errorf("%s: coverage mode %s does not match mode %s of other inputs", name, mode, p.mode)
+			}
+			p.mode = mode
+			continue
+		}
+		b, err := parseBlock(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineno, err)
+		}
+		count := b.count
+		b.count = 0
+		if p.mode == "set" {
+			if count > 0 {
+				p.blocks[b] = 1
+			} else if _, ok := p.blocks[b]; !ok {
+				p.blocks[b] = 0
+			}
+		} else {
+			p.blocks[b] += count
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if lineno == 0 {
+		return fmt.Errorf("%s: empty counter file", name)
+	}
+	return nil
+}
+
+// parseBlock parses a line of a counter file.
+func parseBlock(line string) (block, error) {
+	var b block
+	bad := func() (block, error) {
+		return block{}, fmt.Errorf("malformed line %q", line)
+	}
+	// The file name may itself contain colons,
+	// as in absolute Windows paths: use the last one.
+	i := strings.LastIndex(line, ":")
+	if i < 0 {
+		return bad()
+	}
+	b.file = line[:i]
+	f := strings.Fields(line[i+1:])
+	if len(f) != 3 {
+		return bad()
+	}
+	n, err := fmt.Sscanf(f[0], "%d.%d,%d.%d", &b.line0, &b.col0, &b.line1, &b.col1)
+	if n != 4 || err != nil {
+		return bad()
+	}
+	if b.numStmt, err = strconv.Atoi(f[1]); err != nil {
+		return bad()
+	}
+	if b.count, err = strconv.Atoi(f[2]); err != nil {
+		return bad()
+	}
+	return b, nil
+}
+
+// sortedBlocks returns the blocks of p, with their counts,
+// sorted by file name and position.
+func (p *profile) sortedBlocks() []block {
+	list := make([]block, 0, len(p.blocks))
+	for b, count := range p.blocks {
+		b.count = count
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		bi, bj := list[i], list[j]
+		if bi.file != bj.file {
+			return bi.file < bj.file
+		}
+		if bi.line0 != bj.line0 {
+			return bi.line0 < bj.line0
+		}
+		if bi.col0 != bj.col0 {
+			return bi.col0 < bj.col0
+		}
+		if bi.line1 != bj.line1 {
+			return bi.line1 < bj.line1
+		}
+		return bi.col1 < bj.col1
+	})
+	return list
+}
+
+// write writes p to w in the profile format.
+func (p *profile) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %s\n", p.mode)
+	for _, b := range p.sortedBlocks() {
+		fmt.Fprintf(bw, "%s:%d.%d,%d.%d %d %d\n", b.file, b.line0, b.col0, b.line1, b.col1, b.numStmt, b.count)
+	}
+	return bw.Flush()
+}
+
+// A pkgPercent is the coverage of the statements in one package.
+type pkgPercent struct {
+	pkg     string
+	covered int
+	total   int
+}
+
+// percents returns the coverage of each package in p,
+// sorted by package path.
+func (p *profile) percents() []pkgPercent {
+	byPkg := make(map[string]*pkgPercent)
+	var list []*pkgPercent
+	for b, count := range p.blocks {
+		pkg := path.Dir(filepath.ToSlash(b.file))
+		pp := byPkg[pkg]
+		if pp == nil {
+			pp = &pkgPercent{pkg: pkg}
+			byPkg[pkg] = pp
+			list = append(list, pp)
+		}
+		pp.total += b.numStmt
+		if count > 0 {
+			pp.covered += b.numStmt
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].pkg < list[j].pkg
+	})
+	percents := make([]pkgPercent, len(list))
+	for i, pp := range list {
+		percents[i] = *pp
+	}
+	return percents
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	for _, tt := range []struct {
+		mode   string
+		inputs []string
+		want   string
+	}{
+		{
+			mode: "set",
+			inputs: []string{
+				"example.com/m/a.go:3.10,5.2 2 1\nexample.com/m/a.go:7.4,9.1 1 0\n",
+				"example.com/m/a.go:3.10,5.2 2 0\nexample.com/m/a.go:7.4,9.1 1 1\nexample.com/m/b/b.go:1.1,2.2 1 0\n",
+			},
+			want: "example.com/m/a.go:3.10,5.2 2 1\nexample.com/m/a.go:7.4,9.1 1 1\nexample.com/m/b/b.go:1.1,2.2 1 0\n",
+		},
+		{
+			mode: "count",
+			inputs: []string{
+				"example.com/m/a.go:7.4,9.1 1 2\nexample.com/m/a.go:3.10,5.2 2 3\n",
+				"example.com/m/a.go:3.10,5.2 2 4\n",
+			},
+			want: "example.com/m/a.go:3.10,5.2 2 7\nexample.com/m/a.go:7.4,9.1 1 2\n",
+		},
+	} {
+		p := newProfile()
+		for i, in := range tt.inputs {
+			if err := p.read("input", strings.NewReader("mode: "+tt.mode+"\n"+in)); err != nil {
+				t.Fatalf("%s: reading input %d: %v", tt.mode, i, err)
+			}
+		}
+		var buf bytes.Buffer
+		if err := p.write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		want := "mode: " + tt.mode + "\n" + tt.want
+		if buf.String() != want {
+			t.Errorf("%s: merged profile:\n%s\nwant:\n%s", tt.mode, buf.String(), want)
+		}
+	}
+}
+
+func TestMergeModeMismatch(t *testing.T) {
+	p := newProfile()
+	if err := p.read("a", strings.NewReader("mode: set\n")); err != nil {
+		t.Fatal(err)
+	}
+	err := p.read("b", strings.NewReader("mode: count\n"))
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("reading mismatched modes: err = %v, want mismatch error", err)
+	}
+}
+
+func TestPercents(t *testing.T) {
+	p := newProfile()
+	in := "mode: set\n" +
+		"example.com/m/a.go:3.10,5.2 2 1\n" +
+		"example.com/m/a.go:7.4,9.1 1 0\n" +
+		"example.com/m/b/b.go:1.1,2.2 4 0\n"
+	if err := p.read("input", strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	want := []pkgPercent{
+		{pkg: "example.com/m", covered: 2, total: 3},
+		{pkg: "example.com/m/b", covered: 0, total: 4},
+	}
+	if got := p.percents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("percents() = %v, want %v", got, want)
+	}
+}
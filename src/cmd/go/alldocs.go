@@ -95,6 +95,25 @@
 //
 // The -i flag installs the packages that are dependencies of the target.
 //
+// The build, install, and run commands also accept coverage flags:
+//
+// 	-cover
+// 		enable code coverage instrumentation. A program built with
+// 		-cover writes the values of its coverage counters to a new
+// 		file in the directory named by the GOCOVERDIR environment
+// 		variable each time it exits, by returning from main.main or
+// 		by calling os.Exit. Use 'go tool covdata' to merge the files
+// 		written by many runs and report on them.
+// 	-covermode set,count,atomic
+// 		set the mode for coverage analysis, as for 'go test'.
+// 		The default is "set" unless -race is enabled,
+// 		in which case it is "atomic". Sets -cover.
+// 	-coverpkg pattern1,pattern2,pattern3
+// 		instrument the packages matching the patterns, which can be
+// 		any packages the program depends on. The default is to
+// 		instrument the packages in the main module or, in GOPATH
+// 		mode, the packages named on the command line. Sets -cover.
+//
 // The build flags are shared by the build, clean, get, install, list, run,
 // and test commands:
 //
@@ -158,6 +177,12 @@
 // 		a program to use to invoke toolchain programs like vet and asm.
 // 		For example, instead of running asm, the go command will run
 // 		'cmd args /path/to/asm <arguments for asm>'.
+// 	-trimpath
+// 		remove all file system paths from the resulting executable.
+// 		Instead of absolute file system paths, the recorded file names
+// 		will begin with either "go" (for the standard library),
+// 		or a module path@version (when using modules),
+// 		or a plain import path (when using GOPATH).
 //
 // The -asmflags, -gccgoflags, -gcflags, and -ldflags flags accept a
 // space-separated list of arguments to pass to an underlying tool
@@ -606,6 +631,16 @@
 //
 // The -i flag installs the dependencies of the named packages as well.
 //
+// If the arguments have version suffixes (like @latest or @v1.0.0), go install
+// builds packages in module-aware mode, ignoring the go.mod file in the current
+// directory or any parent directory, if there is one. This is useful for
+// installing executables without affecting the dependencies of the main module.
+// All arguments must refer to packages in the same module at the same version,
+// must be package paths or patterns (not relative or absolute paths), and must
+// name main packages. No module is considered the main module, so replace and
+// exclude directives in the named module's go.mod file are not applied.
+// Module-aware mode cannot be disabled for path@version arguments.
+//
 // For more about the build flags, see 'go help build'.
 // For more about specifying packages, see 'go help packages'.
 //
@@ -790,17 +825,18 @@
 // applied to a Go struct, but now a Module struct:
 //
 //     type Module struct {
-//         Path     string       // module path
-//         Version  string       // module version
-//         Versions []string     // available module versions (with -versions)
-//         Replace  *Module      // replaced by this module
-//         Time     *time.Time   // time version was created
-//         Update   *Module      // available update, if any (with -u)
-//         Main     bool         // is this the main module?
-//         Indirect bool         // is this module only an indirect dependency of main module?
-//         Dir      string       // directory holding files for this module, if any
-//         GoMod    string       // path to go.mod file for this module, if any
-//         Error    *ModuleError // error loading module
+//         Path      string       // module path
+//         Version   string       // module version
+//         Versions  []string     // available module versions (with -versions)
+//         Replace   *Module      // replaced by this module
+//         Time      *time.Time   // time version was created
+//         Update    *Module      // available update, if any (with -u)
+//         Retracted []string     // retraction rationale, if retracted (with -u)
+//         Main      bool         // is this the main module?
+//         Indirect  bool         // is this module only an indirect dependency of main module?
+//         Dir       string       // directory holding files for this module, if any
+//         GoMod     string       // path to go.mod file for this module, if any
+//         Error     *ModuleError // error loading module
 //     }
 //
 //     type ModuleError struct {
@@ -837,6 +873,13 @@
 //     golang.org/x/text v0.3.0 [v0.4.0] => /tmp/text
 //     rsc.io/pdf v0.1.1 [v0.1.2]
 //
+// The -u flag also reports whether the current version of each module has
+// been retracted by its author with a retract directive (see 'go help go.mod'),
+// setting the Module's Retracted field to the rationale for the retraction.
+// The Module's String method indicates a retracted version by adding
+// "(retracted)" after the version. Retracted versions are never reported
+// as available upgrades.
+//
 // (For tools, 'go list -m -u -json all' may be more convenient to parse.)
 //
 // The -versions flag causes list to set the Module's Versions field
@@ -890,6 +933,7 @@
 // 	edit        edit go.mod from tools or scripts
 // 	graph       print module requirement graph
 // 	init        initialize new module in current directory
+// 	mirror      copy modules to a local module proxy directory
 // 	tidy        add missing and remove unused modules
 // 	vendor      make vendored copy of dependencies
 // 	verify      verify dependencies have expected content
@@ -971,9 +1015,14 @@
 // should be a local module root directory, not a module path.
 // Note that -replace overrides any existing replacements for old[@v].
 //
+// The -retract=version and -dropretract=version flags add and drop a
+// retraction on the given version. The version may be a single version
+// like "v1.2.3" or a closed interval like "[v1.1.0,v1.1.9]". Note that
+// -retract=version is a no-op if that retraction already exists.
+//
 // The -require, -droprequire, -exclude, -dropexclude, -replace,
-// and -dropreplace editing flags may be repeated, and the changes
-// are applied in the order given.
+// -dropreplace, -retract, and -dropretract editing flags may be repeated,
+// and the changes are applied in the order given.
 //
 // The -go=version flag sets the expected Go language version.
 //
@@ -994,6 +1043,7 @@
 // 		Require []Require
 // 		Exclude []Module
 // 		Replace []Replace
+// 		Retract []Retract
 // 	}
 //
 // 	type Require struct {
@@ -1007,6 +1057,12 @@
 // 		New Module
 // 	}
 //
+// 	type Retract struct {
+// 		Low       string
+// 		High      string
+// 		Rationale string
+// 	}
+//
 // Note that this only describes the go.mod file itself, not other modules
 // referred to indirectly. For the full set of modules available to a build,
 // use 'go list -m -json all'.
@@ -1042,6 +1098,41 @@
 // To override this guess, supply the module path as an argument.
 //
 //
+// Copy modules to a local module proxy directory
+//
+// Usage:
+//
+// 	go mod mirror [-v] dir [modules]
+//
+// Mirror copies modules into the directory dir, laid out as a module proxy,
+// so that the go command can later use them without network access
+// by setting GOPROXY=file:///path/to/dir.
+//
+// The modules can be module patterns selecting dependencies of the main
+// module or module queries of the form path@version, as for 'go mod download'.
+// With no module arguments, mirror copies everything the go command needs
+// to build the main module: the go.mod file of every module version in the
+// module graph, and the source zip file of every module in the build list.
+//
+// For each module version, mirror writes the .info, .mod and (when needed)
+// .zip files in the layout described in 'go help goproxy', and adds the
+// version to the module's version list. The modules are downloaded to the
+// module cache first, if they are not already there. The go.mod files and,
+// once copied to the mirror, the zip files are checked against the
+// checksums recorded in go.sum, and mirror reports any mismatch, or any
+// zip file without a checksum in go.sum, and exits with a non-zero status.
+// The checksums of zip files that mirror downloads itself do not count:
+// they must already be in go.sum, as 'go mod tidy' records them. Outside
+// a module, where there is no go.sum, mirror only warns that the zip
+// files are not verified. Pseudo-versions are copied but, as in any
+// module proxy, not added to the version lists.
+//
+// Modules replaced by directories are skipped. For modules replaced by
+// other module versions, the replacement is copied instead.
+//
+// The -v flag causes mirror to print the module versions as it copies them.
+//
+//
 // Add missing and remove unused modules
 //
 // Usage:
@@ -1293,9 +1384,27 @@
 //
 // Usage:
 //
-// 	go version
+// 	go version [-m] [-v] [file ...]
+//
+// Version prints the build information for Go executables.
 //
-// Version prints the Go version, as reported by runtime.Version.
+// Go version reports the Go version used to build each of the named
+// executable files.
+//
+// If no files are named on the command line, go version prints its own
+// version information.
+//
+// If a directory is named, go version walks that directory, recursively,
+// looking for recognized Go binaries and reporting their versions.
+// By default, go version does not report unrecognized files found
+// during a directory scan. The -v flag causes it to report unrecognized files.
+//
+// The -m flag causes go version to print each executable's embedded
+// module version information, when available. In the output, the module
+// information consists of multiple lines following the version line, each
+// indented by a leading tab character.
+//
+// See also: go doc runtime/debug.BuildInfo.
 //
 //
 // Report likely mistakes in packages
@@ -1625,16 +1734,44 @@
 // 	require new/thing/v2 v2.3.4
 // 	exclude old/thing v1.2.3
 // 	replace bad/thing v1.4.5 => good/thing v1.4.5
+// 	retract [v1.9.0, v1.9.5]
 //
 // The verbs are
 // 	module, to define the module path;
 // 	go, to set the expected language version;
 // 	require, to require a particular module at a given version or later;
-// 	exclude, to exclude a particular module version from use; and
-// 	replace, to replace a module version with a different module version.
+// 	exclude, to exclude a particular module version from use;
+// 	replace, to replace a module version with a different module version; and
+// 	retract, to indicate a previously released version of the module
+// 	should not be used.
 // Exclude and replace apply only in the main module's go.mod and are ignored
 // in dependencies.  See https://research.swtch.com/vgo-mvs for details.
 //
+// A retract directive names either a single version (retract v1.0.0)
+// or a closed interval of versions (retract [v1.0.0, v1.1.0]) of the
+// module defined by the go.mod file. Retractions are read from the go.mod
+// file of the latest version of the module, so an author retracts a
+// version by publishing a new version whose go.mod lists it; the new version
+// may retract itself too. The comment before or at the end of a retract
+// directive is the rationale reported to users of the retracted versions.
+// Retracted versions are not selected by queries like @latest or by
+// 'go get -u', and 'go get' prints a warning when the resulting build list
+// contains a retracted version. Use 'go list -m -u' to list retracted
+// versions in the build list.
+//
+// If the go directive in the main module's go.mod specifies go 1.13 or
+// higher, the go.mod file lists every module that provides a package
+// imported (directly or indirectly) by the main module's packages and tests,
+// marking those not imported directly with an "// indirect" comment.
+// The go command then builds packages using only the modules listed in
+// go.mod, without loading the go.mod files of dependencies that do not
+// provide packages to the build. When the full module graph is needed,
+// as for 'go list -m all' or 'go get', the requirements of a dependency
+// whose own go.mod specifies go 1.13 or higher are included, but their
+// requirements in turn are not: such a dependency lists everything its
+// own packages need. After raising the go version, run 'go mod tidy'
+// to record the needed indirect dependencies.
+//
 // The leading verb can be factored out of adjacent lines to create a block,
 // like in Go imports:
 //
@@ -1907,6 +2044,10 @@
 // https://example.com/proxy would let other users access those
 // cached module versions with GOPROXY=https://example.com/proxy.
 //
+// To copy just the modules needed to build a particular main module,
+// verified against its go.sum file, into a directory that can be used
+// with GOPROXY=file:///path/to/dir, see 'go help mod mirror'.
+//
 //
 // Import path syntax
 //
@@ -2336,7 +2477,9 @@
 //
 // Module versions disallowed by exclude statements in the
 // main module's go.mod are considered unavailable and cannot
-// be returned by queries.
+// be returned by queries. Similarly, versions retracted by the
+// module author (see 'go help go.mod') are not returned by queries
+// other than a specific version or revision, like "v1.2.3".
 //
 // For example, these commands are all valid:
 //
@@ -2482,7 +2625,9 @@
 // release version, such as v0.4.5 or v1.2.3. If there are no tagged release
 // versions, get chooses the latest tagged prerelease version, such as
 // v0.0.1-pre1. If there are no tagged versions at all, get chooses the latest
-// known commit.
+// known commit. Versions retracted by the module author (see 'go help go.mod')
+// are never chosen by default, and get prints a warning when the resulting
+// build list contains a retracted version.
 //
 // This default version selection can be overridden by adding an @version
 // suffix to the package argument, as in 'go get golang.org/x/text@v0.3.0'.
@@ -2751,6 +2896,13 @@
 // 	    the Go tree can run a sanity check but not spend time running
 // 	    exhaustive tests.
 //
+// 	-shuffle off,on,N
+// 	    Randomize the execution order of tests and benchmarks.
+// 	    It is off by default. If -shuffle is set to on, then it will seed
+// 	    the randomizer using the system clock. If -shuffle is set to an
+// 	    integer N, then N will be used as the seed value. In both cases,
+// 	    the seed will be reported for reproducibility.
+//
 // 	-timeout d
 // 	    If a test binary runs longer than duration d, panic.
 // 	    If d is 0, the timeout is disabled.
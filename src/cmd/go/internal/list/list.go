@@ -202,17 +202,18 @@ When listing modules, the -f flag still specifies a format template
 applied to a Go struct, but now a Module struct:
 
     type Module struct {
-        Path     string       // module path
-        Version  string       // module version
-        Versions []string     // available module versions (with -versions)
-        Replace  *Module      // replaced by this module
-        Time     *time.Time   // time version was created
-        Update   *Module      // available update, if any (with -u)
-        Main     bool         // is this the main module?
-        Indirect bool         // is this module only an indirect dependency of main module?
-        Dir      string       // directory holding files for this module, if any
-        GoMod    string       // path to go.mod file for this module, if any
-        Error    *ModuleError // error loading module
+        Path      string       // module path
+        Version   string       // module version
+        Versions  []string     // available module versions (with -versions)
+        Replace   *Module      // replaced by this module
+        Time      *time.Time   // time version was created
+        Update    *Module      // available update, if any (with -u)
+        Retracted []string     // retraction rationale, if retracted (with -u)
+        Main      bool         // is this the main module?
+        Indirect  bool         // is this module only an indirect dependency of main module?
+        Dir       string       // directory holding files for this module, if any
+        GoMod     string       // path to go.mod file for this module, if any
+        Error     *ModuleError // error loading module
     }
 
     type ModuleError struct {
@@ -249,6 +250,13 @@ For example, 'go list -m -u all' might print:
     golang.org/x/text v0.3.0 [v0.4.0] => /tmp/text
     rsc.io/pdf v0.1.1 [v0.1.2]
 
+The -u flag also reports whether the current version of each module has
+been retracted by its author with a retract directive (see 'go help go.mod'),
+setting the Module's Retracted field to the rationale for the retraction.
+The Module's String method indicates a retracted version by adding
+"(retracted)" after the version. Retracted versions are never reported
+as available upgrades.
+
 (For tools, 'go list -m -u -json all' may be more convenient to parse.)
 
 The -versions flag causes list to set the Module's Versions field
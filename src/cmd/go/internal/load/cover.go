@@ -0,0 +1,166 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cmd/go/internal/base"
+	"cmd/go/internal/cfg"
+	"cmd/go/internal/str"
+)
+
+// DeclareCoverVars attaches the required cover variables names
+// to the files, to be used when annotating the files.
+func DeclareCoverVars(p *Package, files ...string) map[string]*CoverVar {
+	coverVars := make(map[string]*CoverVar)
+	coverIndex := 0
+	// We create the cover counters as new top-level variables in the package.
+	// We need to avoid collisions with user variables (GoCover_0 is unlikely but still)
+	// and more importantly with dot imports of other covered packages,
+	// so we append 12 hex digits from the SHA-256 of the import path.
+	// The point is only to avoid accidents, not to defeat users determined to
+	// break things.
+	sum := sha256.Sum256([]byte(p.ImportPath))
+	h := fmt.Sprintf("%x", sum[:6])
+	for _, file := range files {
+		if base.IsTestFile(file) {
+			continue
+		}
+		// For a package that is "local" (imported via ./ import or command line, outside GOPATH),
+		// we record the full path to the file name.
+		// Otherwise we record the import path, then a forward slash, then the file name.
+		// This makes profiles within GOPATH file system-independent.
+		// These names appear in the cmd/cover HTML interface.
+		var longFile string
+		if p.Internal.Local {
+			longFile = filepath.Join(p.Dir, file)
+		} else {
+			longFile = path.Join(p.ImportPath, file)
+		}
+		coverVars[file] = &CoverVar{
+			File: longFile,
+			Var:  fmt.Sprintf("GoCover_%d_%x", coverIndex, h),
+		}
+		coverIndex++
+	}
+	return coverVars
+}
+
+// EnsureImport ensures that package p imports the named package.
+func EnsureImport(p *Package, pkg string) {
+	for _, d := range p.Internal.Imports {
+		if d.ImportPath == pkg {
+			return
+		}
+	}
+
+	p1 := LoadPackage(pkg, &ImportStack{})
+	if p1.Error != nil {
+		base.Fatalf("load %s: %v", pkg, p1.Error)
+	}
+
+	p.Internal.Imports = append(p.Internal.Imports, p1)
+}
+
+// PrepareForCoverageBuild marks the packages to instrument for coverage
+// when building pkgs with -cover: the packages matching the -coverpkg
+// patterns or, by default, those in the main module (in GOPATH mode,
+// those named on the command line). Each instrumented package registers
+// its counters with package runtime/coverage, which writes them out
+// when the program exits.
+func PrepareForCoverageBuild(pkgs []*Package) {
+	var match []func(*Package) bool
+	for _, pattern := range cfg.BuildCoverPkg {
+		match = append(match, MatchPackage(pattern, base.Cwd))
+	}
+	matched := make([]bool, len(match))
+	selected := func(p *Package) bool {
+		if cfg.BuildCoverPkg == nil {
+			if cfg.ModulesEnabled {
+				return p.Module != nil && p.Module.Main
+			}
+			return p.Internal.CmdlinePkg || p.Internal.CmdlineFiles
+		}
+		haveMatch := false
+		for i := range match {
+			if match[i](p) {
+				matched[i] = true
+				haveMatch = true
+			}
+		}
+		return haveMatch
+	}
+
+	// The packages that write out the coverage data
+	// cannot themselves be instrumented.
+	rt := LoadPackage("runtime/coverage", &ImportStack{})
+	if rt.Error != nil {
+		base.Fatalf("load runtime/coverage: %v", rt.Error)
+	}
+	exclude := map[string]bool{"runtime/coverage": true, "unsafe": true}
+	for _, dep := range rt.Deps {
+		exclude[dep] = true
+	}
+
+	for _, p := range PackageList(pkgs) {
+		if !selected(p) || p.Standard && exclude[p.ImportPath] {
+			continue
+		}
+
+		// If using the race detector, silently ignore
+		// attempts to run coverage on the runtime
+		// packages. It will cause the race detector
+		// to be invoked before it has been initialized.
+		if cfg.BuildRace && p.Standard && (p.ImportPath == "runtime" || strings.HasPrefix(p.ImportPath, "runtime/internal")) {
+			continue
+		}
+
+		p.Internal.CoverMode = cfg.BuildCoverMode
+		p.Internal.CoverVars = DeclareCoverVars(p, str.StringList(p.GoFiles, p.CgoFiles)...)
+		EnsureImport(p, "runtime/coverage")
+		// sync/atomic import is inserted by the cover tool. See #18486
+		if cfg.BuildCoverMode == "atomic" {
+			EnsureImport(p, "sync/atomic")
+		}
+	}
+
+	// Warn about -coverpkg arguments that are not actually used.
+	for i := range match {
+		if !matched[i] {
+			fmt.Fprintf(os.Stderr, "warning: no packages being built depend on matches for pattern %s\n", cfg.BuildCoverPkg[i])
+		}
+	}
+}
+
+// CoverRegisterProg returns the source of a file to add to package p,
+// built with 'go build -cover', that registers the counters declared
+// by the coverage tool with package runtime/coverage.
+func CoverRegisterProg(p *Package) []byte {
+	var files []string
+	for file := range p.Internal.CoverVars {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "package %s\n\n", p.Name)
+	fmt.Fprintf(&buf, "import _cover %q\n\n", "runtime/coverage")
+	fmt.Fprintf(&buf, "func init() {\n")
+	for _, file := range files {
+		cv := p.Internal.CoverVars[file]
+		fmt.Fprintf(&buf, "\t_cover.RegisterFile(%q, %q, %s.Count[:], %s.Pos[:], %s.NumStmt[:])\n",
+			p.Internal.CoverMode, cv.File, cv.Var, cv.Var, cv.Var)
+	}
+	fmt.Fprintf(&buf, "}\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,17 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import "testing"
+
+func TestEnsureImportPresent(t *testing.T) {
+	atomic := &Package{PackagePublic: PackagePublic{ImportPath: "sync/atomic", Name: "atomic"}}
+	p := new(Package)
+	p.Internal.Imports = []*Package{atomic}
+	EnsureImport(p, "sync/atomic")
+	if len(p.Internal.Imports) != 1 || p.Internal.Imports[0] != atomic {
+		t.Errorf("Internal.Imports after EnsureImport of an imported package = %v; want [sync/atomic]", p.Internal.Imports)
+	}
+}
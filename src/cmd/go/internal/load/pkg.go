@@ -37,7 +37,7 @@ var (
 	ModPackageModuleInfo func(path string) *modinfo.ModulePublic             // return module info for Package struct
 	ModImportPaths       func(args []string) []*search.Match                 // expand import paths
 	ModPackageBuildInfo  func(main string, deps []string) string             // return module info to embed in binary
-	ModInfoProg          func(info string) []byte                            // wrap module info in .go code for binary
+	ModInfoProg          func(info string, isgccgo bool) []byte              // wrap module info in .go code for binary
 	ModImportFromFiles   func([]string)                                      // update go.mod to add modules for imports in these files
 	ModDirImportPath     func(string) string                                 // return effective import path for directory
 )
@@ -467,7 +467,7 @@ func LoadImport(path, srcDir string, parent *Package, stk *ImportStack, importPo
 	if strings.Contains(path, "@") {
 		var text string
 		if cfg.ModulesEnabled {
-			text = "can only use path@version syntax with 'go get'"
+			text = "can only use path@version syntax with 'go get' and 'go install'"
 		} else {
 			text = "cannot use path@version syntax in GOPATH mode"
 		}
@@ -20,6 +20,7 @@ import (
 	"cmd/go/internal/modfile"
 	"cmd/go/internal/modload"
 	"cmd/go/internal/module"
+	"cmd/go/internal/semver"
 )
 
 var cmdEdit = &base.Command{
@@ -60,9 +61,14 @@ with the old module path. If the @v in new@v is omitted, the new path
 should be a local module root directory, not a module path.
 Note that -replace overrides any existing replacements for old[@v].
 
+The -retract=version and -dropretract=version flags add and drop a
+retraction on the given version. The version may be a single version
+like "v1.2.3" or a closed interval like "[v1.1.0,v1.1.9]". Note that
+-retract=version is a no-op if that retraction already exists.
+
 The -require, -droprequire, -exclude, -dropexclude, -replace,
-and -dropreplace editing flags may be repeated, and the changes
-are applied in the order given.
+-dropreplace, -retract, and -dropretract editing flags may be repeated,
+and the changes are applied in the order given.
 
 The -go=version flag sets the expected Go language version.
 
@@ -83,6 +89,7 @@ writing it back to go.mod. The JSON output corresponds to these Go types:
 		Require []Require
 		Exclude []Module
 		Replace []Replace
+		Retract []Retract
 	}
 
 	type Require struct {
@@ -96,6 +103,12 @@ writing it back to go.mod. The JSON output corresponds to these Go types:
 		New Module
 	}
 
+	type Retract struct {
+		Low       string
+		High      string
+		Rationale string
+	}
+
 Note that this only describes the go.mod file itself, not other modules
 referred to indirectly. For the full set of modules available to a build,
 use 'go list -m -json all'.
@@ -129,6 +142,8 @@ func init() {
 	cmdEdit.Flag.Var(flagFunc(flagDropReplace), "dropreplace", "")
 	cmdEdit.Flag.Var(flagFunc(flagReplace), "replace", "")
 	cmdEdit.Flag.Var(flagFunc(flagDropExclude), "dropexclude", "")
+	cmdEdit.Flag.Var(flagFunc(flagRetract), "retract", "")
+	cmdEdit.Flag.Var(flagFunc(flagDropRetract), "dropretract", "")
 
 	base.AddBuildFlagsNX(&cmdEdit.Flag)
 }
@@ -362,6 +377,60 @@ func flagDropReplace(arg string) {
 	})
 }
 
+// parseVersionInterval parses a single version like "v1.2.3" or a closed
+// interval like "[v1.2.3,v1.3.0]" for the -retract and -dropretract flags.
+func parseVersionInterval(flag, arg string) modfile.VersionInterval {
+	if !strings.HasPrefix(arg, "[") {
+		if !semver.IsValid(arg) || module.CanonicalVersion(arg) != arg {
+			base.Fatalf("go mod: -%s=%s: invalid version: must be canonical semantic version", flag, arg)
+		}
+		return modfile.VersionInterval{Low: arg, High: arg}
+	}
+	if !strings.HasSuffix(arg, "]") {
+		base.Fatalf("go mod: -%s=%s: version interval must end with ']'", flag, arg)
+	}
+	i := strings.Index(arg, ",")
+	if i < 0 {
+		base.Fatalf("go mod: -%s=%s: version interval must have ',' separating lower and upper bounds", flag, arg)
+	}
+	low := strings.TrimSpace(arg[1:i])
+	high := strings.TrimSpace(arg[i+1 : len(arg)-1])
+	for _, v := range []string{low, high} {
+		if !semver.IsValid(v) || module.CanonicalVersion(v) != v {
+			base.Fatalf("go mod: -%s=%s: invalid version %q: must be canonical semantic version", flag, arg, v)
+		}
+	}
+	if semver.Compare(low, high) > 0 {
+		base.Fatalf("go mod: -%s=%s: lower bound must not be above upper bound", flag, arg)
+	}
+	return modfile.VersionInterval{Low: low, High: high}
+}
+
+// flagRetract implements the -retract flag.
+func flagRetract(arg string) {
+	vi := parseVersionInterval("retract", arg)
+	edits = append(edits, func(f *modfile.File) {
+		for _, r := range f.Retract {
+			if r.VersionInterval == vi {
+				return
+			}
+		}
+		if err := f.AddRetract(vi, ""); err != nil {
+			base.Fatalf("go mod: -retract=%s: %v", arg, err)
+		}
+	})
+}
+
+// flagDropRetract implements the -dropretract flag.
+func flagDropRetract(arg string) {
+	vi := parseVersionInterval("dropretract", arg)
+	edits = append(edits, func(f *modfile.File) {
+		if err := f.DropRetract(vi); err != nil {
+			base.Fatalf("go mod: -dropretract=%s: %v", arg, err)
+		}
+	})
+}
+
 // fileJSON is the -json output data structure.
 type fileJSON struct {
 	Module  module.Version
@@ -369,6 +438,7 @@ type fileJSON struct {
 	Require []requireJSON
 	Exclude []module.Version
 	Replace []replaceJSON
+	Retract []retractJSON `json:",omitempty"`
 }
 
 type requireJSON struct {
@@ -382,6 +452,12 @@ type replaceJSON struct {
 	New module.Version
 }
 
+type retractJSON struct {
+	Low       string `json:",omitempty"`
+	High      string `json:",omitempty"`
+	Rationale string `json:",omitempty"`
+}
+
 // editPrintJSON prints the -json output.
 func editPrintJSON(modFile *modfile.File) {
 	var f fileJSON
@@ -398,6 +474,9 @@ func editPrintJSON(modFile *modfile.File) {
 	for _, r := range modFile.Replace {
 		f.Replace = append(f.Replace, replaceJSON{r.Old, r.New})
 	}
+	for _, r := range modFile.Retract {
+		f.Retract = append(f.Retract, retractJSON{r.Low, r.High, r.Rationale})
+	}
 	data, err := json.MarshalIndent(&f, "", "\t")
 	if err != nil {
 		base.Fatalf("go: internal error: %v", err)
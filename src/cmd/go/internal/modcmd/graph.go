@@ -52,8 +52,13 @@ func runGraph(cmd *base.Command, args []string) {
 	work.Do(1, func(item interface{}) {
 		m := item.(module.Version)
 		list, _ := reqs.Required(m)
+		// If m's requirements are pruned from the module graph,
+		// report them but do not follow them.
+		pruned := m != modload.Target && modload.RequirementsPruned(reqs, m)
 		for _, r := range list {
-			work.Add(r)
+			if !pruned {
+				work.Add(r)
+			}
 			out = append(out, format(m)+" "+format(r)+"\n")
 		}
 		if m == modload.Target {
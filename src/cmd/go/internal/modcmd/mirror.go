@@ -0,0 +1,328 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modcmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"cmd/go/internal/base"
+	"cmd/go/internal/modfetch"
+	"cmd/go/internal/modload"
+	"cmd/go/internal/module"
+	"cmd/go/internal/par"
+	"cmd/go/internal/renameio"
+	"cmd/go/internal/semver"
+)
+
+var cmdMirror = &base.Command{
+	UsageLine: "go mod mirror [-v] dir [modules]",
+	Short:     "copy modules to a local module proxy directory",
+	Long: `
+Mirror copies modules into the directory dir, laid out as a module proxy,
+so that the go command can later use them without network access
+by setting GOPROXY=file:///path/to/dir.
+
+The modules can be module patterns selecting dependencies of the main
+module or module queries of the form path@version, as for 'go mod download'.
+With no module arguments, mirror copies everything the go command needs
+to build the main module: the go.mod file of every module version in the
+module graph, and the source zip file of every module in the build list.
+
+For each module version, mirror writes the .info, .mod and (when needed)
+.zip files in the layout described in 'go help goproxy', and adds the
+version to the module's version list. The modules are downloaded to the
+module cache first, if they are not already there. The go.mod files and,
+once copied to the mirror, the zip files are checked against the
+checksums recorded in go.sum, and mirror reports any mismatch, or any
+zip file without a checksum in go.sum, and exits with a non-zero status.
+The checksums of zip files that mirror downloads itself do not count:
+they must already be in go.sum, as 'go mod tidy' records them. Outside
+a module, where there is no go.sum, mirror only warns that the zip
+files are not verified. Pseudo-versions are copied but, as in any
+module proxy, not added to the version lists.
+
+Modules replaced by directories are skipped. For modules replaced by
+other module versions, the replacement is copied instead.
+
+The -v flag causes mirror to print the module versions as it copies them.
+	`,
+}
+
+var mirrorV = cmdMirror.Flag.Bool("v", false, "")
+
+func init() {
+	cmdMirror.Run = runMirror // break init cycle
+}
+
+// A mirrorMod is a module version to copy to the mirror.
+type mirrorMod struct {
+	mod module.Version
+	zip bool // copy the zip file as well as the .info and .mod files
+}
+
+func runMirror(cmd *base.Command, args []string) {
+	if len(args) < 1 {
+		base.Fatalf("usage: go mod mirror [-v] dir [modules]")
+	}
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		base.Fatalf("go mod mirror: %v", err)
+	}
+	args = args[1:]
+
+	var mods []*mirrorMod
+	if len(args) == 0 {
+		if !modload.HasModRoot() {
+			base.Fatalf("go mod mirror: no modules specified (see 'go help mod mirror')")
+		}
+		mods = mirrorGraph()
+	} else {
+		listU := false
+		listVersions := false
+		for _, info := range modload.ListModules(args, listU, listVersions) {
+			if info.Replace != nil {
+				info = info.Replace
+			}
+			if info.Version == "" {
+				continue
+			}
+			if info.Error != nil {
+				base.Errorf("go mod mirror: %s: %v", info.Path, info.Error.Err)
+				continue
+			}
+			mods = append(mods, &mirrorMod{mod: module.Version{Path: info.Path, Version: info.Version}, zip: true})
+		}
+		base.ExitIfErrors()
+	}
+
+	var (
+		mu       sync.Mutex
+		versions = make(map[string][]string) // versions copied, by module path
+	)
+	var work par.Work
+	for _, m := range mods {
+		work.Add(m)
+	}
+	work.Do(10, func(item interface{}) {
+		m := item.(*mirrorMod)
+		if err := mirrorModule(dir, m); err != nil {
+			base.Errorf("go mod mirror: %s@%s: %v", m.mod.Path, m.mod.Version, err)
+			return
+		}
+		if *mirrorV {
+			fmt.Fprintf(os.Stderr, "mirrored %s %s\n", m.mod.Path, m.mod.Version)
+		}
+		mu.Lock()
+		versions[m.mod.Path] = append(versions[m.mod.Path], m.mod.Version)
+		mu.Unlock()
+	})
+
+	for path, vers := range versions {
+		if err := updateMirrorList(dir, path, vers); err != nil {
+			base.Errorf("go mod mirror: %s: %v", path, err)
+		}
+	}
+	base.ExitIfErrors()
+}
+
+// mirrorGraph returns the module versions needed to build the main
+// module: every module version in the module graph, with the zip
+// files of those in the build list.
+func mirrorGraph() []*mirrorMod {
+	buildList := modload.LoadBuildList()
+	selected := make(map[module.Version]bool)
+	for _, m := range buildList[1:] {
+		selected[m] = true
+	}
+
+	reqs := modload.Reqs()
+	var (
+		mu   sync.Mutex
+		seen = make(map[module.Version]*mirrorMod)
+		mods []*mirrorMod
+	)
+	add := func(m module.Version) {
+		r := modload.Replacement(m)
+		if r.Path == "" {
+			r = m
+		} else if r.Version == "" {
+			// Replaced by a directory: nothing to copy.
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// Several module versions may share a replacement, and the
+		// replacement may itself be in the module graph: it needs
+		// its zip file if any of them is in the build list.
+		if mm := seen[r]; mm != nil {
+			mm.zip = mm.zip || selected[m]
+			return
+		}
+		mm := &mirrorMod{mod: r, zip: selected[m]}
+		seen[r] = mm
+		mods = append(mods, mm)
+	}
+
+	var work par.Work
+	work.Add(modload.Target)
+	work.Do(10, func(item interface{}) {
+		m := item.(module.Version)
+		if m != modload.Target {
+			add(m)
+		}
+		list, err := reqs.Required(m)
+		if err != nil {
+			base.Errorf("go mod mirror: %v", err)
+			return
+		}
+		// If m's requirements are pruned from the module graph,
+		// the go command does not need their go.mod files.
+		pruned := m != modload.Target && modload.RequirementsPruned(reqs, m)
+		if !pruned {
+			for _, r := range list {
+				work.Add(r)
+			}
+		}
+	})
+	base.ExitIfErrors()
+
+	sort.Slice(mods, func(i, j int) bool {
+		mi, mj := mods[i].mod, mods[j].mod
+		if mi.Path != mj.Path {
+			return mi.Path < mj.Path
+		}
+		return semver.Compare(mi.Version, mj.Version) < 0
+	})
+	return mods
+}
+
+// mirrorModule copies the files for m from the module cache to the
+// mirror in dir, downloading them first if needed, and verifies the
+// copies against go.sum.
+func mirrorModule(dir string, m *mirrorMod) error {
+	mod := m.mod
+	vdir, err := mirrorVersionDir(dir, mod.Path)
+	if err != nil {
+		return err
+	}
+	encVer, err := module.EncodeVersion(mod.Version)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(vdir, 0777); err != nil {
+		return err
+	}
+
+	info, err := modfetch.InfoFile(mod.Path, mod.Version)
+	if err != nil {
+		return err
+	}
+	if err := copyMirrorFile(filepath.Join(vdir, encVer+".info"), info); err != nil {
+		return err
+	}
+
+	gomod, err := modfetch.GoModFile(mod.Path, mod.Version)
+	if err != nil {
+		return err
+	}
+	// GoModFile has checked the cached go.mod file against go.sum.
+	if err := copyMirrorFile(filepath.Join(vdir, encVer+".mod"), gomod); err != nil {
+		return err
+	}
+
+	if !m.zip {
+		return nil
+	}
+	zip, err := modfetch.DownloadZip(mod)
+	if err != nil {
+		return err
+	}
+	// DownloadZip does not check a zip file already in the module
+	// cache, so check the copy, and withdraw it if it does not match.
+	target := filepath.Join(vdir, encVer+".zip")
+	if err := copyMirrorFile(target, zip); err != nil {
+		return err
+	}
+	err = modfetch.VerifyZip(mod, target)
+	if err == modfetch.ErrNoSum && !modload.HasModRoot() {
+		// Outside a module there is no go.sum to check against.
+		fmt.Fprintf(os.Stderr, "go mod mirror: warning: %s@%s: zip not verified: no go.sum\n", mod.Path, mod.Version)
+		err = nil
+	}
+	if err != nil {
+		os.Remove(target)
+		return fmt.Errorf("verifying zip: %v", err)
+	}
+	return nil
+}
+
+// mirrorVersionDir returns the directory in the mirror dir
+// holding the files for the versions of the module path.
+func mirrorVersionDir(dir, path string) (string, error) {
+	enc, err := module.EncodePath(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, enc, "@v"), nil
+}
+
+// copyMirrorFile copies the file src to dst, replacing dst atomically
+// so that a go command reading the mirror never sees a partial file.
+func copyMirrorFile(dst, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return renameio.WriteToFile(dst, f)
+}
+
+// updateMirrorList adds vers to the version list
+// for the module path in the mirror dir. As the list of a module
+// proxy, it holds only tagged versions: pseudo-versions are left out,
+// although their files are in the mirror.
+func updateMirrorList(dir, path string, vers []string) error {
+	vdir, err := mirrorVersionDir(dir, path)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(vdir, "list")
+	have := make(map[string]bool)
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, v := range strings.Fields(string(data)) {
+		if !modfetch.IsPseudoVersion(v) {
+			have[v] = true
+		}
+	}
+	for _, v := range vers {
+		if !modfetch.IsPseudoVersion(v) {
+			have[v] = true
+		}
+	}
+
+	list := make([]string, 0, len(have))
+	for v := range have {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return semver.Compare(list[i], list[j]) < 0
+	})
+	var buf bytes.Buffer
+	for _, v := range list {
+		buf.WriteString(v)
+		buf.WriteString("\n")
+	}
+	return renameio.WriteFile(file, buf.Bytes())
+}
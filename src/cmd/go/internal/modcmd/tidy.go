@@ -91,6 +91,10 @@ func modTidyGoSum() {
 			replaced[m] = true
 		}
 		list, _ := reqs.Required(m)
+		if m != modload.Target && modload.RequirementsPruned(reqs, m) {
+			// The go.mod files of m's requirements are not loaded.
+			return
+		}
 		for _, r := range list {
 			if !keep[r] && !replaced[r] {
 				walk(r)
@@ -7,6 +7,7 @@ package modfetch
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -270,6 +271,7 @@ type modSum struct {
 var goSum struct {
 	mu        sync.Mutex
 	m         map[module.Version][]string // content of go.sum file (+ go.modverify if present)
+	recorded  map[module.Version][]string // m as read, before any sums were added
 	checked   map[modSum]bool             // sums actually checked during execution
 	dirty     bool                        // whether we added any new sums to m
 	overwrite bool                        // if true, overwrite go.sum without incorporating its contents
@@ -310,6 +312,11 @@ func initGoSum() bool {
 		}
 		goSum.modverify = alt
 	}
+
+	goSum.recorded = make(map[module.Version][]string, len(goSum.m))
+	for mod, sums := range goSum.m {
+		goSum.recorded[mod] = append([]string(nil), sums...)
+	}
 	return true
 }
 
@@ -421,6 +428,48 @@ func checkOneSumLocked(mod module.Version, h string) {
 	goSum.dirty = true
 }
 
+// ErrNoSum is returned by VerifyZip for a module with no checksum
+// recorded in go.sum.
+var ErrNoSum = errors.New("no checksum recorded in go.sum")
+
+// VerifyZip checks that the hash of zipfile, a zip file for the given
+// module, matches the checksum recorded for the module in go.sum.
+// Only the checksums read from go.sum count: those added since, such
+// as the checksum of a zip file downloaded by this command, do not.
+// If go.sum has no checksum for the module, or there is no go.sum,
+// VerifyZip returns ErrNoSum. Unlike the checks made while downloading,
+// it does not add missing checksums to go.sum.
+func VerifyZip(mod module.Version, zipfile string) error {
+	h, err := dirhash.HashZip(zipfile, dirhash.DefaultHash)
+	if err != nil {
+		return err
+	}
+	return verifySum(mod, h)
+}
+
+// verifySum checks that h matches the checksum recorded for mod in the
+// go.sum file as read.
+func verifySum(mod module.Version, h string) error {
+	goSum.mu.Lock()
+	defer goSum.mu.Unlock()
+	if !initGoSum() {
+		return ErrNoSum
+	}
+	var recorded []string
+	for _, vh := range goSum.recorded[mod] {
+		if h == vh {
+			return nil
+		}
+		if strings.HasPrefix(vh, "h1:") {
+			recorded = append(recorded, vh)
+		}
+	}
+	if len(recorded) > 0 {
+		return fmt.Errorf("checksum mismatch\n\thave:   %v\n\tgo.sum: %v", h, strings.Join(recorded, ", "))
+	}
+	return ErrNoSum
+}
+
 // Sum returns the checksum for the downloaded copy of the given module,
 // if present in the download cache.
 func Sum(mod module.Version) string {
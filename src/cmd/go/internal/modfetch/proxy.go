@@ -82,6 +82,10 @@ The cache layout is the same as the proxy URL space, so
 serving $GOPATH/pkg/mod/cache/download at (or copying it to)
 https://example.com/proxy would let other users access those
 cached module versions with GOPROXY=https://example.com/proxy.
+
+To copy just the modules needed to build a particular main module,
+verified against its go.sum file, into a directory that can be used
+with GOPROXY=file:///path/to/dir, see 'go help mod mirror'.
 `,
 }
 
@@ -139,8 +139,14 @@ func (p *printer) expr(x Expr) {
 	case *Line:
 		sep := ""
 		for _, tok := range x.Token {
+			if tok == "," || tok == "]" {
+				sep = ""
+			}
 			p.printf("%s%s", sep, tok)
 			sep = " "
+			if tok == "[" {
+				sep = ""
+			}
 		}
 
 	case *LineBlock:
@@ -509,6 +509,11 @@ func (in *input) lex(sym *symType) int {
 		in.readRune()
 		return c
 
+	case '[', ']', ',':
+		// Version interval punctuation, as in retract [v1.0.0, v1.1.0].
+		in.readRune()
+		return c
+
 	case '"', '`': // quoted string
 		quote := c
 		in.readRune()
@@ -555,8 +560,13 @@ func (in *input) lex(sym *symType) int {
 }
 
 // isIdent reports whether c is an identifier rune.
-// We treat nearly all runes as identifier runes.
+// We treat nearly all runes as identifier runes,
+// except for the version interval punctuation.
 func isIdent(c int) bool {
+	switch c {
+	case '[', ']', ',':
+		return false
+	}
 	return c != 0 && !unicode.IsSpace(rune(c))
 }
 
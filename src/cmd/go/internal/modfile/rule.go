@@ -26,6 +26,7 @@ type File struct {
 	Require []*Require
 	Exclude []*Exclude
 	Replace []*Replace
+	Retract []*Retract
 
 	Syntax *FileSyntax
 }
@@ -62,6 +63,21 @@ type Replace struct {
 	Syntax *Line
 }
 
+// A VersionInterval represents a range of versions with upper and lower bounds.
+// Intervals are closed: both bounds are included. When Low is equal to High,
+// the interval may refer to a single version ('v1.2.3') or an interval
+// ('[v1.2.3, v1.2.3]'); both have the same representation.
+type VersionInterval struct {
+	Low, High string
+}
+
+// A Retract is a single retract statement.
+type Retract struct {
+	VersionInterval
+	Rationale string // comment text explaining the retraction, if any
+	Syntax    *Line
+}
+
 func (f *File) AddModuleStmt(path string) error {
 	if f.Syntax == nil {
 		f.Syntax = new(FileSyntax)
@@ -140,7 +156,7 @@ func parseToFile(file string, data []byte, fix VersionFixer, strict bool) (*File
 					fmt.Fprintf(&errs, "%s:%d: unknown block type: %s\n", file, x.Start.Line, strings.Join(x.Token, " "))
 				}
 				continue
-			case "module", "require", "exclude", "replace":
+			case "module", "require", "exclude", "replace", "retract":
 				for _, l := range x.Line {
 					f.add(&errs, l, x.Token[0], l.Token, fix, strict)
 				}
@@ -165,8 +181,9 @@ func (f *File) add(errs *bytes.Buffer, line *Line, verb string, args []string, f
 	// and simply ignore those statements.
 	if !strict {
 		switch verb {
-		case "module", "require", "go":
-			// want these even for dependency go.mods
+		case "module", "require", "go", "retract":
+			// want these even for dependency go.mods;
+			// retractions are read from the latest version of a module
 		default:
 			return
 		}
@@ -312,9 +329,86 @@ func (f *File) add(errs *bytes.Buffer, line *Line, verb string, args []string, f
 			New:    module.Version{Path: ns, Version: nv},
 			Syntax: line,
 		})
+	case "retract":
+		if f.Module == nil {
+			fmt.Fprintf(errs, "%s:%d: no module directive found, so retract cannot be used\n", f.Syntax.Name, line.Start.Line)
+			return
+		}
+		vi, err := parseVersionInterval(f.Module.Mod.Path, args, fix)
+		if err != nil {
+			fmt.Fprintf(errs, "%s:%d: %v\n", f.Syntax.Name, line.Start.Line, err)
+			return
+		}
+		pathMajor, err := modulePathMajor(f.Module.Mod.Path)
+		if err != nil {
+			fmt.Fprintf(errs, "%s:%d: %v\n", f.Syntax.Name, line.Start.Line, err)
+			return
+		}
+		for _, v := range []string{vi.Low, vi.High} {
+			if !module.MatchPathMajor(v, pathMajor) {
+				if pathMajor == "" {
+					pathMajor = "v0 or v1"
+				}
+				fmt.Fprintf(errs, "%s:%d: invalid retraction: %s should be %s, not %s (%s)\n", f.Syntax.Name, line.Start.Line, f.Module.Mod.Path, pathMajor, semver.Major(v), v)
+				return
+			}
+		}
+		f.Retract = append(f.Retract, &Retract{
+			VersionInterval: vi,
+			Rationale:       parseRationale(line),
+			Syntax:          line,
+		})
 	}
 }
 
+// parseVersionInterval parses the arguments of a retract statement,
+// either a single version 'v1.2.3' or a closed interval '[v1.2.3, v1.3.0]'.
+func parseVersionInterval(path string, args []string, fix VersionFixer) (VersionInterval, error) {
+	if len(args) == 1 && args[0] != "[" {
+		v, err := parseVersion(path, &args[0], fix)
+		if err != nil {
+			return VersionInterval{}, fmt.Errorf("invalid module version %q: %v", args[0], err)
+		}
+		return VersionInterval{Low: v, High: v}, nil
+	}
+	if len(args) != 5 || args[0] != "[" || args[2] != "," || args[4] != "]" {
+		return VersionInterval{}, fmt.Errorf("usage: retract v1.2.3\n\t or retract [v1.2.3, v1.3.0]")
+	}
+	old := args[1]
+	low, err := parseVersion(path, &args[1], fix)
+	if err != nil {
+		return VersionInterval{}, fmt.Errorf("invalid module version %q: %v", old, err)
+	}
+	old = args[3]
+	high, err := parseVersion(path, &args[3], fix)
+	if err != nil {
+		return VersionInterval{}, fmt.Errorf("invalid module version %q: %v", old, err)
+	}
+	if semver.Compare(low, high) > 0 {
+		return VersionInterval{}, fmt.Errorf("version interval lower bound %s must not be above upper bound %s", low, high)
+	}
+	return VersionInterval{Low: low, High: high}, nil
+}
+
+// parseRationale returns the rationale for a retract statement:
+// the text of the comments immediately before the statement or,
+// if there are none, of the comment at the end of its line.
+func parseRationale(line *Line) string {
+	comments := line.Comment()
+	com := comments.Before
+	if len(com) == 0 {
+		com = comments.Suffix
+	}
+	var lines []string
+	for _, c := range com {
+		text := strings.TrimSpace(strings.TrimPrefix(c.Token, "//"))
+		if text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 // isIndirect reports whether line has a "// indirect" comment,
 // meaning it is in go.mod only for its effect on indirect dependencies,
 // so that it can be dropped entirely once the effective version of the
@@ -474,6 +568,15 @@ func (f *File) Cleanup() {
 	}
 	f.Replace = f.Replace[:w]
 
+	w = 0
+	for _, r := range f.Retract {
+		if r.Low != "" || r.High != "" {
+			f.Retract[w] = r
+			w++
+		}
+	}
+	f.Retract = f.Retract[:w]
+
 	f.Syntax.Cleanup()
 }
 
@@ -658,6 +761,39 @@ func (f *File) DropReplace(oldPath, oldVers string) error {
 	return nil
 }
 
+// AddRetract adds a retract statement for the interval vi,
+// with the given rationale written as a comment before it.
+func (f *File) AddRetract(vi VersionInterval, rationale string) error {
+	var tokens []string
+	if vi.Low == vi.High {
+		tokens = []string{"retract", AutoQuote(vi.Low)}
+	} else {
+		tokens = []string{"retract", "[", AutoQuote(vi.Low), ",", AutoQuote(vi.High), "]"}
+	}
+	r := &Retract{
+		VersionInterval: vi,
+		Rationale:       rationale,
+		Syntax:          f.Syntax.addLine(nil, tokens...),
+	}
+	if rationale != "" {
+		for _, line := range strings.Split(rationale, "\n") {
+			r.Syntax.Comments.Before = append(r.Syntax.Comments.Before, Comment{Token: "// " + line})
+		}
+	}
+	f.Retract = append(f.Retract, r)
+	return nil
+}
+
+func (f *File) DropRetract(vi VersionInterval) error {
+	for _, r := range f.Retract {
+		if r.VersionInterval == vi {
+			f.Syntax.removeLine(r.Syntax)
+			*r = Retract{}
+		}
+	}
+	return nil
+}
+
 func (f *File) SortBlocks() {
 	f.removeDups() // otherwise sorting is unsafe
 
@@ -666,6 +802,11 @@ func (f *File) SortBlocks() {
 		if !ok {
 			continue
 		}
+		if len(block.Token) > 0 && block.Token[0] == "retract" {
+			// Retractions are kept in the author's order,
+			// since their comments may refer to one another.
+			continue
+		}
 		sort.Slice(block.Line, func(i, j int) bool {
 			li := block.Line[i]
 			lj := block.Line[j]
@@ -7,6 +7,7 @@ package modfile
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -88,3 +89,90 @@ func TestAddRequire(t *testing.T) {
 		})
 	}
 }
+
+var parseRetractTests = []struct {
+	in        string
+	low, high string
+	rationale string
+	err       string
+}{
+	{
+		"module m\nretract v1.2.3",
+		"v1.2.3", "v1.2.3", "", "",
+	},
+	{
+		"module m\n// Broken.\nretract [v1.0.0, v1.1.0]",
+		"v1.0.0", "v1.1.0", "Broken.", "",
+	},
+	{
+		"module m\nretract (\n\tv1.0.0 // oops\n)",
+		"v1.0.0", "v1.0.0", "oops", "",
+	},
+	{
+		"module m\nretract [v1.1.0, v1.0.0]",
+		"", "", "", "lower bound v1.1.0 must not be above upper bound v1.0.0",
+	},
+	{
+		"module m\nretract [v1.0.0 v1.1.0]",
+		"", "", "", "usage: retract",
+	},
+	{
+		"module m/v2\nretract v1.0.0",
+		"", "", "", "invalid retraction: m/v2 should be /v2, not v1",
+	},
+	{
+		"retract v1.0.0",
+		"", "", "", "no module directive found",
+	},
+}
+
+func TestParseRetract(t *testing.T) {
+	for i, tt := range parseRetractTests {
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			f, err := Parse("in", []byte(tt.in), nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Parse: error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(f.Retract) != 1 {
+				t.Fatalf("have %d retractions, want 1", len(f.Retract))
+			}
+			r := f.Retract[0]
+			if r.Low != tt.low || r.High != tt.high || r.Rationale != tt.rationale {
+				t.Errorf("have [%s, %s] %q, want [%s, %s] %q", r.Low, r.High, r.Rationale, tt.low, tt.high, tt.rationale)
+			}
+		})
+	}
+}
+
+func TestAddRetract(t *testing.T) {
+	f, err := Parse("in", []byte("module m\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.AddRetract(VersionInterval{Low: "v1.0.0", High: "v1.0.0"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.AddRetract(VersionInterval{Low: "v1.1.0", High: "v1.2.0"}, "Broken."); err != nil {
+		t.Fatal(err)
+	}
+	out, err := f.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "module m\n\nretract (\n\tv1.0.0\n\t// Broken.\n\t[v1.1.0, v1.2.0]\n)\n"
+	if string(out) != want {
+		t.Errorf("have:\n%s\nwant:\n%s", out, want)
+	}
+
+	f.DropRetract(VersionInterval{Low: "v1.0.0", High: "v1.0.0"})
+	f.Cleanup()
+	if len(f.Retract) != 1 || f.Retract[0].Low != "v1.1.0" {
+		t.Errorf("after DropRetract, have %v", f.Retract)
+	}
+}
@@ -23,7 +23,9 @@ import (
 	"os"
 	pathpkg "path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 )
 
 var CmdGet = &base.Command{
@@ -42,7 +44,9 @@ the corresponding module to use. By default, get chooses the latest tagged
 release version, such as v0.4.5 or v1.2.3. If there are no tagged release
 versions, get chooses the latest tagged prerelease version, such as
 v0.0.1-pre1. If there are no tagged versions at all, get chooses the latest
-known commit.
+known commit. Versions retracted by the module author (see 'go help go.mod')
+are never chosen by default, and get prints a warning when the resulting
+build list contains a retracted version.
 
 This default version selection can be overridden by adding an @version
 suffix to the package argument, as in 'go get golang.org/x/text@v0.3.0'.
@@ -506,6 +510,9 @@ func runGet(cmd *base.Command, args []string) {
 		base.Fatalf("%v", buf.String())
 	}
 
+	// Warn about retracted versions in the resulting build list.
+	checkRetractions()
+
 	// Everything succeeded. Update go.mod.
 	modload.AllowWriteGoMod()
 	modload.WriteGoMod()
@@ -550,6 +557,33 @@ func runGet(cmd *base.Command, args []string) {
 	}
 }
 
+// checkRetractions prints a warning for each module in the build list
+// whose version has been retracted by its author.
+func checkRetractions() {
+	var work par.Work
+	for _, m := range modload.BuildList()[1:] {
+		work.Add(m)
+	}
+	var mu sync.Mutex
+	var warnings []string
+	work.Do(10, func(item interface{}) {
+		m := item.(module.Version)
+		if err := modload.CheckRetractions(m); err != nil {
+			mu.Lock()
+			warnings = append(warnings, fmt.Sprintf("go: warning: %s@%s: %v", m.Path, m.Version, err))
+			mu.Unlock()
+		}
+	})
+	if len(warnings) == 0 {
+		return
+	}
+	sort.Strings(warnings)
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, w)
+	}
+	fmt.Fprintf(os.Stderr, "go: run 'go get <module>@latest' to switch to the latest unretracted version\n")
+}
+
 // getQuery evaluates the given package path, version pair
 // to determine the underlying module version being requested.
 // If forceModulePath is set, getQuery must interpret path
@@ -0,0 +1,163 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modget
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+
+	"cmd/go/internal/base"
+	"cmd/go/internal/cfg"
+	"cmd/go/internal/load"
+	"cmd/go/internal/modload"
+	"cmd/go/internal/module"
+	"cmd/go/internal/search"
+	"cmd/go/internal/work"
+)
+
+func init() {
+	work.ModInstallVersion = installVersion
+}
+
+// installVersion implements 'go install path@version'.
+// It builds and installs the named main packages in module mode,
+// using only the requirements of the module that provides them.
+// Any go.mod in the current directory or its parents is ignored
+// and left unmodified, as is the case for 'go get' outside a module:
+// replacements and exclusions in the installed module's go.mod
+// do not apply, since it is not the main module.
+func installVersion(args []string) {
+	if os.Getenv("GO111MODULE") == "off" {
+		base.Fatalf("go install: cannot use path@version syntax in GOPATH mode")
+	}
+	if cfg.BuildMod == "vendor" {
+		base.Fatalf("go install: -mod=vendor cannot be used with path@version arguments")
+	}
+	modload.MustUseModules = true
+	modload.IgnoreModRoot = true
+	modload.Init()
+
+	// Check that the arguments satisfy syntactic constraints.
+	var version string
+	for _, arg := range args {
+		if i := strings.Index(arg, "@"); i >= 0 {
+			version = arg[i+1:]
+			if version == "" {
+				base.Fatalf("go install %s: version must not be empty", arg)
+			}
+			break
+		}
+	}
+	patterns := make([]string, len(args))
+	for i, arg := range args {
+		if !strings.HasSuffix(arg, "@"+version) {
+			base.Errorf("go install %s: all arguments must have the same version (@%s)", arg, version)
+			continue
+		}
+		p := arg[:len(arg)-len(version)-1]
+		switch {
+		case build.IsLocalImport(p):
+			base.Errorf("go install %s: argument must be a package path, not a relative path", arg)
+		case filepath.IsAbs(p):
+			base.Errorf("go install %s: argument must be a package path, not an absolute path", arg)
+		case search.IsMetaPackage(p):
+			base.Errorf("go install %s: argument must be a package path, not a meta-package", arg)
+		case pathpkg.Clean(p) != p:
+			base.Errorf("go install %s: argument must be a clean package path", arg)
+		case !strings.Contains(p, "...") && search.IsStandardImportPath(p):
+			base.Errorf("go install %s: argument must not be a package in the standard library", arg)
+		case version == "none":
+			base.Errorf("go install %s: cannot install version none", arg)
+		default:
+			patterns[i] = p
+		}
+	}
+	base.ExitIfErrors()
+	work.BuildInit()
+
+	// Resolve the module providing the first argument.
+	// It's possible this module won't provide packages named by later
+	// arguments, and other modules would; that is reported below.
+	installMod, err := queryInstallModule(patterns[0], version)
+	if err != nil {
+		base.Fatalf("go install %s: %v", args[0], err)
+	}
+
+	// Outside a module the build list contains only the dummy
+	// command-line-arguments target. Require the module providing the
+	// named packages and let the loader add its dependencies.
+	modload.InitMod()
+	modload.SetBuildList([]module.Version{modload.Target, installMod})
+
+	// Load packages for all arguments. Ignore non-main packages matched
+	// by a pattern, but report literal arguments that are not commands.
+	pkgs := load.PackagesAndErrors(patterns)
+	var mainPkgs []*load.Package
+	for _, p := range pkgs {
+		if p.Error != nil {
+			base.Errorf("go install: %v", p.Error)
+			continue
+		}
+		if p.Name != "main" {
+			if p.Internal.CmdlinePkgLiteral {
+				base.Errorf("go install: package %s is not a main package", p.ImportPath)
+			}
+			continue
+		}
+		mainPkgs = append(mainPkgs, p)
+	}
+	base.ExitIfErrors()
+
+	// Check that named packages are all provided by the same module.
+	for _, p := range mainPkgs {
+		if p.Module == nil {
+			base.Errorf("go install: package %s not provided by module %s@%s", p.ImportPath, installMod.Path, installMod.Version)
+		} else if p.Module.Path != installMod.Path || p.Module.Version != installMod.Version {
+			base.Errorf("go install: package %s provided by module %s@%s\n\tAll packages must be provided by the same module (%s@%s).", p.ImportPath, p.Module.Path, p.Module.Version, installMod.Path, installMod.Version)
+		}
+	}
+	base.ExitIfErrors()
+	if len(mainPkgs) == 0 {
+		base.Fatalf("go install: %s matched no main packages", strings.Join(args, " "))
+	}
+
+	work.InstallPackages(patterns, mainPkgs)
+}
+
+// queryInstallModule returns the module providing the packages
+// matched by pattern at the given version.
+func queryInstallModule(pattern, version string) (module.Version, error) {
+	if !strings.Contains(pattern, "...") {
+		m, _, err := modload.QueryPackage(pattern, version, modload.Allowed)
+		return m, err
+	}
+
+	// For a pattern, look for the longest module path
+	// that is a prefix of the pattern's literal part.
+	prefix := pattern[:strings.Index(pattern, "...")]
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		prefix = prefix[:i]
+	} else {
+		prefix = ""
+	}
+	var firstErr error
+	for p := prefix; p != "." && p != "/" && p != ""; p = pathpkg.Dir(p) {
+		info, err := modload.Query(p, version, modload.Allowed)
+		if err == nil {
+			return module.Version{Path: p, Version: info.Version}, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("no module path in pattern %s", pattern)
+	}
+	return module.Version{}, firstErr
+}
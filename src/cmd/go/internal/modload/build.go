@@ -88,6 +88,22 @@ func addUpdate(m *modinfo.ModulePublic) {
 	}
 }
 
+// addRetraction fills in m.Retracted if the module version
+// has been retracted by its author.
+func addRetraction(m *modinfo.ModulePublic) {
+	if m.Version == "" {
+		return
+	}
+	err := CheckRetractions(module.Version{Path: m.Path, Version: m.Version})
+	if rerr, ok := err.(*ModuleRetractedError); ok {
+		if len(rerr.Rationale) == 0 {
+			m.Retracted = []string{"retracted by module author"}
+		} else {
+			m.Retracted = rerr.Rationale
+		}
+	}
+}
+
 // addVersions fills in m.Versions with the list of known versions.
 func addVersions(m *modinfo.ModulePublic) {
 	m.Versions, _ = versions(m.Path)
@@ -249,17 +265,26 @@ func findModule(target, path string) module.Version {
 	panic("unreachable")
 }
 
-func ModInfoProg(info string) []byte {
-	// Inject a variable with the debug information as runtime/debug.modinfo,
+func ModInfoProg(info string, isgccgo bool) []byte {
+	// Inject a variable with the debug information as runtime.modinfo,
 	// but compile it in package main so that it is specific to the binary.
-	//
 	// The variable must be a literal so that it will have the correct value
 	// before the initializer for package main runs.
 	//
-	// We also want the value to be present even if runtime/debug.modinfo is
-	// otherwise unused in the rest of the program. Reading it in an init function
-	// suffices for now.
-
+	// The runtime startup code refers to the variable, which keeps it live
+	// in all binaries, and the linker records its address in the
+	// .go.buildinfo section so that "go version -m" can find it.
+	//
+	// The gccgo runtime does not have runtime.modinfo, so for gccgo we
+	// keep injecting runtime/debug.modinfo and reading it in an init
+	// function, so that the value is present even if it is otherwise unused.
+	if !isgccgo {
+		return []byte(fmt.Sprintf(`package main
+import _ "unsafe"
+//go:linkname __debug_modinfo__ runtime.modinfo
+var __debug_modinfo__ = %q
+	`, string(infoStart)+info+string(infoEnd)))
+	}
 	return []byte(fmt.Sprintf(`package main
 import _ "unsafe"
 //go:linkname __debug_modinfo__ runtime/debug.modinfo
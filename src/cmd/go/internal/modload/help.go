@@ -251,7 +251,9 @@ to the comparison target.
 
 Module versions disallowed by exclude statements in the
 main module's go.mod are considered unavailable and cannot
-be returned by queries.
+be returned by queries. Similarly, versions retracted by the
+module author (see 'go help go.mod') are not returned by queries
+other than a specific version or revision, like "v1.2.3".
 
 For example, these commands are all valid:
 
@@ -398,16 +400,44 @@ verb followed by arguments. For example:
 	require new/thing/v2 v2.3.4
 	exclude old/thing v1.2.3
 	replace bad/thing v1.4.5 => good/thing v1.4.5
+	retract [v1.9.0, v1.9.5]
 
 The verbs are
 	module, to define the module path;
 	go, to set the expected language version;
 	require, to require a particular module at a given version or later;
-	exclude, to exclude a particular module version from use; and
-	replace, to replace a module version with a different module version.
+	exclude, to exclude a particular module version from use;
+	replace, to replace a module version with a different module version; and
+	retract, to indicate a previously released version of the module
+	should not be used.
 Exclude and replace apply only in the main module's go.mod and are ignored
 in dependencies.  See https://research.swtch.com/vgo-mvs for details.
 
+A retract directive names either a single version (retract v1.0.0)
+or a closed interval of versions (retract [v1.0.0, v1.1.0]) of the
+module defined by the go.mod file. Retractions are read from the go.mod
+file of the latest version of the module, so an author retracts a
+version by publishing a new version whose go.mod lists it; the new version
+may retract itself too. The comment before or at the end of a retract
+directive is the rationale reported to users of the retracted versions.
+Retracted versions are not selected by queries like @latest or by
+'go get -u', and 'go get' prints a warning when the resulting build list
+contains a retracted version. Use 'go list -m -u' to list retracted
+versions in the build list.
+
+If the go directive in the main module's go.mod specifies go 1.13 or
+higher, the go.mod file lists every module that provides a package
+imported (directly or indirectly) by the main module's packages and tests,
+marking those not imported directly with an "// indirect" comment.
+The go command then builds packages using only the modules listed in
+go.mod, without loading the go.mod files of dependencies that do not
+provide packages to the build. When the full module graph is needed,
+as for 'go list -m all' or 'go get', the requirements of a dependency
+whose own go.mod specifies go 1.13 or higher are included, but their
+requirements in turn are not: such a dependency lists everything its
+own packages need. After raising the go version, run 'go mod tidy'
+to record the needed indirect dependencies.
+
 The leading verb can be factored out of adjacent lines to create a block,
 like in Go imports:
 
@@ -46,6 +46,8 @@ var (
 
 	CmdModInit   bool   // running 'go mod init'
 	CmdModModule string // module argument for 'go mod init'
+
+	IgnoreModRoot bool // ignore any enclosing go.mod, as for 'go install path@version'
 )
 
 // ModFile returns the parsed go.mod file.
@@ -163,6 +165,10 @@ func Init() {
 	if CmdModInit {
 		// Running 'go mod init': go.mod will be created in current directory.
 		modRoot = cwd
+	} else if IgnoreModRoot {
+		// Running 'go install path@version': operate outside of any module,
+		// so that the current module's go.mod is neither used nor modified.
+		modRoot = ""
 	} else {
 		modRoot, _ = FindModuleRoot(cwd, "", MustUseModules)
 		if modRoot == "" {
@@ -602,6 +608,9 @@ func AllowWriteGoMod() {
 // MinReqs returns a Reqs with minimal dependencies of Target,
 // as will be written to go.mod.
 func MinReqs() mvs.Reqs {
+	if pruningEnabled() {
+		return &mvsReqs{buildList: append([]module.Version{Target}, prunedRequirements()...)}
+	}
 	var direct []string
 	for _, m := range buildList[1:] {
 		if loaded.direct[m.Path] {
@@ -30,6 +30,7 @@ func ListModules(args []string, listU, listVersions bool) []*modinfo.ModulePubli
 			m := item.(*modinfo.ModulePublic)
 			if listU {
 				addUpdate(m)
+				addRetraction(m)
 			}
 			if listVersions {
 				addVersions(m)
@@ -285,7 +285,7 @@ func LoadBuildList() []module.Version {
 
 func ReloadBuildList() []module.Version {
 	loaded = newLoader()
-	loaded.load(func() []string { return nil })
+	loaded.load(nil) // no packages: load the module graph only
 	return buildList
 }
 
@@ -487,10 +487,19 @@ var errMissing = errors.New("cannot find package")
 // which must call add(path) with the import path of each root package.
 func (ld *loader) load(roots func() []string) {
 	var err error
-	reqs := Reqs()
-	buildList, err = mvs.BuildList(Target, reqs)
-	if err != nil {
-		base.Fatalf("go: %v", err)
+	reqs := Reqs().(*mvsReqs)
+
+	// If the main module's go.mod lists every module providing a package
+	// (see pruningGoVersion), try loading the packages using only those
+	// modules, without loading the module graph.
+	lazy := roots != nil && !ld.isALL && pruningEnabled()
+	if lazy {
+		setRoots(buildList)
+	} else {
+		buildList, err = computeBuildList(reqs)
+		if err != nil {
+			base.Fatalf("go: %v", err)
+		}
 	}
 
 	added := make(map[string]bool)
@@ -528,12 +537,32 @@ func (ld *loader) load(roots func() []string) {
 		}
 		base.ExitIfErrors()
 		if numAdded == 0 {
-			break
+			if !lazy || lazyBuildListConsistent(reqs, ld.pkgs) {
+				break
+			}
+			// Some module providing a package requires a newer version of
+			// a module than go.mod lists. Load the module graph to select
+			// the right versions, and load the packages again.
+			lazy = false
+			buildList, err = computeBuildList(reqs)
+			if err != nil {
+				base.Fatalf("go: %v", err)
+			}
+			continue
+		}
+
+		if lazy {
+			// Some package is not provided by any module listed in go.mod.
+			// Load the module graph, which may provide it, and try again
+			// before adding any modules.
+			lazy = false
+			added = make(map[string]bool)
+			buildList = reqs.buildList
 		}
 
 		// Recompute buildList with all our additions.
-		reqs = Reqs()
-		buildList, err = mvs.BuildList(Target, reqs)
+		reqs = Reqs().(*mvsReqs)
+		buildList, err = computeBuildList(reqs)
 		if err != nil {
 			base.Fatalf("go: %v", err)
 		}
@@ -553,9 +582,19 @@ func (ld *loader) load(roots func() []string) {
 	}
 
 	// Add Go versions, computed during walk.
+	// If the module graph was pruned or not loaded at all, the go.mod
+	// files of modules providing packages may not have been read yet.
+	if pruningEnabled() {
+		for _, pkg := range ld.pkgs {
+			if pkg.mod.Path != "" && pkg.mod != Target {
+				reqs.Required(pkg.mod)
+			}
+		}
+		base.ExitIfErrors()
+	}
 	ld.goVersion = make(map[string]string)
 	for _, m := range buildList {
-		v, _ := reqs.(*mvsReqs).versions.Load(m)
+		v, _ := reqs.versions.Load(m)
 		ld.goVersion[m.Path], _ = v.(string)
 	}
 
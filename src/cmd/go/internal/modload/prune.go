@@ -0,0 +1,228 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modload
+
+import (
+	"sort"
+
+	"cmd/go/internal/cfg"
+	"cmd/go/internal/module"
+	"cmd/go/internal/mvs"
+	"cmd/go/internal/semver"
+)
+
+// pruningGoVersion is the earliest Go version, as declared by the go
+// directive in a go.mod file, for which the module graph is pruned.
+//
+// A main module at or above this version lists in its go.mod file every
+// module that provides a package imported (directly or indirectly) by its
+// packages or tests. That lets the go command build those packages
+// from the go.mod file alone, without loading the go.mod files of
+// dependencies that do not provide packages (lazy loading).
+//
+// When the module graph itself is needed (for example, by 'go list -m all'
+// or 'go get'), the requirements of a dependency that is itself at or above
+// this version are included but not followed further (graph pruning):
+// since such a module lists everything its own packages need, requirements
+// deeper in its graph cannot affect the packages it provides.
+const pruningGoVersion = "1.13"
+
+// goVersionAtLeast reports whether the go.mod language version v
+// is at least min.
+func goVersionAtLeast(v, min string) bool {
+	return v != "" && semver.Compare("v"+v, "v"+min) >= 0
+}
+
+// pruningEnabled reports whether the main module's go.mod file enables
+// module graph pruning and lazy loading.
+func pruningEnabled() bool {
+	if modFile == nil || modFile.Go == nil || cfg.BuildMod == "vendor" {
+		return false
+	}
+	return goVersionAtLeast(modFile.Go.Version, pruningGoVersion)
+}
+
+var (
+	// rootPaths is the set of paths of the root modules
+	// from which the pruned module graph was last computed.
+	rootPaths map[string]bool
+
+	// prunedOnly is the set of modules added to the build list by
+	// the last pruned graph computation that were not themselves roots.
+	// A later computation starting from that build list does not treat
+	// them as roots, so that their requirements are not followed.
+	prunedOnly map[module.Version]bool
+)
+
+// setRoots records the modules in list (excluding the main module)
+// as the roots of the module graph, without loading the graph.
+// It is used when the build list is taken directly from go.mod.
+func setRoots(list []module.Version) {
+	if rootPaths != nil {
+		return
+	}
+	rootPaths = make(map[string]bool)
+	for _, m := range list[1:] {
+		rootPaths[m.Path] = true
+	}
+}
+
+// computeBuildList returns the build list for the requirements in reqs,
+// pruning the module graph if the main module enables it.
+func computeBuildList(reqs *mvsReqs) ([]module.Version, error) {
+	if !pruningEnabled() {
+		return mvs.BuildList(Target, reqs)
+	}
+
+	var roots []module.Version
+	newRootPaths := make(map[string]bool)
+	for _, m := range reqs.buildList[1:] {
+		if !prunedOnly[m] {
+			roots = append(roots, m)
+			newRootPaths[m.Path] = true
+		}
+	}
+
+	selected := make(map[string]string)
+	selectVersion := func(m module.Version) {
+		if m.Path == Target.Path {
+			return
+		}
+		if v, ok := selected[m.Path]; !ok || reqs.Max(v, m.Version) != v {
+			selected[m.Path] = m.Version
+		}
+	}
+	for _, m := range roots {
+		selectVersion(m)
+	}
+
+	// Add the requirements of the selected version of each root.
+	// Selecting a higher version of a root brings in that version's
+	// requirements, so repeat until nothing changes.
+	paths := make([]string, 0, len(newRootPaths))
+	for path := range newRootPaths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	expanded := make(map[module.Version]bool)
+	for {
+		changed := false
+		for _, path := range paths {
+			m := module.Version{Path: path, Version: selected[path]}
+			if expanded[m] {
+				continue
+			}
+			expanded[m] = true
+			changed = true
+
+			deps, err := reqs.Required(m)
+			if err != nil {
+				return nil, err
+			}
+			if !reqs.isPruned(m) {
+				// A module that does not prune its own graph may need
+				// anything in it: include the full transitive closure.
+				deps, err = mvs.BuildList(m, reqs)
+				if err != nil {
+					return nil, err
+				}
+				deps = deps[1:]
+			}
+			for _, d := range deps {
+				selectVersion(d)
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	list := []module.Version{Target}
+	prunedOnly = make(map[module.Version]bool)
+	for path, vers := range selected {
+		m := module.Version{Path: path, Version: vers}
+		list = append(list, m)
+		if !newRootPaths[path] {
+			prunedOnly[m] = true
+		}
+	}
+	sort.Slice(list[1:], func(i, j int) bool {
+		return list[1+i].Path < list[1+j].Path
+	})
+	rootPaths = newRootPaths
+	return list, nil
+}
+
+// isPruned reports whether the go.mod file of m enables graph pruning.
+// It must be called after r.Required(m).
+func (r *mvsReqs) isPruned(m module.Version) bool {
+	v, _ := r.versions.Load(m)
+	s, _ := v.(string)
+	return goVersionAtLeast(s, pruningGoVersion)
+}
+
+// RequirementsPruned reports whether the requirements of m, a module in
+// the graph described by reqs, are pruned from the module graph: that is,
+// whether their own requirements are not followed. It must be called
+// after reqs.Required(m).
+func RequirementsPruned(reqs mvs.Reqs, m module.Version) bool {
+	r, ok := reqs.(*mvsReqs)
+	return ok && pruningEnabled() && r.isPruned(m)
+}
+
+// prunedRequirements returns the requirements to record in go.mod for a
+// main module that enables graph pruning: the roots of the module graph and
+// every module that provides a loaded package, at their selected versions.
+// If the loader scanned all packages in the main module, only the modules
+// providing packages are kept, since those are all that is needed.
+func prunedRequirements() []module.Version {
+	keep := make(map[string]bool)
+	for _, pkg := range loaded.pkgs {
+		if pkg.mod.Path != "" && pkg.mod != Target {
+			keep[pkg.mod.Path] = true
+		}
+	}
+	if !loaded.isALL {
+		for path := range rootPaths {
+			keep[path] = true
+		}
+	}
+	var list []module.Version
+	for _, m := range buildList[1:] {
+		if keep[m.Path] {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// lazyBuildListConsistent reports whether the build list taken directly
+// from go.mod satisfies the requirements of every module providing one of
+// pkgs, as it must for the packages to be loaded without the module graph.
+func lazyBuildListConsistent(reqs *mvsReqs, pkgs []*loadPkg) bool {
+	selected := make(map[string]string)
+	for _, m := range buildList {
+		selected[m.Path] = m.Version
+	}
+	checked := make(map[module.Version]bool)
+	for _, pkg := range pkgs {
+		m := pkg.mod
+		if m.Path == "" || m == Target || checked[m] {
+			continue
+		}
+		checked[m] = true
+		deps, err := reqs.Required(m)
+		if err != nil {
+			// Let the full graph computation report the error.
+			return false
+		}
+		for _, d := range deps {
+			if v, ok := selected[d.Path]; ok && reqs.Max(v, d.Version) != v {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -31,9 +31,19 @@ import (
 //
 // If the allowed function is non-nil, Query excludes any versions for which allowed returns false.
 //
+// Unless the query names a specific version or revision, Query also excludes
+// any versions retracted by the module author; see CheckRetractions.
+//
 // If path is the path of the main module and the query is "latest",
 // Query returns Target.Version as the version.
 func Query(path, query string, allowed func(module.Version) bool) (*modfetch.RevInfo, error) {
+	return queryModule(path, query, allowed, true)
+}
+
+// queryModule implements Query. If honorRetract is false, it does not
+// exclude retracted versions, as needed when looking up the latest
+// version to read retractions from.
+func queryModule(path, query string, allowed func(module.Version) bool, honorRetract bool) (*modfetch.RevInfo, error) {
 	if allowed == nil {
 		allowed = func(module.Version) bool { return true }
 	}
@@ -131,6 +141,14 @@ func Query(path, query string, allowed func(module.Version) bool) (*modfetch.Rev
 		return &modfetch.RevInfo{Version: Target.Version}, nil
 	}
 
+	if honorRetract {
+		// Check retractions last: loading them may require a network fetch.
+		matches := ok
+		ok = func(m module.Version) bool {
+			return matches(m) && !isRetracted(m)
+		}
+	}
+
 	// Load versions and execute query.
 	repo, err := modfetch.Lookup(path)
 	if err != nil {
@@ -0,0 +1,141 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package modload
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"cmd/go/internal/modfetch"
+	"cmd/go/internal/modfile"
+	"cmd/go/internal/module"
+	"cmd/go/internal/par"
+	"cmd/go/internal/semver"
+)
+
+// A ModuleRetractedError indicates that a module version
+// has been retracted by its author.
+type ModuleRetractedError struct {
+	Rationale []string
+}
+
+func (e *ModuleRetractedError) Error() string {
+	msg := "retracted by module author"
+	if len(e.Rationale) > 0 {
+		// This is meant to be a short error printed on a terminal,
+		// so just print the first rationale.
+		if r := shortRationale(e.Rationale[0]); r != "" {
+			msg += ": " + r
+		}
+	}
+	return msg
+}
+
+// shortRationale returns the first line of a retraction rationale,
+// suitable for printing on a terminal, or the empty string if the
+// rationale is too long or contains unprintable characters.
+func shortRationale(rationale string) string {
+	const maxRationaleBytes = 500
+	if i := strings.Index(rationale, "\n"); i >= 0 {
+		rationale = rationale[:i]
+	}
+	rationale = strings.TrimSpace(rationale)
+	if len(rationale) > maxRationaleBytes {
+		return ""
+	}
+	for _, r := range rationale {
+		if !unicode.IsGraphic(r) && !unicode.IsSpace(r) {
+			return ""
+		}
+	}
+	return rationale
+}
+
+// CheckRetractions returns an error of type *ModuleRetractedError
+// if m has been retracted by its author, or nil if it has not.
+//
+// Retractions are read from the go.mod file of the latest version of the
+// module, so that a module author can retract versions published before
+// the retract directive was added (including the latest version itself).
+// Errors loading the retractions are ignored: a version whose
+// retractions cannot be read is treated as not retracted.
+func CheckRetractions(m module.Version) error {
+	if m == Target || m.Version == "" || !semver.IsValid(m.Version) {
+		return nil
+	}
+	retractions, err := loadRetractions(m.Path)
+	if err != nil {
+		return nil
+	}
+	var rationale []string
+	isRetracted := false
+	for _, r := range retractions {
+		if semver.Compare(r.Low, m.Version) <= 0 && semver.Compare(m.Version, r.High) <= 0 {
+			isRetracted = true
+			if r.Rationale != "" {
+				rationale = append(rationale, r.Rationale)
+			}
+		}
+	}
+	if isRetracted {
+		return &ModuleRetractedError{Rationale: rationale}
+	}
+	return nil
+}
+
+// isRetracted reports whether m has been retracted by its author.
+func isRetracted(m module.Version) bool {
+	return CheckRetractions(m) != nil
+}
+
+var retractCache par.Cache
+
+// loadRetractions returns the retract statements in the go.mod file
+// of the latest version of the module with the given path.
+func loadRetractions(path string) ([]*modfile.Retract, error) {
+	type cached struct {
+		retract []*modfile.Retract
+		err     error
+	}
+	c := retractCache.Do(path, func() interface{} {
+		info, err := queryModule(path, "latest", Allowed, false)
+		if err != nil {
+			return cached{nil, err}
+		}
+		latest := module.Version{Path: path, Version: info.Version}
+		data, err := latestGoMod(latest)
+		if err != nil {
+			return cached{nil, err}
+		}
+		f, err := modfile.ParseLax("go.mod", data, nil)
+		if err != nil {
+			return cached{nil, err}
+		}
+		return cached{f.Retract, nil}
+	}).(cached)
+	return c.retract, c.err
+}
+
+// latestGoMod returns the contents of the go.mod file for the module
+// version m, applying any replacement from the main module's go.mod.
+func latestGoMod(m module.Version) ([]byte, error) {
+	if repl := Replacement(m); repl.Path != "" {
+		if repl.Version == "" {
+			dir := repl.Path
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(ModRoot(), dir)
+			}
+			return ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+		}
+		m = repl
+	}
+	if m.Version == "" {
+		return nil, fmt.Errorf("no version for module %s", m.Path)
+	}
+	return modfetch.GoMod(m.Path, m.Version)
+}
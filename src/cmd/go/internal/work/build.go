@@ -49,6 +49,25 @@ in the last two paragraphs.
 
 The -i flag installs the packages that are dependencies of the target.
 
+The build, install, and run commands also accept coverage flags:
+
+	-cover
+		enable code coverage instrumentation. A program built with
+		-cover writes the values of its coverage counters to a new
+		file in the directory named by the GOCOVERDIR environment
+		variable each time it exits, by returning from main.main or
+		by calling os.Exit. Use 'go tool covdata' to merge the files
+		written by many runs and report on them.
+	-covermode set,count,atomic
+		set the mode for coverage analysis, as for 'go test'.
+		The default is "set" unless -race is enabled,
+		in which case it is "atomic". Sets -cover.
+	-coverpkg pattern1,pattern2,pattern3
+		instrument the packages matching the patterns, which can be
+		any packages the program depends on. The default is to
+		instrument the packages in the main module or, in GOPATH
+		mode, the packages named on the command line. Sets -cover.
+
 The build flags are shared by the build, clean, get, install, list, run,
 and test commands:
 
@@ -112,6 +131,12 @@ and test commands:
 		a program to use to invoke toolchain programs like vet and asm.
 		For example, instead of running asm, the go command will run
 		'cmd args /path/to/asm <arguments for asm>'.
+	-trimpath
+		remove all file system paths from the resulting executable.
+		Instead of absolute file system paths, the recorded file names
+		will begin with either "go" (for the standard library),
+		or a module path@version (when using modules),
+		or a plain import path (when using GOPATH).
 
 The -asmflags, -gccgoflags, -gcflags, and -ldflags flags accept a
 space-separated list of arguments to pass to an underlying tool
@@ -159,6 +184,8 @@ func init() {
 
 	AddBuildFlags(CmdBuild)
 	AddBuildFlags(CmdInstall)
+	AddCoverFlags(CmdBuild)
+	AddCoverFlags(CmdInstall)
 }
 
 // Note that flags consulted by other parts of the code
@@ -229,12 +256,37 @@ func AddBuildFlags(cmd *base.Command) {
 	cmd.Flag.BoolVar(&cfg.BuildMSan, "msan", false, "")
 	cmd.Flag.Var((*base.StringsFlag)(&cfg.BuildContext.BuildTags), "tags", "")
 	cmd.Flag.Var((*base.StringsFlag)(&cfg.BuildToolexec), "toolexec", "")
+	cmd.Flag.BoolVar(&cfg.BuildTrimpath, "trimpath", false, "")
 	cmd.Flag.BoolVar(&cfg.BuildWork, "work", false, "")
 
 	// Undocumented, unstable debugging flags.
 	cmd.Flag.StringVar(&cfg.DebugActiongraph, "debug-actiongraph", "", "")
 }
 
+// AddCoverFlags adds the coverage flags to the build, install, and run
+// commands. The test command has its own, with the same names.
+func AddCoverFlags(cmd *base.Command) {
+	cmd.Flag.BoolVar(&cfg.BuildCover, "cover", false, "")
+	cmd.Flag.StringVar(&cfg.BuildCoverMode, "covermode", "", "")
+	cmd.Flag.Var(coverPkgFlag{}, "coverpkg", "")
+}
+
+// coverPkgFlag implements flag.Value for the comma-separated
+// list of patterns given to -coverpkg.
+type coverPkgFlag struct{}
+
+func (coverPkgFlag) Set(value string) error {
+	cfg.BuildCoverPkg = []string{}
+	if value != "" {
+		cfg.BuildCoverPkg = strings.Split(value, ",")
+	}
+	return nil
+}
+
+func (coverPkgFlag) String() string {
+	return strings.Join(cfg.BuildCoverPkg, ",")
+}
+
 // fileExtSplit expects a filename and returns the name
 // and ext (without the dot). If the file has no
 // extension, ext will be empty.
@@ -282,6 +334,9 @@ func runBuild(cmd *base.Command, args []string) {
 	b.Init()
 
 	pkgs := load.PackagesForBuild(args)
+	if cfg.BuildCover {
+		load.PrepareForCoverageBuild(pkgs)
+	}
 
 	if len(pkgs) == 1 && pkgs[0].Name == "main" && cfg.BuildO == "" {
 		cfg.BuildO = load.DefaultExecName(pkgs[0].ImportPath)
@@ -348,6 +403,16 @@ Install compiles and installs the packages named by the import paths.
 
 The -i flag installs the dependencies of the named packages as well.
 
+If the arguments have version suffixes (like @latest or @v1.0.0), go install
+builds packages in module-aware mode, ignoring the go.mod file in the current
+directory or any parent directory, if there is one. This is useful for
+installing executables without affecting the dependencies of the main module.
+All arguments must refer to packages in the same module at the same version,
+must be package paths or patterns (not relative or absolute paths), and must
+name main packages. No module is considered the main module, so replace and
+exclude directives in the named module's go.mod file are not applied.
+Module-aware mode cannot be disabled for path@version arguments.
+
 For more about the build flags, see 'go help build'.
 For more about specifying packages, see 'go help packages'.
 
@@ -411,7 +476,18 @@ func libname(args []string, pkgs []*load.Package) (string, error) {
 	return "lib" + libname + ".so", nil
 }
 
+// ModInstallVersion is set by package modget to implement
+// 'go install path@version' in module mode.
+var ModInstallVersion func(args []string)
+
 func runInstall(cmd *base.Command, args []string) {
+	for _, arg := range args {
+		if strings.Contains(arg, "@") && !strings.HasSuffix(arg, ".go") {
+			ModInstallVersion(args)
+			return
+		}
+	}
+
 	BuildInit()
 	InstallPackages(args, load.PackagesForBuild(args))
 }
@@ -439,6 +515,9 @@ func InstallPackages(patterns []string, pkgs []*load.Package) {
 	}
 
 	pkgs = omitTestOnly(pkgsFilter(pkgs))
+	if cfg.BuildCover {
+		load.PrepareForCoverageBuild(pkgs)
+	}
 	for _, p := range pkgs {
 		if p.Target == "" {
 			switch {
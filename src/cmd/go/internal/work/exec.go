@@ -190,8 +190,15 @@ func (b *Builder) buildActionID(a *Action) cache.ActionID {
 	// but it does not hide the exact value of $GOPATH.
 	// Include the full dir in that case.
 	// Assume b.WorkDir is being trimmed properly.
-	if !p.Goroot && !strings.HasPrefix(p.Dir, b.WorkDir) {
+	// With -trimpath the directory is replaced by the import path
+	// (or module path and version), so record that instead.
+	if !p.Goroot && !cfg.BuildTrimpath && !strings.HasPrefix(p.Dir, b.WorkDir) {
 		fmt.Fprintf(h, "dir %s\n", p.Dir)
+	} else if cfg.BuildTrimpath && p.Module != nil {
+		fmt.Fprintf(h, "module %s@%s\n", p.Module.Path, p.Module.Version)
+	}
+	if cfg.BuildTrimpath {
+		fmt.Fprintf(h, "trimpath\n")
 	}
 	fmt.Fprintf(h, "goos %s goarch %s\n", cfg.Goos, cfg.Goarch)
 	fmt.Fprintf(h, "import %q\n", p.ImportPath)
@@ -213,6 +220,9 @@ func (b *Builder) buildActionID(a *Action) cache.ActionID {
 	}
 	if p.Internal.CoverMode != "" {
 		fmt.Fprintf(h, "cover %q %q\n", p.Internal.CoverMode, b.toolID("cover"))
+		if cfg.BuildCover {
+			fmt.Fprintf(h, "coverregister\n")
+		}
 	}
 	fmt.Fprintf(h, "modinfo %q\n", p.Internal.BuildInfo)
 
@@ -644,12 +654,21 @@ func (b *Builder) build(a *Action) (err error) {
 	}
 
 	if p.Internal.BuildInfo != "" && cfg.ModulesEnabled {
-		if err := b.writeFile(objdir+"_gomod_.go", load.ModInfoProg(p.Internal.BuildInfo)); err != nil {
+		if err := b.writeFile(objdir+"_gomod_.go", load.ModInfoProg(p.Internal.BuildInfo, cfg.BuildToolchainName == "gccgo")); err != nil {
 			return err
 		}
 		gofiles = append(gofiles, objdir+"_gomod_.go")
 	}
 
+	// With 'go build -cover', register the coverage counters
+	// so that they are written out when the program exits.
+	if cfg.BuildCover && len(p.Internal.CoverVars) > 0 {
+		if err := b.writeFile(objdir+"_coverreg_.go", load.CoverRegisterProg(p)); err != nil {
+			return err
+		}
+		gofiles = append(gofiles, objdir+"_coverreg_.go")
+	}
+
 	// Compile Go.
 	objpkg := objdir + "_pkg_.a"
 	ofile, out, err := BuildToolchain.gc(b, a, objpkg, icfg.Bytes(), symabis, len(sfiles) > 0, gofiles)
@@ -1130,8 +1149,13 @@ func (b *Builder) printLinkerConfig(h io.Writer, p *load.Package) {
 		}
 		fmt.Fprintf(h, "GO$GOARCH=%s\n", os.Getenv("GO"+strings.ToUpper(cfg.BuildContext.GOARCH))) // GO386, GOARM, etc
 
-		// The linker writes source file paths that say GOROOT_FINAL.
-		fmt.Fprintf(h, "GOROOT=%s\n", cfg.GOROOT_FINAL)
+		// The linker writes source file paths that say GOROOT_FINAL,
+		// or "go" when -trimpath is set (see ld in gc.go).
+		gorootFinal := cfg.GOROOT_FINAL
+		if cfg.BuildTrimpath {
+			gorootFinal = trimPathGoRootFinal
+		}
+		fmt.Fprintf(h, "GOROOT=%s\n", gorootFinal)
 
 		// TODO(rsc): Convince linker team not to add more magic environment variables,
 		// or perhaps restrict the environment variables passed to subprocesses.
@@ -2115,7 +2139,19 @@ func (b *Builder) ccompile(a *Action, p *load.Package, outfile string, flags []s
 	if !filepath.IsAbs(outfile) {
 		outfile = filepath.Join(p.Dir, outfile)
 	}
-	output, err := b.runOut(filepath.Dir(file), b.cCompilerEnv(), compiler, flags, "-o", outfile, "-c", filepath.Base(file))
+	var trimflags []string
+	if cfg.BuildTrimpath && b.gccSupportsFlag(compiler, "-fdebug-prefix-map=a=b") {
+		// Rewrite source paths in the C debug information
+		// the same way Action.trimpath does for Go files.
+		from, to := p.Dir, p.ImportPath
+		if p.Goroot {
+			from, to = cfg.GOROOT, trimPathGoRootFinal
+		} else if m := p.Module; m != nil && m.Version != "" {
+			to = m.Path + "@" + m.Version + strings.TrimPrefix(p.ImportPath, m.Path)
+		}
+		trimflags = append(trimflags, "-fdebug-prefix-map="+from+"="+to)
+	}
+	output, err := b.runOut(filepath.Dir(file), b.cCompilerEnv(), compiler, flags, trimflags, "-o", outfile, "-c", filepath.Base(file))
 	if len(output) > 0 {
 		// On FreeBSD 11, when we pass -g to clang 3.8 it
 		// invokes its internal assembler with -dwarf-version=2.
@@ -22,6 +22,7 @@ func BuildInit() {
 	load.ModInit()
 	instrumentInit()
 	buildModeInit()
+	coverInit()
 
 	// Make sure -pkgdir is absolute, because we run commands
 	// in different directories.
@@ -35,6 +36,31 @@ func BuildInit() {
 	}
 }
 
+func coverInit() {
+	if cfg.BuildCoverMode != "" || cfg.BuildCoverPkg != nil {
+		cfg.BuildCover = true
+	}
+	if !cfg.BuildCover {
+		return
+	}
+	switch cfg.BuildCoverMode {
+	case "":
+		// Default coverage mode is atomic when -race is set.
+		cfg.BuildCoverMode = "set"
+		if cfg.BuildRace {
+			cfg.BuildCoverMode = "atomic"
+		}
+	case "set", "count", "atomic":
+	default:
+		fmt.Fprintf(os.Stderr, "go %s: invalid flag argument for -covermode: %q\n", flag.Args()[0], cfg.BuildCoverMode)
+		os.Exit(2)
+	}
+	if cfg.BuildRace && cfg.BuildCoverMode != "atomic" {
+		fmt.Fprintf(os.Stderr, "go %s: -covermode must be \"atomic\", not %q, when -race is enabled\n", flag.Args()[0], cfg.BuildCoverMode)
+		os.Exit(2)
+	}
+}
+
 func instrumentInit() {
 	if !cfg.BuildRace && !cfg.BuildMSan {
 		return
@@ -466,7 +466,7 @@ func (f *xcoffFile) getXCOFFscnum(sect *sym.Section) int16 {
 	case &Segtext:
 		return f.sectNameToScnum[".text"]
 	case &Segdata:
-		if sect.Name == ".noptrdata" || sect.Name == ".data" {
+		if sect.Name == ".noptrdata" || sect.Name == ".data" || sect.Name == ".go.buildinfo" {
 			return f.sectNameToScnum[".data"]
 		}
 		if sect.Name == ".noptrbss" || sect.Name == ".bss" {
@@ -93,6 +93,7 @@ const (
 	extensionCertificateAuthorities  uint16 = 47
 	extensionSignatureAlgorithmsCert uint16 = 50
 	extensionKeyShare                uint16 = 51
+	extensionQUICTransportParameters uint16 = 57
 	extensionNextProtoNeg            uint16 = 13172 // not IANA assigned
 	extensionRenegotiationInfo       uint16 = 0xff01
 )
@@ -766,7 +767,9 @@ var supportedVersions = []uint16{
 	VersionSSL30,
 }
 
-func (c *Config) supportedVersions(isClient bool) []uint16 {
+// supportedVersions returns the versions enabled by c, for the client
+// side if isClient is set, of a QUIC connection if quic is set.
+func (c *Config) supportedVersions(isClient, quic bool) []uint16 {
 	versions := make([]uint16, 0, len(supportedVersions))
 	for _, v := range supportedVersions {
 		if c != nil && c.MinVersion != 0 && v < c.MinVersion {
@@ -779,8 +782,9 @@ func (c *Config) supportedVersions(isClient bool) []uint16 {
 		if isClient && v < VersionTLS10 {
 			continue
 		}
-		// TLS 1.3 is opt-in in Go 1.12.
-		if v == VersionTLS13 && !isTLS13Supported() {
+		// TLS 1.3 is opt-in in Go 1.12, through GODEBUG, except for
+		// QUIC connections, which require it (RFC 9001, Section 4.2).
+		if v == VersionTLS13 && !isTLS13Supported() && !quic {
 			continue
 		}
 		versions = append(versions, v)
@@ -826,8 +830,8 @@ func goDebugString(key string) string {
 	return ""
 }
 
-func (c *Config) maxSupportedVersion(isClient bool) uint16 {
-	supportedVersions := c.supportedVersions(isClient)
+func (c *Config) maxSupportedVersion(isClient, quic bool) uint16 {
+	supportedVersions := c.supportedVersions(isClient, quic)
 	if len(supportedVersions) == 0 {
 		return 0
 	}
@@ -859,8 +863,8 @@ func (c *Config) curvePreferences() []CurveID {
 
 // mutualVersion returns the protocol version to use given the advertised
 // versions of the peer. Priority is given to the peer preference order.
-func (c *Config) mutualVersion(isClient bool, peerVersions []uint16) (uint16, bool) {
-	supportedVersions := c.supportedVersions(isClient)
+func (c *Config) mutualVersion(isClient, quic bool, peerVersions []uint16) (uint16, bool) {
+	supportedVersions := c.supportedVersions(isClient, quic)
 	for _, peerVersion := range peerVersions {
 		for _, v := range supportedVersions {
 			if v == peerVersion {
@@ -49,7 +49,7 @@ func (c *Conn) makeClientHello() (*clientHelloMsg, ecdheParameters, error) {
 		return nil, nil, errors.New("tls: NextProtos values too large")
 	}
 
-	supportedVersions := config.supportedVersions(true)
+	supportedVersions := config.supportedVersions(true, c.quic != nil)
 	if len(supportedVersions) == 0 {
 		return nil, nil, errors.New("tls: no supported versions satisfy MinVersion and MaxVersion")
 	}
@@ -108,8 +108,11 @@ NextCipherSuite:
 
 	// A random session ID is used to detect when the server accepted a ticket
 	// and is resuming a session (see RFC 5077). In TLS 1.3, it's always set as
-	// a compatibility measure (see RFC 8446, Section 4.1.2).
-	if _, err := io.ReadFull(config.rand(), hello.sessionId); err != nil {
+	// a compatibility measure (see RFC 8446, Section 4.1.2), except over QUIC
+	// (see RFC 9001, Section 8.4).
+	if c.quic != nil {
+		hello.sessionId = nil
+	} else if _, err := io.ReadFull(config.rand(), hello.sessionId); err != nil {
 		return nil, nil, errors.New("tls: short read from Rand: " + err.Error())
 	}
 
@@ -132,6 +135,14 @@ NextCipherSuite:
 		hello.keyShares = []keyShare{{group: curveID, data: params.PublicKey()}}
 	}
 
+	if c.quic != nil {
+		p, err := c.quicGetTransportParameters()
+		if err != nil {
+			return nil, nil, err
+		}
+		hello.quicTransportParameters = p
+	}
+
 	return hello, params, nil
 }
 
@@ -220,7 +231,8 @@ func (c *Conn) clientHandshake() (err error) {
 
 func (c *Conn) loadSession(hello *clientHelloMsg) (cacheKey string,
 	session *ClientSessionState, earlySecret, binderKey []byte) {
-	if c.config.SessionTicketsDisabled || c.config.ClientSessionCache == nil {
+	// Session resumption is not supported over QUIC.
+	if c.config.SessionTicketsDisabled || c.config.ClientSessionCache == nil || c.quic != nil {
 		return "", nil, nil, nil
 	}
 
@@ -340,7 +352,7 @@ func (c *Conn) pickTLSVersion(serverHello *serverHelloMsg) error {
 		peerVersion = serverHello.supportedVersion
 	}
 
-	vers, ok := c.config.mutualVersion(true, []uint16{peerVersion})
+	vers, ok := c.config.mutualVersion(true, c.quic != nil, []uint16{peerVersion})
 	if !ok {
 		c.sendAlert(alertProtocolVersion)
 		return fmt.Errorf("tls: server selected unsupported protocol version %x", peerVersion)
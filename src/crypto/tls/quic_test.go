@@ -0,0 +1,213 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+type testQUICConn struct {
+	t           *testing.T
+	conn        *QUICConn
+	readSecret  map[QUICEncryptionLevel][]byte
+	writeSecret map[QUICEncryptionLevel][]byte
+	gotParams   []byte
+	complete    bool
+}
+
+func newTestQUICClient(t *testing.T, config *Config) *testQUICConn {
+	q := &testQUICConn{t: t, conn: QUICClient(&QUICConfig{TLSConfig: config})}
+	return q.init()
+}
+
+func newTestQUICServer(t *testing.T, config *Config) *testQUICConn {
+	q := &testQUICConn{t: t, conn: QUICServer(&QUICConfig{TLSConfig: config})}
+	return q.init()
+}
+
+func (q *testQUICConn) init() *testQUICConn {
+	q.readSecret = make(map[QUICEncryptionLevel][]byte)
+	q.writeSecret = make(map[QUICEncryptionLevel][]byte)
+	return q
+}
+
+var errTransportParametersRequired = errors.New("transport parameters required")
+
+// runTestQUICConnection runs a QUIC handshake between cli and srv,
+// passing the handshake data each produces to the other.
+func runTestQUICConnection(ctx context.Context, cli, srv *testQUICConn) error {
+	a, b := cli, srv
+	for _, c := range []*testQUICConn{a, b} {
+		if !c.conn.conn.quic.started {
+			if err := c.conn.Start(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	idleCount := 0
+	for {
+		e := a.conn.NextEvent()
+		switch e.Kind {
+		case QUICNoEvent:
+			idleCount++
+			if idleCount == 2 {
+				if !a.complete || !b.complete {
+					return errors.New("handshake incomplete")
+				}
+				return nil
+			}
+			a, b = b, a
+		case QUICSetReadSecret:
+			a.readSecret[e.Level] = append([]byte(nil), e.Data...)
+		case QUICSetWriteSecret:
+			a.writeSecret[e.Level] = append([]byte(nil), e.Data...)
+		case QUICWriteData:
+			if err := b.conn.HandleData(e.Level, e.Data); err != nil {
+				return err
+			}
+		case QUICTransportParameters:
+			a.gotParams = append([]byte(nil), e.Data...)
+		case QUICTransportParametersRequired:
+			return errTransportParametersRequired
+		case QUICHandshakeDone:
+			a.complete = true
+		}
+		if e.Kind != QUICNoEvent {
+			idleCount = 0
+		}
+	}
+}
+
+func testQUICConfig() *Config {
+	config := testConfig.Clone()
+	config.MinVersion = VersionTLS13
+	config.NextProtos = []string{"h3"}
+	return config
+}
+
+func TestQUICConnection(t *testing.T) {
+	config := testQUICConfig()
+	cli := newTestQUICClient(t, config)
+	cli.conn.SetTransportParameters([]byte("client params"))
+	defer cli.conn.Close()
+	srv := newTestQUICServer(t, config)
+	srv.conn.SetTransportParameters([]byte("server params"))
+	defer srv.conn.Close()
+	if err := runTestQUICConnection(context.Background(), cli, srv); err != nil {
+		t.Fatalf("error during connection handshake: %v", err)
+	}
+
+	for _, level := range []QUICEncryptionLevel{QUICEncryptionLevelHandshake, QUICEncryptionLevelApplication} {
+		if cli.writeSecret[level] == nil || !bytes.Equal(cli.writeSecret[level], srv.readSecret[level]) {
+			t.Errorf("client write secret does not match server read secret at level %v", level)
+		}
+		if srv.writeSecret[level] == nil || !bytes.Equal(srv.writeSecret[level], cli.readSecret[level]) {
+			t.Errorf("server write secret does not match client read secret at level %v", level)
+		}
+	}
+	if got, want := string(cli.gotParams), "server params"; got != want {
+		t.Errorf("client got transport parameters %q; want %q", got, want)
+	}
+	if got, want := string(srv.gotParams), "client params"; got != want {
+		t.Errorf("server got transport parameters %q; want %q", got, want)
+	}
+
+	for _, q := range []*testQUICConn{cli, srv} {
+		cs := q.conn.ConnectionState()
+		if cs.Version != VersionTLS13 || cs.NegotiatedProtocol != "h3" {
+			t.Errorf("connection state: version %x, protocol %q; want TLS 1.3, %q", cs.Version, cs.NegotiatedProtocol, "h3")
+		}
+	}
+}
+
+func TestQUICDelayedTransportParameters(t *testing.T) {
+	config := testQUICConfig()
+	cli := newTestQUICClient(t, config)
+	cli.conn.SetTransportParameters([]byte("client params"))
+	defer cli.conn.Close()
+	srv := newTestQUICServer(t, config)
+	defer srv.conn.Close()
+
+	if err := runTestQUICConnection(context.Background(), cli, srv); err != errTransportParametersRequired {
+		t.Fatalf("handshake with no server parameters: %v; want errTransportParametersRequired", err)
+	}
+	if got, want := string(srv.gotParams), "client params"; got != want {
+		t.Errorf("server got transport parameters %q; want %q", got, want)
+	}
+	srv.conn.SetTransportParameters([]byte("server params"))
+	if err := runTestQUICConnection(context.Background(), cli, srv); err != nil {
+		t.Fatalf("error during connection handshake: %v", err)
+	}
+	if got, want := string(cli.gotParams), "server params"; got != want {
+		t.Errorf("client got transport parameters %q; want %q", got, want)
+	}
+}
+
+func TestQUICNoApplicationProtocol(t *testing.T) {
+	clientConfig := testQUICConfig()
+	clientConfig.NextProtos = []string{"h2"}
+	cli := newTestQUICClient(t, clientConfig)
+	cli.conn.SetTransportParameters(nil)
+	defer cli.conn.Close()
+	srv := newTestQUICServer(t, testQUICConfig())
+	srv.conn.SetTransportParameters(nil)
+	defer srv.conn.Close()
+
+	err := runTestQUICConnection(context.Background(), cli, srv)
+	if ae, ok := err.(*AlertError); !ok || alert(ae.Alert) != alertNoApplicationProtocol {
+		t.Fatalf("handshake with no common protocol: %v; want no_application_protocol alert", err)
+	}
+}
+
+func TestQUICRequiresTLS13(t *testing.T) {
+	config := testConfig.Clone()
+	config.MinVersion = VersionTLS12
+	q := QUICClient(&QUICConfig{TLSConfig: config})
+	if err := q.Start(context.Background()); err == nil {
+		t.Fatal("Start with MinVersion TLS 1.2 succeeded")
+	}
+}
+
+// TestQUICWithoutTLS13GODEBUG checks that QUIC connections use TLS 1.3
+// even when GODEBUG does not enable it, and that other connections
+// requiring TLS 1.3 do not.
+func TestQUICWithoutTLS13GODEBUG(t *testing.T) {
+	tls13Support.Do(func() {})
+	defer func(saved bool) { tls13Support.cached = saved }(tls13Support.cached)
+	tls13Support.cached = false
+
+	config := testQUICConfig()
+	if vers := config.supportedVersions(true, false); len(vers) != 0 {
+		t.Errorf("supported versions of a TLS connection with MinVersion TLS 1.3 = %x; want none", vers)
+	}
+
+	cli := newTestQUICClient(t, config)
+	cli.conn.SetTransportParameters(nil)
+	defer cli.conn.Close()
+	srv := newTestQUICServer(t, config)
+	srv.conn.SetTransportParameters(nil)
+	defer srv.conn.Close()
+	if err := runTestQUICConnection(context.Background(), cli, srv); err != nil {
+		t.Fatalf("error during connection handshake: %v", err)
+	}
+	if v := cli.conn.ConnectionState().Version; v != VersionTLS13 {
+		t.Errorf("QUIC connection version = %x; want TLS 1.3", v)
+	}
+}
+
+func TestQUICCanceledHandshake(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := QUICServer(&QUICConfig{TLSConfig: testQUICConfig()})
+	if err := srv.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := srv.Close(); err == nil {
+		t.Fatal("Close of canceled handshake returned nil error")
+	}
+}
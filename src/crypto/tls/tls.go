@@ -13,6 +13,9 @@
 //     func init() {
 //         os.Setenv("GODEBUG", os.Getenv("GODEBUG")+",tls13=1")
 //     }
+//
+// QUIC connections, made with QUICClient and QUICServer, always use
+// TLS 1.3, regardless of the GODEBUG setting.
 package tls
 
 // BUG(agl): The crypto/tls package only implements some countermeasures
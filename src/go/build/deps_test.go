@@ -185,12 +185,13 @@ var pkgDeps = map[string][]string{
 	"log": {"L1", "os", "fmt", "time"},
 
 	// Packages used by testing must be low-level (L2+fmt).
-	"regexp":         {"L2", "regexp/syntax"},
-	"regexp/syntax":  {"L2"},
-	"runtime/debug":  {"L2", "fmt", "io/ioutil", "os", "time"},
-	"runtime/pprof":  {"L2", "compress/gzip", "context", "encoding/binary", "fmt", "io/ioutil", "os", "text/tabwriter", "time"},
-	"runtime/trace":  {"L0", "context", "fmt"},
-	"text/tabwriter": {"L2"},
+	"regexp":           {"L2", "regexp/syntax"},
+	"regexp/syntax":    {"L2"},
+	"runtime/coverage": {"L2", "fmt", "os", "path/filepath", "time"},
+	"runtime/debug":    {"L2", "fmt", "io/ioutil", "os", "time"},
+	"runtime/pprof":    {"L2", "compress/gzip", "context", "encoding/binary", "fmt", "io/ioutil", "os", "text/tabwriter", "time"},
+	"runtime/trace":    {"L0", "context", "fmt"},
+	"text/tabwriter":   {"L2"},
 
 	"testing":          {"L2", "flag", "fmt", "internal/race", "os", "runtime/debug", "runtime/pprof", "runtime/trace", "time"},
 	"testing/iotest":   {"L2", "log"},
@@ -388,7 +389,7 @@ var pkgDeps = map[string][]string{
 
 	// SSL/TLS.
 	"crypto/tls": {
-		"L4", "CRYPTO-MATH", "OS", "internal/x/crypto/cryptobyte", "internal/x/crypto/hkdf",
+		"L4", "CRYPTO-MATH", "OS", "context", "internal/x/crypto/cryptobyte", "internal/x/crypto/hkdf",
 		"container/list", "crypto/x509", "encoding/pem", "net", "syscall",
 	},
 	"crypto/x509": {
@@ -406,6 +407,7 @@ var pkgDeps = map[string][]string{
 	"net/http": {
 		"L4", "NET", "OS",
 		"compress/gzip",
+		"compress/zlib",
 		"container/list",
 		"context",
 		"crypto/rand",
@@ -420,16 +422,24 @@ var pkgDeps = map[string][]string{
 		"mime/multipart",
 		"net/http/httptrace",
 		"net/http/internal",
+		"net/http/internal/qpack",
+		"net/http/internal/quic",
 		"runtime/debug",
 		"syscall/js",
 	},
-	"net/http/internal":  {"L4"},
+	"net/http/internal":       {"L4"},
+	"net/http/internal/qpack": {"L4", "internal/x/net/http2/hpack"},
+	"net/http/internal/quic": {
+		"L4", "NET", "CRYPTO", "context", "crypto/rand", "crypto/tls",
+		"internal/x/crypto/chacha20poly1305", "internal/x/crypto/hkdf",
+	},
 	"net/http/httptrace": {"context", "crypto/tls", "internal/nettrace", "net", "net/textproto", "reflect", "time"},
 
 	// HTTP-using packages.
 	"expvar":             {"L4", "OS", "encoding/json", "net/http"},
 	"net/http/cgi":       {"L4", "NET", "OS", "crypto/tls", "net/http", "regexp"},
-	"net/http/cookiejar": {"L4", "NET", "net/http"},
+	"net/dnstransport":   {"L4", "NET", "context", "crypto/tls", "net/http"},
+	"net/http/cookiejar": {"L4", "NET", "encoding/json", "net/http"},
 	"net/http/fcgi":      {"L4", "NET", "OS", "context", "net/http", "net/http/cgi"},
 	"net/http/httptest": {
 		"L4", "NET", "OS", "crypto/tls", "flag", "net/http", "net/http/internal", "crypto/x509",
@@ -437,8 +447,12 @@ var pkgDeps = map[string][]string{
 	},
 	"net/http/httputil": {"L4", "NET", "OS", "context", "net/http", "net/http/internal", "internal/x/net/http/httpguts"},
 	"net/http/pprof":    {"L4", "OS", "html/template", "net/http", "runtime/pprof", "runtime/trace"},
-	"net/rpc":           {"L4", "NET", "encoding/gob", "html/template", "net/http"},
-	"net/rpc/jsonrpc":   {"L4", "NET", "encoding/json", "net/rpc"},
+	"net/http/websocket": {
+		"L4", "NET", "compress/flate", "context", "crypto/rand", "crypto/sha1", "crypto/tls", "io/ioutil",
+		"net/http", "internal/x/net/http/httpguts",
+	},
+	"net/rpc":         {"L4", "NET", "encoding/gob", "html/template", "net/http"},
+	"net/rpc/jsonrpc": {"L4", "NET", "encoding/json", "net/rpc"},
 }
 
 // isMacro reports whether p is a package dependency macro
@@ -1135,3 +1135,70 @@ func largeTestMsg() Message {
 		},
 	}
 }
+
+func TestUnknownPackUnpack(t *testing.T) {
+	name := mustNewName("example.com.")
+	msg := Message{
+		Header: Header{Response: true, AuthenticData: true, CheckingDisabled: true},
+		Questions: []Question{
+			{Name: name, Type: Type(257), Class: ClassINET},
+		},
+		Answers: []Resource{
+			{
+				ResourceHeader{Name: name, Type: Type(257), Class: ClassINET, TTL: 300, Length: 22},
+				&UnknownResource{Type: Type(257), Data: []byte("\x00\x05issueletsencrypt.org")},
+			},
+		},
+		Authorities: []Resource{},
+		Additionals: []Resource{},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatal("Pack:", err)
+	}
+	var got Message
+	if err := got.Unpack(b); err != nil {
+		t.Fatal("Unpack:", err)
+	}
+	if !reflect.DeepEqual(got, msg) {
+		t.Errorf("got = %+v, want = %+v", &got, &msg)
+	}
+
+	bld := NewBuilder(nil, msg.Header)
+	bld.EnableCompression()
+	bld.StartQuestions()
+	bld.Question(msg.Questions[0])
+	bld.StartAnswers()
+	if err := bld.UnknownResource(msg.Answers[0].Header, *msg.Answers[0].Body.(*UnknownResource)); err != nil {
+		t.Fatal("Builder.UnknownResource:", err)
+	}
+	built, err := bld.Finish()
+	if err != nil {
+		t.Fatal("Builder.Finish:", err)
+	}
+	if !bytes.Equal(built, b) {
+		t.Errorf("Builder: got %#v\nwant %#v", built, b)
+	}
+
+	var p Parser
+	h, err := p.Start(b)
+	if err != nil {
+		t.Fatal("Parser.Start:", err)
+	}
+	if !h.AuthenticData || !h.CheckingDisabled {
+		t.Errorf("Parser.Start: got header %+v, want AuthenticData and CheckingDisabled", h)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		t.Fatal("Parser.SkipAllQuestions:", err)
+	}
+	if _, err := p.AnswerHeader(); err != nil {
+		t.Fatal("Parser.AnswerHeader:", err)
+	}
+	r, err := p.UnknownResource()
+	if err != nil {
+		t.Fatal("Parser.UnknownResource:", err)
+	}
+	if want := msg.Answers[0].Body.(*UnknownResource); !reflect.DeepEqual(&r, want) {
+		t.Errorf("Parser.UnknownResource: got %+v, want %+v", r, want)
+	}
+}
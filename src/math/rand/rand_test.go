@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package rand
+package rand_test
 
 import (
 	"bytes"
@@ -11,6 +11,7 @@ import (
 	"internal/testenv"
 	"io"
 	"math"
+	. "math/rand"
 	"os"
 	"runtime"
 	"testing"
@@ -212,6 +213,7 @@ func TestNonStandardExponentialValues(t *testing.T) {
 
 func initNorm() (testKn []uint32, testWn, testFn []float32) {
 	const m1 = 1 << 31
+	rn, _, _, _ := GetNormalDistributionParameters()
 	var (
 		dn float64 = rn
 		tn         = dn
@@ -241,6 +243,7 @@ func initNorm() (testKn []uint32, testWn, testFn []float32) {
 
 func initExp() (testKe []uint32, testWe, testFe []float32) {
 	const m2 = 1 << 32
+	re, _, _, _ := GetExponentialDistributionParameters()
 	var (
 		de float64 = re
 		te         = de
@@ -306,6 +309,7 @@ func compareFloat32Slices(s1, s2 []float32) int {
 
 func TestNormTables(t *testing.T) {
 	testKn, testWn, testFn := initNorm()
+	_, kn, wn, fn := GetNormalDistributionParameters()
 	if i := compareUint32Slices(kn[0:], testKn); i >= 0 {
 		t.Errorf("kn disagrees at index %v; %v != %v", i, kn[i], testKn[i])
 	}
@@ -319,6 +323,7 @@ func TestNormTables(t *testing.T) {
 
 func TestExpTables(t *testing.T) {
 	testKe, testWe, testFe := initExp()
+	_, ke, we, fe := GetExponentialDistributionParameters()
 	if i := compareUint32Slices(ke[0:], testKe); i >= 0 {
 		t.Errorf("ke disagrees at index %v; %v != %v", i, ke[i], testKe[i])
 	}
@@ -503,7 +508,7 @@ func TestUniformFactorial(t *testing.T) {
 				fn   func() int
 			}{
 				{name: "Int31n", fn: func() int { return int(r.Int31n(int32(nfact))) }},
-				{name: "int31n", fn: func() int { return int(r.int31n(int32(nfact))) }},
+				{name: "int31n", fn: func() int { return int(Int31nForTest(r, int32(nfact))) }},
 				{name: "Perm", fn: func() int { return encodePerm(r.Perm(n)) }},
 				{name: "Shuffle", fn: func() int {
 					// Generate permutation using Shuffle.
@@ -0,0 +1,297 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package net
+
+import (
+	"sync"
+	"time"
+
+	"internal/x/net/dns/dnsmessage"
+)
+
+// defaultMaxDNSCacheEntries is the default value of
+// DNSCache.MaxEntries.
+const defaultMaxDNSCacheEntries = 10000
+
+// A DNSCache caches the responses of name servers to Go's DNS
+// resolver, for the time to live of their records. Set as the Cache
+// of a Resolver, it answers the queries of every lookup made with
+// Go's resolver, other than those of /etc/hosts.
+//
+// Positive responses are cached for the smallest TTL of their
+// answer records. Negative responses, which report that a name does
+// not exist or has no records of the type asked for, are cached for
+// the TTL given by the SOA record of their authority section, and
+// not at all without one (RFC 2308 section 5). Other responses and
+// failures are never cached.
+//
+// All the lookups of a name and record type share one cached
+// response. The AD bit of a cached response is reported by
+// Resolver.LookupRecords only if the response was cached from one of
+// its queries, which ask for that bit.
+//
+// The zero DNSCache is ready to use. A DNSCache is safe for
+// concurrent use and may be shared by several Resolvers, which
+// should then use the same name servers. A DNSCache must not be
+// copied after first use.
+type DNSCache struct {
+	// MinTTL and MaxTTL clamp how long a response is cached:
+	// a response is kept for at least MinTTL, and for at most
+	// MaxTTL, if non-zero.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// Stale is how long after a response expires it may still be
+	// used to answer a query, while it is refreshed in the
+	// background (RFC 8767). If zero, expired responses are never
+	// used.
+	Stale time.Duration
+
+	// MaxEntries is the maximum number of responses in the cache.
+	// If zero, 10000 is used.
+	MaxEntries int
+
+	now func() time.Time // for tests; time.Now if nil
+
+	mu        sync.Mutex
+	entries   map[dnsCacheKey]*dnsCacheEntry
+	hits      uint64
+	staleHits uint64
+	misses    uint64
+}
+
+// DNSCacheStats contains statistics of a DNSCache.
+type DNSCacheStats struct {
+	Entries   int    // responses in the cache, including expired ones
+	Hits      uint64 // queries answered from the cache
+	StaleHits uint64 // queries answered with an expired response; included in Hits
+	Misses    uint64 // queries sent to a name server
+}
+
+// Stats returns statistics of the cache.
+func (c *DNSCache) Stats() DNSCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return DNSCacheStats{
+		Entries:   len(c.entries),
+		Hits:      c.hits,
+		StaleHits: c.staleHits,
+		Misses:    c.misses,
+	}
+}
+
+// Flush removes all the responses from the cache.
+func (c *DNSCache) Flush() {
+	c.mu.Lock()
+	c.entries = nil
+	c.mu.Unlock()
+}
+
+type dnsCacheKey struct {
+	name  string // lower case
+	qtype dnsmessage.Type
+}
+
+type dnsCacheEntry struct {
+	msg        dnsmessage.Message
+	stored     time.Time
+	expires    time.Time
+	refreshing bool // a query is refreshing the expired response
+	ad         bool // the query asked for the AD bit
+}
+
+func newDNSCacheKey(q dnsmessage.Question) dnsCacheKey {
+	name := q.Name.Data[:q.Name.Length]
+	return dnsCacheKey{string(lowerASCIIBytesCopy(name)), q.Type}
+}
+
+// lowerASCIIBytesCopy is like lowerASCIIBytes, but returns a copy.
+func lowerASCIIBytesCopy(x []byte) []byte {
+	b := make([]byte, len(x))
+	copy(b, x)
+	lowerASCIIBytes(b)
+	return b
+}
+
+func (c *DNSCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+func (c *DNSCache) maxEntries() int {
+	if c.MaxEntries > 0 {
+		return c.MaxEntries
+	}
+	return defaultMaxDNSCacheEntries
+}
+
+// get returns the cached response to q, positioned at its answer
+// section, with its TTLs reduced by its age. The AD bit of the
+// response is kept only if ad is set and the cached query also asked
+// for it. It reports whether there is a response, and whether it has
+// expired and the caller should refresh it.
+func (c *DNSCache) get(q dnsmessage.Question, ad bool) (p dnsmessage.Parser, h dnsmessage.Header, ok, refresh bool) {
+	key := newDNSCacheKey(q)
+	now := c.clock()
+	c.mu.Lock()
+	e := c.entries[key]
+	if e != nil && now.After(e.expires.Add(c.Stale)) {
+		delete(c.entries, key)
+		e = nil
+	}
+	if e == nil {
+		c.misses++
+		c.mu.Unlock()
+		return dnsmessage.Parser{}, dnsmessage.Header{}, false, false
+	}
+	c.hits++
+	if now.After(e.expires) {
+		c.staleHits++
+		if !e.refreshing {
+			e.refreshing = true
+			refresh = true
+		}
+	}
+	msg, stored := e.msg, e.stored
+	msg.AuthenticData = msg.AuthenticData && ad && e.ad
+	c.mu.Unlock()
+
+	// The entry is never modified, so its resources can be copied
+	// without holding the lock.
+	age := uint32(now.Sub(stored) / time.Second)
+	msg.Answers = agedResources(msg.Answers, age)
+	msg.Authorities = agedResources(msg.Authorities, age)
+	b, err := msg.Pack()
+	if err != nil {
+		return dnsmessage.Parser{}, dnsmessage.Header{}, false, refresh
+	}
+	if h, err = p.Start(b); err != nil {
+		return dnsmessage.Parser{}, dnsmessage.Header{}, false, refresh
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return dnsmessage.Parser{}, dnsmessage.Header{}, false, refresh
+	}
+	return p, h, true, refresh
+}
+
+// agedResources returns a copy of rs with TTLs reduced by age.
+func agedResources(rs []dnsmessage.Resource, age uint32) []dnsmessage.Resource {
+	if len(rs) == 0 {
+		return nil
+	}
+	aged := make([]dnsmessage.Resource, len(rs))
+	copy(aged, rs)
+	for i := range aged {
+		if aged[i].Header.TTL > age {
+			aged[i].Header.TTL -= age
+		} else {
+			aged[i].Header.TTL = 0
+		}
+	}
+	return aged
+}
+
+// put caches the response to q, whose header h has been parsed and
+// whose answer section is next in p, if it may be cached. ad reports
+// whether q asked for the AD bit.
+func (c *DNSCache) put(q dnsmessage.Question, ad bool, p dnsmessage.Parser, h dnsmessage.Header) {
+	msg := dnsmessage.Message{Header: h, Questions: []dnsmessage.Question{q}}
+	var err error
+	if msg.Answers, err = p.AllAnswers(); err == nil {
+		if msg.Authorities, err = p.AllAuthorities(); err == nil {
+			msg.Additionals, err = p.AllAdditionals()
+		}
+	}
+	ttl, ok := dnsCacheTTL(&msg)
+	if err != nil || !ok {
+		c.refreshFailed(q)
+		return
+	}
+	key := newDNSCacheKey(q)
+	now := c.clock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ttl < c.MinTTL {
+		ttl = c.MinTTL
+	}
+	if c.MaxTTL > 0 && ttl > c.MaxTTL {
+		ttl = c.MaxTTL
+	}
+	if c.entries == nil {
+		c.entries = make(map[dnsCacheKey]*dnsCacheEntry)
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries() {
+		c.evictLocked(now)
+	}
+	c.entries[key] = &dnsCacheEntry{
+		msg:     msg,
+		stored:  now,
+		expires: now.Add(ttl),
+		ad:      ad,
+	}
+}
+
+// refreshFailed records that the response to q could not be
+// refreshed. The stale response, if any, keeps being used until a
+// later query refreshes it.
+func (c *DNSCache) refreshFailed(q dnsmessage.Question) {
+	c.mu.Lock()
+	if e := c.entries[newDNSCacheKey(q)]; e != nil {
+		e.refreshing = false
+	}
+	c.mu.Unlock()
+}
+
+// evictLocked makes room for a new entry, removing the responses that
+// can no longer be used or, if there are none, an arbitrary one.
+func (c *DNSCache) evictLocked(now time.Time) {
+	for key, e := range c.entries {
+		if now.After(e.expires.Add(c.Stale)) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.entries {
+		if len(c.entries) < c.maxEntries() {
+			break
+		}
+		delete(c.entries, key)
+	}
+}
+
+// dnsCacheTTL returns how long msg may be cached, and whether it may
+// be cached at all.
+func dnsCacheTTL(msg *dnsmessage.Message) (time.Duration, bool) {
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+		if len(msg.Answers) == 0 && !msg.Authoritative && !msg.RecursionAvailable {
+			// A lame referral; see checkHeader.
+			return 0, false
+		}
+	case dnsmessage.RCodeNameError:
+	default:
+		return 0, false
+	}
+	if len(msg.Answers) > 0 {
+		ttl := msg.Answers[0].Header.TTL
+		for _, rr := range msg.Answers[1:] {
+			if rr.Header.TTL < ttl {
+				ttl = rr.Header.TTL
+			}
+		}
+		return time.Duration(ttl) * time.Second, true
+	}
+	for _, rr := range msg.Authorities {
+		if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+			ttl := rr.Header.TTL
+			if soa.MinTTL < ttl {
+				ttl = soa.MinTTL
+			}
+			return time.Duration(ttl) * time.Second, true
+		}
+	}
+	return 0, false
+}
@@ -40,9 +40,12 @@ var (
 	errServerTemporarlyMisbehaving = errors.New("server misbehaving")
 )
 
-func newRequest(q dnsmessage.Question) (id uint16, udpReq, tcpReq []byte, err error) {
+// newRequest builds a query for q. If ad is set, the query asks the
+// server to report whether it validated the answer with DNSSEC
+// (RFC 6840 section 5.7).
+func newRequest(q dnsmessage.Question, ad bool) (id uint16, udpReq, tcpReq []byte, err error) {
 	id = uint16(rand.Int()) ^ uint16(time.Now().UnixNano())
-	b := dnsmessage.NewBuilder(make([]byte, 2, 514), dnsmessage.Header{ID: id, RecursionDesired: true})
+	b := dnsmessage.NewBuilder(make([]byte, 2, 514), dnsmessage.Header{ID: id, RecursionDesired: true, AuthenticData: ad})
 	b.EnableCompression()
 	if err := b.StartQuestions(); err != nil {
 		return 0, nil, nil, err
@@ -130,13 +133,46 @@ func dnsStreamRoundTrip(c Conn, id uint16, query dnsmessage.Question, b []byte)
 	return p, h, nil
 }
 
-// exchange sends a query on the connection and hopes for a response.
-func (r *Resolver) exchange(ctx context.Context, server string, q dnsmessage.Question, timeout time.Duration) (dnsmessage.Parser, dnsmessage.Header, error) {
+// exchange sends a query on the connection and hopes for a response,
+// unless r.Cache has one. Only LookupRecords sets ad, to ask for the
+// AD bit of the response.
+func (r *Resolver) exchange(ctx context.Context, server string, q dnsmessage.Question, timeout time.Duration, ad bool) (dnsmessage.Parser, dnsmessage.Header, error) {
 	q.Class = dnsmessage.ClassINET
-	id, udpReq, tcpReq, err := newRequest(q)
+	if r == nil || r.Cache == nil {
+		return r.exchangeUncached(ctx, server, q, timeout, ad)
+	}
+	if p, h, ok, refresh := r.Cache.get(q, ad); ok {
+		if refresh {
+			go r.refreshCached(server, q, timeout, ad)
+		}
+		return p, h, nil
+	}
+	p, h, err := r.exchangeUncached(ctx, server, q, timeout, ad)
+	if err == nil {
+		r.Cache.put(q, ad, p, h)
+	}
+	return p, h, err
+}
+
+// refreshCached replaces the expired response to q in r.Cache.
+func (r *Resolver) refreshCached(server string, q dnsmessage.Question, timeout time.Duration, ad bool) {
+	p, h, err := r.exchangeUncached(context.Background(), server, q, timeout, ad)
+	if err != nil {
+		r.Cache.refreshFailed(q)
+		return
+	}
+	r.Cache.put(q, ad, p, h)
+}
+
+// exchangeUncached is like exchange, without the cache.
+func (r *Resolver) exchangeUncached(ctx context.Context, server string, q dnsmessage.Question, timeout time.Duration, ad bool) (dnsmessage.Parser, dnsmessage.Header, error) {
+	id, udpReq, tcpReq, err := newRequest(q, ad)
 	if err != nil {
 		return dnsmessage.Parser{}, dnsmessage.Header{}, errCannotMarshalDNSMessage
 	}
+	if r != nil && r.Exchange != nil {
+		return r.exchangeMessage(ctx, server, id, q, udpReq, timeout)
+	}
 	for _, network := range []string{"udp", "tcp"} {
 		ctx, cancel := context.WithDeadline(ctx, time.Now().Add(timeout))
 		defer cancel()
@@ -170,6 +206,34 @@ func (r *Resolver) exchange(ctx context.Context, server string, q dnsmessage.Que
 	return dnsmessage.Parser{}, dnsmessage.Header{}, errNoAnswerFromDNSServer
 }
 
+// exchangeMessage sends the query req, whose ID is id, with
+// r.Exchange and parses the response.
+func (r *Resolver) exchangeMessage(ctx context.Context, server string, id uint16, q dnsmessage.Question, req []byte, timeout time.Duration) (dnsmessage.Parser, dnsmessage.Header, error) {
+	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(timeout))
+	defer cancel()
+
+	b, err := r.Exchange(ctx, server, req)
+	if err != nil {
+		return dnsmessage.Parser{}, dnsmessage.Header{}, mapErr(err)
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(b)
+	if err != nil {
+		return dnsmessage.Parser{}, dnsmessage.Header{}, errCannotUnmarshalDNSMessage
+	}
+	rq, err := p.Question()
+	if err != nil {
+		return dnsmessage.Parser{}, dnsmessage.Header{}, errCannotUnmarshalDNSMessage
+	}
+	if !checkResponse(id, q, h, rq) {
+		return dnsmessage.Parser{}, dnsmessage.Header{}, errInvalidDNSResponse
+	}
+	if err := p.SkipQuestion(); err != dnsmessage.ErrSectionDone {
+		return dnsmessage.Parser{}, dnsmessage.Header{}, errInvalidDNSResponse
+	}
+	return p, h, nil
+}
+
 // checkHeader performs basic sanity checks on the header.
 func checkHeader(p *dnsmessage.Parser, h dnsmessage.Header, name, server string) error {
 	if h.RCode == dnsmessage.RCodeNameError {
@@ -241,7 +305,7 @@ func (r *Resolver) tryOneName(ctx context.Context, cfg *dnsConfig, name string,
 		for j := uint32(0); j < sLen; j++ {
 			server := cfg.servers[(serverOffset+j)%sLen]
 
-			p, h, err := r.exchange(ctx, server, q, cfg.timeout)
+			p, h, err := r.exchange(ctx, server, q, cfg.timeout, false)
 			if err != nil {
 				dnsErr := &DNSError{
 					Err:    err.Error(),
@@ -753,3 +817,239 @@ func (r *Resolver) goLookupPTR(ctx context.Context, addr string) ([]string, erro
 	}
 	return ptrs, nil
 }
+
+// goLookupRecords is the native Go implementation of LookupRecords.
+func (r *Resolver) goLookupRecords(ctx context.Context, name string, typ DNSType) (*DNSResponse, error) {
+	if !isDomainName(name) {
+		// See comment in func lookup above about use of errNoSuchHost.
+		return nil, &DNSError{Err: errNoSuchHost.Error(), Name: name}
+	}
+	resolvConf.tryUpdate("/etc/resolv.conf")
+	resolvConf.mu.RLock()
+	conf := resolvConf.dnsConfig
+	resolvConf.mu.RUnlock()
+	var (
+		negative *DNSResponse
+		lastErr  error = &DNSError{Err: errNoSuchHost.Error(), Name: name}
+	)
+	for _, fqdn := range conf.nameList(name) {
+		resp, err := r.tryOneNameRecords(ctx, conf, fqdn, dnsmessage.Type(typ))
+		if err != nil {
+			lastErr = err
+			if nerr, ok := err.(Error); ok && nerr.Temporary() && r.strictErrors() {
+				break
+			}
+			continue
+		}
+		if resp.RCode == int(dnsmessage.RCodeSuccess) && len(resp.Answers) > 0 {
+			return resp, nil
+		}
+		// Try the other names of the search list, but prefer the
+		// negative response for the original name.
+		if negative == nil || fqdn == name+"." || fqdn == name {
+			negative = resp
+		}
+	}
+	if negative != nil {
+		return negative, nil
+	}
+	if err, ok := lastErr.(*DNSError); ok {
+		err.Name = name
+	}
+	return nil, lastErr
+}
+
+// tryOneNameRecords is like tryOneName, but returns the response of
+// the first server that answers with NOERROR or NXDOMAIN.
+func (r *Resolver) tryOneNameRecords(ctx context.Context, cfg *dnsConfig, name string, qtype dnsmessage.Type) (*DNSResponse, error) {
+	var lastErr error
+	serverOffset := cfg.serverOffset()
+	sLen := uint32(len(cfg.servers))
+
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, &DNSError{Err: errCannotMarshalDNSMessage.Error(), Name: name}
+	}
+	q := dnsmessage.Question{
+		Name:  n,
+		Type:  qtype,
+		Class: dnsmessage.ClassINET,
+	}
+
+	for i := 0; i < cfg.attempts; i++ {
+		for j := uint32(0); j < sLen; j++ {
+			server := cfg.servers[(serverOffset+j)%sLen]
+
+			p, h, err := r.exchange(ctx, server, q, cfg.timeout, true)
+			if err == nil {
+				if err = checkHeader(&p, h, name, server); err == errNoSuchHost {
+					err = nil
+				}
+			}
+			if err == nil {
+				var resp *DNSResponse
+				if resp, err = newDNSResponse(&p, h, name, server); err == nil {
+					return resp, nil
+				}
+			}
+			dnsErr := &DNSError{
+				Err:    err.Error(),
+				Name:   name,
+				Server: server,
+			}
+			if nerr, ok := err.(Error); ok && nerr.Timeout() {
+				dnsErr.IsTimeout = true
+			}
+			if _, ok := err.(*OpError); ok || err == errServerTemporarlyMisbehaving {
+				dnsErr.IsTemporary = true
+			}
+			lastErr = dnsErr
+		}
+	}
+	return nil, lastErr
+}
+
+// newDNSResponse returns the response p, whose header h has been
+// parsed, to the query for name sent to server.
+func newDNSResponse(p *dnsmessage.Parser, h dnsmessage.Header, name, server string) (*DNSResponse, error) {
+	resp := &DNSResponse{
+		Name:              name,
+		Server:            server,
+		RCode:             int(h.RCode),
+		AuthenticatedData: h.AuthenticData,
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return nil, errCannotUnmarshalDNSMessage
+	}
+	authorities, err := p.AllAuthorities()
+	if err != nil {
+		return nil, errCannotUnmarshalDNSMessage
+	}
+	if resp.Answers, err = dnsRecords(answers); err != nil {
+		return nil, err
+	}
+	if resp.Authorities, err = dnsRecords(authorities); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// dnsRecords converts the resources of a DNS message to DNSRecords.
+func dnsRecords(rs []dnsmessage.Resource) ([]DNSRecord, error) {
+	if len(rs) == 0 {
+		return nil, nil
+	}
+	records := make([]DNSRecord, 0, len(rs))
+	for _, res := range rs {
+		rec := DNSRecord{
+			Name: res.Header.Name.String(),
+			Type: DNSType(res.Header.Type),
+			TTL:  time.Duration(res.Header.TTL) * time.Second,
+		}
+		var err error
+		switch b := res.Body.(type) {
+		case *dnsmessage.AResource:
+			rec.Data = IP(b.A[:])
+		case *dnsmessage.AAAAResource:
+			rec.Data = IP(b.AAAA[:])
+		case *dnsmessage.NSResource:
+			rec.Data = &NS{Host: b.NS.String()}
+		case *dnsmessage.CNAMEResource:
+			rec.Data = b.CNAME.String()
+		case *dnsmessage.PTRResource:
+			rec.Data = b.PTR.String()
+		case *dnsmessage.SOAResource:
+			rec.Data = &SOA{
+				NS:      b.NS.String(),
+				MBox:    b.MBox.String(),
+				Serial:  b.Serial,
+				Refresh: time.Duration(b.Refresh) * time.Second,
+				Retry:   time.Duration(b.Retry) * time.Second,
+				Expire:  time.Duration(b.Expire) * time.Second,
+				MinTTL:  time.Duration(b.MinTTL) * time.Second,
+			}
+		case *dnsmessage.MXResource:
+			rec.Data = &MX{Host: b.MX.String(), Pref: b.Pref}
+		case *dnsmessage.TXTResource:
+			rec.Data = b.TXT
+		case *dnsmessage.SRVResource:
+			rec.Data = &SRV{Target: b.Target.String(), Port: b.Port, Priority: b.Priority, Weight: b.Weight}
+		case *dnsmessage.UnknownResource:
+			switch rec.Type {
+			case DNSTypeTLSA:
+				rec.Data, err = parseTLSA(b.Data)
+			case DNSTypeSVCB, DNSTypeHTTPS:
+				rec.Data, err = parseSVCB(b.Data)
+			case DNSTypeCAA:
+				rec.Data, err = parseCAA(b.Data)
+			default:
+				rec.Data = b.Data
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func parseTLSA(b []byte) (*TLSA, error) {
+	if len(b) < 3 {
+		return nil, errCannotUnmarshalDNSMessage
+	}
+	return &TLSA{Usage: b[0], Selector: b[1], MatchingType: b[2], Data: b[3:]}, nil
+}
+
+func parseSVCB(b []byte) (*SVCB, error) {
+	if len(b) < 2 {
+		return nil, errCannotUnmarshalDNSMessage
+	}
+	svcb := &SVCB{Priority: uint16(b[0])<<8 | uint16(b[1])}
+	b = b[2:]
+	// The target name is never compressed (RFC 9460 section 2.2).
+	var target []byte
+	for {
+		if len(b) == 0 {
+			return nil, errCannotUnmarshalDNSMessage
+		}
+		l := int(b[0])
+		b = b[1:]
+		if l == 0 {
+			break
+		}
+		if l > 63 || l > len(b) {
+			return nil, errCannotUnmarshalDNSMessage
+		}
+		target = append(target, b[:l]...)
+		target = append(target, '.')
+		b = b[l:]
+	}
+	if len(target) == 0 {
+		target = append(target, '.')
+	}
+	svcb.Target = string(target)
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errCannotUnmarshalDNSMessage
+		}
+		key := uint16(b[0])<<8 | uint16(b[1])
+		l := int(b[2])<<8 | int(b[3])
+		b = b[4:]
+		if l > len(b) {
+			return nil, errCannotUnmarshalDNSMessage
+		}
+		svcb.Params = append(svcb.Params, SVCParam{Key: key, Value: b[:l:l]})
+		b = b[l:]
+	}
+	return svcb, nil
+}
+
+func parseCAA(b []byte) (*CAA, error) {
+	if len(b) < 2 || len(b) < 2+int(b[1]) {
+		return nil, errCannotUnmarshalDNSMessage
+	}
+	l := int(b[1])
+	return &CAA{Flag: b[0], Tag: string(b[2 : 2+l]), Value: string(b[2+l:])}, nil
+}
@@ -80,7 +80,7 @@ func TestDNSTransportFallback(t *testing.T) {
 	for _, tt := range dnsTransportFallbackTests {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
-		_, h, err := r.exchange(ctx, tt.server, tt.question, time.Second)
+		_, h, err := r.exchange(ctx, tt.server, tt.question, time.Second, false)
 		if err != nil {
 			t.Error(err)
 			continue
@@ -136,7 +136,7 @@ func TestSpecialDomainName(t *testing.T) {
 	for _, tt := range specialDomainNameTests {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
-		_, h, err := r.exchange(ctx, server, tt.question, 3*time.Second)
+		_, h, err := r.exchange(ctx, server, tt.question, 3*time.Second, false)
 		if err != nil {
 			t.Error(err)
 			continue
@@ -1563,7 +1563,7 @@ func TestDNSDialTCP(t *testing.T) {
 	}
 	r := Resolver{PreferGo: true, Dial: fake.DialContext}
 	ctx := context.Background()
-	_, _, err := r.exchange(ctx, "0.0.0.0", mustQuestion("com.", dnsmessage.TypeALL, dnsmessage.ClassINET), time.Second)
+	_, _, err := r.exchange(ctx, "0.0.0.0", mustQuestion("com.", dnsmessage.TypeALL, dnsmessage.ClassINET), time.Second, false)
 	if err != nil {
 		t.Fatal("exhange failed:", err)
 	}
@@ -1621,3 +1621,440 @@ func TestTXTRecordTwoStrings(t *testing.T) {
 		t.Errorf("txt[1], got %q, want %q", txt[1], want)
 	}
 }
+
+func TestLookupRecords(t *testing.T) {
+	svcb := []byte{
+		0, 1, // priority
+		3, 'w', 'e', 'b', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, // target
+		0, 1, 0, 3, 2, 'h', '2', // alpn
+		0, 3, 0, 2, 0x01, 0xbb, // port
+	}
+	fake := fakeDNSServer{
+		rh: func(_, _ string, q dnsmessage.Message, _ time.Time) (dnsmessage.Message, error) {
+			if !q.Header.AuthenticData {
+				t.Error("query without the AD bit")
+			}
+			r := dnsmessage.Message{
+				Header: dnsmessage.Header{
+					ID:                 q.Header.ID,
+					Response:           true,
+					RecursionAvailable: true,
+					AuthenticData:      true,
+				},
+				Questions: q.Questions,
+			}
+			qt := q.Questions[0].Type
+			rh := dnsmessage.ResourceHeader{
+				Name:  q.Questions[0].Name,
+				Type:  qt,
+				Class: dnsmessage.ClassINET,
+				TTL:   300,
+			}
+			var body dnsmessage.ResourceBody
+			switch DNSType(qt) {
+			case DNSTypeCAA:
+				body = &dnsmessage.UnknownResource{Type: qt, Data: []byte("\x80\x05issueca.example.net")}
+			case DNSTypeHTTPS:
+				body = &dnsmessage.UnknownResource{Type: qt, Data: svcb}
+			case DNSTypeTLSA:
+				body = &dnsmessage.UnknownResource{Type: qt, Data: []byte{3, 1, 1, 0xab, 0xcd}}
+			case DNSTypePTR:
+				body = &dnsmessage.PTRResource{PTR: mustNewName("host.example.com.")}
+			default:
+				body = &dnsmessage.UnknownResource{Type: qt, Data: []byte{1, 2, 3}}
+			}
+			r.Answers = append(r.Answers, dnsmessage.Resource{Header: rh, Body: body})
+			return r, nil
+		},
+	}
+	r := Resolver{PreferGo: true, Dial: fake.DialContext}
+
+	tests := []struct {
+		name string
+		typ  DNSType
+		want interface{}
+	}{
+		{"example.com.", DNSTypeCAA, &CAA{Flag: 128, Tag: "issue", Value: "ca.example.net"}},
+		{"example.com.", DNSTypeHTTPS, &SVCB{
+			Priority: 1,
+			Target:   "web.example.com.",
+			Params:   []SVCParam{{1, []byte("\x02h2")}, {3, []byte{0x01, 0xbb}}},
+		}},
+		{"_443._tcp.example.com.", DNSTypeTLSA, &TLSA{Usage: 3, Selector: 1, MatchingType: 1, Data: []byte{0xab, 0xcd}}},
+		{"1.2.0.192.in-addr.arpa.", DNSTypePTR, "host.example.com."},
+		{"example.com.", 65280, []byte{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		resp, err := r.LookupRecords(context.Background(), tt.name, tt.typ)
+		if err != nil {
+			t.Errorf("LookupRecords(%q, %v): %v", tt.name, tt.typ, err)
+			continue
+		}
+		if resp.Name != tt.name || resp.RCode != 0 || !resp.AuthenticatedData || len(resp.Answers) != 1 {
+			t.Errorf("LookupRecords(%q, %v) = %+v", tt.name, tt.typ, resp)
+			continue
+		}
+		rec := resp.Answers[0]
+		if rec.Name != tt.name || rec.Type != tt.typ || rec.TTL != 300*time.Second {
+			t.Errorf("LookupRecords(%q, %v): record %s %v %v", tt.name, tt.typ, rec.Name, rec.Type, rec.TTL)
+		}
+		if !reflect.DeepEqual(rec.Data, tt.want) {
+			t.Errorf("LookupRecords(%q, %v): data = %#v; want %#v", tt.name, tt.typ, rec.Data, tt.want)
+		}
+	}
+}
+
+func TestLookupRecordsNXDOMAIN(t *testing.T) {
+	fake := fakeDNSServer{
+		rh: func(_, _ string, q dnsmessage.Message, _ time.Time) (dnsmessage.Message, error) {
+			r := dnsmessage.Message{
+				Header: dnsmessage.Header{
+					ID:                 q.Header.ID,
+					Response:           true,
+					RecursionAvailable: true,
+					RCode:              dnsmessage.RCodeNameError,
+				},
+				Questions: q.Questions,
+				Authorities: []dnsmessage.Resource{
+					{
+						Header: dnsmessage.ResourceHeader{
+							Name:  mustNewName("example.com."),
+							Type:  dnsmessage.TypeSOA,
+							Class: dnsmessage.ClassINET,
+							TTL:   3600,
+						},
+						Body: &dnsmessage.SOAResource{
+							NS:      mustNewName("ns.example.com."),
+							MBox:    mustNewName("admin.example.com."),
+							Serial:  2019010101,
+							Refresh: 7200,
+							Retry:   900,
+							Expire:  1209600,
+							MinTTL:  60,
+						},
+					},
+				},
+			}
+			return r, nil
+		},
+	}
+	r := Resolver{PreferGo: true, Dial: fake.DialContext}
+	resp, err := r.LookupRecords(context.Background(), "nx.example.com.", DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RCode != int(dnsmessage.RCodeNameError) || len(resp.Answers) != 0 || len(resp.Authorities) != 1 {
+		t.Fatalf("got %+v; want NXDOMAIN with one authority record", resp)
+	}
+	want := &SOA{
+		NS:      "ns.example.com.",
+		MBox:    "admin.example.com.",
+		Serial:  2019010101,
+		Refresh: 2 * time.Hour,
+		Retry:   15 * time.Minute,
+		Expire:  14 * 24 * time.Hour,
+		MinTTL:  time.Minute,
+	}
+	if soa := resp.Authorities[0]; soa.Type != DNSTypeSOA || soa.TTL != time.Hour || !reflect.DeepEqual(soa.Data, want) {
+		t.Errorf("authority = %+v; want SOA %+v", soa, want)
+	}
+}
+
+func TestResolverExchange(t *testing.T) {
+	r := Resolver{
+		Dial: func(context.Context, string, string) (Conn, error) {
+			t.Error("Dial called with Exchange set")
+			return nil, errors.New("no dialing")
+		},
+		Exchange: func(_ context.Context, _ string, query []byte) ([]byte, error) {
+			var q dnsmessage.Message
+			if err := q.Unpack(query); err != nil {
+				return nil, err
+			}
+			if q.AuthenticData {
+				t.Error("LookupHost query with the AD bit")
+			}
+			r := dnsmessage.Message{
+				Header: dnsmessage.Header{
+					ID:                 q.ID,
+					Response:           true,
+					RecursionAvailable: true,
+				},
+				Questions: q.Questions,
+			}
+			if q.Questions[0].Type == dnsmessage.TypeA {
+				r.Answers = []dnsmessage.Resource{
+					{
+						Header: dnsmessage.ResourceHeader{
+							Name:  q.Questions[0].Name,
+							Type:  dnsmessage.TypeA,
+							Class: dnsmessage.ClassINET,
+						},
+						Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+					},
+				}
+			}
+			return r.Pack()
+		},
+	}
+	addrs, err := r.LookupHost(context.Background(), "exchange.example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+		t.Errorf("LookupHost = %v; want [192.0.2.1]", addrs)
+	}
+}
+
+// cacheTestServer answers A queries with an address, and other
+// queries with no data, except for names starting with "nx", which do
+// not exist. Its negative responses carry an SOA record, so that they
+// can be cached. It sets the AD bit of a response if the query asked
+// for it.
+type cacheTestServer struct {
+	mu      sync.Mutex
+	queries int
+}
+
+func (s *cacheTestServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+func (s *cacheTestServer) handle(_, _ string, q dnsmessage.Message, _ time.Time) (dnsmessage.Message, error) {
+	s.mu.Lock()
+	s.queries++
+	s.mu.Unlock()
+	r := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 q.ID,
+			Response:           true,
+			RecursionAvailable: true,
+			AuthenticData:      q.AuthenticData,
+		},
+		Questions: q.Questions,
+	}
+	name := q.Questions[0].Name
+	switch {
+	case strings.HasPrefix(name.String(), "nx"):
+		r.RCode = dnsmessage.RCodeNameError
+	case q.Questions[0].Type == dnsmessage.TypeA:
+		r.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{
+				Name:  name,
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+				TTL:   60,
+			},
+			Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+		}}
+		return r, nil
+	}
+	r.Authorities = []dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{
+			Name:  mustNewName("example."),
+			Type:  dnsmessage.TypeSOA,
+			Class: dnsmessage.ClassINET,
+			TTL:   300,
+		},
+		Body: &dnsmessage.SOAResource{
+			NS:     mustNewName("ns.example."),
+			MBox:   mustNewName("admin.example."),
+			MinTTL: 30,
+		},
+	}}
+	return r, nil
+}
+
+// testClock is a clock for DNSCache.now.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func newCacheTestResolver(cache *DNSCache) (*Resolver, *cacheTestServer, *testClock) {
+	s := new(cacheTestServer)
+	fake := fakeDNSServer{rh: s.handle}
+	clock := &testClock{now: time.Unix(1e9, 0)}
+	cache.now = clock.Now
+	return &Resolver{PreferGo: true, Dial: fake.DialContext, Cache: cache}, s, clock
+}
+
+func TestDNSCache(t *testing.T) {
+	cache := new(DNSCache)
+	r, s, clock := newCacheTestResolver(cache)
+	ctx := context.Background()
+
+	lookup := func(want int) {
+		t.Helper()
+		addrs, err := r.LookupHost(ctx, "cached.example.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+			t.Errorf("LookupHost = %v; want [192.0.2.1]", addrs)
+		}
+		if got := s.count(); got != want {
+			t.Errorf("%d queries sent; want %d", got, want)
+		}
+	}
+	lookup(2) // A and AAAA
+	lookup(2)
+	clock.advance(29 * time.Second)
+	lookup(2)
+	// The negative response to the AAAA query expires first, after
+	// the SOA minimum TTL.
+	clock.advance(2 * time.Second)
+	lookup(3)
+	clock.advance(31 * time.Second)
+	lookup(5)
+
+	if got, want := cache.Stats(), (DNSCacheStats{Entries: 2, Hits: 5, Misses: 5}); got != want {
+		t.Errorf("Stats() = %+v; want %+v", got, want)
+	}
+
+	// The TTLs of cached responses count down.
+	clock.advance(20 * time.Second)
+	resp, err := r.LookupRecords(ctx, "cached.example.", DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answers) != 1 || resp.Answers[0].TTL != 40*time.Second {
+		t.Errorf("cached answers = %+v; want one with a TTL of 40s", resp.Answers)
+	}
+
+	cache.Flush()
+	lookup(7)
+}
+
+func TestDNSCacheNegative(t *testing.T) {
+	r, s, clock := newCacheTestResolver(new(DNSCache))
+	ctx := context.Background()
+	for i, want := range []int{1, 1, 2} {
+		_, err := r.LookupRecords(ctx, "nx.example.", DNSTypeTXT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.LookupTXT(ctx, "nx.example.")
+		if err, ok := err.(*DNSError); !ok || err.Err != errNoSuchHost.Error() {
+			t.Errorf("lookup %d: LookupTXT error = %v; want %v", i, err, errNoSuchHost)
+		}
+		if got := s.count(); got != want {
+			t.Errorf("lookup %d: %d queries sent; want %d", i, got, want)
+		}
+		clock.advance(20 * time.Second)
+	}
+}
+
+func TestDNSCacheAuthenticatedData(t *testing.T) {
+	cache := new(DNSCache)
+	r, s, _ := newCacheTestResolver(cache)
+	ctx := context.Background()
+	lookup := func(want bool) {
+		t.Helper()
+		resp, err := r.LookupRecords(ctx, "cached.example.", DNSTypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.AuthenticatedData != want {
+			t.Errorf("AuthenticatedData = %v; want %v", resp.AuthenticatedData, want)
+		}
+	}
+	lookup(true)
+	lookup(true)
+
+	// A response cached from a query without the AD bit does not
+	// report it.
+	cache.Flush()
+	if _, err := r.LookupIPAddr(ctx, "cached.example."); err != nil {
+		t.Fatal(err)
+	}
+	lookup(false)
+	if got := s.count(); got != 3 {
+		t.Errorf("%d queries sent; want 3", got)
+	}
+}
+
+func TestDNSCacheClamps(t *testing.T) {
+	cache := &DNSCache{MinTTL: 5 * time.Minute}
+	r, s, clock := newCacheTestResolver(cache)
+	ctx := context.Background()
+	r.LookupIPAddr(ctx, "cached.example.")
+	clock.advance(4 * time.Minute)
+	r.LookupIPAddr(ctx, "cached.example.")
+	if got := s.count(); got != 2 {
+		t.Errorf("MinTTL: %d queries sent; want 2", got)
+	}
+
+	cache = &DNSCache{MaxTTL: 10 * time.Second}
+	r, s, clock = newCacheTestResolver(cache)
+	r.LookupIPAddr(ctx, "cached.example.")
+	clock.advance(11 * time.Second)
+	r.LookupIPAddr(ctx, "cached.example.")
+	if got := s.count(); got != 4 {
+		t.Errorf("MaxTTL: %d queries sent; want 4", got)
+	}
+}
+
+func TestDNSCacheStale(t *testing.T) {
+	cache := &DNSCache{Stale: time.Hour}
+	r, s, clock := newCacheTestResolver(cache)
+	ctx := context.Background()
+	if _, err := r.LookupRecords(ctx, "cached.example.", DNSTypeA); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(2 * time.Minute)
+	// The expired response is used, and refreshed in the background.
+	resp, err := r.LookupRecords(ctx, "cached.example.", DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answers) != 1 || resp.Answers[0].TTL != 0 {
+		t.Errorf("stale answers = %+v; want one with a TTL of 0", resp.Answers)
+	}
+	for i := 0; s.count() < 2; i++ {
+		if i == 100 {
+			t.Fatal("stale response not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Wait for the refreshed response to be stored.
+	for i := 0; ; i++ {
+		cache.mu.Lock()
+		refreshing := cache.entries[dnsCacheKey{"cached.example.", dnsmessage.TypeA}].refreshing
+		cache.mu.Unlock()
+		if !refreshing {
+			break
+		}
+		if i == 100 {
+			t.Fatal("refreshed response not stored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, err = r.LookupRecords(ctx, "cached.example.", DNSTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answers) != 1 || resp.Answers[0].TTL != time.Minute {
+		t.Errorf("refreshed answers = %+v; want one with a TTL of 1m", resp.Answers)
+	}
+	if got, want := cache.Stats(), (DNSCacheStats{Entries: 1, Hits: 2, StaleHits: 1, Misses: 1}); got != want {
+		t.Errorf("Stats() = %+v; want %+v", got, want)
+	}
+	if got := s.count(); got != 2 {
+		t.Errorf("%d queries sent; want 2", got)
+	}
+}
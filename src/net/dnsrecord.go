@@ -0,0 +1,155 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package net
+
+import "time"
+
+// A DNSType is a DNS resource record type, as registered with IANA.
+type DNSType uint16
+
+// Resource record types whose data Resolver.LookupRecords parses.
+// Records of other types can be looked up too.
+const (
+	DNSTypeA     DNSType = 1
+	DNSTypeNS    DNSType = 2
+	DNSTypeCNAME DNSType = 5
+	DNSTypeSOA   DNSType = 6
+	DNSTypePTR   DNSType = 12
+	DNSTypeMX    DNSType = 15
+	DNSTypeTXT   DNSType = 16
+	DNSTypeAAAA  DNSType = 28
+	DNSTypeSRV   DNSType = 33
+	DNSTypeTLSA  DNSType = 52
+	DNSTypeSVCB  DNSType = 64
+	DNSTypeHTTPS DNSType = 65
+	DNSTypeCAA   DNSType = 257
+)
+
+var dnsTypeNames = map[DNSType]string{
+	DNSTypeA:     "A",
+	DNSTypeNS:    "NS",
+	DNSTypeCNAME: "CNAME",
+	DNSTypeSOA:   "SOA",
+	DNSTypePTR:   "PTR",
+	DNSTypeMX:    "MX",
+	DNSTypeTXT:   "TXT",
+	DNSTypeAAAA:  "AAAA",
+	DNSTypeSRV:   "SRV",
+	DNSTypeTLSA:  "TLSA",
+	DNSTypeSVCB:  "SVCB",
+	DNSTypeHTTPS: "HTTPS",
+	DNSTypeCAA:   "CAA",
+}
+
+// String returns the mnemonic of t, such as "CAA", or, for the types
+// without a constant in this package, "TYPE" followed by its number,
+// as in RFC 3597.
+func (t DNSType) String() string {
+	if s, ok := dnsTypeNames[t]; ok {
+		return s
+	}
+	return "TYPE" + uitoa(uint(t))
+}
+
+// A DNSRecord is a DNS resource record returned by
+// Resolver.LookupRecords.
+type DNSRecord struct {
+	Name string        // owner name, with a trailing dot
+	Type DNSType       // record type
+	TTL  time.Duration // time to live, in whole seconds
+
+	// Data is the record data, whose type depends on Type:
+	//
+	//	DNSTypeA, DNSTypeAAAA     IP
+	//	DNSTypeNS                 *NS
+	//	DNSTypeCNAME, DNSTypePTR  string, a name with a trailing dot
+	//	DNSTypeSOA                *SOA
+	//	DNSTypeMX                 *MX
+	//	DNSTypeTXT                []string, the strings of the record
+	//	DNSTypeSRV                *SRV
+	//	DNSTypeTLSA               *TLSA
+	//	DNSTypeSVCB, DNSTypeHTTPS *SVCB
+	//	DNSTypeCAA                *CAA
+	//
+	// For other types, Data is the record data in wire format, a
+	// []byte.
+	Data interface{}
+}
+
+// A DNSResponse is the response to a query made by
+// Resolver.LookupRecords.
+type DNSResponse struct {
+	// Name is the name that was queried, with a trailing dot: the
+	// name passed to LookupRecords, or that name completed with a
+	// domain of the search list.
+	Name string
+
+	// Server is the address of the name server that answered.
+	Server string
+
+	// RCode is the response code, as defined in RFC 1035 section
+	// 4.1.1: 0 (NOERROR) or 3 (NXDOMAIN, the name does not exist).
+	RCode int
+
+	// AuthenticatedData reports whether the server set the AD bit
+	// (RFC 4035 section 3.2.3), claiming to have validated the
+	// answer with DNSSEC. It is only as trustworthy as the server
+	// and the network path to it.
+	AuthenticatedData bool
+
+	// Answers holds the records of the answer section, which
+	// include the CNAME records leading from Name to the records
+	// of the type asked for, if any.
+	Answers []DNSRecord
+
+	// Authorities holds the records of the authority section. In
+	// a negative response they usually include the SOA record of
+	// the zone, which bounds how long the response may be cached
+	// (RFC 2308).
+	Authorities []DNSRecord
+}
+
+// An SOA represents a DNS start of authority record.
+type SOA struct {
+	NS      string // primary name server
+	MBox    string // mailbox of the person responsible for the zone, as a name
+	Serial  uint32
+	Refresh time.Duration
+	Retry   time.Duration
+	Expire  time.Duration
+	MinTTL  time.Duration // time to live of negative responses (RFC 2308)
+}
+
+// A TLSA represents a DNS TLSA record, which associates a TLS
+// certificate or public key with a service (RFC 6698).
+type TLSA struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	Data         []byte // certificate association data
+}
+
+// An SVCB represents a DNS service binding record, of type SVCB or
+// HTTPS (RFC 9460).
+type SVCB struct {
+	Priority uint16     // 0 for an alias record
+	Target   string     // target name, with a trailing dot
+	Params   []SVCParam // in the order of the record
+}
+
+// An SVCParam is a service parameter of an SVCB record, such as the
+// ALPN protocol identifiers (key 1), with its value in wire format.
+type SVCParam struct {
+	Key   uint16
+	Value []byte
+}
+
+// A CAA represents a DNS certification authority authorization
+// record (RFC 8659).
+type CAA struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnstransport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// dnsMessageType is the media type of DNS messages (RFC 8484 section 6).
+const dnsMessageType = "application/dns-message"
+
+// HTTPS is a DNS-over-HTTPS transport (RFC 8484).
+//
+// It sends each query in a POST request. Connection reuse, and the
+// concurrent requests of HTTP/2 on a single connection, are left to
+// the HTTP client.
+//
+// An HTTPS is safe for concurrent use.
+type HTTPS struct {
+	// URL is the URL of the server's DNS API, such as
+	// "https://dns.example.com/dns-query".
+	URL string
+
+	// Client is the HTTP client used to send the queries. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Exchange sends query to the server at t.URL and returns its
+// response. The server parameter is ignored, so that Exchange can be
+// used as the Exchange function of a net.Resolver.
+func (t *HTTPS) Exchange(ctx context.Context, server string, query []byte) ([]byte, error) {
+	if err := checkQuery(query); err != nil {
+		return nil, err
+	}
+	// Send ID 0, so that responses to the same question can be
+	// cached by HTTP caches (RFC 8484 section 4.1).
+	req, err := http.NewRequest("POST", t.URL, bytes.NewReader(withID(query, 0)))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	res, err := t.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("dnstransport: unexpected HTTP status " + res.Status)
+	}
+	if ct, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err != nil || ct != dnsMessageType {
+		return nil, errors.New("dnstransport: unexpected Content-Type " + res.Header.Get("Content-Type"))
+	}
+	// Read at most one byte more than the longest DNS message, to
+	// tell an overlong response from one of the maximum length.
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(io.LimitReader(res.Body, maxMessageLen+1)); err != nil {
+		return nil, err
+	}
+	resp := buf.Bytes()
+	if len(resp) < headerLen {
+		return nil, errShortMessage
+	}
+	if len(resp) > maxMessageLen {
+		return nil, errLongMessage
+	}
+	resp[0] = query[0]
+	resp[1] = query[1]
+	return resp, nil
+}
+
+func (t *HTTPS) client() *http.Client {
+	if t.Client != nil {
+		return t.Client
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,27 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnstransport
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestResolver(t *testing.T) {
+	s := newTLSServer(t, 1, false)
+	defer s.close()
+	tr := s.transport()
+	defer tr.Close()
+
+	r := &net.Resolver{Exchange: tr.Exchange}
+	addrs, err := r.LookupHost(context.Background(), "golang.org.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+		t.Errorf("LookupHost = %v; want [192.0.2.1]", addrs)
+	}
+}
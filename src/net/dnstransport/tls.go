@@ -0,0 +1,377 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnstransport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errClosed   = errors.New("dnstransport: connection closed")
+	errIdle     = errors.New("dnstransport: idle connection closed")
+	errTooBusy  = errors.New("dnstransport: too many queries in progress")
+	errNoServer = errors.New("dnstransport: no server address")
+)
+
+// defaultIdleTimeout is the default value of TLS.IdleTimeout.
+const defaultIdleTimeout = 10 * time.Second
+
+// TLS is a DNS-over-TLS transport (RFC 7858).
+//
+// It sends the queries of concurrent lookups on a single connection
+// without waiting for their responses, which the server may return
+// in any order (RFC 7766 section 6.2.1.1), and keeps the connection
+// open between lookups.
+//
+// A TLS is safe for concurrent use. It must not be copied after
+// first use.
+type TLS struct {
+	// Addr is the address of the server, as "host:port". The
+	// port of DNS over TLS is 853.
+	Addr string
+
+	// Config is the TLS configuration used to connect to the
+	// server. If nil, the zero configuration is used. If its
+	// ServerName is empty, the host of Addr is used to verify the
+	// server's certificate.
+	Config *tls.Config
+
+	// DialContext specifies the dial function for creating the
+	// TCP connection to the server. If nil, a zero net.Dialer is
+	// used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// IdleTimeout is how long the connection stays open while no
+	// query is in progress. If zero, 10 seconds is used.
+	IdleTimeout time.Duration
+
+	mu      sync.Mutex
+	conn    *tlsConn      // open connection, or nil
+	dialing chan struct{} // closed when the dial in progress is done; nil if none
+}
+
+// Exchange sends query to the server at t.Addr and returns its
+// response. The server parameter is ignored, so that Exchange can be
+// used as the Exchange function of a net.Resolver.
+func (t *TLS) Exchange(ctx context.Context, server string, query []byte) ([]byte, error) {
+	if err := checkQuery(query); err != nil {
+		return nil, err
+	}
+	resp, reused, err := t.exchange(ctx, query)
+	if err != nil && reused && ctx.Err() == nil {
+		// The server may have closed the connection, which was
+		// idle, as the query was sent. Retry on a new one.
+		resp, _, err = t.exchange(ctx, query)
+	}
+	return resp, err
+}
+
+func (t *TLS) exchange(ctx context.Context, query []byte) (resp []byte, reused bool, err error) {
+	c, reused, err := t.getConn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err = c.exchange(ctx, query)
+	return resp, reused, err
+}
+
+// Close closes the connection to the server, if any, failing the
+// queries in progress on it. A later Exchange opens a new one.
+func (t *TLS) Close() error {
+	t.mu.Lock()
+	c := t.conn
+	t.conn = nil
+	t.mu.Unlock()
+	if c != nil {
+		c.fail(errClosed)
+	}
+	return nil
+}
+
+func (t *TLS) idleTimeout() time.Duration {
+	if t.IdleTimeout > 0 {
+		return t.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+// getConn returns the open connection, dialing it if needed, and
+// whether it was already open.
+func (t *TLS) getConn(ctx context.Context) (c *tlsConn, reused bool, err error) {
+	t.mu.Lock()
+	for {
+		if t.conn != nil {
+			c := t.conn
+			t.mu.Unlock()
+			return c, true, nil
+		}
+		if t.dialing == nil {
+			break
+		}
+		// Wait for the other dial rather than open a second
+		// connection. If it fails, dial again.
+		dialing := t.dialing
+		t.mu.Unlock()
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		t.mu.Lock()
+	}
+	dialing := make(chan struct{})
+	t.dialing = dialing
+	t.mu.Unlock()
+
+	c, err = t.dial(ctx)
+
+	t.mu.Lock()
+	t.dialing = nil
+	close(dialing)
+	if err == nil {
+		t.conn = c
+	}
+	t.mu.Unlock()
+	if err != nil {
+		return nil, false, err
+	}
+	go c.readLoop()
+	return c, false, nil
+}
+
+func (t *TLS) dial(ctx context.Context) (*tlsConn, error) {
+	if t.Addr == "" {
+		return nil, errNoServer
+	}
+	var (
+		nc  net.Conn
+		err error
+	)
+	if t.DialContext != nil {
+		nc, err = t.DialContext(ctx, "tcp", t.Addr)
+	} else {
+		var d net.Dialer
+		nc, err = d.DialContext(ctx, "tcp", t.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg *tls.Config
+	if t.Config != nil {
+		cfg = t.Config.Clone()
+	} else {
+		cfg = new(tls.Config)
+	}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(t.Addr)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+	tc := tls.Client(nc, cfg)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			nc.Close()
+		case <-done:
+		}
+	}()
+	err = tc.Handshake()
+	close(done)
+	if err != nil {
+		nc.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return &tlsConn{
+		t:       t,
+		nc:      nc,
+		conn:    tc,
+		pending: make(map[uint16]chan []byte),
+	}, nil
+}
+
+// removeConn forgets c, if it is the open connection.
+func (t *TLS) removeConn(c *tlsConn) {
+	t.mu.Lock()
+	if t.conn == c {
+		t.conn = nil
+	}
+	t.mu.Unlock()
+}
+
+// A tlsConn is a connection to a DNS-over-TLS server, on which each
+// query in progress has its own message ID.
+type tlsConn struct {
+	t    *TLS
+	nc   net.Conn // underlying connection, closed without waiting for writes
+	conn *tls.Conn
+	wmu  sync.Mutex // serializes writes
+
+	mu      sync.Mutex
+	pending map[uint16]chan []byte // by message ID; closed if the connection fails
+	nextID  uint16
+	err     error       // why the connection was closed; nil while it is open
+	idle    *time.Timer // closes the connection once idle
+}
+
+func (c *tlsConn) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	ch := make(chan []byte, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	if len(c.pending) > 0xffff {
+		c.mu.Unlock()
+		return nil, errTooBusy
+	}
+	id := c.nextID
+	for c.pending[id] != nil {
+		id++
+	}
+	c.nextID = id + 1
+	c.pending[id] = ch
+	if c.idle != nil {
+		c.idle.Stop()
+	}
+	c.mu.Unlock()
+
+	// The server answers with the ID of the query, which is
+	// replaced by one unique on the connection.
+	msg := make([]byte, 2+len(query))
+	msg[0] = byte(len(query) >> 8)
+	msg[1] = byte(len(query))
+	copy(msg[2:], query)
+	msg[2] = byte(id >> 8)
+	msg[3] = byte(id)
+	c.wmu.Lock()
+	if d, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(d)
+	} else {
+		c.conn.SetWriteDeadline(time.Time{})
+	}
+	_, err := c.conn.Write(msg)
+	c.wmu.Unlock()
+	if err != nil {
+		// A partial write leaves the stream unusable.
+		c.fail(err)
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			c.mu.Lock()
+			err := c.err
+			c.mu.Unlock()
+			return nil, err
+		}
+		resp[0] = query[0]
+		resp[1] = query[1]
+		return resp, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		if c.pending[id] == ch {
+			delete(c.pending, id)
+			c.startIdleLocked()
+		}
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// readLoop reads responses and passes them to the queries in
+// progress, until the connection fails.
+func (c *tlsConn) readLoop() {
+	br := bufio.NewReader(c.conn)
+	var lenBuf [2]byte
+	for {
+		if _, err := io.ReadFull(br, lenBuf[:]); err != nil {
+			c.fail(err)
+			return
+		}
+		n := int(lenBuf[0])<<8 | int(lenBuf[1])
+		if n < headerLen {
+			c.fail(errShortMessage)
+			return
+		}
+		resp := make([]byte, n)
+		if _, err := io.ReadFull(br, resp); err != nil {
+			c.fail(err)
+			return
+		}
+		id := messageID(resp)
+		c.mu.Lock()
+		ch := c.pending[id]
+		if ch != nil {
+			delete(c.pending, id)
+			c.startIdleLocked()
+		}
+		c.mu.Unlock()
+		// Responses to canceled queries are dropped.
+		if ch != nil {
+			ch <- resp
+		}
+	}
+}
+
+// startIdleLocked arranges for c to be closed if it stays without
+// queries in progress for the idle timeout.
+func (c *tlsConn) startIdleLocked() {
+	if len(c.pending) > 0 || c.err != nil {
+		return
+	}
+	d := c.t.idleTimeout()
+	if c.idle == nil {
+		c.idle = time.AfterFunc(d, c.closeIdle)
+	} else {
+		c.idle.Reset(d)
+	}
+}
+
+func (c *tlsConn) closeIdle() {
+	c.mu.Lock()
+	if len(c.pending) > 0 || c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = errIdle
+	c.mu.Unlock()
+	c.t.removeConn(c)
+	c.nc.Close()
+}
+
+// fail closes c because of err, failing the queries in progress.
+func (c *tlsConn) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+		if c.idle != nil {
+			c.idle.Stop()
+		}
+	}
+	c.mu.Unlock()
+	c.t.removeConn(c)
+	c.nc.Close()
+}
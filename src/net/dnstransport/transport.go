@@ -0,0 +1,57 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dnstransport implements encrypted transports for Go's DNS
+// resolver: DNS over TLS (RFC 7858) and DNS over HTTPS (RFC 8484).
+//
+// The Exchange method of each transport is meant to be set as the
+// Exchange function of a net.Resolver:
+//
+//	t := &dnstransport.TLS{Addr: "192.0.2.53:853", Config: &tls.Config{ServerName: "dns.example.com"}}
+//	r := &net.Resolver{Exchange: t.Exchange}
+//	addrs, err := r.LookupHost(ctx, "golang.org")
+//
+// The resolver then sends all its queries to the transport's server,
+// instead of the name servers of /etc/resolv.conf, which still
+// provides the search list and options.
+package dnstransport
+
+import "errors"
+
+// headerLen is the length of the header of a DNS message.
+const headerLen = 12
+
+var (
+	errShortMessage = errors.New("dnstransport: DNS message too short")
+	errLongMessage  = errors.New("dnstransport: DNS message too long")
+)
+
+// maxMessageLen is the maximum length of a DNS message sent over a
+// stream, whose length prefix is 16 bits.
+const maxMessageLen = 65535
+
+// messageID returns the ID of the DNS message m.
+func messageID(m []byte) uint16 {
+	return uint16(m[0])<<8 | uint16(m[1])
+}
+
+// withID returns a copy of the DNS message m with the ID id.
+func withID(m []byte, id uint16) []byte {
+	c := make([]byte, len(m))
+	copy(c, m)
+	c[0] = byte(id >> 8)
+	c[1] = byte(id)
+	return c
+}
+
+// checkQuery reports whether query can be sent to a server.
+func checkQuery(query []byte) error {
+	if len(query) < headerLen {
+		return errShortMessage
+	}
+	if len(query) > maxMessageLen {
+		return errLongMessage
+	}
+	return nil
+}
@@ -0,0 +1,368 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dnstransport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"internal/x/net/dns/dnsmessage"
+)
+
+func mustNewName(t *testing.T, name string) dnsmessage.Name {
+	t.Helper()
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func newQuery(t *testing.T, id uint16, name string) []byte {
+	t.Helper()
+	q := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  mustNewName(t, name),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	b, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// answer returns the response to query, which gives the address
+// 192.0.2.1 to any name.
+func answer(query []byte) ([]byte, error) {
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil {
+		return nil, err
+	}
+	r := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 q.ID,
+			Response:           true,
+			RecursionAvailable: true,
+		},
+		Questions: q.Questions,
+	}
+	if len(q.Questions) == 1 && q.Questions[0].Type == dnsmessage.TypeA {
+		r.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{
+				Name:  q.Questions[0].Name,
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+				TTL:   60,
+			},
+			Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+		}}
+	}
+	return r.Pack()
+}
+
+// checkResponse checks that resp answers the query for name with
+// the given ID.
+func checkResponse(t *testing.T, resp []byte, id uint16, name string) {
+	t.Helper()
+	var m dnsmessage.Message
+	if err := m.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != id {
+		t.Errorf("response ID = %d; want %d", m.ID, id)
+	}
+	if len(m.Questions) != 1 || m.Questions[0].Name.String() != name {
+		t.Errorf("response questions = %v; want %s", m.Questions, name)
+	}
+	if len(m.Answers) != 1 {
+		t.Errorf("got %d answers; want 1", len(m.Answers))
+	}
+}
+
+// tlsServer is an in-process DNS-over-TLS server.
+type tlsServer struct {
+	ln     net.Listener
+	config *tls.Config // for clients
+	conns  int32
+
+	// batch is how many queries the server reads before
+	// answering them, in reverse order.
+	batch int
+
+	// closeAfter, if true, makes the server close each connection
+	// once it has answered a batch of queries.
+	closeAfter bool
+}
+
+func newTLSServer(t *testing.T, batch int, closeAfter bool) *tlsServer {
+	// Borrow the test certificate of httptest.
+	hs := httptest.NewTLSServer(nil)
+	hs.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: hs.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(hs.Certificate())
+	s := &tlsServer{
+		ln:         ln,
+		config:     &tls.Config{RootCAs: roots, ServerName: "example.com"},
+		batch:      batch,
+		closeAfter: closeAfter,
+	}
+	go s.serve()
+	return s
+}
+
+func (s *tlsServer) transport() *TLS {
+	return &TLS{Addr: s.ln.Addr().String(), Config: s.config}
+}
+
+func (s *tlsServer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&s.conns, 1)
+		go s.serveConn(c)
+	}
+}
+
+func (s *tlsServer) serveConn(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for {
+		var queries [][]byte
+		for len(queries) < s.batch {
+			var l [2]byte
+			if _, err := io.ReadFull(br, l[:]); err != nil {
+				return
+			}
+			q := make([]byte, int(l[0])<<8|int(l[1]))
+			if _, err := io.ReadFull(br, q); err != nil {
+				return
+			}
+			queries = append(queries, q)
+		}
+		for i := len(queries) - 1; i >= 0; i-- {
+			resp, err := answer(queries[i])
+			if err != nil {
+				return
+			}
+			msg := append([]byte{byte(len(resp) >> 8), byte(len(resp))}, resp...)
+			if _, err := c.Write(msg); err != nil {
+				return
+			}
+		}
+		if s.closeAfter {
+			return
+		}
+	}
+}
+
+func (s *tlsServer) close() { s.ln.Close() }
+
+func TestTLS(t *testing.T) {
+	s := newTLSServer(t, 1, false)
+	defer s.close()
+	tr := s.transport()
+	defer tr.Close()
+
+	for i := 0; i < 3; i++ {
+		id := uint16(1000 + i)
+		resp, err := tr.Exchange(context.Background(), "", newQuery(t, id, "golang.org."))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResponse(t, resp, id, "golang.org.")
+	}
+	if n := atomic.LoadInt32(&s.conns); n != 1 {
+		t.Errorf("%d connections for sequential queries; want 1", n)
+	}
+
+	tr.Close()
+	if _, err := tr.Exchange(context.Background(), "", newQuery(t, 1, "golang.org.")); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&s.conns); n != 2 {
+		t.Errorf("%d connections after Close; want 2", n)
+	}
+}
+
+func TestTLSPipelining(t *testing.T) {
+	const n = 10
+	s := newTLSServer(t, n, false)
+	defer s.close()
+	tr := s.transport()
+	defer tr.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// All the queries have the same ID, as the queries
+			// of different lookups might.
+			name := fmt.Sprintf("q%d.example.com.", i)
+			resp, err := tr.Exchange(context.Background(), "", newQuery(t, 7, name))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			checkResponse(t, resp, 7, name)
+		}(i)
+	}
+	wg.Wait()
+	if c := atomic.LoadInt32(&s.conns); c != 1 {
+		t.Errorf("%d connections for concurrent queries; want 1", c)
+	}
+}
+
+func TestTLSServerClosesConnection(t *testing.T) {
+	s := newTLSServer(t, 1, true)
+	defer s.close()
+	tr := s.transport()
+	defer tr.Close()
+
+	for i := 0; i < 3; i++ {
+		resp, err := tr.Exchange(context.Background(), "", newQuery(t, 1, "golang.org."))
+		if err != nil {
+			t.Fatalf("query %d: %v", i, err)
+		}
+		checkResponse(t, resp, 1, "golang.org.")
+	}
+}
+
+func TestTLSIdleTimeout(t *testing.T) {
+	s := newTLSServer(t, 1, false)
+	defer s.close()
+	tr := s.transport()
+	tr.IdleTimeout = 10 * time.Millisecond
+	defer tr.Close()
+
+	if _, err := tr.Exchange(context.Background(), "", newQuery(t, 1, "golang.org.")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		tr.mu.Lock()
+		open := tr.conn != nil
+		tr.mu.Unlock()
+		if !open {
+			break
+		}
+		if i == 100 {
+			t.Fatal("idle connection still open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := tr.Exchange(context.Background(), "", newQuery(t, 1, "golang.org.")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSCancel(t *testing.T) {
+	s := newTLSServer(t, 2, false) // never answers a lone query
+	defer s.close()
+	tr := s.transport()
+	defer tr.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := tr.Exchange(ctx, "", newQuery(t, 1, "golang.org.")); err != context.DeadlineExceeded {
+		t.Errorf("Exchange = %v; want %v", err, context.DeadlineExceeded)
+	}
+	tr.mu.Lock()
+	c := tr.conn
+	tr.mu.Unlock()
+	if c == nil {
+		t.Fatal("connection closed by a canceled query")
+	}
+	c.mu.Lock()
+	pending := len(c.pending)
+	c.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("%d queries pending after cancelation; want 0", pending)
+	}
+}
+
+func TestHTTPS(t *testing.T) {
+	var conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, err := ioutil.ReadAll(r.Body)
+		if err != nil || len(query) < headerLen {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if id := messageID(query); id != 0 {
+			t.Errorf("query ID = %d; want 0", id)
+		}
+		resp, err := answer(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", dnsMessageType)
+		w.Write(resp)
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.StartTLS()
+	defer ts.Close()
+	tr := &HTTPS{URL: ts.URL + "/dns-query", Client: ts.Client()}
+
+	for i := 0; i < 3; i++ {
+		id := uint16(1000 + i)
+		resp, err := tr.Exchange(context.Background(), "", newQuery(t, id, "golang.org."))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResponse(t, resp, id, "golang.org.")
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("%d connections for sequential queries; want 1", n)
+	}
+}
+
+func TestHTTPSErrors(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/html" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write(make([]byte, 100))
+			return
+		}
+		http.Error(w, "no DNS here", http.StatusNotFound)
+	}))
+	defer ts.Close()
+	for _, path := range []string{"/html", "/missing"} {
+		tr := &HTTPS{URL: ts.URL + path, Client: ts.Client()}
+		if _, err := tr.Exchange(context.Background(), "", newQuery(t, 1, "golang.org.")); err == nil {
+			t.Errorf("%s: no error", path)
+		}
+	}
+}
@@ -0,0 +1,651 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP response caching, as described by RFC 7234.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStorage is the interface implemented by the stores a
+// CachingTransport keeps responses in. Entries are opaque byte slices
+// keyed by request URL. Implementations may drop entries at any time
+// and must be safe for concurrent use.
+type CacheStorage interface {
+	// Get returns the entry stored under key, if any.
+	Get(key string) (value []byte, ok bool)
+
+	// Set stores value under key, replacing any existing entry.
+	// The storage may retain value; the caller must not modify it.
+	Set(key string, value []byte)
+
+	// Delete removes the entry stored under key, if any.
+	Delete(key string)
+}
+
+// NewMemoryCacheStorage returns a CacheStorage that keeps entries in
+// memory, evicting the least recently used ones once the total size of
+// their keys and values exceeds maxBytes.
+func NewMemoryCacheStorage(maxBytes int64) CacheStorage {
+	return &memoryCacheStorage{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+type memoryCacheStorage struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	ll       *list.List // of *memoryCacheItem; most recently used at front
+	items    map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	value []byte
+}
+
+func (it *memoryCacheItem) size() int64 { return int64(len(it.key) + len(it.value)) }
+
+func (s *memoryCacheStorage) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(e)
+	return e.Value.(*memoryCacheItem).value, true
+}
+
+func (s *memoryCacheStorage) Set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(key)
+	it := &memoryCacheItem{key, value}
+	if it.size() > s.maxBytes {
+		return
+	}
+	s.items[key] = s.ll.PushFront(it)
+	s.size += it.size()
+	for s.size > s.maxBytes {
+		s.removeLocked(s.ll.Back().Value.(*memoryCacheItem).key)
+	}
+}
+
+func (s *memoryCacheStorage) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(key)
+}
+
+func (s *memoryCacheStorage) removeLocked(key string) {
+	if e, ok := s.items[key]; ok {
+		s.size -= e.Value.(*memoryCacheItem).size()
+		s.ll.Remove(e)
+		delete(s.items, key)
+	}
+}
+
+// defaultCacheStorageSize is the size of the CacheStorage used by a
+// CachingTransport with a nil Storage.
+const defaultCacheStorageSize = 64 << 20
+
+// maxCachedBody is the largest response body a CachingTransport
+// stores.
+const maxCachedBody = 32 << 20
+
+// CachingTransport is a RoundTripper that acts as a private HTTP
+// cache, as described by RFC 7234. It answers GET requests from
+// previously stored responses while they are fresh according to their
+// Cache-Control and Expires headers, revalidates stale responses that
+// carry an ETag or Last-Modified validator with a conditional request,
+// and honors Vary and the request's own Cache-Control directives
+// (no-cache, no-store, max-age, max-stale, min-fresh and
+// only-if-cached). Successful requests with unsafe methods, such as
+// POST, invalidate the stored response for their URL.
+//
+// Responses are stored once their body has been read to the end, and
+// only if it is no larger than 32 MB. Range requests and requests
+// carrying their own conditional headers bypass the cache.
+//
+// Responses served from the cache have an Age header, and, if served
+// stale, a Warning header. When the request's context carries an
+// httptrace.ClientTrace, its GotCachedResponse hook is called for
+// each response served from the cache.
+//
+// A CachingTransport is safe for concurrent use.
+type CachingTransport struct {
+	// Transport is used to make requests the cache cannot answer.
+	// If nil, DefaultTransport is used.
+	Transport RoundTripper
+
+	// Storage holds the cached responses.
+	// If nil, an in-memory storage of 64 MB is used.
+	Storage CacheStorage
+
+	storageOnce    sync.Once
+	defaultStorage CacheStorage
+}
+
+func (t *CachingTransport) transport() RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return DefaultTransport
+}
+
+func (t *CachingTransport) storage() CacheStorage {
+	if t.Storage != nil {
+		return t.Storage
+	}
+	t.storageOnce.Do(func() {
+		t.defaultStorage = NewMemoryCacheStorage(defaultCacheStorageSize)
+	})
+	return t.defaultStorage
+}
+
+func cacheKey(u *url.URL) string {
+	if u.Fragment == "" {
+		return u.String()
+	}
+	u2 := *u
+	u2.Fragment = ""
+	return u2.String()
+}
+
+func (t *CachingTransport) RoundTrip(req *Request) (*Response, error) {
+	if req.Method != "GET" && req.Method != "" {
+		res, err := t.transport().RoundTrip(req)
+		if err == nil && !isSafeMethod(req.Method) && res.StatusCode < 400 {
+			t.invalidate(req, res)
+		}
+		return res, err
+	}
+	if req.Header.Get("Range") != "" || hasConditional(req.Header) {
+		return t.transport().RoundTrip(req)
+	}
+
+	key := cacheKey(req.URL)
+	reqCC := parseCacheControl(req.Header)
+	var e *cacheEntry
+	if !reqCC.has("no-store") {
+		e = t.load(key, req)
+	}
+	if e != nil {
+		if ok, stale := e.usable(req, reqCC, time.Now()); ok {
+			return e.response(req, false, stale), nil
+		}
+	}
+	if reqCC.has("only-if-cached") {
+		return &Response{
+			Status:     "504 Gateway Timeout",
+			StatusCode: StatusGatewayTimeout,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     make(Header),
+			Body:       NoBody,
+			Request:    req,
+		}, nil
+	}
+
+	outreq := req
+	if e != nil && e.hasValidator() {
+		outreq = e.conditionalRequest(req)
+	}
+	reqTime := time.Now()
+	res, err := t.transport().RoundTrip(outreq)
+	if err != nil {
+		return nil, err
+	}
+	resTime := time.Now()
+	if outreq != req && res.StatusCode == StatusNotModified {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		e.update(res.Header, reqTime, resTime)
+		t.store(key, e)
+		return e.response(req, true, false), nil
+	}
+	if res.Request == outreq {
+		res.Request = req
+	}
+	if !reqCC.has("no-store") && storable(res) {
+		t.storeOnEOF(key, req, res, reqTime, resTime)
+	}
+	return res, nil
+}
+
+// invalidate removes the stored responses an unsafe request may have
+// changed, as described by RFC 7234 section 4.4.
+func (t *CachingTransport) invalidate(req *Request, res *Response) {
+	t.storage().Delete(cacheKey(req.URL))
+	for _, h := range []string{"Location", "Content-Location"} {
+		v := res.Header.Get(h)
+		if v == "" {
+			continue
+		}
+		if u, err := req.URL.Parse(v); err == nil && u.Host == req.URL.Host {
+			t.storage().Delete(cacheKey(u))
+		}
+	}
+}
+
+func (t *CachingTransport) load(key string, req *Request) *cacheEntry {
+	data, ok := t.storage().Get(key)
+	if !ok {
+		return nil
+	}
+	e, err := decodeCacheEntry(data)
+	if err != nil {
+		t.storage().Delete(key)
+		return nil
+	}
+	if !e.matches(req) {
+		return nil
+	}
+	return e
+}
+
+func (t *CachingTransport) store(key string, e *cacheEntry) {
+	t.storage().Set(key, e.encode())
+}
+
+// storeOnEOF arranges for res to be stored once its body has been read
+// to the end.
+func (t *CachingTransport) storeOnEOF(key string, req *Request, res *Response, reqTime, resTime time.Time) {
+	stored := new(Response)
+	*stored = *res
+	stored.Header = res.Header.clone()
+	e := &cacheEntry{
+		reqTime: reqTime,
+		resTime: resTime,
+		vary:    make(Header),
+		res:     stored,
+	}
+	for _, name := range varyHeaders(res.Header) {
+		e.vary[name] = req.Header[name]
+	}
+	if res.Body == nil || res.Body == NoBody || res.ContentLength == 0 {
+		t.store(key, e)
+		return
+	}
+	res.Body = &cachingBody{rc: res.Body, done: func(body []byte) {
+		e.body = body
+		t.store(key, e)
+	}}
+}
+
+// cachingBody copies a response body as it is read, calling done with
+// the copy if the body is read to the end.
+type cachingBody struct {
+	rc   io.ReadCloser
+	buf  bytes.Buffer
+	done func([]byte) // nil once called or abandoned
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if b.done != nil {
+		b.buf.Write(p[:n])
+		if b.buf.Len() > maxCachedBody {
+			b.done = nil
+			b.buf = bytes.Buffer{}
+		} else if err == io.EOF {
+			b.done(b.buf.Bytes())
+			b.done = nil
+		}
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	b.done = nil
+	return b.rc.Close()
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func hasConditional(h Header) bool {
+	for _, k := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		if _, ok := h[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// heuristicallyCacheable reports whether responses with the given
+// status may be stored without explicit freshness information, per
+// RFC 7231 section 6.1 and RFC 7538.
+func heuristicallyCacheable(code int) bool {
+	switch code {
+	case 200, 203, 204, 300, 301, 308, 404, 405, 410, 414, 501:
+		return true
+	}
+	return false
+}
+
+// storable reports whether res, the response to a GET request, may be
+// stored, as described by RFC 7234 section 3.
+func storable(res *Response) bool {
+	if res.StatusCode == StatusPartialContent {
+		return false
+	}
+	cc := parseCacheControl(res.Header)
+	if cc.has("no-store") {
+		return false
+	}
+	for _, name := range varyHeaders(res.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+	_, hasExpires := res.Header["Expires"]
+	return hasExpires || cc.has("max-age") || cc.has("public") || heuristicallyCacheable(res.StatusCode)
+}
+
+// varyHeaders returns the canonical names of the request headers
+// listed in h's Vary header.
+func varyHeaders(h Header) []string {
+	var names []string
+	for _, v := range h["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				names = append(names, CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// cacheControl holds the directives of a Cache-Control header, keyed
+// by lower-case name. Directives without an argument map to "".
+type cacheControl map[string]string
+
+func parseCacheControl(h Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range h["Cache-Control"] {
+		for _, d := range strings.Split(v, ",") {
+			d = textproto.TrimString(d)
+			if d == "" {
+				continue
+			}
+			name, arg := d, ""
+			if i := strings.IndexByte(d, '='); i >= 0 {
+				name, arg = textproto.TrimString(d[:i]), textproto.TrimString(d[i+1:])
+				arg = strings.Trim(arg, `"`)
+			}
+			cc[strings.ToLower(name)] = arg
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the delta-seconds argument of the named directive.
+// An invalid argument is treated as zero.
+func (cc cacheControl) seconds(name string) (d time.Duration, ok bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true
+	}
+	if n > int64(1<<63-1)/int64(time.Second) {
+		n = int64(1<<63-1) / int64(time.Second)
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// A cacheEntry is a stored response.
+type cacheEntry struct {
+	reqTime time.Time // when the request that produced res was sent
+	resTime time.Time // when res was received
+	vary    Header    // request headers selected by res's Vary header
+	res     *Response // Body is not used
+	body    []byte
+}
+
+// encode serializes e as a line holding its times, its Vary request
+// headers, and its response in HTTP/1.1 wire format.
+func (e *cacheEntry) encode() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d %d %s\r\n", e.reqTime.UnixNano(), e.resTime.UnixNano(), e.res.Proto)
+	e.vary.Write(&buf)
+	buf.WriteString("\r\n")
+	res := &Response{
+		Status:        e.res.Status,
+		StatusCode:    e.res.StatusCode,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.res.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+	}
+	res.Write(&buf)
+	return buf.Bytes()
+}
+
+func decodeCacheEntry(data []byte) (*cacheEntry, error) {
+	br := bufio.NewReader(bytes.NewReader(data))
+	tp := textproto.NewReader(br)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	var reqNano, resNano int64
+	var proto string
+	if _, err := fmt.Sscanf(line, "%d %d %s", &reqNano, &resNano, &proto); err != nil {
+		return nil, errors.New("http: malformed cache entry")
+	}
+	vary, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	res, err := ReadResponse(br, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = nil
+	res.Proto = proto
+	res.ProtoMajor, res.ProtoMinor, _ = ParseHTTPVersion(proto)
+	return &cacheEntry{
+		reqTime: time.Unix(0, reqNano),
+		resTime: time.Unix(0, resNano),
+		vary:    Header(vary),
+		res:     res,
+		body:    body,
+	}, nil
+}
+
+// matches reports whether e may answer req, according to the Vary
+// header of e's response.
+func (e *cacheEntry) matches(req *Request) bool {
+	for _, name := range varyHeaders(e.res.Header) {
+		if name == "*" {
+			return false
+		}
+		if strings.Join(req.Header[name], ", ") != strings.Join(e.vary[name], ", ") {
+			return false
+		}
+	}
+	return true
+}
+
+// date returns the value of e's Date header, or the time e was
+// received if it has none.
+func (e *cacheEntry) date() time.Time {
+	if d, err := ParseTime(e.res.Header.Get("Date")); err == nil {
+		return d
+	}
+	return e.resTime
+}
+
+// age returns e's current age, as described by RFC 7234 section 4.2.3.
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	apparentAge := e.resTime.Sub(e.date())
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	correctedAge := e.resTime.Sub(e.reqTime)
+	if v, err := strconv.ParseInt(e.res.Header.Get("Age"), 10, 64); err == nil && v > 0 {
+		correctedAge += time.Duration(v) * time.Second
+	}
+	if apparentAge > correctedAge {
+		correctedAge = apparentAge
+	}
+	return correctedAge + now.Sub(e.resTime)
+}
+
+// lifetime returns e's freshness lifetime, as described by RFC 7234
+// section 4.2.1, using a heuristic of 10% of the time since the
+// response was last modified if it has no explicit lifetime.
+func (e *cacheEntry) lifetime(cc cacheControl) time.Duration {
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+	if v, ok := e.res.Header["Expires"]; ok {
+		expires, err := ParseTime(strings.Join(v, ""))
+		if err != nil {
+			return 0
+		}
+		return expires.Sub(e.date())
+	}
+	if lm, err := ParseTime(e.res.Header.Get("Last-Modified")); err == nil && heuristicallyCacheable(e.res.StatusCode) {
+		if d := e.date().Sub(lm) / 10; d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// usable reports whether e may answer req without revalidation, and
+// if so, whether it is stale.
+func (e *cacheEntry) usable(req *Request, reqCC cacheControl, now time.Time) (ok, stale bool) {
+	resCC := parseCacheControl(e.res.Header)
+	if reqCC.has("no-cache") || resCC.has("no-cache") {
+		return false, false
+	}
+	if len(reqCC) == 0 && strings.Contains(strings.ToLower(req.Header.Get("Pragma")), "no-cache") {
+		return false, false
+	}
+	age := e.age(now)
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false, false
+	}
+	lifetime := e.lifetime(resCC)
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok {
+		lifetime -= minFresh
+	}
+	if age < lifetime {
+		return true, false
+	}
+	if resCC.has("must-revalidate") || !reqCC.has("max-stale") {
+		return false, false
+	}
+	if reqCC["max-stale"] != "" {
+		// A bare max-stale accepts a response of any staleness.
+		if maxStale, _ := reqCC.seconds("max-stale"); age-lifetime > maxStale {
+			return false, false
+		}
+	}
+	return true, true
+}
+
+func (e *cacheEntry) hasValidator() bool {
+	return e.res.Header.Get("ETag") != "" || e.res.Header.Get("Last-Modified") != ""
+}
+
+// conditionalRequest returns a copy of req that asks the server to
+// validate e.
+func (e *cacheEntry) conditionalRequest(req *Request) *Request {
+	r2 := new(Request)
+	*r2 = *req
+	r2.Header = req.Header.clone()
+	if etag := e.res.Header.Get("ETag"); etag != "" {
+		r2.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.res.Header.Get("Last-Modified"); lm != "" {
+		r2.Header.Set("If-Modified-Since", lm)
+	}
+	return r2
+}
+
+// update freshens e with the headers of a 304 Not Modified response,
+// as described by RFC 7234 section 4.3.4.
+func (e *cacheEntry) update(h Header, reqTime, resTime time.Time) {
+	for k, vv := range h {
+		switch k {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Content-Range", "Connection":
+			continue
+		}
+		e.res.Header[k] = vv
+	}
+	if _, ok := h["Date"]; !ok {
+		e.res.Header.Del("Date")
+	}
+	e.reqTime, e.resTime = reqTime, resTime
+}
+
+// response returns a new Response for req from e, and reports it to
+// req's ClientTrace.
+func (e *cacheEntry) response(req *Request, revalidated, stale bool) *Response {
+	now := time.Now()
+	age := e.age(now)
+	res := new(Response)
+	*res = *e.res
+	res.Header = e.res.Header.clone()
+	res.Header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	if stale {
+		res.Header.Add("Warning", `110 - "Response is Stale"`)
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(e.body))
+	res.ContentLength = int64(len(e.body))
+	res.TransferEncoding = nil
+	res.Uncompressed = false
+	res.Request = req
+	res.TLS = nil
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.GotCachedResponse != nil {
+		trace.GotCachedResponse(httptrace.CachedResponseInfo{
+			Revalidated: revalidated,
+			Stale:       stale,
+			Age:         age,
+		})
+	}
+	return res
+}
@@ -0,0 +1,329 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cacheOrigin is a fake origin server for CachingTransport tests.
+type cacheOrigin struct {
+	calls  int
+	last   *Request
+	handle func(r *Request) (code int, h Header, body string)
+}
+
+func (o *cacheOrigin) RoundTrip(r *Request) (*Response, error) {
+	o.calls++
+	o.last = r
+	code, h, body := o.handle(r)
+	if h == nil {
+		h = make(Header)
+	}
+	return &Response{
+		Status:        fmt.Sprintf("%d %s", code, StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
+
+// cacheGet sends req through ct and reads the whole response. It
+// returns the response, its body, and the info reported to
+// GotCachedResponse, if it was called.
+func cacheGet(t *testing.T, ct *CachingTransport, req *Request) (*Response, string, *httptrace.CachedResponseInfo) {
+	t.Helper()
+	var info *httptrace.CachedResponseInfo
+	trace := &httptrace.ClientTrace{
+		GotCachedResponse: func(i httptrace.CachedResponseInfo) { info = &i },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	res, err := ct.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body), info
+}
+
+func newCacheRequest(method, url string, h Header) *Request {
+	req, _ := NewRequest(method, url, nil)
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	return req
+}
+
+func httpDate(t time.Time) string { return t.UTC().Format(TimeFormat) }
+
+func TestCachingTransportFresh(t *testing.T) {
+	origin := &cacheOrigin{handle: func(r *Request) (int, Header, string) {
+		return 200, Header{"Cache-Control": {"max-age=3600"}, "Date": {httpDate(time.Now())}}, "hello"
+	}}
+	ct := &CachingTransport{Transport: origin}
+	const url = "http://example.com/a"
+
+	_, body, info := cacheGet(t, ct, newCacheRequest("GET", url, nil))
+	if body != "hello" || info != nil {
+		t.Fatalf("first GET: body %q, cache info %v; want \"hello\" from the network", body, info)
+	}
+	res, body, info := cacheGet(t, ct, newCacheRequest("GET", url, nil))
+	if body != "hello" || info == nil || info.Revalidated || info.Stale {
+		t.Errorf("second GET: body %q, cache info %+v; want fresh cache hit", body, info)
+	}
+	if origin.calls != 1 {
+		t.Errorf("origin called %d times; want 1", origin.calls)
+	}
+	if res.Header.Get("Age") == "" {
+		t.Error("cached response has no Age header")
+	}
+	if res.Request == nil || res.Request.URL.String() != url {
+		t.Errorf("cached response Request = %v", res.Request)
+	}
+
+	// Request directives.
+	cacheGet(t, ct, newCacheRequest("GET", url, Header{"Cache-Control": {"no-cache"}}))
+	if origin.calls != 2 {
+		t.Errorf("no-cache request: origin called %d times; want 2", origin.calls)
+	}
+	cacheGet(t, ct, newCacheRequest("GET", url, Header{"Pragma": {"no-cache"}}))
+	if origin.calls != 3 {
+		t.Errorf("Pragma: no-cache request: origin called %d times; want 3", origin.calls)
+	}
+	cacheGet(t, ct, newCacheRequest("GET", url, Header{"Cache-Control": {"min-fresh=7200"}}))
+	if origin.calls != 4 {
+		t.Errorf("min-fresh request: origin called %d times; want 4", origin.calls)
+	}
+	if _, _, info := cacheGet(t, ct, newCacheRequest("GET", url, Header{"Cache-Control": {"max-age=600"}})); info == nil {
+		t.Error("max-age=600 request for a new response missed the cache")
+	}
+}
+
+func TestCachingTransportUnreadBody(t *testing.T) {
+	origin := &cacheOrigin{handle: func(r *Request) (int, Header, string) {
+		return 200, Header{"Cache-Control": {"max-age=3600"}}, "hello"
+	}}
+	ct := &CachingTransport{Transport: origin}
+	res, err := ct.RoundTrip(newCacheRequest("GET", "http://example.com/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	cacheGet(t, ct, newCacheRequest("GET", "http://example.com/", nil))
+	if origin.calls != 2 {
+		t.Errorf("origin called %d times; want 2, as an unread body isn't stored", origin.calls)
+	}
+}
+
+func TestCachingTransportRevalidate(t *testing.T) {
+	past := httpDate(time.Now().Add(-2 * time.Minute))
+	origin := &cacheOrigin{handle: func(r *Request) (int, Header, string) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			return 304, Header{"Cache-Control": {"max-age=60"}, "X-Fresh": {"yes"}}, ""
+		}
+		return 200, Header{
+			"Cache-Control": {"max-age=60"},
+			"Date":          {past},
+			"Etag":          {`"v1"`},
+		}, "body v1"
+	}}
+	ct := &CachingTransport{Transport: origin}
+	const url = "http://example.com/r"
+
+	cacheGet(t, ct, newCacheRequest("GET", url, nil))
+	res, body, info := cacheGet(t, ct, newCacheRequest("GET", url, nil))
+	if origin.calls != 2 || origin.last.Header.Get("If-None-Match") != `"v1"` {
+		t.Fatalf("stale entry not revalidated: %d calls, last If-None-Match %q", origin.calls, origin.last.Header.Get("If-None-Match"))
+	}
+	if res.StatusCode != 200 || body != "body v1" || info == nil || !info.Revalidated {
+		t.Errorf("revalidated GET = %d %q, cache info %+v; want 200 \"body v1\" revalidated", res.StatusCode, body, info)
+	}
+	if res.Header.Get("X-Fresh") != "yes" {
+		t.Error("headers from 304 response not merged into stored response")
+	}
+
+	// The 304 freshened the entry.
+	if _, _, info := cacheGet(t, ct, newCacheRequest("GET", url, nil)); info == nil || info.Revalidated {
+		t.Errorf("GET after revalidation: cache info %+v; want fresh hit", info)
+	}
+	if origin.calls != 2 {
+		t.Errorf("origin called %d times; want 2", origin.calls)
+	}
+}
+
+func TestCachingTransportStale(t *testing.T) {
+	origin := &cacheOrigin{handle: func(r *Request) (int, Header, string) {
+		return 200, Header{
+			"Date":    {httpDate(time.Now())},
+			"Expires": {httpDate(time.Now().Add(-time.Hour))},
+		}, "old"
+	}}
+	ct := &CachingTransport{Transport: origin}
+	const url = "http://example.com/s"
+
+	cacheGet(t, ct, newCacheRequest("GET", url, nil))
+	cacheGet(t, ct, newCacheRequest("GET", url, nil))
+	if origin.calls != 2 {
+		t.Errorf("expired response without validators: origin called %d times; want 2", origin.calls)
+	}
+
+	res, _, info := cacheGet(t, ct, newCacheRequest("GET", url, Header{"Cache-Control": {"max-stale"}}))
+	if info == nil || !info.Stale {
+		t.Fatalf("max-stale request: cache info %+v; want stale hit", info)
+	}
+	if !strings.HasPrefix(res.Header.Get("Warning"), "110 ") {
+		t.Errorf("stale response Warning = %q; want 110", res.Header.Get("Warning"))
+	}
+	if _, _, info := cacheGet(t, ct, newCacheRequest("GET", url, Header{"Cache-Control": {"max-stale=60"}})); info != nil {
+		t.Errorf("max-stale=60 request served an hour-stale response: %+v", info)
+	}
+
+	res, _, _ = cacheGet(t, &CachingTransport{Transport: origin}, newCacheRequest("GET", url, Header{"Cache-Control": {"only-if-cached"}}))
+	if res.StatusCode != StatusGatewayTimeout {
+		t.Errorf("only-if-cached miss status = %d; want %d", res.StatusCode, StatusGatewayTimeout)
+	}
+}
+
+func TestCachingTransportHeuristic(t *testing.T) {
+	now := time.Now()
+	origin := &cacheOrigin{handle: func(r *Request) (int, Header, string) {
+		return 200, Header{
+			"Date":          {httpDate(now)},
+			"Last-Modified": {httpDate(now.Add(-10 * 24 * time.Hour))},
+		}, "static"
+	}}
+	ct := &CachingTransport{Transport: origin}
+	cacheGet(t, ct, newCacheRequest("GET", "http://example.com/h", nil))
+	if _, _, info := cacheGet(t, ct, newCacheRequest("GET", "http://example.com/h", nil)); info == nil {
+		t.Error("response modified 10 days ago not heuristically fresh")
+	}
+}
+
+func TestCachingTransportNotStored(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   int
+		header Header
+		reqHdr Header
+	}{
+		{"no-store response", 200, Header{"Cache-Control": {"no-store, max-age=3600"}}, nil},
+		{"no-store request", 200, Header{"Cache-Control": {"max-age=3600"}}, Header{"Cache-Control": {"no-store"}}},
+		{"Vary *", 200, Header{"Cache-Control": {"max-age=3600"}, "Vary": {"*"}}, nil},
+		{"uncacheable status", 500, nil, nil},
+		{"range request", 200, Header{"Cache-Control": {"max-age=3600"}}, Header{"Range": {"bytes=0-1"}}},
+	}
+	for _, tt := range tests {
+		origin := &cacheOrigin{handle: func(r *Request) (int, Header, string) {
+			return tt.code, tt.header, "body"
+		}}
+		ct := &CachingTransport{Transport: origin}
+		cacheGet(t, ct, newCacheRequest("GET", "http://example.com/", tt.reqHdr))
+		cacheGet(t, ct, newCacheRequest("GET", "http://example.com/", tt.reqHdr))
+		if origin.calls != 2 {
+			t.Errorf("%s: origin called %d times; want 2", tt.name, origin.calls)
+		}
+	}
+}
+
+func TestCachingTransportVary(t *testing.T) {
+	origin := &cacheOrigin{handle: func(r *Request) (int, Header, string) {
+		return 200, Header{
+			"Cache-Control": {"max-age=3600"},
+			"Vary":          {"Accept-Language"},
+		}, "in " + r.Header.Get("Accept-Language")
+	}}
+	ct := &CachingTransport{Transport: origin}
+	const url = "http://example.com/v"
+	en := Header{"Accept-Language": {"en"}}
+	fr := Header{"Accept-Language": {"fr"}}
+
+	cacheGet(t, ct, newCacheRequest("GET", url, en))
+	if _, body, info := cacheGet(t, ct, newCacheRequest("GET", url, en)); info == nil || body != "in en" {
+		t.Errorf("same Accept-Language: body %q, cache info %+v; want hit", body, info)
+	}
+	if _, body, info := cacheGet(t, ct, newCacheRequest("GET", url, fr)); info != nil || body != "in fr" {
+		t.Errorf("different Accept-Language: body %q, cache info %+v; want miss", body, info)
+	}
+}
+
+func TestCachingTransportInvalidate(t *testing.T) {
+	origin := &cacheOrigin{handle: func(r *Request) (int, Header, string) {
+		if r.Method == "POST" {
+			return 201, Header{"Location": {"/other"}}, ""
+		}
+		return 200, Header{"Cache-Control": {"max-age=3600"}}, r.URL.Path
+	}}
+	ct := &CachingTransport{Transport: origin}
+	for _, u := range []string{"http://example.com/items", "http://example.com/other"} {
+		cacheGet(t, ct, newCacheRequest("GET", u, nil))
+	}
+	cacheGet(t, ct, newCacheRequest("POST", "http://example.com/items", nil))
+	calls := origin.calls
+	for _, u := range []string{"http://example.com/items", "http://example.com/other"} {
+		if _, _, info := cacheGet(t, ct, newCacheRequest("GET", u, nil)); info != nil {
+			t.Errorf("GET %s after POST served from cache", u)
+		}
+	}
+	if origin.calls != calls+2 {
+		t.Errorf("origin called %d times after POST; want 2", origin.calls-calls)
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(Header{"Cache-Control": {`Max-Age=60, no-cache`, `private="Set-Cookie", max-stale`}})
+	want := cacheControl{"max-age": "60", "no-cache": "", "private": "Set-Cookie", "max-stale": ""}
+	if len(cc) != len(want) {
+		t.Fatalf("parseCacheControl = %q; want %q", cc, want)
+	}
+	for k, v := range want {
+		if cc[k] != v || !cc.has(k) {
+			t.Errorf("directive %q = %q; want %q", k, cc[k], v)
+		}
+	}
+	if d, ok := cc.seconds("max-age"); !ok || d != time.Minute {
+		t.Errorf("seconds(max-age) = %v, %v; want 1m, true", d, ok)
+	}
+	if d, ok := (cacheControl{"max-age": "bogus"}).seconds("max-age"); !ok || d != 0 {
+		t.Errorf("seconds of invalid max-age = %v, %v; want 0, true", d, ok)
+	}
+}
+
+func TestMemoryCacheStorage(t *testing.T) {
+	s := NewMemoryCacheStorage(10)
+	s.Set("a", []byte("111")) // 4 bytes
+	s.Set("b", []byte("222")) // 8 bytes
+	s.Get("a")                // a is now most recently used
+	s.Set("c", []byte("333")) // 12 bytes; evicts b
+	if _, ok := s.Get("b"); ok {
+		t.Error("least recently used entry not evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := s.Get(k); !ok {
+			t.Errorf("entry %q evicted", k)
+		}
+	}
+	s.Set("big", make([]byte, 20))
+	if _, ok := s.Get("big"); ok {
+		t.Error("entry larger than the storage was kept")
+	}
+	s.Delete("a")
+	if _, ok := s.Get("a"); ok {
+		t.Error("deleted entry still present")
+	}
+}
@@ -9,6 +9,7 @@ package http_test
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"crypto/tls"
@@ -20,7 +21,9 @@ import (
 	"net"
 	. "net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
 	"net/http/httputil"
+	"net/textproto"
 	"net/url"
 	"os"
 	"reflect"
@@ -696,6 +699,61 @@ func testTrailersServerToClient(t *testing.T, h2, flush bool) {
 	}
 }
 
+// Tests that handlers can send 1xx informational responses before the
+// final response and that the client reports them via httptrace.
+func TestInformationalResponses_h1(t *testing.T) { testInformationalResponses(t, h1Mode) }
+func TestInformationalResponses_h2(t *testing.T) { testInformationalResponses(t, h2Mode) }
+
+func testInformationalResponses(t *testing.T, h2 bool) {
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		h := w.Header()
+		h.Add("Link", "</style.css>; rel=preload; as=style")
+		h.Set("Content-Length", "123") // not sent with a 1xx response
+		w.WriteHeader(StatusEarlyHints)
+
+		h.Add("Link", "</script.js>; rel=preload; as=script")
+		w.WriteHeader(StatusEarlyHints)
+
+		w.WriteHeader(StatusProcessing)
+
+		h.Del("Content-Length")
+		h.Set("Content-Type", "text/plain")
+		io.WriteString(w, "done")
+	}))
+	defer cst.close()
+
+	var got []string
+	req, _ := NewRequest("GET", cst.ts.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			got = append(got, fmt.Sprintf("%d %q cl=%q", code, header["Link"], header.Get("Content-Length")))
+			return nil
+		},
+	}))
+	res, err := cst.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wantBody(res, nil, "done"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`103 ["</style.css>; rel=preload; as=style"] cl=""`,
+		`103 ["</style.css>; rel=preload; as=style" "</script.js>; rel=preload; as=script"] cl=""`,
+		`102 ["</style.css>; rel=preload; as=style" "</script.js>; rel=preload; as=script"] cl=""`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("1xx responses:\n got: %q\nwant: %q", got, want)
+	}
+	if res.StatusCode != StatusOK {
+		t.Errorf("StatusCode = %d; want 200", res.StatusCode)
+	}
+	if links := res.Header["Link"]; len(links) != 2 {
+		t.Errorf("final response Link = %q; want both links", links)
+	}
+}
+
 // Don't allow a Body.Read after Body.Close. Issue 13648.
 func TestResponseBodyReadAfterClose_h1(t *testing.T) { testResponseBodyReadAfterClose(t, h1Mode) }
 func TestResponseBodyReadAfterClose_h2(t *testing.T) { testResponseBodyReadAfterClose(t, h2Mode) }
@@ -0,0 +1,232 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// On-the-fly response compression.
+
+package http
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"sync"
+)
+
+// compressMinSize is the smallest response body CompressHandler
+// compresses; smaller bodies gain little and may grow.
+const compressMinSize = 1024
+
+// CompressHandler returns a handler that runs h and compresses its
+// responses with gzip or deflate, whichever the request's
+// Accept-Encoding header prefers.
+//
+// Only responses with a compressible Content-Type (text/*, JSON,
+// JavaScript, XML, SVG and WebAssembly) of at least 1 KB are
+// compressed. Responses that h has already encoded, partial content
+// responses and responses to HEAD requests are passed through
+// unchanged. A compressed response has its Content-Length header
+// removed and any ETag made weak, since the encoded bytes differ from
+// those the tag was computed for. All compressible responses carry a
+// "Vary: Accept-Encoding" header.
+//
+// The ResponseWriter passed to h supports Flush, which flushes any
+// compressed data buffered so far, and Unwrap for use with
+// ResponseController.
+func CompressHandler(h Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		cw := &compressWriter{rw: w}
+		if r.Method != "HEAD" {
+			cw.coding = negotiateCompression(r.Header)
+		}
+		h.ServeHTTP(cw, r)
+		cw.close()
+	})
+}
+
+// negotiateCompression returns the content coding CompressHandler
+// should use for a request with header h, or "" for none.
+func negotiateCompression(h Header) string {
+	gz, fl := acceptEncodingQ(h, "gzip"), acceptEncodingQ(h, "deflate")
+	switch {
+	case gz > 0 && gz >= fl:
+		return "gzip"
+	case fl > 0:
+		return "deflate"
+	}
+	return ""
+}
+
+var (
+	gzipWriterPool sync.Pool // of *gzip.Writer
+	zlibWriterPool sync.Pool // of *zlib.Writer
+)
+
+// compressWriter is the ResponseWriter passed to the handler wrapped by
+// CompressHandler. It holds back the response header until it has
+// seen enough of the body to decide whether to compress it.
+type compressWriter struct {
+	rw     ResponseWriter
+	coding string // negotiated coding; "" to never compress
+
+	code        int    // status passed to WriteHeader
+	wroteHeader bool   // whether the handler's WriteHeader was called
+	started     bool   // whether the header has been sent to rw
+	buf         []byte // body held back until started
+
+	enc compressor // non-nil when compressing
+}
+
+// A compressor is a *gzip.Writer or *zlib.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func (cw *compressWriter) Header() Header { return cw.rw.Header() }
+
+func (cw *compressWriter) Unwrap() ResponseWriter { return cw.rw }
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		if cw.started {
+			cw.rw.WriteHeader(code) // let rw report the superfluous call
+		}
+		return
+	}
+	if code >= 100 && code <= 199 {
+		cw.rw.WriteHeader(code)
+		return
+	}
+	cw.wroteHeader = true
+	cw.code = code
+	if !bodyAllowedForStatus(code) || code == StatusPartialContent {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(StatusOK)
+	}
+	if !cw.started {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= compressMinSize {
+			cw.start(true)
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.rw.Write(p)
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(StatusOK)
+	}
+	if !cw.started {
+		cw.start(len(cw.buf) > 0)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.rw.(Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start sends the response header, compressing the body from here on
+// if large is set and the response is eligible, and writes any body
+// held back so far.
+func (cw *compressWriter) start(large bool) {
+	cw.started = true
+	h := cw.rw.Header()
+	if _, haveType := h["Content-Type"]; !haveType && len(cw.buf) > 0 && h.Get("Content-Encoding") == "" {
+		// Sniff the plain body; the server would otherwise
+		// sniff the compressed one.
+		h.Set("Content-Type", DetectContentType(cw.buf))
+	}
+	eligible := h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		cw.code != StatusPartialContent && compressibleType(h.Get("Content-Type"))
+	if eligible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if eligible && large && cw.coding != "" && bodyAllowedForStatus(cw.code) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.coding)
+		if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("Etag", "W/"+etag)
+		}
+		cw.enc = newCompressor(cw.coding, cw.rw)
+	}
+	if cw.code != 0 {
+		cw.rw.WriteHeader(cw.code)
+	}
+	if len(cw.buf) > 0 {
+		if cw.enc != nil {
+			cw.enc.Write(cw.buf)
+		} else {
+			cw.rw.Write(cw.buf)
+		}
+		cw.buf = nil
+	}
+}
+
+// close finishes the response after the handler has returned.
+func (cw *compressWriter) close() {
+	if !cw.started {
+		cw.start(false)
+	}
+	if cw.enc == nil {
+		return
+	}
+	cw.enc.Close()
+	switch enc := cw.enc.(type) {
+	case *gzip.Writer:
+		gzipWriterPool.Put(enc)
+	case *zlib.Writer:
+		zlibWriterPool.Put(enc)
+	}
+	cw.enc = nil
+}
+
+func newCompressor(coding string, w io.Writer) compressor {
+	if coding == "gzip" {
+		if zw, ok := gzipWriterPool.Get().(*gzip.Writer); ok {
+			zw.Reset(w)
+			return zw
+		}
+		return gzip.NewWriter(w)
+	}
+	// The "deflate" content coding is the zlib format (RFC 1950)
+	// wrapped around compress/flate's raw DEFLATE stream.
+	if zw, ok := zlibWriterPool.Get().(*zlib.Writer); ok {
+		zw.Reset(w)
+		return zw
+	}
+	return zlib.NewWriter(w)
+}
+
+// compressibleType reports whether responses with the given
+// Content-Type benefit from compression.
+func compressibleType(ctype string) bool {
+	if i := strings.IndexByte(ctype, ';'); i >= 0 {
+		ctype = ctype[:i]
+	}
+	ctype = strings.ToLower(strings.TrimSpace(ctype))
+	switch {
+	case strings.HasPrefix(ctype, "text/"),
+		strings.HasSuffix(ctype, "+json"),
+		strings.HasSuffix(ctype, "+xml"):
+		return true
+	}
+	switch ctype {
+	case "application/json", "application/javascript", "application/x-javascript",
+		"application/xml", "image/svg+xml", "application/wasm":
+		return true
+	}
+	return false
+}
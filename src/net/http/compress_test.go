@@ -0,0 +1,144 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressHandler(t *testing.T) {
+	large := strings.Repeat("hello, compressible world\n", 100)
+	tests := []struct {
+		name           string
+		method         string
+		acceptEncoding string
+		header         Header // set by the handler
+		code           int
+		body           string
+
+		wantEncoding string
+		wantVary     bool
+	}{
+		{name: "gzip", acceptEncoding: "gzip, deflate", body: large, wantEncoding: "gzip", wantVary: true},
+		{name: "deflate", acceptEncoding: "gzip;q=0.5, deflate", body: large, wantEncoding: "deflate", wantVary: true},
+		{name: "sniffed", acceptEncoding: "gzip", header: Header{}, body: large, wantEncoding: "gzip", wantVary: true},
+		{name: "not accepted", acceptEncoding: "br", body: large, wantVary: true},
+		{name: "refused", acceptEncoding: "gzip;q=0, *;q=0", body: large, wantVary: true},
+		{name: "small", acceptEncoding: "gzip", body: "tiny", wantVary: true},
+		{name: "HEAD", method: "HEAD", acceptEncoding: "gzip", body: large, wantVary: true},
+		{name: "incompressible", acceptEncoding: "gzip", header: Header{"Content-Type": {"image/png"}}, body: large},
+		{name: "already encoded", acceptEncoding: "gzip",
+			header: Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"br"}}, body: large, wantEncoding: "br"},
+		{name: "partial", acceptEncoding: "gzip", code: StatusPartialContent,
+			header: Header{"Content-Type": {"text/plain"}, "Content-Range": {"bytes 0-2599/5000"}}, body: large},
+		{name: "not modified", acceptEncoding: "gzip", code: StatusNotModified, wantVary: true},
+	}
+	for _, tt := range tests {
+		h := CompressHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+			header := tt.header
+			if header == nil {
+				header = Header{"Content-Type": {"text/plain; charset=utf-8"}}
+			}
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("ETag", `"x"`)
+			if tt.code != 0 {
+				w.WriteHeader(tt.code)
+			}
+			if r.Method != "HEAD" {
+				io.WriteString(w, tt.body[:len(tt.body)/2])
+				io.WriteString(w, tt.body[len(tt.body)/2:])
+			}
+		}))
+		method := tt.method
+		if method == "" {
+			method = "GET"
+		}
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		res := rec.Result()
+
+		if ce := res.Header.Get("Content-Encoding"); ce != tt.wantEncoding {
+			t.Errorf("%s: Content-Encoding = %q; want %q", tt.name, ce, tt.wantEncoding)
+		}
+		if vary := res.Header.Get("Vary") == "Accept-Encoding"; vary != tt.wantVary {
+			t.Errorf("%s: Vary = %q; want Accept-Encoding: %v", tt.name, res.Header.Get("Vary"), tt.wantVary)
+		}
+		if tt.code != 0 && res.StatusCode != tt.code {
+			t.Errorf("%s: status = %d; want %d", tt.name, res.StatusCode, tt.code)
+		}
+		var body io.Reader = rec.Body
+		var err error
+		switch tt.wantEncoding {
+		case "gzip":
+			body, err = gzip.NewReader(body)
+		case "deflate":
+			body, err = zlib.NewReader(body)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if tt.wantEncoding == "gzip" || tt.wantEncoding == "deflate" {
+			if etag := res.Header.Get("Etag"); etag != `W/"x"` {
+				t.Errorf("%s: ETag = %q; want weak", tt.name, etag)
+			}
+			if cl := res.Header.Get("Content-Length"); cl != "" {
+				t.Errorf("%s: compressed response has Content-Length %q", tt.name, cl)
+			}
+		}
+		got, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Errorf("%s: reading body: %v", tt.name, err)
+		}
+		want := tt.body
+		if method == "HEAD" {
+			want = ""
+		}
+		if string(got) != want {
+			t.Errorf("%s: body = %q; want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestCompressHandlerFlush(t *testing.T) {
+	h := CompressHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(Flusher).Flush()
+		io.WriteString(w, "data: 2\n\n")
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !rec.Flushed {
+		t.Error("Flush not passed through")
+	}
+	if ce := rec.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("flushed stream Content-Encoding = %q; want gzip", ce)
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "data: 1\n\ndata: 2\n\n"; string(got) != want {
+		t.Errorf("body = %q; want %q", got, want)
+	}
+}
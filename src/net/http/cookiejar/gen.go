@@ -0,0 +1,163 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build ignore
+
+// This program generates table.go, the copy of the public suffix list
+// built into the cookiejar package, from publicsuffix.org's
+// public_suffix_list.dat. Invoke as
+//
+//	go run gen.go -output table.go
+//
+// or, to generate the table from a local copy of the list,
+//
+//	go run gen.go -input public_suffix_list.dat -output table.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"internal/x/net/idna"
+)
+
+const defaultURL = "https://publicsuffix.org/list/public_suffix_list.dat"
+
+var (
+	input   = flag.String("input", "", "file to read the list from instead of fetching "+defaultURL)
+	output  = flag.String("output", "table.go", "output file name")
+	version = flag.String("version", "", "list version to record, if the list has no VERSION comment")
+)
+
+func main() {
+	log.SetPrefix("gen: ")
+	log.SetFlags(0)
+	flag.Parse()
+
+	var r io.Reader
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	} else {
+		res, err := http.Get(defaultURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			log.Fatalf("fetching %s: %s", defaultURL, res.Status)
+		}
+		r = res.Body
+	}
+
+	rules, ver, err := parse(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *version != "" {
+		ver = *version
+	}
+	if ver == "" {
+		log.Fatal("the list has no VERSION comment; use -version")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by go run gen.go; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package cookiejar\n\n")
+	fmt.Fprintf(&buf, "// pslVersion describes the list pslRules was generated from.\n")
+	fmt.Fprintf(&buf, "const pslVersion = %q\n\n", "publicsuffix.org's public_suffix_list.dat, "+ver)
+	fmt.Fprintf(&buf, "// pslRules are the %d rules of the public suffix list in sorted order,\n", len(rules))
+	fmt.Fprintf(&buf, "// with internationalized labels converted to Punycode.\n")
+	fmt.Fprintf(&buf, "var pslRules = [...]string{\n")
+	for _, rule := range rules {
+		fmt.Fprintf(&buf, "\t%q,\n", rule)
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*output, src, 0666); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parse reads the rules of the list in r, returning them sorted, and
+// the version recorded in its VERSION and COMMIT comments, if any.
+func parse(r io.Reader) (rules []string, version string, err error) {
+	var commit string
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "//") {
+			if v := strings.TrimPrefix(line, "// VERSION:"); v != line {
+				version = strings.TrimSpace(v)
+			} else if v := strings.TrimPrefix(line, "// COMMIT:"); v != line {
+				commit = strings.TrimSpace(v)
+			}
+			continue
+		}
+		// Rules end at the first whitespace.
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		rule, err := canonicalRule(fields[0])
+		if err != nil {
+			return nil, "", err
+		}
+		if !seen[rule] {
+			seen[rule] = true
+			rules = append(rules, rule)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, "", err
+	}
+	if len(rules) == 0 {
+		return nil, "", fmt.Errorf("no rules found")
+	}
+	sort.Strings(rules)
+	if version != "" && commit != "" {
+		version += " (" + commit + ")"
+	}
+	return rules, version, nil
+}
+
+// canonicalRule returns rule in lower case with its labels in
+// Punycode, keeping any leading "!" or "*." marker.
+func canonicalRule(rule string) (string, error) {
+	var marker string
+	switch {
+	case strings.HasPrefix(rule, "!"):
+		marker, rule = "!", rule[1:]
+	case strings.HasPrefix(rule, "*."):
+		marker, rule = "*.", rule[2:]
+	}
+	if strings.Contains(rule, "*") {
+		return "", fmt.Errorf("unsupported wildcard in rule %q", marker+rule)
+	}
+	ascii, err := idna.ToASCII(rule)
+	if err != nil {
+		return "", fmt.Errorf("rule %q: %v", marker+rule, err)
+	}
+	return marker + strings.ToLower(ascii), nil
+}
@@ -3,6 +3,9 @@
 // license that can be found in the LICENSE file.
 
 // Package cookiejar implements an in-memory RFC 6265-compliant http.CookieJar.
+//
+// A Jar's cookies can be saved to and loaded from a file, and the
+// package includes a copy of the public suffix list for use with it.
 package cookiejar
 
 import (
@@ -29,8 +32,8 @@ import (
 // testing but it is not secure: it means that the HTTP server for foo.com can
 // set a cookie for bar.com.
 //
-// A public suffix list implementation is in the package
-// golang.org/x/net/publicsuffix.
+// DefaultPublicSuffixList is an implementation built from the list
+// maintained at https://publicsuffix.org/.
 type PublicSuffixList interface {
 	// PublicSuffix returns the public suffix of domain.
 	//
@@ -52,13 +55,20 @@ type Options struct {
 	//
 	// A nil value is valid and may be useful for testing but it is not
 	// secure: it means that the HTTP server for foo.co.uk can set a cookie
-	// for bar.co.uk.
+	// for bar.co.uk. Most programs should use DefaultPublicSuffixList.
 	PublicSuffixList PublicSuffixList
+
+	// SaveSessionCookies makes Jar.Save include session cookies,
+	// those without an Expires or Max-Age attribute, which are
+	// otherwise dropped like a browser drops them on exit. Programs
+	// that continue one logical session across runs may want to set it.
+	SaveSessionCookies bool
 }
 
 // Jar implements the http.CookieJar interface from the net/http package.
 type Jar struct {
-	psList PublicSuffixList
+	psList      PublicSuffixList
+	saveSession bool
 
 	// mu locks the remaining fields.
 	mu sync.Mutex
@@ -80,6 +90,7 @@ func New(o *Options) (*Jar, error) {
 	}
 	if o != nil {
 		jar.psList = o.PublicSuffixList
+		jar.saveSession = o.SaveSessionCookies
 	}
 	return jar, nil
 }
@@ -87,7 +98,8 @@ func New(o *Options) (*Jar, error) {
 // entry is the internal representation of a cookie.
 //
 // This struct type is not used outside of this package per se, but the exported
-// fields are those of RFC 6265.
+// fields are those of RFC 6265. They are also the fields of each cookie in the
+// JSON written by Jar.Save.
 type entry struct {
 	Name       string
 	Value      string
@@ -0,0 +1,124 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cookiejar
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Save writes the cookies in the jar to w as a JSON array, so that
+// Load can restore them later, possibly in another process.
+//
+// Expired cookies are not written. Neither are session cookies, those
+// without an Expires or Max-Age attribute, unless the jar was created
+// with Options.SaveSessionCookies set.
+func (j *Jar) Save(w io.Writer) error {
+	return j.save(w, time.Now())
+}
+
+// save is like Save but takes the current time as a parameter.
+func (j *Jar) save(w io.Writer, now time.Time) error {
+	j.mu.Lock()
+	entries := make([]entry, 0)
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if e.Persistent && !e.Expires.After(now) {
+				continue
+			}
+			if !e.Persistent && !j.saveSession {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	j.mu.Unlock()
+
+	// Write the cookies in the order they were created, which Load
+	// preserves.
+	sort.Slice(entries, func(i, k int) bool {
+		return entries[i].seqNum < entries[k].seqNum
+	})
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// Load adds the cookies in r, as written by Save, to the jar. They
+// replace any cookies in the jar with the same name, domain and path.
+//
+// Cookies that have expired since they were saved are skipped, as are
+// cookies with a malformed domain or path, and domain cookies for a
+// public suffix according to the jar's PublicSuffixList, which might
+// differ from that of the jar that saved them.
+func (j *Jar) Load(r io.Reader) error {
+	return j.load(r, time.Now())
+}
+
+// load is like Load but takes the current time as a parameter.
+func (j *Jar) load(r io.Reader, now time.Time) error {
+	var entries []entry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return fmt.Errorf("cookiejar: loading cookies: %v", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range entries {
+		if !j.validLoadedEntry(&e, now) {
+			continue
+		}
+		key := jarKey(e.Domain, j.psList)
+		submap := j.entries[key]
+		if submap == nil {
+			submap = make(map[string]entry)
+			j.entries[key] = submap
+		}
+		id := e.id()
+		if old, ok := submap[id]; ok {
+			e.seqNum = old.seqNum
+		} else {
+			e.seqNum = j.nextSeqNum
+			j.nextSeqNum++
+		}
+		submap[id] = e
+	}
+	return nil
+}
+
+// validLoadedEntry reports whether e, read by Load, may be added to
+// the jar, normalizing the fields Save does not need to preserve.
+func (j *Jar) validLoadedEntry(e *entry, now time.Time) bool {
+	if e.Persistent {
+		if !e.Expires.After(now) {
+			return false
+		}
+	} else {
+		e.Expires = endOfTime
+	}
+	if !strings.HasPrefix(e.Path, "/") {
+		return false
+	}
+	if host, err := canonicalHost(e.Domain); err != nil || host != e.Domain || host == "" {
+		return false
+	}
+	if !e.HostOnly {
+		// Apply the checks SetCookies applies to a Domain
+		// attribute, as if the cookie came from Domain itself.
+		domain, hostOnly, err := j.domainAndType(e.Domain, e.Domain)
+		if err != nil || hostOnly || domain != e.Domain {
+			return false
+		}
+	}
+	if e.Creation.IsZero() {
+		e.Creation = now
+	}
+	if e.LastAccess.IsZero() {
+		e.LastAccess = now
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cookiejar
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cookieString returns the cookies jar sends to u at now in the form
+// "name1=val1 name2=val2".
+func cookieString(jar *Jar, u string, now time.Time) string {
+	var s []string
+	for _, c := range jar.cookies(mustParseURL(u), now) {
+		s = append(s, c.Name+"="+c.Value)
+	}
+	return strings.Join(s, " ")
+}
+
+// populatedJar returns a jar holding a mix of persistent and session,
+// host and domain cookies, set at tNow.
+func populatedJar(o *Options) *Jar {
+	jar, err := New(o)
+	if err != nil {
+		panic(err)
+	}
+	jar.setCookies(mustParseURL("https://www.example.com/"), []*http.Cookie{
+		{Name: "host", Value: "1", MaxAge: 3600},
+		{Name: "domain", Value: "2", Domain: "example.com", MaxAge: 3600},
+		{Name: "secure", Value: "3", Path: "/app", Secure: true, HttpOnly: true, MaxAge: 3600},
+		{Name: "session", Value: "4"},
+		{Name: "shortlived", Value: "5", MaxAge: 10},
+	}, tNow)
+	return jar
+}
+
+func TestSaveLoad(t *testing.T) {
+	later := tNow.Add(time.Minute)
+	for _, saveSession := range []bool{false, true} {
+		jar := populatedJar(&Options{PublicSuffixList: testPSL{}, SaveSessionCookies: saveSession})
+		var buf bytes.Buffer
+		if err := jar.save(&buf, later); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded := newTestJar()
+		if err := loaded.load(bytes.NewReader(buf.Bytes()), later); err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range []struct{ url, want string }{
+			{"https://www.example.com/app", "secure=3 host=1 domain=2 session=4"},
+			{"http://www.example.com/app", "host=1 domain=2 session=4"},
+			{"https://other.example.com/app", "domain=2"},
+		} {
+			want := tt.want
+			if !saveSession {
+				want = strings.TrimSuffix(strings.TrimSuffix(want, "session=4"), " ")
+			}
+			if got := cookieString(loaded, tt.url, later); got != want {
+				t.Errorf("saveSession=%v: cookies for %s = %q; want %q", saveSession, tt.url, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadSkipsExpired(t *testing.T) {
+	jar := populatedJar(&Options{PublicSuffixList: testPSL{}, SaveSessionCookies: true})
+	var buf bytes.Buffer
+	if err := jar.save(&buf, tNow); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newTestJar()
+	later := tNow.Add(time.Minute)
+	if err := loaded.load(&buf, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cookieString(loaded, "http://www.example.com/", later), "host=1 domain=2 session=4"; got != want {
+		t.Errorf("cookies = %q; want %q", got, want)
+	}
+}
+
+func TestLoadReplaces(t *testing.T) {
+	jar := newTestJar()
+	jar.setCookies(mustParseURL("http://www.example.com/"), []*http.Cookie{
+		{Name: "a", Value: "old", MaxAge: 3600},
+		{Name: "b", Value: "kept", MaxAge: 3600},
+	}, tNow)
+	saved := newTestJar()
+	saved.setCookies(mustParseURL("http://www.example.com/"), []*http.Cookie{
+		{Name: "a", Value: "new", MaxAge: 3600},
+	}, tNow)
+	var buf bytes.Buffer
+	if err := saved.save(&buf, tNow); err != nil {
+		t.Fatal(err)
+	}
+	if err := jar.load(&buf, tNow); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cookieString(jar, "http://www.example.com/", tNow), "a=new b=kept"; got != want {
+		t.Errorf("cookies = %q; want %q", got, want)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	const valid = `"Value":"v","Path":"/","Persistent":true,"Expires":"2013-01-01T13:00:00Z"`
+	input := `[
+		{"Name":"ok",` + valid + `,"Domain":"www.example.com","HostOnly":true},
+		{"Name":"nopath","Value":"v","Domain":"www.example.com","HostOnly":true},
+		{"Name":"upper",` + valid + `,"Domain":"WWW.example.com","HostOnly":true},
+		{"Name":"port",` + valid + `,"Domain":"www.example.com:80","HostOnly":true},
+		{"Name":"suffix",` + valid + `,"Domain":"co.uk"},
+		{"Name":"ip",` + valid + `,"Domain":"127.0.0.1"},
+		{"Name":"expired","Value":"v","Path":"/","Persistent":true,"Expires":"2013-01-01T11:00:00Z","Domain":"www.example.com","HostOnly":true}
+	]`
+	jar := newTestJar()
+	if err := jar.load(strings.NewReader(input), tNow); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, submap := range jar.entries {
+		for _, e := range submap {
+			names = append(names, e.Name)
+		}
+	}
+	if got := strings.Join(names, " "); got != "ok" {
+		t.Errorf("loaded cookies %q; want only %q", got, "ok")
+	}
+
+	for _, bad := range []string{"", "{}", `[{"Name":1}]`, "[{"} {
+		if err := newTestJar().load(strings.NewReader(bad), tNow); err == nil {
+			t.Errorf("load(%q) succeeded; want error", bad)
+		}
+	}
+}
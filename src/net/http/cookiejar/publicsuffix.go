@@ -0,0 +1,66 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:generate go run gen.go -output table.go
+
+package cookiejar
+
+import (
+	"sort"
+	"strings"
+)
+
+// DefaultPublicSuffixList is a PublicSuffixList built from a copy of
+// the list maintained at https://publicsuffix.org/, including both
+// its ICANN and its private domains. Its String method reports the
+// version of the list.
+//
+// Domains passed to its PublicSuffix method must be in lower case,
+// without a trailing dot, and with internationalized labels already
+// converted to Punycode, as the Jar does.
+//
+// The list changes over time; the copy is refreshed by running
+// "go generate" in the cookiejar package directory.
+var DefaultPublicSuffixList PublicSuffixList = publicSuffixList{}
+
+type publicSuffixList struct{}
+
+// PublicSuffix implements the algorithm described at
+// https://publicsuffix.org/list/: the longest matching rule wins,
+// exception rules take priority over wildcard rules, and a domain
+// matching no rule has its last label as public suffix.
+func (publicSuffixList) PublicSuffix(domain string) string {
+	// Try the suffixes of domain from longest to shortest, so that
+	// the first match is the longest one. An exception rule is
+	// always longer than the wildcard rule it overrides.
+	for i := 0; i < len(domain); i++ {
+		if i > 0 && domain[i-1] != '.' {
+			continue
+		}
+		suffix := domain[i:]
+		if hasRule("!" + suffix) {
+			// The exception's leftmost label is not
+			// part of the public suffix.
+			return suffix[strings.IndexByte(suffix, '.')+1:]
+		}
+		if hasRule(suffix) {
+			return suffix
+		}
+		if dot := strings.IndexByte(suffix, '.'); dot >= 0 && hasRule("*"+suffix[dot:]) {
+			return suffix
+		}
+	}
+	// The implicit "*" rule.
+	return domain[strings.LastIndexByte(domain, '.')+1:]
+}
+
+func (publicSuffixList) String() string {
+	return pslVersion
+}
+
+// hasRule reports whether rule is in pslRules.
+func hasRule(rule string) bool {
+	i := sort.Search(len(pslRules), func(i int) bool { return pslRules[i] >= rule })
+	return i < len(pslRules) && pslRules[i] == rule
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cookiejar
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var publicSuffixTests = [...]struct {
+	domain, want string
+}{
+	// TLDs, listed and unlisted.
+	{"com", "com"},
+	{"example.com", "com"},
+	{"a.b.example.com", "com"},
+	{"example", "example"},
+	{"a.b.example.example", "example"},
+
+	// Two-level rules.
+	{"example.co.uk", "co.uk"},
+	{"b.example.uk.com", "uk.com"},
+	{"www.test.k12.ak.us", "k12.ak.us"},
+	{"test.ac.jp", "ac.jp"},
+
+	// Wildcard rules.
+	{"mm", "mm"},
+	{"c.mm", "c.mm"},
+	{"a.b.c.mm", "c.mm"},
+	{"ide.kyoto.jp", "ide.kyoto.jp"},
+	{"b.ide.kyoto.jp", "ide.kyoto.jp"},
+	{"c.kobe.jp", "c.kobe.jp"},
+
+	// Exception rules.
+	{"city.kobe.jp", "kobe.jp"},
+	{"www.city.kobe.jp", "kobe.jp"},
+	{"test.ck", "test.ck"},
+	{"www.ck", "ck"},
+	{"www.www.ck", "ck"},
+
+	// Internationalized labels in Punycode.
+	{"xn--85x722f.com.cn", "com.cn"},
+	{"shishi.xn--55qx5d.cn", "xn--55qx5d.cn"},
+
+	// Private domains.
+	{"foo.github.io", "github.io"},
+	{"foo.blogspot.com", "blogspot.com"},
+}
+
+func TestDefaultPublicSuffixList(t *testing.T) {
+	for _, tt := range publicSuffixTests {
+		if got := DefaultPublicSuffixList.PublicSuffix(tt.domain); got != tt.want {
+			t.Errorf("PublicSuffix(%q) = %q; want %q", tt.domain, got, tt.want)
+		}
+	}
+	if s := DefaultPublicSuffixList.String(); !strings.Contains(s, "publicsuffix.org") {
+		t.Errorf("String() = %q; want it to name the list", s)
+	}
+}
+
+func TestPublicSuffixRulesSorted(t *testing.T) {
+	if !sort.StringsAreSorted(pslRules[:]) {
+		t.Fatal("pslRules is not sorted; regenerate table.go")
+	}
+	for _, rule := range pslRules {
+		if rule != strings.ToLower(rule) || strings.ContainsAny(rule, " \t") {
+			t.Errorf("rule %q is not canonical", rule)
+		}
+	}
+}
+
+func TestJarDefaultPublicSuffixList(t *testing.T) {
+	jar, err := New(&Options{PublicSuffixList: DefaultPublicSuffixList})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar.setCookies(mustParseURL("http://www.example.co.uk/"), []*http.Cookie{
+		{Name: "a", Value: "1", Domain: "co.uk"},
+		{Name: "b", Value: "2", Domain: "example.co.uk"},
+	}, tNow)
+	jar.setCookies(mustParseURL("http://user.github.io/"), []*http.Cookie{
+		{Name: "c", Value: "3", Domain: "github.io"},
+	}, tNow)
+
+	for _, tt := range []struct{ url, want string }{
+		{"http://www.example.co.uk/", "b=2"},
+		{"http://other.co.uk/", ""},
+		{"http://other.github.io/", ""},
+	} {
+		var s []string
+		for _, c := range jar.cookies(mustParseURL(tt.url), tNow) {
+			s = append(s, c.Name+"="+c.Value)
+		}
+		if got := strings.Join(s, " "); got != tt.want {
+			t.Errorf("cookies for %s = %q; want %q", tt.url, got, tt.want)
+		}
+	}
+}
@@ -103,5 +103,11 @@ functions. Manually configuring HTTP/2 via the golang.org/x/net/http2
 package takes precedence over the net/http package's built-in HTTP/2
 support.
 
+HTTP/3, which runs over QUIC instead of TCP, is opt-in. A Server
+serves it with ServeHTTP3, or from ListenAndServeTLS when
+Server.EnableHTTP3 is set, and advertises it to clients in an Alt-Svc
+header on its HTTPS responses. A Transport with EnableHTTP3 set
+switches to HTTP/3 for origins advertising it.
+
 */
 package http
@@ -28,7 +28,6 @@ var (
 	ExportErrRequestCanceled          = errRequestCanceled
 	ExportErrRequestCanceledConn      = errRequestCanceledConn
 	ExportErrServerClosedIdle         = errServerClosedIdle
-	ExportServeFile                   = serveFile
 	ExportScanETag                    = scanETag
 	ExportHttp2ConfigureServer        = http2ConfigureServer
 	Export_shouldCopyHeaderOnRedirect = shouldCopyHeaderOnRedirect
@@ -36,6 +35,10 @@ var (
 	Export_is408Message               = is408Message
 )
 
+func ExportServeFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirect bool) {
+	serveFile(w, r, fs, name, redirect, nil)
+}
+
 const MaxWriteWaitBeforeConnReuse = maxWriteWaitBeforeConnReuse
 
 func init() {
@@ -82,6 +85,14 @@ func SetPendingDialHooks(before, after func()) {
 
 func SetTestHookServerServe(fn func(*Server, net.Listener)) { testHookServerServe = fn }
 
+// SetUse121 sets whether ServeMux behaves as with GODEBUG=httpmuxgo121=1,
+// and returns a function restoring the previous setting.
+func SetUse121(v bool) (restore func()) {
+	old := use121
+	use121 = v
+	return func() { use121 = old }
+}
+
 func NewTestTimeoutHandler(handler Handler, ch <-chan time.Time) Handler {
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -7,7 +7,9 @@
 // See https://fast-cgi.github.io/ for an unofficial mirror of the
 // original documentation.
 //
-// Currently only the responder role is supported.
+// Currently only the responder role is supported, both by applications,
+// with Serve, and by web servers passing requests to an application,
+// with Handler, which is both an http.Handler and an http.RoundTripper.
 package fcgi
 
 // This file defines the raw protocol and some utilities used by the child and
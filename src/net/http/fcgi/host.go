@@ -0,0 +1,829 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fcgi
+
+// This file implements FastCGI from the perspective of the web server,
+// which passes requests to a FastCGI application.
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Handler passes requests to a FastCGI application, such as PHP-FPM,
+// in the responder role, and writes back its responses.
+//
+// The application receives the same CGI variables as the environment
+// net/http/cgi.Handler gives a CGI process. Connections to it are kept
+// open and reused for later requests.
+//
+// A Handler is safe for concurrent use by multiple goroutines. Its
+// fields must not be changed once it has served a request.
+type Handler struct {
+	// Network and Address locate the application, as for net.Dial:
+	// "tcp" and "127.0.0.1:9000", or "unix" and
+	// "/run/php-fpm.sock", for instance. An empty Network means
+	// "tcp".
+	Network string
+	Address string
+
+	Path string // script run by the application, passed as SCRIPT_FILENAME
+	Root string // root URI prefix of handler or empty for "/"
+
+	Env    []string    // extra parameters to set, if any, as "key=value"
+	Logger *log.Logger // optional log for errors or nil to use log.Print
+	Stderr io.Writer   // optional writer for the application's error stream; nil means os.Stderr
+
+	// PathLocationHandler specifies the root http Handler that
+	// should handle internal redirects when the application
+	// returns a Location header value starting with a "/", as
+	// specified in RFC 3875 § 6.3.2. This will likely be
+	// http.DefaultServeMux.
+	//
+	// If nil, a response with a local URI path is instead sent
+	// back to the client and not redirected internally.
+	PathLocationHandler http.Handler
+
+	// MaxConns limits the number of connections to the
+	// application. Requests wait for a connection to become free
+	// when the limit is reached. Zero means no limit.
+	MaxConns int
+
+	// MaxIdleConns is the number of idle connections kept open
+	// for later requests. Zero means 2; a negative value means
+	// connections are closed as soon as they are idle.
+	MaxIdleConns int
+
+	// Multiplex makes concurrent requests share connections,
+	// spread over up to MaxConns connections, or one if MaxConns
+	// is zero. Only applications that report FCGI_MPXS_CONNS,
+	// such as those using Serve, support it; PHP-FPM does not.
+	// Responses sharing a connection are read from it in turn, so
+	// a client that is slow to read one delays the others.
+	Multiplex bool
+
+	mu      sync.Mutex
+	conns   []*hostConn     // open connections
+	idle    []*hostConn     // connections with no request, if !Multiplex
+	dialing int             // connections being opened
+	waiters []chan struct{} // closed when a connection may be available
+}
+
+const defaultMaxIdleConns = 2
+
+func (h *Handler) stderr() io.Writer {
+	if h.Stderr != nil {
+		return h.Stderr
+	}
+	return os.Stderr
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked" {
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte("Chunked request bodies are not supported by FastCGI."))
+		return
+	}
+
+	res, err := h.roundTrip(req)
+	if err != nil {
+		if _, ok := err.(badResponseError); ok {
+			rw.WriteHeader(http.StatusInternalServerError)
+		} else {
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+		h.printf("%v", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if loc := res.Header.Get("Location"); strings.HasPrefix(loc, "/") && h.PathLocationHandler != nil {
+		h.handleInternalRedirect(rw, req, loc)
+		return
+	}
+
+	// Copy headers to rw's headers, after we've decided not to
+	// go into handleInternalRedirect, which won't want its rw
+	// headers to have been touched.
+	for k, vv := range res.Header {
+		for _, v := range vv {
+			rw.Header().Add(k, v)
+		}
+	}
+
+	rw.WriteHeader(res.StatusCode)
+
+	if _, err := io.Copy(rw, res.Body); err != nil {
+		h.printf("fcgi: copy error: %v", err)
+	}
+}
+
+// A badResponseError reports an invalid response from the application.
+type badResponseError string
+
+func (e badResponseError) Error() string { return "fcgi: " + string(e) }
+
+// roundTrip passes req to the application and returns the response
+// it sends back, whose body streams the rest of it. The error is a
+// badResponseError if the application sent an invalid response.
+func (h *Handler) roundTrip(req *http.Request) (*http.Response, error) {
+	root := h.Root
+	if root == "" {
+		root = "/"
+	}
+	params := h.params(req, root)
+	var body io.Reader
+	if req.ContentLength != 0 {
+		body = req.Body
+	}
+
+	// A connection taken from the pool may have been closed by the
+	// application in the meantime. Nothing has been sent if starting
+	// the request fails, so it is retried once on a new connection.
+	var r *hostRequest
+	for retried := false; ; retried = true {
+		cc, reused, err := h.getConn(req.Context())
+		if err == nil {
+			r, err = cc.startRequest(params, body)
+		}
+		if err == nil {
+			break
+		}
+		if !reused || retried {
+			return nil, fmt.Errorf("fcgi: %v", err)
+		}
+	}
+
+	rb := &responseBody{r: r}
+	if done := req.Context().Done(); done != nil {
+		rb.finished = make(chan struct{})
+		go func() {
+			select {
+			case <-done:
+				r.stdout.CloseWithError(req.Context().Err())
+			case <-rb.finished:
+			}
+		}()
+	}
+	rb.Reader = bufio.NewReaderSize(r.stdout, 1024)
+
+	res, err := h.readResponseHeader(rb.Reader)
+	if err != nil {
+		rb.Close()
+		return nil, err
+	}
+	res.Body = rb
+	res.Request = req
+	return res, nil
+}
+
+// readResponseHeader reads the CGI response header from br.
+func (h *Handler) readResponseHeader(br *bufio.Reader) (*http.Response, error) {
+	headers := make(http.Header)
+	statusCode := 0
+	headerLines := 0
+	sawBlankLine := false
+	for {
+		line, isPrefix, err := br.ReadLine()
+		if isPrefix {
+			return nil, badResponseError("long header line from application.")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fcgi: error reading headers: %v", err)
+		}
+		if len(line) == 0 {
+			sawBlankLine = true
+			break
+		}
+		headerLines++
+		parts := strings.SplitN(string(line), ":", 2)
+		if len(parts) < 2 {
+			h.printf("fcgi: bogus header line: %s", string(line))
+			continue
+		}
+		header, val := parts[0], parts[1]
+		header = strings.TrimSpace(header)
+		val = strings.TrimSpace(val)
+		switch {
+		case header == "Status":
+			if len(val) < 3 {
+				return nil, badResponseError(fmt.Sprintf("bogus status (short): %q", val))
+			}
+			code, err := strconv.Atoi(val[0:3])
+			if err != nil {
+				return nil, badResponseError(fmt.Sprintf("bogus status: %q", val))
+			}
+			statusCode = code
+		default:
+			headers.Add(header, val)
+		}
+	}
+	if headerLines == 0 || !sawBlankLine {
+		return nil, badResponseError("no headers")
+	}
+
+	if statusCode == 0 && headers.Get("Location") != "" {
+		statusCode = http.StatusFound
+	}
+	if statusCode == 0 && headers.Get("Content-Type") == "" {
+		return nil, badResponseError("missing required Content-Type in headers")
+	}
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(statusCode) + " " + http.StatusText(statusCode),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        headers,
+		ContentLength: -1,
+	}, nil
+}
+
+// responseBody is the body of a response from the application.
+// Closing it ends the request.
+type responseBody struct {
+	*bufio.Reader
+	r        *hostRequest
+	finished chan struct{} // closed by Close, if non-nil
+	once     sync.Once
+}
+
+func (b *responseBody) Close() error {
+	b.once.Do(func() {
+		if b.finished != nil {
+			close(b.finished)
+		}
+		b.r.close()
+	})
+	return nil
+}
+
+// params returns the parameters of the FastCGI request for req: the
+// variables net/http/cgi.Handler sets in the environment of a CGI
+// process, other than those describing the host process, followed by
+// h.Env.
+func (h *Handler) params(req *http.Request, root string) map[string]string {
+	pathInfo := req.URL.Path
+	if root != "/" && strings.HasPrefix(pathInfo, root) {
+		pathInfo = pathInfo[len(root):]
+	}
+
+	port := "80"
+	if i := strings.LastIndexByte(req.Host, ':'); i >= 0 && isDigits(req.Host[i+1:]) {
+		port = req.Host[i+1:]
+	}
+
+	params := map[string]string{
+		"SERVER_SOFTWARE":   "go",
+		"SERVER_NAME":       req.Host,
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"HTTP_HOST":         req.Host,
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"REQUEST_METHOD":    req.Method,
+		"QUERY_STRING":      req.URL.RawQuery,
+		"REQUEST_URI":       req.URL.RequestURI(),
+		"PATH_INFO":         pathInfo,
+		"SCRIPT_NAME":       root,
+		"SCRIPT_FILENAME":   h.Path,
+		"SERVER_PORT":       port,
+	}
+
+	if remoteIP, remotePort, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		params["REMOTE_ADDR"] = remoteIP
+		params["REMOTE_HOST"] = remoteIP
+		params["REMOTE_PORT"] = remotePort
+	} else {
+		// could not parse ip:port, let's use whole RemoteAddr and leave REMOTE_PORT undefined
+		params["REMOTE_ADDR"] = req.RemoteAddr
+		params["REMOTE_HOST"] = req.RemoteAddr
+	}
+
+	if req.TLS != nil {
+		params["HTTPS"] = "on"
+	}
+
+	for k, v := range req.Header {
+		k = strings.Map(upperCaseAndUnderscore, k)
+		if k == "PROXY" {
+			// See Issue 16405
+			continue
+		}
+		joinStr := ", "
+		if k == "COOKIE" {
+			joinStr = "; "
+		}
+		params["HTTP_"+k] = strings.Join(v, joinStr)
+	}
+
+	if req.ContentLength > 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+	if ctype := req.Header.Get("Content-Type"); ctype != "" {
+		params["CONTENT_TYPE"] = ctype
+	}
+
+	for _, e := range h.Env {
+		if eq := strings.IndexByte(e, '='); eq != -1 {
+			params[e[:eq]] = e[eq+1:]
+		}
+	}
+	return params
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func upperCaseAndUnderscore(r rune) rune {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return r - ('a' - 'A')
+	case r == '-', r == '=':
+		return '_'
+	}
+	return r
+}
+
+func (h *Handler) printf(format string, v ...interface{}) {
+	if h.Logger != nil {
+		h.Logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+func (h *Handler) handleInternalRedirect(rw http.ResponseWriter, req *http.Request, path string) {
+	url, err := req.URL.Parse(path)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		h.printf("fcgi: error resolving local URI path %q: %v", path, err)
+		return
+	}
+	newReq := &http.Request{
+		Method:     "GET",
+		URL:        url,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       url.Host,
+		RemoteAddr: req.RemoteAddr,
+		TLS:        req.TLS,
+	}
+	h.PathLocationHandler.ServeHTTP(rw, newReq.WithContext(req.Context()))
+}
+
+// RoundTrip implements http.RoundTripper, so that a Handler can be the
+// Transport of an http.Client that sends its requests straight to the
+// application. The request is passed on as by ServeHTTP, with the host
+// of its URL as the server name unless req.Host is set. A failure to
+// reach the application, or an invalid response from it, is returned
+// as an error. PathLocationHandler is not used: a response with a
+// local URI path in its Location header is returned as is.
+//
+// FastCGI requires the length of a request body to be known in
+// advance, so requests with a body of unknown length are rejected.
+func (h *Handler) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.ContentLength <= 0 {
+		req.Body.Close()
+		return nil, errUnknownLength
+	}
+	r2 := new(http.Request)
+	*r2 = *req
+	if r2.Host == "" {
+		r2.Host = req.URL.Host
+	}
+	r2.RequestURI = req.URL.RequestURI()
+	if req.Body != nil {
+		// The body is closed once it has been sent, or by RoundTrip
+		// if the request fails, whichever comes first.
+		r2.Body = &onceCloseBody{ReadCloser: req.Body}
+	} else {
+		r2.Body = http.NoBody
+	}
+
+	res, err := h.roundTrip(r2)
+	if err != nil {
+		r2.Body.Close()
+		return nil, err
+	}
+	res.Request = req
+	return res, nil
+}
+
+var errUnknownLength = errors.New("fcgi: request body of unknown length")
+
+// onceCloseBody is a request body that may be closed more than once.
+type onceCloseBody struct {
+	io.ReadCloser
+	once sync.Once
+	err  error
+}
+
+func (b *onceCloseBody) Close() error {
+	b.once.Do(func() { b.err = b.ReadCloser.Close() })
+	return b.err
+}
+
+// CloseIdleConnections closes the connections to the application that
+// are not serving a request.
+func (h *Handler) CloseIdleConnections() {
+	h.mu.Lock()
+	var idle []*hostConn
+	for _, cc := range h.conns {
+		if cc.active == 0 {
+			idle = append(idle, cc)
+		}
+	}
+	for _, cc := range idle {
+		h.removeConnLocked(cc)
+	}
+	h.mu.Unlock()
+	for _, cc := range idle {
+		cc.close(errConnIdle)
+	}
+}
+
+var errConnIdle = errors.New("fcgi: idle connection closed")
+
+// getConn returns a connection on which to start a request, and
+// whether it was used by an earlier request.
+func (h *Handler) getConn(ctx context.Context) (cc *hostConn, reused bool, err error) {
+	limit := h.MaxConns
+	if h.Multiplex && limit <= 0 {
+		limit = 1
+	}
+	h.mu.Lock()
+	for {
+		if h.Multiplex {
+			for _, c := range h.conns {
+				if cc == nil || c.active < cc.active {
+					cc = c
+				}
+			}
+			if cc != nil && (cc.active == 0 || len(h.conns)+h.dialing >= limit) {
+				cc.active++
+				h.mu.Unlock()
+				return cc, true, nil
+			}
+			cc = nil
+		} else if n := len(h.idle); n > 0 {
+			cc = h.idle[n-1]
+			h.idle = h.idle[:n-1]
+			cc.active++
+			h.mu.Unlock()
+			return cc, true, nil
+		}
+		if limit <= 0 || len(h.conns)+h.dialing < limit {
+			break
+		}
+		wait := make(chan struct{})
+		h.waiters = append(h.waiters, wait)
+		h.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		h.mu.Lock()
+	}
+	h.dialing++
+	h.mu.Unlock()
+
+	network := h.Network
+	if network == "" {
+		network = "tcp"
+	}
+	var d net.Dialer
+	rwc, err := d.DialContext(ctx, network, h.Address)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dialing--
+	if err != nil {
+		h.wakeLocked()
+		return nil, false, err
+	}
+	cc = &hostConn{
+		h:      h,
+		c:      newConn(rwc),
+		reqs:   make(map[uint16]*hostRequest),
+		active: 1,
+	}
+	h.conns = append(h.conns, cc)
+	if h.Multiplex {
+		// Waiting requests may share the new connection.
+		h.wakeLocked()
+	}
+	go cc.readLoop()
+	return cc, false, nil
+}
+
+// putConn records that a request on cc is done.
+func (h *Handler) putConn(cc *hostConn) {
+	h.mu.Lock()
+	cc.active--
+	if cc.removed {
+		h.mu.Unlock()
+		return
+	}
+	if !h.Multiplex {
+		max := h.MaxIdleConns
+		if max == 0 {
+			max = defaultMaxIdleConns
+		}
+		if len(h.idle) >= max {
+			h.removeConnLocked(cc)
+			h.mu.Unlock()
+			cc.close(errConnIdle)
+			return
+		}
+		h.idle = append(h.idle, cc)
+	}
+	h.wakeLocked()
+	h.mu.Unlock()
+}
+
+// removeConn removes cc, which has been closed, from the pool.
+func (h *Handler) removeConn(cc *hostConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeConnLocked(cc)
+}
+
+func (h *Handler) removeConnLocked(cc *hostConn) {
+	if cc.removed {
+		return
+	}
+	cc.removed = true
+	h.conns = removeHostConn(h.conns, cc)
+	h.idle = removeHostConn(h.idle, cc)
+	h.wakeLocked()
+}
+
+func removeHostConn(conns []*hostConn, cc *hostConn) []*hostConn {
+	for i, c := range conns {
+		if c == cc {
+			copy(conns[i:], conns[i+1:])
+			conns[len(conns)-1] = nil
+			return conns[:len(conns)-1]
+		}
+	}
+	return conns
+}
+
+// wakeLocked wakes the requests waiting for a connection, so that
+// they look for one again.
+func (h *Handler) wakeLocked() {
+	for _, wait := range h.waiters {
+		close(wait)
+	}
+	h.waiters = nil
+}
+
+// hostConn is a connection to a FastCGI application.
+type hostConn struct {
+	h *Handler
+	c *conn
+
+	// guarded by h.mu
+	active  int  // requests using the connection
+	removed bool // no longer in the pool
+
+	mu     sync.Mutex
+	reqs   map[uint16]*hostRequest
+	lastID uint16
+	err    error // set when the connection is closed
+}
+
+// hostRequest is a request in progress on a hostConn. It stays in
+// the connection's requests, so that its ID is not reused, until the
+// application has ended it and its body has been sent.
+type hostRequest struct {
+	cc     *hostConn
+	id     uint16
+	stdout *io.PipeReader
+	pw     *io.PipeWriter
+
+	// guarded by cc.mu
+	ended     bool // FCGI_END_REQUEST received
+	stdinDone bool // FCGI_STDIN stream sent
+}
+
+// startRequest sends the beginning of a request and starts sending its
+// body, if any.
+func (cc *hostConn) startRequest(params map[string]string, body io.Reader) (*hostRequest, error) {
+	r := &hostRequest{cc: cc}
+	r.stdout, r.pw = io.Pipe()
+
+	cc.mu.Lock()
+	if cc.err != nil {
+		err := cc.err
+		cc.mu.Unlock()
+		cc.h.putConn(cc)
+		return nil, err
+	}
+	for {
+		cc.lastID++
+		if cc.lastID != 0 && cc.reqs[cc.lastID] == nil {
+			break
+		}
+	}
+	r.id = cc.lastID
+	cc.reqs[r.id] = r
+	cc.mu.Unlock()
+
+	b := []byte{0, roleResponder, flagKeepConn, 0, 0, 0, 0, 0}
+	err := cc.c.writeRecord(typeBeginRequest, r.id, b)
+	if err == nil {
+		err = cc.c.writePairs(typeParams, r.id, params)
+	}
+	if err == nil && body == nil {
+		err = cc.c.writeRecord(typeStdin, r.id, nil)
+	}
+	if err != nil {
+		cc.close(err)
+		cc.h.putConn(cc)
+		return nil, err
+	}
+	if body == nil {
+		cc.sentStdin(r, nil)
+	} else {
+		go func() {
+			w := newWriter(cc.c, typeStdin, r.id)
+			_, err := io.Copy(w, body)
+			if c, ok := body.(io.Closer); ok {
+				c.Close()
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			cc.sentStdin(r, err)
+		}()
+	}
+	return r, nil
+}
+
+// sentStdin records that r's body has been sent, or failed to be.
+func (cc *hostConn) sentStdin(r *hostRequest, err error) {
+	if err != nil {
+		cc.abort(r)
+	}
+	cc.mu.Lock()
+	r.stdinDone = true
+	done := r.ended
+	cc.mu.Unlock()
+	if done {
+		cc.finish(r)
+	}
+}
+
+// endRequest handles the FCGI_END_REQUEST record b for r.
+func (cc *hostConn) endRequest(r *hostRequest, b []byte) {
+	var err error
+	if len(b) < 8 {
+		err = errors.New("fcgi: short FCGI_END_REQUEST record")
+	} else {
+		switch status := b[4]; status {
+		case statusRequestComplete:
+		case statusCantMultiplex:
+			err = errors.New("fcgi: application cannot multiplex connections")
+		case statusOverloaded:
+			err = errors.New("fcgi: application is overloaded")
+		case statusUnknownRole:
+			err = errors.New("fcgi: application does not support the responder role")
+		default:
+			err = fmt.Errorf("fcgi: unknown protocol status %d (application status %d)",
+				status, binary.BigEndian.Uint32(b))
+		}
+	}
+	r.pw.CloseWithError(err)
+
+	cc.mu.Lock()
+	r.ended = true
+	done := r.stdinDone
+	cc.mu.Unlock()
+	if done {
+		cc.finish(r)
+	}
+}
+
+// finish releases the resources of r, which has ended and whose body
+// has been sent.
+func (cc *hostConn) finish(r *hostRequest) {
+	cc.mu.Lock()
+	if cc.reqs[r.id] != r {
+		// The connection has been closed.
+		cc.mu.Unlock()
+		return
+	}
+	delete(cc.reqs, r.id)
+	cc.mu.Unlock()
+	cc.h.putConn(cc)
+}
+
+// close is called once the handler is done with r.
+func (r *hostRequest) close() {
+	r.stdout.Close()
+	r.cc.mu.Lock()
+	ended := r.ended
+	r.cc.mu.Unlock()
+	if !ended {
+		r.cc.abort(r)
+	}
+}
+
+var errRequestAborted = errors.New("fcgi: request aborted")
+
+// abort stops r before the application has ended it. Without
+// multiplexing, the application is not expected to answer
+// FCGI_ABORT_REQUEST promptly, so the connection is closed instead.
+func (cc *hostConn) abort(r *hostRequest) {
+	if !cc.h.Multiplex {
+		cc.close(errRequestAborted)
+		return
+	}
+	if err := cc.c.writeRecord(typeAbortRequest, r.id, nil); err != nil {
+		cc.close(err)
+	}
+}
+
+// readLoop reads the records the application sends and passes them
+// on to the requests they belong to.
+func (cc *hostConn) readLoop() {
+	br := bufio.NewReader(cc.c.rwc)
+	var rec record
+	for {
+		if err := rec.read(br); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			cc.close(err)
+			return
+		}
+		cc.mu.Lock()
+		r := cc.reqs[rec.h.Id]
+		cc.mu.Unlock()
+		if r == nil {
+			// Records for unknown requests, including management
+			// records, are ignored.
+			continue
+		}
+		switch rec.h.Type {
+		case typeStdout:
+			if content := rec.content(); len(content) > 0 {
+				// This fails once the handler is done with r.
+				r.pw.Write(content)
+			}
+		case typeStderr:
+			cc.h.stderr().Write(rec.content())
+		case typeEndRequest:
+			cc.endRequest(r, rec.content())
+		}
+	}
+}
+
+// close closes the connection, failing the requests on it with err.
+func (cc *hostConn) close(err error) {
+	cc.mu.Lock()
+	if cc.err != nil {
+		cc.mu.Unlock()
+		return
+	}
+	cc.err = err
+	reqs := cc.reqs
+	cc.reqs = nil
+	cc.mu.Unlock()
+
+	// Not cc.c.Close, which would wait for a blocked write to finish.
+	cc.c.rwc.Close()
+	for _, r := range reqs {
+		r.pw.CloseWithError(err)
+	}
+	cc.h.removeConn(cc)
+}
@@ -0,0 +1,420 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fcgi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	n int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.n, 1)
+	}
+	return c, err
+}
+
+func (l *countingListener) conns() int {
+	return int(atomic.LoadInt32(&l.n))
+}
+
+// startApp serves app with Serve on a new listener for network.
+func startApp(t *testing.T, network string, app http.Handler) (*countingListener, func()) {
+	var (
+		ln  net.Listener
+		err error
+		dir string
+	)
+	if network == "unix" {
+		dir, err = ioutil.TempDir("", "fcgi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, err = net.Listen("unix", filepath.Join(dir, "app.sock"))
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", network, err)
+	}
+	cl := &countingListener{Listener: ln}
+	go Serve(cl, app)
+	return cl, func() {
+		ln.Close()
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+	}
+}
+
+// echoApp describes the requests it receives.
+var echoApp = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	w.Header().Set("X-Script", ProcessEnv(r)["SCRIPT_FILENAME"])
+	w.Header().Set("X-Extra", ProcessEnv(r)["EXTRA"])
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "%s %s host=%s foo=%s https=%v body=%q",
+		r.Method, r.URL.RequestURI(), r.Host, r.Header.Get("X-Foo"), r.TLS != nil, body)
+})
+
+func get(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+func TestHandler(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		t.Run(network, func(t *testing.T) {
+			ln, stop := startApp(t, network, echoApp)
+			defer stop()
+			h := &Handler{
+				Network: network,
+				Address: ln.Addr().String(),
+				Path:    "/srv/www/index.php",
+				Root:    "/app",
+				Env:     []string{"EXTRA=1", "SERVER_SOFTWARE=test"},
+			}
+			ts := httptest.NewServer(h)
+			defer ts.Close()
+
+			req, _ := http.NewRequest("POST", ts.URL+"/app/x?q=1", strings.NewReader("hello"))
+			req.Header.Set("X-Foo", "bar")
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusCreated {
+				t.Errorf("status = %d; want %d", res.StatusCode, http.StatusCreated)
+			}
+			if got := res.Header.Get("X-Script"); got != "/srv/www/index.php" {
+				t.Errorf("SCRIPT_FILENAME = %q", got)
+			}
+			if got := res.Header.Get("X-Extra"); got != "1" {
+				t.Errorf("EXTRA = %q", got)
+			}
+			want := fmt.Sprintf(`POST /app/x?q=1 host=%s foo=bar https=false body="hello"`, req.URL.Host)
+			if string(body) != want {
+				t.Errorf("body = %q; want %q", body, want)
+			}
+		})
+	}
+}
+
+func TestHandlerRoundTrip(t *testing.T) {
+	ln, stop := startApp(t, "tcp", echoApp)
+	defer stop()
+	h := &Handler{Address: ln.Addr().String(), Path: "/srv/www/index.php", Root: "/app"}
+	defer h.CloseIdleConnections()
+	c := &http.Client{Transport: h}
+
+	req, _ := http.NewRequest("POST", "http://example.com/app/x?q=1", strings.NewReader("hello"))
+	req.Header.Set("X-Foo", "bar")
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Errorf("status = %d; want %d", res.StatusCode, http.StatusCreated)
+	}
+	if got := res.Header.Get("X-Script"); got != "/srv/www/index.php" {
+		t.Errorf("SCRIPT_FILENAME = %q", got)
+	}
+	want := `POST /app/x?q=1 host=example.com foo=bar https=false body="hello"`
+	if string(body) != want {
+		t.Errorf("body = %q; want %q", body, want)
+	}
+
+	req, _ = http.NewRequest("POST", "http://example.com/app/x", ioutil.NopCloser(strings.NewReader("hello")))
+	if _, err := c.Do(req); err == nil || !strings.Contains(err.Error(), errUnknownLength.Error()) {
+		t.Errorf("request with a body of unknown length: error = %v; want %v", err, errUnknownLength)
+	}
+}
+
+// closeBody is a request body that reports when it is closed.
+type closeBody struct {
+	io.Reader
+	closed chan bool
+}
+
+func (b *closeBody) Close() error {
+	select {
+	case b.closed <- true:
+	default:
+	}
+	return nil
+}
+
+func newCloseBody(s string) *closeBody {
+	return &closeBody{Reader: strings.NewReader(s), closed: make(chan bool, 1)}
+}
+
+func (b *closeBody) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-b.closed:
+	case <-time.After(10 * time.Second):
+		t.Error("request body not closed")
+	}
+}
+
+func TestHandlerRoundTripErrors(t *testing.T) {
+	ln, stop := startApp(t, "tcp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		if r.URL.Path == "/long" {
+			w.Header().Set("X-Long", strings.Repeat("x", 2048))
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer stop()
+	h := &Handler{Address: ln.Addr().String(), Logger: log.New(ioutil.Discard, "", 0)}
+	defer h.CloseIdleConnections()
+
+	post := func(h *Handler, path string) (*http.Response, error) {
+		t.Helper()
+		body := newCloseBody("hello")
+		req, _ := http.NewRequest("POST", "http://example.com"+path, body)
+		req.ContentLength = 5
+		res, err := h.RoundTrip(req)
+		if err == nil {
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		body.wait(t)
+		return res, err
+	}
+
+	if _, err := post(h, "/"); err != nil {
+		t.Errorf("valid response: %v", err)
+	}
+	if _, err := post(h, "/long"); err == nil {
+		t.Error("invalid response: no error")
+	} else if _, ok := err.(badResponseError); !ok {
+		t.Errorf("invalid response: error = %v; want a badResponseError", err)
+	}
+
+	noApp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	noApp.Close()
+	if _, err := post(&Handler{Address: noApp.Addr().String()}, "/"); err == nil {
+		t.Error("no application: no error")
+	}
+}
+
+func TestHandlerParams(t *testing.T) {
+	h := &Handler{Path: "/srv/index.php", Env: []string{"SERVER_SOFTWARE=test", "DOCUMENT_ROOT=/srv"}}
+	req, _ := http.NewRequest("POST", "http://example.com:8080/app/x/y?q=1", strings.NewReader("body"))
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Add("Cookie", "a=1")
+	req.Header.Add("Cookie", "b=2")
+	req.Header.Set("Proxy", "evil")
+	req.Header.Set("Content-Type", "text/plain")
+	params := h.params(req, "/app")
+	for k, want := range map[string]string{
+		"SERVER_SOFTWARE": "test",
+		"SERVER_NAME":     "example.com:8080",
+		"SERVER_PORT":     "8080",
+		"REQUEST_METHOD":  "POST",
+		"REQUEST_URI":     "/app/x/y?q=1",
+		"QUERY_STRING":    "q=1",
+		"SCRIPT_NAME":     "/app",
+		"SCRIPT_FILENAME": "/srv/index.php",
+		"PATH_INFO":       "/x/y",
+		"REMOTE_ADDR":     "10.0.0.1",
+		"REMOTE_PORT":     "1234",
+		"HTTP_COOKIE":     "a=1; b=2",
+		"CONTENT_LENGTH":  "4",
+		"CONTENT_TYPE":    "text/plain",
+		"DOCUMENT_ROOT":   "/srv",
+	} {
+		if got := params[k]; got != want {
+			t.Errorf("%s = %q; want %q", k, got, want)
+		}
+	}
+	if v, ok := params["HTTP_PROXY"]; ok {
+		t.Errorf("HTTP_PROXY = %q; want unset", v)
+	}
+	if _, ok := params["HTTPS"]; ok {
+		t.Error("HTTPS set for a plain HTTP request")
+	}
+}
+
+func TestHandlerReusesConnections(t *testing.T) {
+	ln, stop := startApp(t, "tcp", echoApp)
+	defer stop()
+	h := &Handler{Address: ln.Addr().String()}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	for i := 0; i < 5; i++ {
+		if res, _ := get(t, ts.URL); res.StatusCode != http.StatusCreated {
+			t.Fatalf("request %d: status = %d", i, res.StatusCode)
+		}
+	}
+	if n := ln.conns(); n != 1 {
+		t.Errorf("%d connections for sequential requests; want 1", n)
+	}
+	h.CloseIdleConnections()
+	get(t, ts.URL)
+	if n := ln.conns(); n != 2 {
+		t.Errorf("%d connections after CloseIdleConnections; want 2", n)
+	}
+}
+
+// testConcurrentRequests makes n concurrent requests through h to an
+// application that answers none of them until it has received all of
+// them, if wait is true, and returns the number of connections it
+// accepted.
+func testConcurrentRequests(t *testing.T, h *Handler, n int, wait bool) int {
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	ln, stop := startApp(t, "tcp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wait {
+			arrived.Done()
+			arrived.Wait()
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer stop()
+	h.Address = ln.Addr().String()
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("/%d", i)
+			if _, body := get(t, ts.URL+path); body != path {
+				t.Errorf("body = %q; want %q", body, path)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return ln.conns()
+}
+
+func TestHandlerMultiplex(t *testing.T) {
+	if n := testConcurrentRequests(t, &Handler{Multiplex: true}, 10, true); n != 1 {
+		t.Errorf("%d connections; want 1", n)
+	}
+}
+
+func TestHandlerMaxConns(t *testing.T) {
+	if n := testConcurrentRequests(t, &Handler{MaxConns: 2}, 10, false); n > 2 {
+		t.Errorf("%d connections; want at most 2", n)
+	}
+}
+
+func TestHandlerLargeBody(t *testing.T) {
+	ln, stop := startApp(t, "tcp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+		w.Write(body)
+	}))
+	defer stop()
+	ts := httptest.NewServer(&Handler{Address: ln.Addr().String()})
+	defer ts.Close()
+
+	body := bytes.Repeat([]byte("0123456789"), 20000)
+	res, err := http.Post(ts.URL, "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(body, body...); !bytes.Equal(got, want) {
+		t.Errorf("got %d bytes; want %d", len(got), len(want))
+	}
+}
+
+func TestHandlerLocation(t *testing.T) {
+	ln, stop := startApp(t, "tcp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("loc"), http.StatusFound)
+	}))
+	defer stop()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal " + r.URL.Path))
+	})
+	h := &Handler{Address: ln.Addr().String(), PathLocationHandler: mux}
+	mux.Handle("/", h)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	if _, body := get(t, ts.URL+"/?loc=/internal"); body != "internal /internal" {
+		t.Errorf("internal redirect body = %q", body)
+	}
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := c.Get(ts.URL + "/?loc=http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "http://example.com/" {
+		t.Errorf("external redirect = %d %q; want 302 to http://example.com/",
+			res.StatusCode, res.Header.Get("Location"))
+	}
+}
+
+func TestHandlerNoApplication(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var logged bytes.Buffer
+	ts := httptest.NewServer(&Handler{Address: addr, Logger: log.New(&logged, "", 0)})
+	defer ts.Close()
+	if res, _ := get(t, ts.URL); res.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d; want %d", res.StatusCode, http.StatusBadGateway)
+	}
+	if !strings.Contains(logged.String(), "fcgi:") {
+		t.Errorf("logged %q; want an fcgi error", logged.String())
+	}
+}
@@ -160,7 +160,7 @@ func ServeContent(w ResponseWriter, req *Request, name string, modtime time.Time
 		}
 		return size, nil
 	}
-	serveContent(w, req, name, modtime, sizeFunc, content)
+	serveContent(w, req, name, modtime, sizeFunc, content, false)
 }
 
 // errSeeker is returned by ServeContent's sizeFunc when the content
@@ -177,7 +177,9 @@ var errNoOverlap = errors.New("invalid range: failed to overlap")
 // if modtime.IsZero(), modtime is unknown.
 // content must be seeked to the beginning of the file.
 // The sizeFunc is called at most once. Its error, if any, is sent in the HTTP response.
-func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time, sizeFunc func() (int64, error), content io.ReadSeeker) {
+// If precompressed, content is already encoded with the response's
+// Content-Encoding, and sizeFunc reports its encoded size.
+func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time, sizeFunc func() (int64, error), content io.ReadSeeker, precompressed bool) {
 	setLastModified(w, modtime)
 	done, rangeReq := checkPreconditions(w, r, modtime)
 	if done {
@@ -285,7 +287,7 @@ func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time,
 		}
 
 		w.Header().Set("Accept-Ranges", "bytes")
-		if w.Header().Get("Content-Encoding") == "" {
+		if w.Header().Get("Content-Encoding") == "" || precompressed {
 			w.Header().Set("Content-Length", strconv.FormatInt(sendSize, 10))
 		}
 	}
@@ -495,6 +497,7 @@ func writeNotModified(w ResponseWriter) {
 	h := w.Header()
 	delete(h, "Content-Type")
 	delete(h, "Content-Length")
+	delete(h, "Content-Encoding")
 	if h.Get("Etag") != "" {
 		delete(h, "Last-Modified")
 	}
@@ -537,7 +540,7 @@ func checkPreconditions(w ResponseWriter, r *Request, modtime time.Time) (done b
 }
 
 // name is '/'-separated, not filepath.Separator.
-func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirect bool) {
+func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirect bool, encodings []PrecompressedEncoding) {
 	const indexPage = "/index.html"
 
 	// redirect .../index.html to .../
@@ -615,9 +618,115 @@ func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirec
 		return
 	}
 
+	if len(encodings) > 0 {
+		cf, cd, coding, vary := openPrecompressed(fs, name, d, encodings, r.Header)
+		if vary {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		if cf != nil {
+			defer cf.Close()
+			// The type is that of the original file, not of its
+			// encoded sibling.
+			if _, haveType := w.Header()["Content-Type"]; !haveType {
+				ctype := mime.TypeByExtension(filepath.Ext(d.Name()))
+				if ctype == "" {
+					var buf [sniffLen]byte
+					n, _ := io.ReadFull(f, buf[:])
+					ctype = DetectContentType(buf[:n])
+				}
+				w.Header().Set("Content-Type", ctype)
+			}
+			w.Header().Set("Content-Encoding", coding)
+			if etag := w.Header().Get("Etag"); etag != "" {
+				w.Header().Set("Etag", precompressedETag(etag, coding))
+			}
+			sizeFunc := func() (int64, error) { return cd.Size(), nil }
+			serveContent(w, r, d.Name(), d.ModTime(), sizeFunc, cf, true)
+			return
+		}
+	}
+
 	// serveContent will check modification time
 	sizeFunc := func() (int64, error) { return d.Size(), nil }
-	serveContent(w, r, d.Name(), d.ModTime(), sizeFunc, f)
+	serveContent(w, r, d.Name(), d.ModTime(), sizeFunc, f, false)
+}
+
+// openPrecompressed opens the sibling of the named file, whose info
+// is d, that is best suited to a request with header h. It returns a
+// nil File if the request accepts none of the siblings. Siblings older
+// than the file itself are ignored as stale. The vary result reports
+// whether any sibling exists, in which case the response depends on
+// the request's Accept-Encoding.
+func openPrecompressed(fs FileSystem, name string, d os.FileInfo, encodings []PrecompressedEncoding, h Header) (f File, fd os.FileInfo, coding string, vary bool) {
+	var bestQ float64
+	for _, enc := range encodings {
+		ef, err := fs.Open(name + enc.Extension)
+		if err != nil {
+			continue
+		}
+		ed, err := ef.Stat()
+		if err != nil || ed.IsDir() || ed.ModTime().Before(d.ModTime()) {
+			ef.Close()
+			continue
+		}
+		vary = true
+		// Earlier encodings win ties.
+		if q := acceptEncodingQ(h, enc.ContentEncoding); q > bestQ {
+			if f != nil {
+				f.Close()
+			}
+			f, fd, coding, bestQ = ef, ed, enc.ContentEncoding, q
+			continue
+		}
+		ef.Close()
+	}
+	return f, fd, coding, vary
+}
+
+// precompressedETag returns the entity tag of the variant of a
+// representation, whose tag is etag, encoded with coding.
+func precompressedETag(etag, coding string) string {
+	if !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + coding + `"`
+}
+
+// acceptEncodingQ returns the quality value that the Accept-Encoding
+// header in h gives coding, or 0 if coding is not acceptable.
+func acceptEncodingQ(h Header, coding string) float64 {
+	q, wildcard := -1.0, -1.0
+	for _, v := range h["Accept-Encoding"] {
+		for _, elem := range strings.Split(v, ",") {
+			params := strings.Split(elem, ";")
+			name := strings.ToLower(textproto.TrimString(params[0]))
+			if name == "x-gzip" {
+				name = "gzip"
+			}
+			eq := 1.0
+			for _, p := range params[1:] {
+				p = textproto.TrimString(p)
+				if strings.HasPrefix(p, "q=") || strings.HasPrefix(p, "Q=") {
+					if f, err := strconv.ParseFloat(p[2:], 64); err == nil && f >= 0 && f <= 1 {
+						eq = f
+					}
+				}
+			}
+			switch name {
+			case coding:
+				q = eq
+			case "*":
+				wildcard = eq
+			}
+		}
+	}
+	if q >= 0 {
+		return q
+	}
+	if wildcard >= 0 {
+		return wildcard
+	}
+	return 0
 }
 
 // toHTTPError returns a non-specific HTTP error message and status code
@@ -678,7 +787,7 @@ func ServeFile(w ResponseWriter, r *Request, name string) {
 		return
 	}
 	dir, file := filepath.Split(name)
-	serveFile(w, r, Dir(dir), file, false)
+	serveFile(w, r, Dir(dir), file, false, nil)
 }
 
 func containsDotDot(v string) bool {
@@ -696,7 +805,8 @@ func containsDotDot(v string) bool {
 func isSlashRune(r rune) bool { return r == '/' || r == '\\' }
 
 type fileHandler struct {
-	root FileSystem
+	root      FileSystem
+	encodings []PrecompressedEncoding
 }
 
 // FileServer returns a handler that serves HTTP requests
@@ -711,7 +821,43 @@ type fileHandler struct {
 // ending in "/index.html" to the same path, without the final
 // "index.html".
 func FileServer(root FileSystem) Handler {
-	return &fileHandler{root}
+	return &fileHandler{root: root}
+}
+
+// A PrecompressedEncoding describes files stored precompressed with a
+// content coding, next to the original, under the original's name
+// with an added extension.
+type PrecompressedEncoding struct {
+	ContentEncoding string // content coding, such as "gzip"
+	Extension       string // file name extension, such as ".gz"
+}
+
+// defaultPrecompressedEncodings are the encodings served by a
+// PrecompressedFileServer created without any.
+var defaultPrecompressedEncodings = []PrecompressedEncoding{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// PrecompressedFileServer returns a handler that serves HTTP requests
+// like FileServer, except that for each file it also looks for
+// precompressed siblings, such as "app.js.gz" for "app.js", and serves
+// the one the request's Accept-Encoding header prefers instead of the
+// original. Encodings the request values equally are preferred in the
+// order given; if none are given, Brotli (".br") and gzip (".gz")
+// siblings are served.
+//
+// A sibling is served with the original's Content-Type and
+// Last-Modified time, and with a Content-Encoding header. Responses for
+// files with siblings carry a "Vary: Accept-Encoding" header. If the
+// caller has set an ETag header, it is extended with the content
+// coding for encoded responses, so that each variant has its own
+// entity tag. Siblings older than the original are ignored.
+func PrecompressedFileServer(root FileSystem, encodings ...PrecompressedEncoding) Handler {
+	if len(encodings) == 0 {
+		encodings = defaultPrecompressedEncodings
+	}
+	return &fileHandler{root: root, encodings: encodings}
 }
 
 func (f *fileHandler) ServeHTTP(w ResponseWriter, r *Request) {
@@ -720,7 +866,7 @@ func (f *fileHandler) ServeHTTP(w ResponseWriter, r *Request) {
 		upath = "/" + upath
 		r.URL.Path = upath
 	}
-	serveFile(w, r, f.root, path.Clean(upath), true)
+	serveFile(w, r, f.root, path.Clean(upath), true, f.encodings)
 }
 
 // httpRange specifies the byte range to be sent to the client.
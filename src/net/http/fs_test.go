@@ -556,6 +556,94 @@ func testServeFileWithContentEncoding(t *testing.T, h2 bool) {
 	}
 }
 
+func TestPrecompressedFileServer(t *testing.T) {
+	mod := time.Unix(1e9, 0)
+	fs := fakeFS{
+		"/app.js":     {basename: "app.js", modtime: mod, contents: "plain js"},
+		"/app.js.gz":  {basename: "app.js.gz", modtime: mod, contents: "gzipped"},
+		"/app.js.br":  {basename: "app.js.br", modtime: mod, contents: "brotli!"},
+		"/old.css":    {basename: "old.css", modtime: mod, contents: "plain css"},
+		"/old.css.gz": {basename: "old.css.gz", modtime: mod.Add(-time.Hour), contents: "stale"},
+		"/page.txt":   {basename: "page.txt", modtime: mod, contents: "plain text"},
+	}
+	var etag string
+	h := PrecompressedFileServer(fs)
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		h.ServeHTTP(w, r)
+	})
+	tests := []struct {
+		path, acceptEncoding string
+		reqHeader            Header
+		etag                 string
+
+		wantCode     int
+		wantBody     string
+		wantEncoding string
+		wantVary     bool
+		wantETag     string
+	}{
+		{path: "/app.js", acceptEncoding: "gzip, br", wantBody: "brotli!", wantEncoding: "br", wantVary: true},
+		{path: "/app.js", acceptEncoding: "gzip;q=1, br;q=0.5", wantBody: "gzipped", wantEncoding: "gzip", wantVary: true},
+		{path: "/app.js", acceptEncoding: "x-gzip", wantBody: "gzipped", wantEncoding: "gzip", wantVary: true},
+		{path: "/app.js", acceptEncoding: "br;q=0, *", wantBody: "gzipped", wantEncoding: "gzip", wantVary: true},
+		{path: "/app.js", acceptEncoding: "", wantBody: "plain js", wantVary: true},
+		{path: "/app.js", acceptEncoding: "identity", wantBody: "plain js", wantVary: true},
+		{path: "/old.css", acceptEncoding: "gzip", wantBody: "plain css"},
+		{path: "/page.txt", acceptEncoding: "gzip, br", wantBody: "plain text"},
+		{path: "/app.js", acceptEncoding: "gzip", etag: `"v1"`,
+			wantBody: "gzipped", wantEncoding: "gzip", wantVary: true, wantETag: `"v1-gzip"`},
+		{path: "/app.js", acceptEncoding: "gzip", etag: `W/"v1"`, reqHeader: Header{"If-None-Match": {`W/"v1-gzip"`}},
+			wantCode: StatusNotModified, wantVary: true, wantETag: `W/"v1-gzip"`},
+		{path: "/app.js", acceptEncoding: "gzip", reqHeader: Header{"Range": {"bytes=0-1"}},
+			wantCode: StatusPartialContent, wantBody: "gz", wantEncoding: "gzip", wantVary: true},
+	}
+	for _, tt := range tests {
+		etag = tt.etag
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		for k, v := range tt.reqHeader {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		res := rec.Result()
+		name := fmt.Sprintf("GET %s, Accept-Encoding %q, %v", tt.path, tt.acceptEncoding, tt.reqHeader)
+		wantCode := tt.wantCode
+		if wantCode == 0 {
+			wantCode = StatusOK
+		}
+		if res.StatusCode != wantCode {
+			t.Errorf("%s: status = %d; want %d", name, res.StatusCode, wantCode)
+		}
+		if body := rec.Body.String(); body != tt.wantBody {
+			t.Errorf("%s: body = %q; want %q", name, body, tt.wantBody)
+		}
+		if ce := res.Header.Get("Content-Encoding"); ce != tt.wantEncoding {
+			t.Errorf("%s: Content-Encoding = %q; want %q", name, ce, tt.wantEncoding)
+		}
+		if vary := res.Header.Get("Vary") == "Accept-Encoding"; vary != tt.wantVary {
+			t.Errorf("%s: Vary = %q; want Accept-Encoding: %v", name, res.Header.Get("Vary"), tt.wantVary)
+		}
+		if tt.wantETag != "" && res.Header.Get("Etag") != tt.wantETag {
+			t.Errorf("%s: ETag = %q; want %q", name, res.Header.Get("Etag"), tt.wantETag)
+		}
+		if wantCode == StatusOK {
+			if cl := res.Header.Get("Content-Length"); cl != fmt.Sprint(len(tt.wantBody)) {
+				t.Errorf("%s: Content-Length = %q; want %d", name, cl, len(tt.wantBody))
+			}
+			ext := path.Ext(tt.path)
+			if ct, want := res.Header.Get("Content-Type"), mime.TypeByExtension(ext); ct != want {
+				t.Errorf("%s: Content-Type = %q; want %q", name, ct, want)
+			}
+		}
+	}
+}
+
 func TestServeIndexHtml(t *testing.T) {
 	defer afterTest(t)
 	const want = "index.html says hello\n"
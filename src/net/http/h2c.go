@@ -0,0 +1,285 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Unencrypted HTTP/2 ("h2c"), as described in RFC 7540, section 3.2
+// (upgrade from HTTP/1.1) and section 3.4 (prior knowledge).
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"internal/x/net/http/httpguts"
+)
+
+// unencryptedHTTP2Server returns the HTTP/2 server used for
+// connections accepted with srv.UnencryptedHTTP2, creating it
+// on first use.
+func (srv *Server) unencryptedHTTP2Server() *http2Server {
+	srv.h2cOnce.Do(func() {
+		conf := &http2Server{
+			NewWriteScheduler: func() http2WriteScheduler { return http2NewPriorityWriteScheduler(nil) },
+		}
+		conf.state = &http2serverInternalState{activeConns: make(map[*http2serverConn]struct{})}
+		if srv.IdleTimeout != 0 {
+			conf.IdleTimeout = srv.IdleTimeout
+		} else {
+			conf.IdleTimeout = srv.ReadTimeout
+		}
+		srv.RegisterOnShutdown(conf.state.startGracefulShutdown)
+		srv.h2cServer = conf
+	})
+	return srv.h2cServer
+}
+
+// http2ClientPrefaceRest is what follows the part of the HTTP/2 client
+// connection preface that parses as an HTTP/1 request line
+// ("PRI * HTTP/2.0") and an empty header.
+const http2ClientPrefaceRest = "SM\r\n\r\n"
+
+// maybeServeUnencryptedHTTP2 serves c as an unencrypted HTTP/2
+// connection if w.req, the request just read from c, begins one:
+// either it is the start of the HTTP/2 connection preface, sent by a
+// client with prior knowledge that the server supports h2c, or it is
+// an HTTP/1.1 request asking to upgrade to h2c.
+// It reports whether it took over the connection.
+func (c *conn) maybeServeUnencryptedHTTP2(ctx context.Context, w *response) bool {
+	req := w.req
+	opts := &http2ServeConnOpts{
+		Context:    ctx,
+		Handler:    serverHandler{c.server},
+		BaseConfig: c.server,
+	}
+	var preface []byte
+	switch {
+	case req.isH2Upgrade():
+		if b, err := c.bufr.Peek(len(http2ClientPrefaceRest)); err != nil || string(b) != http2ClientPrefaceRest {
+			return false
+		}
+		preface = []byte("PRI * HTTP/2.0\r\n\r\n")
+	case isH2CUpgrade(req):
+		settings, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.Header.get("Http2-Settings"), "="))
+		if err != nil || len(settings)%6 != 0 {
+			return false
+		}
+		c.bufw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+		if err := c.bufw.Flush(); err != nil {
+			return true // the connection is unusable either way
+		}
+		opts.Settings = settings
+		opts.UpgradeRequest = h2cUpgradeRequest(req)
+	default:
+		return false
+	}
+
+	// Hand the HTTP/2 server whatever the HTTP/1 server has
+	// already read from the connection.
+	buffered, _ := c.bufr.Peek(c.bufr.Buffered())
+	r := io.MultiReader(bytes.NewReader(preface), bytes.NewReader(append([]byte(nil), buffered...)), c.rwc)
+	c.server.unencryptedHTTP2Server().ServeConn(&unencryptedHTTP2Conn{c.rwc, r}, opts)
+	return true
+}
+
+// isH2CUpgrade reports whether req asks to upgrade the connection to
+// h2c. Requests with a body are not upgraded: they are served using
+// HTTP/1.1, as RFC 7540 permits.
+func isH2CUpgrade(req *Request) bool {
+	return req.ProtoAtLeast(1, 1) &&
+		req.Method != "CONNECT" &&
+		req.Body == NoBody &&
+		len(req.Header["Http2-Settings"]) == 1 &&
+		httpguts.HeaderValuesContainsToken(req.Header["Upgrade"], "h2c") &&
+		httpguts.HeaderValuesContainsToken(req.Header["Connection"], "Upgrade") &&
+		httpguts.HeaderValuesContainsToken(req.Header["Connection"], "HTTP2-Settings")
+}
+
+// h2cUpgradeRequest returns a copy of req, an HTTP/1.1 request asking
+// to upgrade to h2c, as the HTTP/2 request on stream 1 that it becomes.
+func h2cUpgradeRequest(req *Request) *Request {
+	r2 := new(Request)
+	*r2 = *req
+	r2.Header = req.Header.clone()
+	for _, k := range req.Header["Connection"] {
+		for _, f := range strings.Split(k, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				r2.Header.Del(f)
+			}
+		}
+	}
+	r2.Header.Del("Connection")
+	r2.Header.Del("Upgrade")
+	r2.Header.Del("Http2-Settings")
+	r2.Proto = "HTTP/2.0"
+	r2.ProtoMajor = 2
+	r2.ProtoMinor = 0
+	r2.Close = false
+	return r2
+}
+
+// unencryptedHTTP2Conn is a net.Conn read through r,
+// which returns any bytes already read from the Conn first.
+type unencryptedHTTP2Conn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *unencryptedHTTP2Conn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// useUnencryptedHTTP2 reports whether the request for cm should be
+// sent using HTTP/2 with prior knowledge.
+func (t *Transport) useUnencryptedHTTP2(cm connectMethod) bool {
+	return t.UseUnencryptedHTTP2 != nil &&
+		cm.proxyURL == nil &&
+		cm.targetScheme == "http" &&
+		t.UseUnencryptedHTTP2(cm.targetAddr)
+}
+
+// unencryptedHTTP2Transport returns the HTTP/2 transport used for
+// requests selected by t.UseUnencryptedHTTP2, creating it on first use.
+//
+// Through its t1 field, the HTTP/2 transport takes IdleConnTimeout,
+// ResponseHeaderTimeout, ExpectContinueTimeout, DisableKeepAlives and
+// DisableCompression from t. Its connections are dialed by
+// unencryptedHTTP2ConnPool.
+func (t *Transport) unencryptedHTTP2Transport() *http2Transport {
+	t.h2cOnce.Do(func() {
+		t2 := &http2Transport{
+			AllowHTTP: true,
+			t1:        t,
+		}
+		t2.ConnPool = &unencryptedHTTP2ConnPool{t: t, p: &http2clientConnPool{t: t2}}
+		if limit1 := t.MaxResponseHeaderBytes; limit1 != 0 {
+			const h2max = 1<<32 - 1
+			if limit1 >= h2max {
+				t2.MaxHeaderListSize = h2max
+			} else {
+				t2.MaxHeaderListSize = uint32(limit1)
+			}
+		}
+		t.h2cTransport = t2
+	})
+	return t.h2cTransport
+}
+
+// unencryptedHTTP2ConnPool is the connection pool of the HTTP/2
+// transport used for requests selected by Transport.UseUnencryptedHTTP2.
+// The pool of the bundled HTTP/2 transport dials without a context, so
+// unencryptedHTTP2ConnPool dials new connections itself, with t.dial:
+// the dial uses the Transport's DialContext or Dial, and the values of
+// the context of the request that started it.
+//
+// Concurrent requests to an address with no connection share a single
+// dial, so that they are multiplexed on one connection. The dial is
+// canceled once every request waiting for it has been canceled or has
+// reached its deadline; a request that gives up earlier returns
+// without waiting for the dial.
+type unencryptedHTTP2ConnPool struct {
+	t *Transport
+	p *http2clientConnPool
+
+	mu    sync.Mutex
+	dials map[string]*unencryptedHTTP2Dial // in-flight dials, by address
+}
+
+// unencryptedHTTP2Dial is an in-flight dial of unencryptedHTTP2ConnPool.
+type unencryptedHTTP2Dial struct {
+	done    chan struct{}    // closed when the dial is done
+	cc      *http2ClientConn // valid after done is closed
+	err     error            // valid after done is closed
+	waiters int              // requests waiting for the dial; guarded by the pool's mu
+	cancel  context.CancelFunc
+}
+
+func (p *unencryptedHTTP2ConnPool) GetClientConn(req *Request, addr string) (*http2ClientConn, error) {
+	if http2isConnectionCloseRequest(req) {
+		// It gets its own connection.
+		http2traceGetConn(req, addr)
+		return p.dialClientConn(req.Context(), addr, true)
+	}
+
+	// The pool is checked with p.mu held, so that a dial that finishes
+	// in the meantime is either joined or has added its connection.
+	p.mu.Lock()
+	cc, err := p.p.getClientConn(req, addr, http2noDialOnMiss)
+	if err != http2ErrNoCachedConn {
+		p.mu.Unlock()
+		return cc, err
+	}
+	http2traceGetConn(req, addr)
+	d := p.dials[addr]
+	if d == nil {
+		ctx, cancel := context.WithCancel(valueOnlyContext{req.Context()})
+		d = &unencryptedHTTP2Dial{done: make(chan struct{}), cancel: cancel}
+		if p.dials == nil {
+			p.dials = make(map[string]*unencryptedHTTP2Dial)
+		}
+		p.dials[addr] = d
+		go p.dial(ctx, addr, d)
+	}
+	d.waiters++
+	p.mu.Unlock()
+
+	select {
+	case <-d.done:
+		return d.cc, d.err
+	case <-req.Context().Done():
+		p.mu.Lock()
+		d.waiters--
+		if d.waiters == 0 {
+			d.cancel()
+		}
+		p.mu.Unlock()
+		return nil, req.Context().Err()
+	}
+}
+
+// dial runs d, a shared dial to addr, in its own goroutine, and adds
+// the new connection to the pool.
+func (p *unencryptedHTTP2ConnPool) dial(ctx context.Context, addr string, d *unencryptedHTTP2Dial) {
+	d.cc, d.err = p.dialClientConn(ctx, addr, false)
+	d.cancel()
+	if d.err == nil {
+		p.p.addConn(addr, d.cc)
+	}
+	p.mu.Lock()
+	delete(p.dials, addr)
+	p.mu.Unlock()
+	close(d.done)
+}
+
+func (p *unencryptedHTTP2ConnPool) dialClientConn(ctx context.Context, addr string, singleUse bool) (*http2ClientConn, error) {
+	c, err := p.t.dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := p.p.t.newClientConn(c, singleUse)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+func (p *unencryptedHTTP2ConnPool) MarkDead(cc *http2ClientConn) {
+	p.p.MarkDead(cc)
+}
+
+func (p *unencryptedHTTP2ConnPool) closeIdleConnections() {
+	p.p.closeIdleConnections()
+}
+
+// valueOnlyContext is a context with the values of its parent, but
+// without its deadline and cancelation.
+type valueOnlyContext struct{ context.Context }
+
+func (valueOnlyContext) Deadline() (deadline time.Time, ok bool) { return }
+func (valueOnlyContext) Done() <-chan struct{}                   { return nil }
+func (valueOnlyContext) Err() error                              { return nil }
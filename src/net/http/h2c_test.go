@@ -0,0 +1,311 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// White-box tests for h2c.go (in package http instead of http_test).
+
+package http
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"internal/x/net/http2/hpack"
+)
+
+// newH2CServer starts a Server with UnencryptedHTTP2 set on a local
+// listener and returns its address and a function to shut it down.
+func newH2CServer(t *testing.T, h Handler) (addr string, stop func()) {
+	ln := newLocalListener(t)
+	srv := &Server{Handler: h, UnencryptedHTTP2: true}
+	go srv.Serve(ln)
+	return ln.Addr().String(), func() { srv.Close() }
+}
+
+func h2cTestHandler(w ResponseWriter, r *Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	fmt.Fprintf(w, "%s %s %s %q", r.Proto, r.Method, r.URL.Path, body)
+}
+
+func TestUnencryptedHTTP2PriorKnowledge(t *testing.T) {
+	addr, stop := newH2CServer(t, HandlerFunc(h2cTestHandler))
+	defer stop()
+
+	tr := &Transport{UseUnencryptedHTTP2: func(a string) bool { return a == addr }}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	for _, method := range []string{"GET", "POST"} {
+		req, _ := NewRequest(method, "http://"+addr+"/path", strings.NewReader("body"))
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Proto != "HTTP/2.0" {
+			t.Errorf("%s: response proto = %q; want HTTP/2.0", method, res.Proto)
+		}
+		if want := "HTTP/2.0 " + method + ` /path "body"`; string(got) != want {
+			t.Errorf("%s: body = %q; want %q", method, got, want)
+		}
+	}
+}
+
+func TestUnencryptedHTTP2DialContext(t *testing.T) {
+	type ctxKey struct{}
+	dialed := make(chan context.Context, 1)
+	tr := &Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed <- ctx
+			<-ctx.Done() // an unresponsive server
+			return nil, ctx.Err()
+		},
+		UseUnencryptedHTTP2: func(string) bool { return true },
+	}
+	defer tr.CloseIdleConnections()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "value"))
+	defer cancel()
+	req, _ := NewRequest("GET", "http://example.com/", nil)
+	req = req.WithContext(ctx)
+	errc := make(chan error, 1)
+	go func() {
+		res, err := (&Client{Transport: tr}).Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		errc <- err
+	}()
+	select {
+	case dctx := <-dialed:
+		if dctx.Value(ctxKey{}) != "value" {
+			t.Error("dial context is not derived from the request context")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("DialContext not called")
+	}
+	cancel()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("Do succeeded; want a dial error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("dial not canceled with the request")
+	}
+}
+
+func TestUnencryptedHTTP2IdleConnTimeout(t *testing.T) {
+	addr, stop := newH2CServer(t, HandlerFunc(h2cTestHandler))
+	defer stop()
+
+	var dials int32
+	tr := &Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+		IdleConnTimeout:     50 * time.Millisecond,
+		UseUnencryptedHTTP2: func(string) bool { return true },
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	get := func() {
+		t.Helper()
+		res, err := c.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	get()
+	get()
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("%d dials for two requests in a row; want 1", n)
+	}
+	time.Sleep(200 * time.Millisecond)
+	get()
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Errorf("%d dials after IdleConnTimeout; want 2", n)
+	}
+}
+
+func TestUnencryptedHTTP2ConcurrentDials(t *testing.T) {
+	addr, stop := newH2CServer(t, HandlerFunc(h2cTestHandler))
+	defer stop()
+
+	const n = 5
+	var dials int32
+	waiting := make(chan bool, n)
+	tr := &Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			// Hold the dial until every request is waiting for a connection.
+			for i := 0; i < n; i++ {
+				select {
+				case <-waiting:
+				case <-time.After(10 * time.Second):
+					return nil, fmt.Errorf("%d of %d requests waiting", i, n)
+				}
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+		UseUnencryptedHTTP2: func(string) bool { return true },
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) { waiting <- true },
+	}
+	errc := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			req, _ := NewRequest("GET", "http://"+addr+"/", nil)
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+			res, err := c.Do(req)
+			if err == nil {
+				_, err = ioutil.ReadAll(res.Body)
+				res.Body.Close()
+			}
+			errc <- err
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
+	}
+	if got := atomic.LoadInt32(&dials); got != 1 {
+		t.Errorf("%d dials for %d concurrent requests; want 1", got, n)
+	}
+}
+
+func TestUnencryptedHTTP2ServerAcceptsHTTP1(t *testing.T) {
+	addr, stop := newH2CServer(t, HandlerFunc(h2cTestHandler))
+	defer stop()
+
+	// Transport does not select addr for h2c.
+	tr := &Transport{UseUnencryptedHTTP2: func(string) bool { return false }}
+	defer tr.CloseIdleConnections()
+	res, err := (&Client{Transport: tr}).Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if want := `HTTP/1.1 GET / ""`; string(got) != want {
+		t.Errorf("body = %q; want %q", got, want)
+	}
+}
+
+const h2cUpgradeRequestText = "GET /up HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"Connection: Upgrade, HTTP2-Settings\r\n" +
+	"Upgrade: h2c\r\n" +
+	"HTTP2-Settings: AAMAAABkAAQAAP__\r\n" +
+	"\r\n"
+
+func TestUnencryptedHTTP2Upgrade(t *testing.T) {
+	addr, stop := newH2CServer(t, HandlerFunc(h2cTestHandler))
+	defer stop()
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, h2cUpgradeRequestText); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(c)
+	res, err := ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != StatusSwitchingProtocols || res.Header.Get("Upgrade") != "h2c" {
+		t.Fatalf("response = %v, Upgrade %q; want 101, h2c", res.Status, res.Header.Get("Upgrade"))
+	}
+
+	// Speak HTTP/2 from here on.
+	if _, err := io.WriteString(c, http2ClientPreface); err != nil {
+		t.Fatal(err)
+	}
+	fr := http2NewFramer(c, br)
+	fr.ReadMetaHeaders = hpack.NewDecoder(http2initialHeaderTableSize, nil)
+	if err := fr.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	var status, body string
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch f := f.(type) {
+		case *http2SettingsFrame:
+			if !f.IsAck() {
+				fr.WriteSettingsAck()
+			}
+		case *http2MetaHeadersFrame:
+			if f.StreamID != 1 {
+				t.Fatalf("HEADERS on stream %d; want 1", f.StreamID)
+			}
+			status = f.PseudoValue("status")
+		case *http2DataFrame:
+			if f.StreamID != 1 {
+				t.Fatalf("DATA on stream %d; want 1", f.StreamID)
+			}
+			body += string(f.Data())
+		}
+		if f.Header().StreamID == 1 && f.Header().Flags.Has(http2FlagDataEndStream) {
+			break
+		}
+	}
+	if status != "200" {
+		t.Errorf("status = %q; want 200", status)
+	}
+	if want := `HTTP/2.0 GET /up ""`; body != want {
+		t.Errorf("body = %q; want %q", body, want)
+	}
+}
+
+func TestUnencryptedHTTP2UpgradeDisabled(t *testing.T) {
+	ln := newLocalListener(t)
+	srv := &Server{Handler: HandlerFunc(h2cTestHandler)}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, h2cUpgradeRequestText); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(io.LimitReader(res.Body, 100))
+	if res.StatusCode != StatusOK {
+		t.Errorf("status = %v; want 200", res.Status)
+	}
+	if want := `HTTP/1.1 GET /up ""`; string(got) != want {
+		t.Errorf("body = %q; want %q", got, want)
+	}
+}
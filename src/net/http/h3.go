@@ -0,0 +1,854 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP/3, as specified in RFC 9114, over the QUIC transport in
+// net/http/internal/quic. This file holds what the server (h3_server.go)
+// and the client (h3_transport.go) share: frame and stream encoding,
+// the control stream, and Alt-Svc header handling.
+
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"internal/x/net/http/httpguts"
+	"net/http/internal/qpack"
+	"net/http/internal/quic"
+)
+
+// http3NextProto is the ALPN protocol identifier for HTTP/3.
+const http3NextProto = "h3"
+
+// HTTP/3 frame types. See RFC 9114, Section 7.2.
+const (
+	http3FrameData        = 0x00
+	http3FrameHeaders     = 0x01
+	http3FrameCancelPush  = 0x03
+	http3FrameSettings    = 0x04
+	http3FramePushPromise = 0x05
+	http3FrameGoAway      = 0x07
+	http3FrameMaxPushID   = 0x0d
+)
+
+// HTTP/3 unidirectional stream types. See RFC 9114, Section 6.2,
+// and RFC 9204, Section 4.2.
+const (
+	http3StreamControl      = 0x00
+	http3StreamPush         = 0x01
+	http3StreamQPACKEncoder = 0x02
+	http3StreamQPACKDecoder = 0x03
+)
+
+// HTTP/3 settings. See RFC 9114, Section 7.2.4.1, and RFC 9204,
+// Section 5.
+const (
+	http3SettingQPACKMaxTableCapacity = 0x01
+	http3SettingMaxFieldSectionSize   = 0x06
+	http3SettingQPACKBlockedStreams   = 0x07
+)
+
+// An http3ErrCode is an HTTP/3 error code, sent in QUIC
+// CONNECTION_CLOSE, RESET_STREAM and STOP_SENDING frames.
+// See RFC 9114, Section 8.1.
+type http3ErrCode uint64
+
+const (
+	http3ErrNoError              http3ErrCode = 0x100
+	http3ErrGeneralProtocol      http3ErrCode = 0x101
+	http3ErrInternal             http3ErrCode = 0x102
+	http3ErrStreamCreation       http3ErrCode = 0x103
+	http3ErrClosedCriticalStream http3ErrCode = 0x104
+	http3ErrFrameUnexpected      http3ErrCode = 0x105
+	http3ErrFrame                http3ErrCode = 0x106
+	http3ErrExcessiveLoad        http3ErrCode = 0x107
+	http3ErrID                   http3ErrCode = 0x108
+	http3ErrSettings             http3ErrCode = 0x109
+	http3ErrMissingSettings      http3ErrCode = 0x10a
+	http3ErrRequestRejected      http3ErrCode = 0x10b
+	http3ErrRequestCancelled     http3ErrCode = 0x10c
+	http3ErrRequestIncomplete    http3ErrCode = 0x10d
+	http3ErrMessage              http3ErrCode = 0x10e
+	http3ErrConnect              http3ErrCode = 0x10f
+	http3ErrVersionFallback      http3ErrCode = 0x110
+
+	// http3ErrQPACKDecompressionFailed is defined by RFC 9204, Section 6.
+	http3ErrQPACKDecompressionFailed http3ErrCode = 0x200
+)
+
+var http3ErrCodeName = map[http3ErrCode]string{
+	http3ErrNoError:                  "H3_NO_ERROR",
+	http3ErrGeneralProtocol:          "H3_GENERAL_PROTOCOL_ERROR",
+	http3ErrInternal:                 "H3_INTERNAL_ERROR",
+	http3ErrStreamCreation:           "H3_STREAM_CREATION_ERROR",
+	http3ErrClosedCriticalStream:     "H3_CLOSED_CRITICAL_STREAM",
+	http3ErrFrameUnexpected:          "H3_FRAME_UNEXPECTED",
+	http3ErrFrame:                    "H3_FRAME_ERROR",
+	http3ErrExcessiveLoad:            "H3_EXCESSIVE_LOAD",
+	http3ErrID:                       "H3_ID_ERROR",
+	http3ErrSettings:                 "H3_SETTINGS_ERROR",
+	http3ErrMissingSettings:          "H3_MISSING_SETTINGS",
+	http3ErrRequestRejected:          "H3_REQUEST_REJECTED",
+	http3ErrRequestCancelled:         "H3_REQUEST_CANCELLED",
+	http3ErrRequestIncomplete:        "H3_REQUEST_INCOMPLETE",
+	http3ErrMessage:                  "H3_MESSAGE_ERROR",
+	http3ErrConnect:                  "H3_CONNECT_ERROR",
+	http3ErrVersionFallback:          "H3_VERSION_FALLBACK",
+	http3ErrQPACKDecompressionFailed: "QPACK_DECOMPRESSION_FAILED",
+}
+
+func (e http3ErrCode) String() string {
+	if s, ok := http3ErrCodeName[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint64(e))
+}
+
+// An http3ConnError is an HTTP/3 error which closes the connection.
+type http3ConnError struct {
+	code   http3ErrCode
+	reason string
+}
+
+func (e http3ConnError) Error() string {
+	return fmt.Sprintf("http3: connection error: %v: %v", e.code, e.reason)
+}
+
+// An http3StreamError is an error which ends a single request stream.
+type http3StreamError struct {
+	code  http3ErrCode
+	cause error
+}
+
+func (e http3StreamError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("http3: stream error: %v: %v", e.code, e.cause)
+	}
+	return fmt.Sprintf("http3: stream error: %v", e.code)
+}
+
+// http3PeerStreamError converts the error code a peer reset a stream
+// with into an error.
+func http3PeerStreamError(code quic.StreamErrorCode) error {
+	return fmt.Errorf("http3: stream reset by peer: %v", http3ErrCode(code))
+}
+
+// http3AppendVarint appends v encoded as a QUIC variable-length
+// integer. See RFC 9000, Section 16.
+func http3AppendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// http3ReadVarint reads a QUIC variable-length integer from r.
+func http3ReadVarint(r io.ByteReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	n := 1 << (b >> 6)
+	v := uint64(b & 0x3f)
+	for i := 1; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// http3ConsumeVarint parses a QUIC variable-length integer from the
+// start of b, returning its value and length, or a length of zero if
+// b does not hold a complete integer.
+func http3ConsumeVarint(b []byte) (v uint64, n int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	n = 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0
+	}
+	v = uint64(b[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n
+}
+
+// http3AppendFrameHeader appends the type and length of a frame.
+func http3AppendFrameHeader(b []byte, typ uint64, size int64) []byte {
+	b = http3AppendVarint(b, typ)
+	return http3AppendVarint(b, uint64(size))
+}
+
+// http3AppendSettings appends a SETTINGS frame advertising a maximum
+// field section size. The QPACK dynamic table settings are left at
+// their default of zero.
+func http3AppendSettings(b []byte, maxFieldSectionSize int64) []byte {
+	var p []byte
+	p = http3AppendVarint(p, http3SettingMaxFieldSectionSize)
+	p = http3AppendVarint(p, uint64(maxFieldSectionSize))
+	b = http3AppendFrameHeader(b, http3FrameSettings, int64(len(p)))
+	return append(b, p...)
+}
+
+// http3IsReservedFrame reports whether typ is a frame type reserved
+// for HTTP/2 which must not be sent in HTTP/3. See RFC 9114,
+// Section 7.2.8.
+func http3IsReservedFrame(typ uint64) bool {
+	switch typ {
+	case 0x02, 0x06, 0x08, 0x09:
+		return true
+	}
+	return false
+}
+
+// An http3Stream reads and writes the frames of a request stream.
+type http3Stream struct {
+	st *quic.Stream
+	br *bufio.Reader
+
+	// maxFieldSection limits the size of HEADERS frames read.
+	maxFieldSection int64
+
+	// dataLeft is the number of bytes remaining in the DATA frame
+	// being read.
+	dataLeft int64
+
+	// wbuf is reused for frame headers written.
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+func newHTTP3Stream(st *quic.Stream, maxFieldSection int64) *http3Stream {
+	return &http3Stream{
+		st:              st,
+		br:              bufio.NewReader(st),
+		maxFieldSection: maxFieldSection,
+	}
+}
+
+// readFrameHeader reads the type and length of the next frame. It
+// returns io.EOF if the stream ends between frames.
+func (s *http3Stream) readFrameHeader() (typ uint64, size int64, err error) {
+	typ, err = http3ReadVarint(s.br)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := http3ReadVarint(s.br)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, err
+	}
+	if n > 1<<62 {
+		return 0, 0, http3ConnError{http3ErrFrame, "invalid frame length"}
+	}
+	return typ, int64(n), nil
+}
+
+// discard skips n bytes of frame payload.
+func (s *http3Stream) discard(n int64) error {
+	m, err := io.CopyN(ioutil.Discard, s.br, n)
+	if m < n && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readHeaders reads frames until a HEADERS frame, skipping frames of
+// unknown types, and decodes its field section. It returns io.EOF if
+// the stream ends first.
+func (s *http3Stream) readHeaders() ([]qpack.HeaderField, error) {
+	for {
+		typ, size, err := s.readFrameHeader()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case typ == http3FrameHeaders:
+			if size > s.maxFieldSection {
+				return nil, http3StreamError{http3ErrExcessiveLoad, errors.New("header too large")}
+			}
+			p := make([]byte, size)
+			if _, err := io.ReadFull(s.br, p); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			var fields []qpack.HeaderField
+			var total int64
+			err := qpack.DecodeFieldSection(p, func(f qpack.HeaderField) error {
+				total += int64(f.Size())
+				if total > s.maxFieldSection {
+					return http3StreamError{http3ErrExcessiveLoad, errors.New("header too large")}
+				}
+				fields = append(fields, f)
+				return nil
+			})
+			if err != nil {
+				if _, ok := err.(http3StreamError); ok {
+					return nil, err
+				}
+				return nil, http3ConnError{http3ErrQPACKDecompressionFailed, err.Error()}
+			}
+			return fields, nil
+		case http3IsKnownFrame(typ):
+			return nil, http3ConnError{http3ErrFrameUnexpected, fmt.Sprintf("unexpected frame type 0x%x on request stream", typ)}
+		default:
+			if err := s.discard(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// http3IsKnownFrame reports whether typ is a frame type with a meaning
+// in HTTP/3 or a type reserved for HTTP/2, as opposed to an extension
+// type to be ignored.
+func http3IsKnownFrame(typ uint64) bool {
+	switch typ {
+	case http3FrameData, http3FrameHeaders, http3FrameCancelPush, http3FrameSettings,
+		http3FramePushPromise, http3FrameGoAway, http3FrameMaxPushID:
+		return true
+	}
+	return http3IsReservedFrame(typ)
+}
+
+// readData reads message content from DATA frames into p. When the
+// content ends it returns io.EOF, together with the trailers, if the
+// message has any.
+func (s *http3Stream) readData(p []byte) (n int, trailer []qpack.HeaderField, err error) {
+	for s.dataLeft == 0 {
+		typ, size, err := s.readFrameHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch typ {
+		case http3FrameData:
+			s.dataLeft = size
+		case http3FrameHeaders:
+			trailer, err := s.readTrailers(size)
+			if err != nil {
+				return 0, nil, err
+			}
+			return 0, trailer, io.EOF
+		default:
+			if http3IsKnownFrame(typ) {
+				return 0, nil, http3ConnError{http3ErrFrameUnexpected, fmt.Sprintf("unexpected frame type 0x%x on request stream", typ)}
+			}
+			if err := s.discard(size); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+	if int64(len(p)) > s.dataLeft {
+		p = p[:s.dataLeft]
+	}
+	n, err = s.br.Read(p)
+	s.dataLeft -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, nil, err
+}
+
+// readTrailers decodes the trailer section in a HEADERS frame of the
+// given size, whose header has been read, and checks that nothing
+// follows it.
+func (s *http3Stream) readTrailers(size int64) ([]qpack.HeaderField, error) {
+	if size > s.maxFieldSection {
+		return nil, http3StreamError{http3ErrExcessiveLoad, errors.New("trailer too large")}
+	}
+	p := make([]byte, size)
+	if _, err := io.ReadFull(s.br, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	s.dataLeft = 0
+	var fields []qpack.HeaderField
+	err := qpack.DecodeFieldSection(p, func(f qpack.HeaderField) error {
+		if strings.HasPrefix(f.Name, ":") {
+			return http3StreamError{http3ErrMessage, errors.New("pseudo-header in trailer")}
+		}
+		fields = append(fields, f)
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(http3StreamError); ok {
+			return nil, err
+		}
+		return nil, http3ConnError{http3ErrQPACKDecompressionFailed, err.Error()}
+	}
+	// Nothing but frames of unknown types may follow the trailers.
+	for {
+		typ, size, err := s.readFrameHeader()
+		if err == io.EOF {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if http3IsKnownFrame(typ) {
+			return nil, http3ConnError{http3ErrFrameUnexpected, "frame after trailers"}
+		}
+		if err := s.discard(size); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// writeHeaders writes a HEADERS frame holding fields.
+func (s *http3Stream) writeHeaders(fields []qpack.HeaderField) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	p := qpack.AppendFieldSection(nil, fields)
+	s.wbuf = http3AppendFrameHeader(s.wbuf[:0], http3FrameHeaders, int64(len(p)))
+	s.wbuf = append(s.wbuf, p...)
+	_, err := s.st.Write(s.wbuf)
+	return err
+}
+
+// writeData writes p in a DATA frame.
+func (s *http3Stream) writeData(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.wbuf = http3AppendFrameHeader(s.wbuf[:0], http3FrameData, int64(len(p)))
+	if _, err := s.st.Write(s.wbuf); err != nil {
+		return err
+	}
+	_, err := s.st.Write(p)
+	return err
+}
+
+// http3AppendHeader appends the fields of h to fields, with lower case
+// names, leaving out connection-specific fields and those for which
+// skip returns true.
+func http3AppendHeader(fields []qpack.HeaderField, h Header, skip func(k string) bool) []qpack.HeaderField {
+	for k, vv := range h {
+		if skip != nil && skip(k) {
+			continue
+		}
+		switch CanonicalHeaderKey(k) {
+		case "Connection", "Proxy-Connection", "Transfer-Encoding", "Upgrade", "Keep-Alive":
+			// Connection-specific fields are not allowed.
+			// See RFC 9114, Section 4.2.
+			continue
+		}
+		name := strings.ToLower(k)
+		for _, v := range vv {
+			fields = append(fields, qpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	return fields
+}
+
+// http3ValidateField checks a field received from the peer.
+// See RFC 9114, Section 4.1.2.
+func http3ValidateField(f qpack.HeaderField) error {
+	name := f.Name
+	if strings.HasPrefix(name, ":") {
+		name = name[1:]
+	}
+	if !http2validWireHeaderFieldName(name) {
+		return fmt.Errorf("invalid header field name %q", f.Name)
+	}
+	if !httpguts.ValidHeaderFieldValue(f.Value) {
+		return fmt.Errorf("invalid header field value for %q", f.Name)
+	}
+	switch f.Name {
+	case "connection", "proxy-connection", "transfer-encoding", "upgrade", "keep-alive":
+		return fmt.Errorf("connection-specific header field %q", f.Name)
+	case "te":
+		if f.Value != "trailers" {
+			return errors.New(`header field "te" may only be "trailers"`)
+		}
+	}
+	return nil
+}
+
+// http3TrailerHeader converts the fields of a trailer section to a
+// Header, storing the values in t.
+func http3TrailerHeader(t Header, fields []qpack.HeaderField) error {
+	for _, f := range fields {
+		if err := http3ValidateField(f); err != nil {
+			return err
+		}
+		k := CanonicalHeaderKey(f.Name)
+		t[k] = append(t[k], f.Value)
+	}
+	return nil
+}
+
+// An http3Conn holds the state shared by client and server HTTP/3
+// connections: the control streams and the peer's settings.
+type http3Conn struct {
+	qconn    *quic.Conn
+	isServer bool
+
+	// maxFieldSection is the MAX_FIELD_SECTION_SIZE we advertise.
+	maxFieldSection int64
+
+	// onGoAway, if non-nil, is called with the ID in each GOAWAY
+	// frame received.
+	onGoAway func(id int64)
+
+	controlMu sync.Mutex
+	control   *quic.Stream
+
+	peerMu              sync.Mutex
+	peerControl         bool  // the peer opened a control stream
+	peerMaxFieldSection int64 // -1 until the peer's settings arrive
+}
+
+func (c *http3Conn) init(qconn *quic.Conn, isServer bool, maxFieldSection int64) {
+	c.qconn = qconn
+	c.isServer = isServer
+	c.maxFieldSection = maxFieldSection
+	c.peerMaxFieldSection = -1
+}
+
+// abort closes the connection with an HTTP/3 error code.
+func (c *http3Conn) abort(code http3ErrCode, reason string) {
+	c.qconn.CloseWithError(uint64(code), reason)
+}
+
+// abortStream ends a request stream after a stream error.
+func (c *http3Conn) abortStream(s *http3Stream, code http3ErrCode) {
+	s.st.StopSending(uint64(code))
+	s.st.Reset(uint64(code))
+}
+
+// handleError takes the action an error reading a request stream
+// calls for: connection errors close the connection, and others reset
+// the stream.
+func (c *http3Conn) handleError(s *http3Stream, err error) {
+	switch err := err.(type) {
+	case http3ConnError:
+		c.abort(err.code, err.reason)
+	case http3StreamError:
+		c.abortStream(s, err.code)
+	default:
+		if err == io.ErrUnexpectedEOF {
+			c.abortStream(s, http3ErrRequestIncomplete)
+		} else {
+			c.abortStream(s, http3ErrRequestCancelled)
+		}
+	}
+}
+
+// openControlStream opens the control stream and sends our SETTINGS.
+func (c *http3Conn) openControlStream(ctx context.Context) error {
+	st, err := c.qconn.NewSendOnlyStream(ctx)
+	if err != nil {
+		return err
+	}
+	b := http3AppendVarint(nil, http3StreamControl)
+	b = http3AppendSettings(b, c.maxFieldSection)
+	if _, err := st.Write(b); err != nil {
+		return err
+	}
+	c.controlMu.Lock()
+	c.control = st
+	c.controlMu.Unlock()
+	return nil
+}
+
+// sendGoAway sends a GOAWAY frame carrying id on the control stream.
+func (c *http3Conn) sendGoAway(id int64) error {
+	c.controlMu.Lock()
+	defer c.controlMu.Unlock()
+	if c.control == nil {
+		return errors.New("http3: no control stream")
+	}
+	var p []byte
+	p = http3AppendVarint(p, uint64(id))
+	b := http3AppendFrameHeader(nil, http3FrameGoAway, int64(len(p)))
+	_, err := c.control.Write(append(b, p...))
+	return err
+}
+
+// handleUniStream serves a unidirectional stream opened by the peer.
+// See RFC 9114, Section 6.2.
+func (c *http3Conn) handleUniStream(st *quic.Stream) {
+	br := bufio.NewReader(st)
+	typ, err := http3ReadVarint(br)
+	if err != nil {
+		st.StopSending(uint64(http3ErrStreamCreation))
+		return
+	}
+	switch typ {
+	case http3StreamControl:
+		c.peerMu.Lock()
+		dup := c.peerControl
+		c.peerControl = true
+		c.peerMu.Unlock()
+		if dup {
+			c.abort(http3ErrStreamCreation, "second control stream")
+			return
+		}
+		if err := c.readControlStream(br); err != nil {
+			if ce, ok := err.(http3ConnError); ok {
+				c.abort(ce.code, ce.reason)
+			} else if _, ok := err.(quic.StreamErrorCode); ok || err == io.EOF || err == io.ErrUnexpectedEOF {
+				c.abort(http3ErrClosedCriticalStream, "control stream closed")
+			}
+		}
+	case http3StreamQPACKEncoder, http3StreamQPACKDecoder:
+		// With a dynamic table capacity of zero on both sides,
+		// these streams carry nothing of use, but must stay open.
+		// See RFC 9204, Section 4.2.
+		if _, err := io.Copy(ioutil.Discard, br); err == nil {
+			c.abort(http3ErrClosedCriticalStream, "QPACK stream closed")
+		}
+	case http3StreamPush:
+		if c.isServer {
+			c.abort(http3ErrStreamCreation, "push stream from client")
+		} else {
+			// We never send MAX_PUSH_ID, so the server may not push.
+			c.abort(http3ErrID, "push stream without MAX_PUSH_ID")
+		}
+	default:
+		// Unknown stream types are ignored.
+		st.StopSending(uint64(http3ErrStreamCreation))
+	}
+}
+
+// readControlStream reads frames from the peer's control stream.
+// See RFC 9114, Section 6.2.1.
+func (c *http3Conn) readControlStream(br *bufio.Reader) error {
+	s := &http3Stream{br: br}
+	first := true
+	for {
+		typ, size, err := s.readFrameHeader()
+		if err != nil {
+			return err
+		}
+		if first && typ != http3FrameSettings {
+			return http3ConnError{http3ErrMissingSettings, "first frame on control stream is not SETTINGS"}
+		}
+		switch typ {
+		case http3FrameSettings:
+			if !first {
+				return http3ConnError{http3ErrFrameUnexpected, "second SETTINGS frame"}
+			}
+			first = false
+			if size > 1<<16 {
+				return http3ConnError{http3ErrExcessiveLoad, "SETTINGS frame too large"}
+			}
+			p := make([]byte, size)
+			if _, err := io.ReadFull(br, p); err != nil {
+				return err
+			}
+			if err := c.handleSettings(p); err != nil {
+				return err
+			}
+		case http3FrameGoAway:
+			if size > 8 {
+				return http3ConnError{http3ErrFrame, "invalid GOAWAY frame"}
+			}
+			p := make([]byte, size)
+			if _, err := io.ReadFull(br, p); err != nil {
+				return err
+			}
+			id, n := http3ConsumeVarint(p)
+			if n == 0 || n != len(p) {
+				return http3ConnError{http3ErrFrame, "invalid GOAWAY frame"}
+			}
+			if !c.isServer && id&3 != 0 {
+				return http3ConnError{http3ErrID, "GOAWAY with invalid stream ID"}
+			}
+			if c.onGoAway != nil {
+				c.onGoAway(int64(id))
+			}
+		case http3FrameMaxPushID:
+			if !c.isServer {
+				return http3ConnError{http3ErrFrameUnexpected, "MAX_PUSH_ID from server"}
+			}
+			// We never push, so the limit is of no interest.
+			if err := s.discard(size); err != nil {
+				return err
+			}
+		case http3FrameCancelPush:
+			if err := s.discard(size); err != nil {
+				return err
+			}
+		default:
+			if http3IsKnownFrame(typ) {
+				return http3ConnError{http3ErrFrameUnexpected, fmt.Sprintf("unexpected frame type 0x%x on control stream", typ)}
+			}
+			if err := s.discard(size); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleSettings processes the payload of the peer's SETTINGS frame.
+func (c *http3Conn) handleSettings(p []byte) error {
+	seen := make(map[uint64]bool)
+	for len(p) > 0 {
+		id, n := http3ConsumeVarint(p)
+		if n == 0 {
+			return http3ConnError{http3ErrFrame, "truncated SETTINGS frame"}
+		}
+		p = p[n:]
+		v, n := http3ConsumeVarint(p)
+		if n == 0 {
+			return http3ConnError{http3ErrFrame, "truncated SETTINGS frame"}
+		}
+		p = p[n:]
+		if seen[id] {
+			return http3ConnError{http3ErrSettings, "duplicate setting"}
+		}
+		seen[id] = true
+		switch id {
+		case 0x02, 0x03, 0x04, 0x05:
+			// HTTP/2 settings have no place in HTTP/3.
+			// See RFC 9114, Section 7.2.4.1.
+			return http3ConnError{http3ErrSettings, "HTTP/2 setting in SETTINGS frame"}
+		case http3SettingMaxFieldSectionSize:
+			if v > 1<<62 {
+				v = 1 << 62
+			}
+			c.peerMu.Lock()
+			c.peerMaxFieldSection = int64(v)
+			c.peerMu.Unlock()
+		}
+		// Other settings, including the QPACK ones, need no action:
+		// we never use the dynamic table.
+	}
+	return nil
+}
+
+// peerFieldSectionLimit returns the limit the peer set on the size of
+// field sections it accepts, or -1 if there is none.
+func (c *http3Conn) peerFieldSectionLimit() int64 {
+	c.peerMu.Lock()
+	defer c.peerMu.Unlock()
+	return c.peerMaxFieldSection
+}
+
+// http3FieldSectionSize returns the size of fields as limited by
+// MAX_FIELD_SECTION_SIZE.
+func http3FieldSectionSize(fields []qpack.HeaderField) int64 {
+	var n int64
+	for _, f := range fields {
+		n += int64(f.Size())
+	}
+	return n
+}
+
+// http3DefaultAltSvcMaxAge is how long an alternative service is
+// remembered when the Alt-Svc header does not give a max age.
+// See RFC 7838, Section 3.1.
+const http3DefaultAltSvcMaxAge = 24 * time.Hour
+
+// http3AltSvc parses an Alt-Svc header value, as described in RFC 7838,
+// Section 3. It returns the authority ("host:port", where host may be
+// empty to mean the origin's host) of the first HTTP/3 alternative and
+// how long it stays fresh. It returns clear as true if the value is
+// "clear", which invalidates all alternatives.
+func http3AltSvc(v string) (authority string, maxAge time.Duration, clear, ok bool) {
+	v = strings.TrimSpace(v)
+	if v == "clear" {
+		return "", 0, true, true
+	}
+	for v != "" {
+		var entry string
+		entry, v = http3SplitAltSvc(v, ',')
+		protocol, params := http3SplitAltSvc(entry, ';')
+		eq := strings.IndexByte(protocol, '=')
+		if eq < 0 {
+			continue
+		}
+		id := strings.TrimSpace(protocol[:eq])
+		alt, err := strconv.Unquote(strings.TrimSpace(protocol[eq+1:]))
+		if err != nil || id != http3NextProto {
+			continue
+		}
+		if _, port, err := splitHostPortLoose(alt); err != nil || port == "" {
+			continue
+		}
+		maxAge = http3DefaultAltSvcMaxAge
+		for params != "" {
+			var p string
+			p, params = http3SplitAltSvc(params, ';')
+			eq := strings.IndexByte(p, '=')
+			if eq < 0 || strings.TrimSpace(p[:eq]) != "ma" {
+				continue
+			}
+			val := strings.Trim(strings.TrimSpace(p[eq+1:]), `"`)
+			if secs, err := strconv.ParseUint(val, 10, 32); err == nil {
+				maxAge = time.Duration(secs) * time.Second
+			}
+		}
+		return alt, maxAge, false, true
+	}
+	return "", 0, false, false
+}
+
+// http3SplitAltSvc splits s at the first sep outside a quoted string.
+func http3SplitAltSvc(s string, sep byte) (before, after string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && quoted:
+			i++
+		case c == sep && !quoted:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// splitHostPortLoose splits "host:port" where host may be empty or a
+// bracketed IPv6 literal.
+func splitHostPortLoose(hostport string) (host, port string, err error) {
+	i := strings.LastIndexByte(hostport, ':')
+	if i < 0 {
+		return "", "", errors.New("missing port")
+	}
+	host, port = hostport[:i], hostport[i+1:]
+	if strings.HasPrefix(host, "[") {
+		if !strings.HasSuffix(host, "]") {
+			return "", "", errors.New("invalid IPv6 literal")
+		}
+		host = host[1 : len(host)-1]
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", errors.New("invalid port")
+	}
+	return host, port, nil
+}
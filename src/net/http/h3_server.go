@@ -0,0 +1,931 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP/3 server. See h3.go.
+
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"internal/x/net/http/httpguts"
+	"net/http/internal/qpack"
+	"net/http/internal/quic"
+)
+
+// ServeHTTP3 accepts incoming HTTP/3 connections on the PacketConn pc,
+// serving the requests they carry with srv.Handler. ServeHTTP3 takes
+// ownership of pc and closes it when it returns.
+//
+// The certificate and key are given as for ServeTLS; the Server's
+// TLSConfig is otherwise used as is, except that connections always
+// negotiate TLS 1.3 and the "h3" protocol. Connections are closed after
+// they have been idle for IdleTimeout, or a default of 30 seconds.
+//
+// While ServeHTTP3 runs, responses sent by the Server over TLS with
+// HTTP/1.1 or HTTP/2 carry an Alt-Svc header advertising HTTP/3 on
+// pc's port, unless the Handler sets an Alt-Svc header itself.
+// Transports with EnableHTTP3 set use that header to switch to HTTP/3.
+//
+// BaseContext, if set, is called with a nil Listener. ConnContext and
+// ConnState are not used for HTTP/3 connections.
+//
+// ServeHTTP3 always returns a non-nil error. After Shutdown or Close,
+// the returned error is ErrServerClosed; Shutdown waits for HTTP/3
+// requests in progress to finish.
+func (srv *Server) ServeHTTP3(pc net.PacketConn, certFile, keyFile string) error {
+	config := cloneTLSConfig(srv.TLSConfig)
+	config.NextProtos = []string{http3NextProto}
+	configHasCert := len(config.Certificates) > 0 || config.GetCertificate != nil
+	if !configHasCert || certFile != "" || keyFile != "" {
+		var err error
+		config.Certificates = make([]tls.Certificate, 1)
+		config.Certificates[0], err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			pc.Close()
+			return err
+		}
+	}
+
+	ep := quic.NewEndpoint(pc, &quic.Config{
+		TLSConfig:      config,
+		MaxIdleTimeout: srv.idleTimeout(),
+	})
+	if !srv.trackHTTP3Endpoint(ep, true) {
+		ep.Close(context.Background())
+		return ErrServerClosed
+	}
+
+	baseCtx := context.Background()
+	if srv.BaseContext != nil {
+		baseCtx = srv.BaseContext(nil)
+		if baseCtx == nil {
+			panic("http: BaseContext returned a nil context")
+		}
+	}
+	ctx := context.WithValue(baseCtx, ServerContextKey, srv)
+	for {
+		qconn, err := ep.Accept(ctx)
+		if err != nil {
+			if srv.shuttingDown() {
+				return ErrServerClosed
+			}
+			srv.trackHTTP3Endpoint(ep, false)
+			ep.Close(context.Background())
+			return err
+		}
+		go srv.serveHTTP3Conn(ctx, qconn)
+	}
+}
+
+// listenAndServeHTTP3 serves HTTP/3 on UDP at addr, the address of
+// the TCP listener ListenAndServeTLS has just opened.
+func (srv *Server) listenAndServeHTTP3(addr, certFile, keyFile string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := srv.ServeHTTP3(pc, certFile, keyFile); err != ErrServerClosed {
+			srv.logf("http: HTTP/3 server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// trackHTTP3Endpoint adds or removes a QUIC endpoint to the set of
+// endpoints serving HTTP/3, updating the Alt-Svc header advertising
+// them. It reports whether the server is still up.
+func (s *Server) trackHTTP3Endpoint(ep *quic.Endpoint, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.h3Endpoints == nil {
+		s.h3Endpoints = make(map[*quic.Endpoint]struct{})
+	}
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		s.h3Endpoints[ep] = struct{}{}
+	} else {
+		delete(s.h3Endpoints, ep)
+	}
+	s.updateHTTP3AltSvcLocked()
+	return true
+}
+
+// updateHTTP3AltSvcLocked recomputes the Alt-Svc header value
+// advertising the HTTP/3 endpoints.
+func (s *Server) updateHTTP3AltSvcLocked() {
+	var ports []int
+	for ep := range s.h3Endpoints {
+		if a, ok := ep.LocalAddr().(*net.UDPAddr); ok {
+			ports = append(ports, a.Port)
+		}
+	}
+	sort.Ints(ports)
+	var b strings.Builder
+	for i, port := range ports {
+		if i > 0 && port == ports[i-1] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s=\":%d\"", http3NextProto, port)
+	}
+	s.h3AltSvc.Store(b.String())
+}
+
+// http3AltSvcHeader returns the Alt-Svc header value advertising the
+// server's HTTP/3 endpoints, or "" if it has none.
+func (s *Server) http3AltSvcHeader() string {
+	v, _ := s.h3AltSvc.Load().(string)
+	return v
+}
+
+// trackHTTP3Conn adds or removes an HTTP/3 connection to the set of
+// active connections. It reports whether the server is still up.
+func (s *Server) trackHTTP3Conn(sc *http3serverConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.h3Conns == nil {
+		s.h3Conns = make(map[*http3serverConn]struct{})
+	}
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		s.h3Conns[sc] = struct{}{}
+	} else {
+		delete(s.h3Conns, sc)
+	}
+	return true
+}
+
+// closeHTTP3Locked closes the HTTP/3 endpoints and with them all
+// HTTP/3 connections.
+func (s *Server) closeHTTP3Locked() {
+	for ep := range s.h3Endpoints {
+		ep.Close(context.Background())
+		delete(s.h3Endpoints, ep)
+	}
+	s.updateHTTP3AltSvcLocked()
+}
+
+// closeIdleHTTP3ConnsLocked starts a graceful shutdown of each HTTP/3
+// connection and closes those with no requests in progress. It reports
+// whether all HTTP/3 connections are closed.
+func (s *Server) closeIdleHTTP3ConnsLocked() bool {
+	quiescent := true
+	for sc := range s.h3Conns {
+		if sc.shutdownIfIdle() {
+			delete(s.h3Conns, sc)
+		} else {
+			quiescent = false
+		}
+	}
+	return quiescent
+}
+
+// An http3serverConn is a server's HTTP/3 connection.
+type http3serverConn struct {
+	http3Conn
+	srv        *Server
+	remoteAddr string
+	tlsState   *tls.ConnectionState
+
+	mu        sync.Mutex
+	active    int   // requests in progress
+	nextID    int64 // the stream ID after the last request accepted
+	goingAway bool  // GOAWAY sent
+	goAwayID  int64 // requests on streams from here on are rejected
+}
+
+func (srv *Server) serveHTTP3Conn(ctx context.Context, qconn *quic.Conn) {
+	sc := &http3serverConn{
+		srv:        srv,
+		remoteAddr: qconn.RemoteAddr().String(),
+	}
+	sc.init(qconn, true, int64(srv.maxHeaderBytes()))
+	state := qconn.ConnectionState()
+	sc.tlsState = &state
+	if !srv.trackHTTP3Conn(sc, true) {
+		sc.abort(http3ErrNoError, "")
+		return
+	}
+	defer srv.trackHTTP3Conn(sc, false)
+
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, LocalAddrContextKey, qconn.LocalAddr()))
+	defer cancel()
+	if err := sc.openControlStream(ctx); err != nil {
+		sc.abort(http3ErrInternal, "")
+		return
+	}
+	for {
+		st, err := qconn.AcceptStream(ctx)
+		if err != nil {
+			sc.abort(http3ErrNoError, "")
+			return
+		}
+		if st.ID()&2 != 0 {
+			go sc.handleUniStream(st)
+			continue
+		}
+		if !sc.startRequest(st.ID()) {
+			st.StopSending(uint64(http3ErrRequestRejected))
+			st.Reset(uint64(http3ErrRequestRejected))
+			continue
+		}
+		go sc.serveRequest(ctx, st)
+	}
+}
+
+// startRequest notes the start of the request on stream id,
+// reporting false if it is to be rejected.
+func (sc *http3serverConn) startRequest(id int64) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.goingAway && id >= sc.goAwayID {
+		return false
+	}
+	sc.active++
+	if id >= sc.nextID {
+		sc.nextID = id + 4
+	}
+	return true
+}
+
+// endRequest notes the end of a request, closing the connection if it
+// is shutting down and this was the last request.
+func (sc *http3serverConn) endRequest() {
+	sc.mu.Lock()
+	sc.active--
+	idle := sc.goingAway && sc.active == 0
+	sc.mu.Unlock()
+	if idle {
+		sc.abort(http3ErrNoError, "")
+	}
+}
+
+// shutdownIfIdle sends a GOAWAY frame, unless one was sent already, and
+// closes the connection if it has no requests in progress. It reports
+// whether it closed the connection.
+func (sc *http3serverConn) shutdownIfIdle() bool {
+	sc.mu.Lock()
+	if !sc.goingAway {
+		sc.goingAway = true
+		sc.goAwayID = sc.nextID
+		sc.sendGoAway(sc.goAwayID)
+	}
+	idle := sc.active == 0
+	sc.mu.Unlock()
+	if idle {
+		sc.abort(http3ErrNoError, "")
+	}
+	return idle
+}
+
+func (sc *http3serverConn) logf(format string, args ...interface{}) {
+	sc.srv.logf(format, args...)
+}
+
+// serveRequest reads the request on st and runs the Handler for it.
+func (sc *http3serverConn) serveRequest(ctx context.Context, st *quic.Stream) {
+	defer sc.endRequest()
+	s := newHTTP3Stream(st, sc.maxFieldSection)
+	fields, err := s.readHeaders()
+	if err != nil {
+		if se, ok := err.(http3StreamError); ok && se.code == http3ErrExcessiveLoad {
+			// See RFC 9114, Section 4.2.2.
+			s.writeHeaders([]qpack.HeaderField{{Name: ":status", Value: "431"}})
+			st.CloseWrite()
+			st.StopSending(uint64(http3ErrNoError))
+			return
+		}
+		if err == io.EOF {
+			err = http3StreamError{http3ErrRequestIncomplete, nil}
+		}
+		sc.handleError(s, err)
+		return
+	}
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// Cancel the request if the client abandons it.
+		select {
+		case <-st.PeerAborted():
+			cancel()
+		case <-reqCtx.Done():
+		}
+	}()
+	rw, req, err := sc.newWriterAndRequest(reqCtx, s, fields)
+	if err != nil {
+		sc.handleError(s, err)
+		return
+	}
+	if sc.srv.WriteTimeout != 0 {
+		rw.SetWriteDeadline(time.Now().Add(sc.srv.WriteTimeout))
+	}
+	if !sc.runHandler(rw, req) {
+		return
+	}
+	// Keep the request counted as in progress until the peer has
+	// the whole response, so that a graceful shutdown does not cut
+	// it short.
+	st.WaitAcked(ctx)
+}
+
+// runHandler runs the Handler for req, reporting whether it returned
+// without panicking.
+func (sc *http3serverConn) runHandler(rw *http3responseWriter, req *Request) (ok bool) {
+	defer func() {
+		rw.stopTimers()
+		if ok {
+			rw.handlerDone()
+			return
+		}
+		e := recover()
+		sc.abortStream(rw.s, http3ErrInternal)
+		if e != nil && e != ErrAbortHandler {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			sc.logf("http3: panic serving %v: %v\n%s", sc.remoteAddr, e, buf)
+		}
+	}()
+	serverHandler{sc.srv}.ServeHTTP(rw, req)
+	return true
+}
+
+// newWriterAndRequest creates the Request read from the fields of a
+// HEADERS frame on s and its ResponseWriter. See RFC 9114, Section 4.3.1.
+func (sc *http3serverConn) newWriterAndRequest(ctx context.Context, s *http3Stream, fields []qpack.HeaderField) (*http3responseWriter, *Request, error) {
+	malformed := func(err error) error {
+		return http3StreamError{http3ErrMessage, err}
+	}
+	var method, scheme, authority, path string
+	seen := make(map[string]bool)
+	header := make(Header)
+	for i, f := range fields {
+		if err := http3ValidateField(f); err != nil {
+			return nil, nil, malformed(err)
+		}
+		if !strings.HasPrefix(f.Name, ":") {
+			k := CanonicalHeaderKey(f.Name)
+			header[k] = append(header[k], f.Value)
+			continue
+		}
+		if i > 0 && !strings.HasPrefix(fields[i-1].Name, ":") {
+			return nil, nil, malformed(errors.New("pseudo-header after regular header"))
+		}
+		if seen[f.Name] {
+			return nil, nil, malformed(fmt.Errorf("duplicate %s pseudo-header", f.Name))
+		}
+		seen[f.Name] = true
+		switch f.Name {
+		case ":method":
+			method = f.Value
+		case ":scheme":
+			scheme = f.Value
+		case ":authority":
+			authority = f.Value
+		case ":path":
+			path = f.Value
+		default:
+			return nil, nil, malformed(fmt.Errorf("invalid pseudo-header %s", f.Name))
+		}
+	}
+	if method == "CONNECT" {
+		if path != "" || scheme != "" || authority == "" {
+			return nil, nil, malformed(errors.New("malformed CONNECT request"))
+		}
+	} else if method == "" || path == "" || (scheme != "https" && scheme != "http") {
+		return nil, nil, malformed(errors.New("missing or invalid pseudo-header"))
+	}
+	if authority == "" {
+		authority = header.Get("Host")
+	}
+
+	needsContinue := header.Get("Expect") == "100-continue"
+	if needsContinue {
+		header.Del("Expect")
+	}
+	// Merge Cookie headers into one "; "-delimited value.
+	if cookies := header["Cookie"]; len(cookies) > 1 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+
+	var trailer Header
+	for _, v := range header["Trailer"] {
+		foreachHeaderElement(v, func(key string) {
+			key = CanonicalHeaderKey(key)
+			switch key {
+			case "Transfer-Encoding", "Trailer", "Content-Length":
+				// Bogus. (copy of http1 rules)
+				// Ignore.
+			default:
+				if trailer == nil {
+					trailer = make(Header)
+				}
+				trailer[key] = nil
+			}
+		})
+	}
+	delete(header, "Trailer")
+
+	var u *url.URL
+	var requestURI string
+	if method == "CONNECT" {
+		u = &url.URL{Host: authority}
+		requestURI = authority // mimic HTTP/1 server behavior
+	} else {
+		var err error
+		u, err = url.ParseRequestURI(path)
+		if err != nil {
+			return nil, nil, malformed(err)
+		}
+		requestURI = path
+	}
+
+	contentLength := int64(-1)
+	if vv := header["Content-Length"]; len(vv) > 0 {
+		n, err := strconv.ParseInt(vv[0], 10, 64)
+		if err != nil || n < 0 || len(vv) > 1 {
+			return nil, nil, malformed(errors.New("invalid Content-Length"))
+		}
+		contentLength = n
+	}
+
+	req := &Request{
+		Method:        method,
+		URL:           u,
+		RemoteAddr:    sc.remoteAddr,
+		Header:        header,
+		RequestURI:    requestURI,
+		Proto:         "HTTP/3.0",
+		ProtoMajor:    3,
+		ProtoMinor:    0,
+		TLS:           sc.tlsState,
+		Host:          authority,
+		ContentLength: contentLength,
+		Trailer:       trailer,
+	}
+	req = req.WithContext(ctx)
+
+	rw := &http3responseWriter{sc: sc, s: s, req: req}
+	rw.bw = bufio.NewWriterSize(http3chunkWriter{rw}, 4<<10)
+	body := &http3requestBody{
+		conn:          sc,
+		s:             s,
+		req:           req,
+		rw:            rw,
+		needsContinue: needsContinue,
+		remaining:     contentLength,
+	}
+	if contentLength == 0 {
+		req.Body = NoBody
+		s.st.StopSending(uint64(http3ErrNoError))
+	} else {
+		req.Body = body
+		rw.body = body
+	}
+	return rw, req, nil
+}
+
+// http3errDeadlineExceeded is returned to a Handler reading a request
+// body past its read deadline.
+var http3errDeadlineExceeded error = &httpError{err: "http3: i/o timeout", timeout: true}
+
+// http3requestBody is the Body of a request received over HTTP/3.
+type http3requestBody struct {
+	conn *http3serverConn
+	s    *http3Stream
+	req  *Request
+	rw   *http3responseWriter
+
+	mu            sync.Mutex
+	needsContinue bool  // need to send a 100-continue
+	remaining     int64 // declared content left to read, or -1
+	err           error // sticky Read error
+	closed        bool
+	readDeadline  *time.Timer
+}
+
+func (b *http3requestBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return 0, ErrBodyReadAfterClose
+	}
+	if b.err != nil {
+		err := b.err
+		b.mu.Unlock()
+		return 0, err
+	}
+	if b.needsContinue {
+		b.needsContinue = false
+		b.rw.write100Continue()
+	}
+	b.mu.Unlock()
+
+	n, trailer, err := b.s.readData(p)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		// Set by a read deadline or Close while reading.
+		return n, b.err
+	}
+	if b.remaining >= 0 {
+		if int64(n) > b.remaining || (err == io.EOF && int64(n) < b.remaining) {
+			err = http3StreamError{http3ErrMessage, errors.New("body length does not match Content-Length")}
+		} else {
+			b.remaining -= int64(n)
+		}
+	}
+	if err == io.EOF && trailer != nil {
+		if b.req.Trailer == nil {
+			b.req.Trailer = make(Header)
+		}
+		if terr := http3TrailerHeader(b.req.Trailer, trailer); terr != nil {
+			err = http3StreamError{http3ErrMessage, terr}
+		}
+	}
+	switch e := err.(type) {
+	case nil:
+	case http3ConnError, http3StreamError:
+		b.conn.handleError(b.s, err)
+	case quic.StreamErrorCode:
+		err = http3PeerStreamError(e)
+	default:
+		if err == io.ErrUnexpectedEOF {
+			b.conn.handleError(b.s, err)
+		}
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *http3requestBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.needsContinue = false
+	if b.err == nil {
+		// Let the client stop sending a body we will not read.
+		// See RFC 9114, Section 4.1.
+		b.s.st.StopSending(uint64(http3ErrNoError))
+	}
+	if b.readDeadline != nil {
+		b.readDeadline.Stop()
+	}
+	return nil
+}
+
+// setReadDeadline arranges for reads to fail after deadline.
+func (b *http3requestBody) setReadDeadline(deadline time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.readDeadline != nil {
+		b.readDeadline.Stop()
+		b.readDeadline = nil
+	}
+	if deadline.IsZero() {
+		return
+	}
+	b.readDeadline = time.AfterFunc(time.Until(deadline), b.onReadTimeout)
+}
+
+func (b *http3requestBody) onReadTimeout() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil && !b.closed {
+		b.err = http3errDeadlineExceeded
+		b.s.st.StopSending(uint64(http3ErrRequestCancelled))
+	}
+}
+
+// http3responseWriter is the ResponseWriter of a request received over
+// HTTP/3. It follows the HTTP/2 responseWriter closely.
+type http3responseWriter struct {
+	sc   *http3serverConn
+	s    *http3Stream
+	req  *Request
+	body *http3requestBody // nil if the request has no body
+	bw   *bufio.Writer     // writing to http3chunkWriter{rw}
+
+	handlerHeader  Header   // nil until called
+	snapHeader     Header   // snapshot of handlerHeader at WriteHeader time
+	trailers       []string // set in writeChunk
+	status         int      // status code passed to WriteHeader
+	wroteHeader    bool     // WriteHeader called (explicitly or implicitly)
+	handlerDoneSet bool     // handler has finished
+	sentContentLen int64    // non-zero if handler set a Content-Length header
+	wroteBytes     int64
+
+	mu            sync.Mutex // guards following, written by body reads too
+	sentHeader    bool       // have we sent the HEADERS frame?
+	writeDeadline *time.Timer
+	writeTimedOut bool
+}
+
+// Optional http.ResponseWriter interfaces implemented.
+var (
+	_ Flusher = (*http3responseWriter)(nil)
+)
+
+type http3chunkWriter struct{ rw *http3responseWriter }
+
+func (cw http3chunkWriter) Write(p []byte) (n int, err error) {
+	return cw.rw.writeChunk(p)
+}
+
+func (rw *http3responseWriter) Header() Header {
+	if rw.handlerHeader == nil {
+		rw.handlerHeader = make(Header)
+	}
+	return rw.handlerHeader
+}
+
+func (rw *http3responseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	checkWriteHeaderCode(code)
+	if code >= 100 && code <= 199 && code != StatusSwitchingProtocols {
+		rw.writeInformational(code)
+		return
+	}
+	rw.wroteHeader = true
+	rw.status = code
+	if len(rw.handlerHeader) > 0 {
+		rw.snapHeader = rw.handlerHeader.clone()
+	}
+}
+
+func (rw *http3responseWriter) Write(p []byte) (n int, err error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(StatusOK)
+	}
+	if !bodyAllowedForStatus(rw.status) {
+		return 0, ErrBodyNotAllowed
+	}
+	rw.wroteBytes += int64(len(p))
+	if rw.sentContentLen != 0 && rw.wroteBytes > rw.sentContentLen {
+		return 0, errors.New("http3: handler wrote more than declared Content-Length")
+	}
+	return rw.bw.Write(p)
+}
+
+func (rw *http3responseWriter) WriteString(s string) (n int, err error) {
+	return rw.Write([]byte(s))
+}
+
+func (rw *http3responseWriter) Flush() {
+	rw.FlushError()
+}
+
+func (rw *http3responseWriter) FlushError() error {
+	if rw.bw.Buffered() > 0 {
+		return rw.bw.Flush()
+	}
+	// The bufio.Writer won't call writeChunk with zero bytes,
+	// so do it ourselves to force the HEADERS frame out.
+	_, err := rw.writeChunk(nil)
+	return err
+}
+
+func (rw *http3responseWriter) SetReadDeadline(deadline time.Time) error {
+	if rw.body != nil {
+		rw.body.setReadDeadline(deadline)
+	}
+	return nil
+}
+
+func (rw *http3responseWriter) SetWriteDeadline(deadline time.Time) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.writeDeadline != nil {
+		rw.writeDeadline.Stop()
+		rw.writeDeadline = nil
+	}
+	if !deadline.IsZero() {
+		rw.writeDeadline = time.AfterFunc(time.Until(deadline), rw.onWriteTimeout)
+	}
+	return nil
+}
+
+func (rw *http3responseWriter) onWriteTimeout() {
+	rw.mu.Lock()
+	rw.writeTimedOut = true
+	rw.mu.Unlock()
+	rw.sc.abortStream(rw.s, http3ErrRequestCancelled)
+}
+
+// stopTimers stops the read and write deadline timers when the
+// Handler returns.
+func (rw *http3responseWriter) stopTimers() {
+	rw.SetWriteDeadline(time.Time{})
+	rw.SetReadDeadline(time.Time{})
+}
+
+// write100Continue sends a 100 Continue response, unless the final
+// response has been sent already.
+func (rw *http3responseWriter) write100Continue() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if !rw.sentHeader {
+		rw.s.writeHeaders([]qpack.HeaderField{{Name: ":status", Value: "100"}})
+	}
+}
+
+// writeInformational sends a 1xx informational HEADERS frame with the
+// handler's current header map, which is kept for the final response
+// (RFC 8297, section 2).
+func (rw *http3responseWriter) writeInformational(code int) {
+	if code == StatusContinue && rw.body != nil {
+		rw.body.mu.Lock()
+		rw.body.needsContinue = false
+		rw.body.mu.Unlock()
+	}
+	fields := []qpack.HeaderField{{Name: ":status", Value: strconv.Itoa(code)}}
+	fields = http3AppendHeader(fields, rw.handlerHeader, func(k string) bool {
+		switch k {
+		case "Content-Length", "Trailer":
+			return true
+		}
+		if strings.HasPrefix(k, TrailerPrefix) {
+			return true
+		}
+		for _, v := range rw.handlerHeader[k] {
+			if !httpguts.ValidHeaderFieldValue(v) {
+				return true
+			}
+		}
+		return !httpguts.ValidHeaderFieldName(k)
+	})
+	// A failed write shows up again when the final response is sent.
+	rw.s.writeHeaders(fields)
+}
+
+// writeChunk writes p, which the bufio.Writer passes on in chunks of
+// at most its size unless a Write is larger, as a DATA frame, first
+// sending the HEADERS frame if it has not been sent yet. When the
+// Handler is done, it also writes any trailers and ends the stream.
+func (rw *http3responseWriter) writeChunk(p []byte) (n int, err error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(StatusOK)
+	}
+	isHeadResp := rw.req.Method == "HEAD"
+	rw.mu.Lock()
+	sentHeader := rw.sentHeader
+	rw.sentHeader = true
+	rw.mu.Unlock()
+	if !sentHeader {
+		if err := rw.writeResponseHeaders(p); err != nil {
+			return 0, rw.writeError(err)
+		}
+	}
+	if isHeadResp {
+		if rw.handlerDoneSet {
+			rw.s.st.CloseWrite()
+		}
+		return len(p), nil
+	}
+	if len(p) > 0 {
+		if err := rw.s.writeData(p); err != nil {
+			return 0, rw.writeError(err)
+		}
+	}
+	if rw.handlerDoneSet {
+		rw.promoteUndeclaredTrailers()
+		if len(rw.trailers) > 0 {
+			var fields []qpack.HeaderField
+			for _, k := range rw.trailers {
+				name := strings.ToLower(k)
+				for _, v := range rw.handlerHeader[k] {
+					if !httpguts.ValidHeaderFieldValue(v) {
+						continue
+					}
+					fields = append(fields, qpack.HeaderField{Name: name, Value: v})
+				}
+			}
+			if err := rw.s.writeHeaders(fields); err != nil {
+				return 0, rw.writeError(err)
+			}
+		}
+		rw.s.st.CloseWrite()
+	}
+	return len(p), nil
+}
+
+// writeError converts an error writing the stream into the error to
+// return to the Handler.
+func (rw *http3responseWriter) writeError(err error) error {
+	rw.mu.Lock()
+	timedOut := rw.writeTimedOut
+	rw.mu.Unlock()
+	if timedOut {
+		return http3errDeadlineExceeded
+	}
+	if code, ok := err.(quic.StreamErrorCode); ok {
+		return http3PeerStreamError(code)
+	}
+	return err
+}
+
+// writeResponseHeaders sends the HEADERS frame of the response, given
+// the first chunk of the body.
+func (rw *http3responseWriter) writeResponseHeaders(p []byte) error {
+	h := rw.snapHeader
+	if h == nil {
+		h = make(Header)
+	}
+	fields := []qpack.HeaderField{{Name: ":status", Value: strconv.Itoa(rw.status)}}
+	if clen := h.Get("Content-Length"); clen != "" {
+		h.Del("Content-Length")
+		if n, err := strconv.ParseInt(clen, 10, 64); err == nil && n >= 0 {
+			rw.sentContentLen = n
+			fields = append(fields, qpack.HeaderField{Name: "content-length", Value: clen})
+		}
+	} else if rw.handlerDoneSet && bodyAllowedForStatus(rw.status) && (len(p) > 0 || rw.req.Method != "HEAD") {
+		fields = append(fields, qpack.HeaderField{Name: "content-length", Value: strconv.Itoa(len(p))})
+	}
+	if _, ok := h["Content-Type"]; !ok && bodyAllowedForStatus(rw.status) && len(p) > 0 {
+		fields = append(fields, qpack.HeaderField{Name: "content-type", Value: DetectContentType(p)})
+	}
+	if _, ok := h["Date"]; !ok {
+		fields = append(fields, qpack.HeaderField{Name: "date", Value: time.Now().UTC().Format(TimeFormat)})
+	}
+	for _, v := range h["Trailer"] {
+		foreachHeaderElement(v, rw.declareTrailer)
+	}
+	fields = http3AppendHeader(fields, h, func(k string) bool {
+		if strings.HasPrefix(k, TrailerPrefix) {
+			return true
+		}
+		for _, v := range h[k] {
+			if !httpguts.ValidHeaderFieldValue(v) {
+				return true
+			}
+		}
+		return !httpguts.ValidHeaderFieldName(k)
+	})
+	return rw.s.writeHeaders(fields)
+}
+
+// declareTrailer notes a trailer to send at the end of the response.
+func (rw *http3responseWriter) declareTrailer(k string) {
+	k = CanonicalHeaderKey(k)
+	if !httpguts.ValidTrailerHeader(k) {
+		// Forbidden by RFC 7230, section 4.1.2.
+		rw.sc.logf("http3: ignoring invalid trailer %q", k)
+		return
+	}
+	if !strSliceContains(rw.trailers, k) {
+		rw.trailers = append(rw.trailers, k)
+	}
+}
+
+// promoteUndeclaredTrailers turns Header entries with TrailerPrefix
+// into trailers. See the HTTP/2 method of the same name.
+func (rw *http3responseWriter) promoteUndeclaredTrailers() {
+	for k, vv := range rw.handlerHeader {
+		if !strings.HasPrefix(k, TrailerPrefix) {
+			continue
+		}
+		trailerKey := strings.TrimPrefix(k, TrailerPrefix)
+		rw.declareTrailer(trailerKey)
+		rw.handlerHeader[CanonicalHeaderKey(trailerKey)] = vv
+	}
+	sort.Strings(rw.trailers)
+}
+
+// handlerDone finishes the response after the Handler returns.
+func (rw *http3responseWriter) handlerDone() {
+	rw.handlerDoneSet = true
+	rw.FlushError()
+	if rw.body != nil {
+		rw.body.Close()
+	}
+}
@@ -0,0 +1,597 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// White-box tests for h3.go, h3_server.go and h3_transport.go
+// (in package http instead of http_test).
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http/httptrace"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"net/http/internal"
+)
+
+// h3TestServer is a Server serving HTTP/1.1 over TLS and HTTP/3 on
+// the same loopback port.
+type h3TestServer struct {
+	srv  *Server
+	url  string
+	port int
+	done chan struct{} // closed when ServeHTTP3 returns
+	err  error         // returned by ServeHTTP3
+}
+
+func newH3TestServer(t *testing.T, h Handler) *h3TestServer {
+	cert, err := tls.X509KeyPair(internal.LocalhostCert, internal.LocalhostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ln net.Listener
+	var pc net.PacketConn
+	for i := 0; ; i++ {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc, err = net.ListenPacket("udp", ln.Addr().String())
+		if err == nil {
+			break
+		}
+		ln.Close()
+		if i == 10 {
+			t.Fatalf("no free TCP and UDP port pair: %v", err)
+		}
+	}
+	ts := &h3TestServer{
+		srv: &Server{
+			Handler:   h,
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+			ErrorLog:  log.New(ioutil.Discard, "", 0),
+		},
+		url:  "https://" + ln.Addr().String(),
+		port: ln.Addr().(*net.TCPAddr).Port,
+		done: make(chan struct{}),
+	}
+	go ts.srv.ServeTLS(ln, "", "")
+	go func() {
+		ts.err = ts.srv.ServeHTTP3(pc, "", "")
+		close(ts.done)
+	}()
+	return ts
+}
+
+func (ts *h3TestServer) close() {
+	ts.srv.Close()
+	<-ts.done
+}
+
+func newH3TestTransport() *Transport {
+	return &Transport{
+		EnableHTTP3:     true,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+}
+
+// h3Get fetches url and returns the response with its body read.
+func h3Get(t *testing.T, c *Client, url string) (*Response, string) {
+	t.Helper()
+	res, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(body)
+}
+
+// h3Upgrade makes a first request over TCP so that tr learns about the
+// server's HTTP/3 support.
+func h3Upgrade(t *testing.T, tr *Transport, ts *h3TestServer) {
+	t.Helper()
+	res, _ := h3Get(t, &Client{Transport: tr}, ts.url+"/upgrade")
+	if res.ProtoMajor == 3 {
+		t.Fatalf("first request used %s; want TCP", res.Proto)
+	}
+}
+
+func h3ProtoHandler(w ResponseWriter, r *Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	fmt.Fprintf(w, "%s %s %s %q", r.Proto, r.Method, r.URL.Path, body)
+}
+
+func TestHTTP3AltSvcUpgrade(t *testing.T) {
+	ts := newH3TestServer(t, HandlerFunc(h3ProtoHandler))
+	defer ts.close()
+	tr := newH3TestTransport()
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	res, body := h3Get(t, c, ts.url+"/one")
+	if want := fmt.Sprintf(`h3=":%d"`, ts.port); res.Header.Get("Alt-Svc") != want {
+		t.Errorf("Alt-Svc = %q; want %q", res.Header.Get("Alt-Svc"), want)
+	}
+	if want := `HTTP/1.1 GET /one ""`; body != want {
+		t.Errorf("first body = %q; want %q", body, want)
+	}
+
+	for _, method := range []string{"GET", "POST"} {
+		req, _ := NewRequest(method, ts.url+"/two", strings.NewReader("body"))
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Proto != "HTTP/3.0" || res.TLS == nil {
+			t.Errorf("%s: proto = %q, TLS = %v; want HTTP/3.0 over TLS", method, res.Proto, res.TLS)
+		}
+		if res.Header.Get("Alt-Svc") != "" {
+			t.Errorf("%s: HTTP/3 response has Alt-Svc header %q", method, res.Header.Get("Alt-Svc"))
+		}
+		if want := "HTTP/3.0 " + method + ` /two "body"`; string(got) != want {
+			t.Errorf("%s: body = %q; want %q", method, got, want)
+		}
+	}
+}
+
+func TestHTTP3NoAltSvcWithoutEnable(t *testing.T) {
+	ts := newH3TestServer(t, HandlerFunc(h3ProtoHandler))
+	defer ts.close()
+	tr := &Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+	for i := 0; i < 2; i++ {
+		if _, body := h3Get(t, c, ts.url+"/"); !strings.HasPrefix(body, "HTTP/1.1 ") {
+			t.Fatalf("request %d: body = %q; want HTTP/1.1", i, body)
+		}
+	}
+}
+
+func TestHTTP3HeadersAndTrailers(t *testing.T) {
+	ts := newH3TestServer(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading request body: %v", err)
+		}
+		w.Header().Set("Trailer", "Server-Trailer")
+		w.Header().Set("Content-Type", "text/x-test")
+		w.Header().Add("X-Multi", "a")
+		w.Header().Add("X-Multi", "b")
+		w.WriteHeader(StatusAccepted)
+		fmt.Fprintf(w, "%s|%s|%s|%s", body, r.Header.Get("X-Req"), r.Trailer.Get("Client-Trailer"), r.Host)
+		w.Header().Set("Server-Trailer", "st")
+		w.Header().Set(TrailerPrefix+"Undeclared", "ut")
+	}))
+	defer ts.close()
+	tr := newH3TestTransport()
+	defer tr.CloseIdleConnections()
+	h3Upgrade(t, tr, ts)
+
+	req, _ := NewRequest("POST", ts.url+"/", ioutil.NopCloser(strings.NewReader("payload")))
+	req.Header.Set("X-Req", "req")
+	req.Trailer = Header{"Client-Trailer": {"ct"}}
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ProtoMajor != 3 {
+		t.Fatalf("proto = %s; want HTTP/3", res.Proto)
+	}
+	if res.StatusCode != StatusAccepted {
+		t.Errorf("status = %d; want %d", res.StatusCode, StatusAccepted)
+	}
+	if want := "payload|req|ct|" + strings.TrimPrefix(ts.url, "https://"); string(body) != want {
+		t.Errorf("body = %q; want %q", body, want)
+	}
+	if got := res.Header.Get("Content-Type"); got != "text/x-test" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := res.Header["X-Multi"]; len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("X-Multi = %q; want [a b]", got)
+	}
+	if got := res.Header.Get("Date"); got == "" {
+		t.Error("missing Date header")
+	}
+	if got := res.Trailer.Get("Server-Trailer"); got != "st" {
+		t.Errorf("Server-Trailer = %q; want st", got)
+	}
+	if got := res.Trailer.Get("Undeclared"); got != "ut" {
+		t.Errorf("Undeclared trailer = %q; want ut", got)
+	}
+}
+
+func TestHTTP3ContentLengthAndHead(t *testing.T) {
+	ts := newH3TestServer(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/nocontent":
+			w.WriteHeader(StatusNoContent)
+		default:
+			io.WriteString(w, "hello, world")
+		}
+	}))
+	defer ts.close()
+	tr := newH3TestTransport()
+	defer tr.CloseIdleConnections()
+	h3Upgrade(t, tr, ts)
+	c := &Client{Transport: tr}
+
+	res, body := h3Get(t, c, ts.url+"/")
+	if res.ContentLength != 12 || body != "hello, world" {
+		t.Errorf("GET: ContentLength = %d, body = %q", res.ContentLength, body)
+	}
+	if got := res.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("GET: sniffed Content-Type = %q", got)
+	}
+
+	res, err := c.Head(ts.url + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.ProtoMajor != 3 || len(b) != 0 {
+		t.Errorf("HEAD: proto = %s, body = %q", res.Proto, b)
+	}
+
+	res, body = h3Get(t, c, ts.url+"/nocontent")
+	if res.StatusCode != StatusNoContent || body != "" {
+		t.Errorf("204: status = %d, body = %q", res.StatusCode, body)
+	}
+}
+
+func TestHTTP3InformationalResponses(t *testing.T) {
+	ts := newH3TestServer(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(StatusEarlyHints)
+		w.Header().Add("Link", "</script.js>; rel=preload; as=script")
+		w.WriteHeader(StatusEarlyHints)
+		io.WriteString(w, "done")
+	}))
+	defer ts.close()
+	tr := newH3TestTransport()
+	defer tr.CloseIdleConnections()
+	h3Upgrade(t, tr, ts)
+
+	var links []int
+	req, _ := NewRequest("GET", ts.url+"/", nil)
+	req = req.WithContext(httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code != StatusEarlyHints {
+				t.Errorf("1xx code = %d; want 103", code)
+			}
+			links = append(links, len(header["Link"]))
+			return nil
+		},
+	}))
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ProtoMajor != 3 || res.StatusCode != StatusOK || string(body) != "done" {
+		t.Errorf("got %s %d %q; want HTTP/3 200 \"done\"", res.Proto, res.StatusCode, body)
+	}
+	if fmt.Sprint(links) != "[1 2]" {
+		t.Errorf("Link values per 103 response = %v; want [1 2]", links)
+	}
+}
+
+func TestHTTP3LargeBodies(t *testing.T) {
+	const size = 3 << 20
+	ts := newH3TestServer(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		n, err := io.Copy(ioutil.Discard, r.Body)
+		if err != nil {
+			t.Errorf("reading request body: %v", err)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		w.Write(bytes.Repeat([]byte{byte(n)}, size))
+	}))
+	defer ts.close()
+	tr := newH3TestTransport()
+	defer tr.CloseIdleConnections()
+	h3Upgrade(t, tr, ts)
+
+	req, _ := NewRequest("PUT", ts.url+"/", bytes.NewReader(make([]byte, size+7)))
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ProtoMajor != 3 {
+		t.Fatalf("proto = %s; want HTTP/3", res.Proto)
+	}
+	if len(body) != size {
+		t.Fatalf("got %d bytes; want %d", len(body), size)
+	}
+	if want := byte((size + 7) & 0xff); body[0] != want {
+		t.Errorf("got bytes of %d; want %d", body[0], want)
+	}
+}
+
+func TestHTTP3ConcurrentRequests(t *testing.T) {
+	ts := newH3TestServer(t, HandlerFunc(h3ProtoHandler))
+	defer ts.close()
+	tr := newH3TestTransport()
+	defer tr.CloseIdleConnections()
+	h3Upgrade(t, tr, ts)
+	c := &Client{Transport: tr}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("/%d", i)
+			res, err := c.Get(ts.url + path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if want := "HTTP/3.0 GET " + path + ` ""`; string(body) != want {
+				t.Errorf("body = %q; want %q", body, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := len(tr.http3Transport().conns); n != 1 {
+		t.Errorf("%d HTTP/3 connections; want 1", n)
+	}
+}
+
+func TestHTTP3RequestCancel(t *testing.T) {
+	handlerDone := make(chan error, 1)
+	ts := newH3TestServer(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/upgrade" {
+			return
+		}
+		w.WriteHeader(StatusOK)
+		w.(Flusher).Flush()
+		<-r.Context().Done()
+		handlerDone <- r.Context().Err()
+	}))
+	defer ts.close()
+	tr := newH3TestTransport()
+	defer tr.CloseIdleConnections()
+	h3Upgrade(t, tr, ts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := NewRequest("GET", ts.url+"/", nil)
+	res, err := tr.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ProtoMajor != 3 {
+		t.Fatalf("proto = %s; want HTTP/3", res.Proto)
+	}
+	cancel()
+	if _, err := ioutil.ReadAll(res.Body); err != context.Canceled {
+		t.Errorf("reading body after cancel: %v; want %v", err, context.Canceled)
+	}
+	res.Body.Close()
+	select {
+	case err := <-handlerDone:
+		if err == nil {
+			t.Error("handler context not canceled")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("handler still running after request canceled")
+	}
+}
+
+func TestHTTP3HandlerPanic(t *testing.T) {
+	ts := newH3TestServer(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/panic" {
+			panic(ErrAbortHandler)
+		}
+	}))
+	defer ts.close()
+	tr := newH3TestTransport()
+	defer tr.CloseIdleConnections()
+	h3Upgrade(t, tr, ts)
+	c := &Client{Transport: tr}
+
+	if res, err := c.Get(ts.url + "/panic"); err == nil {
+		res.Body.Close()
+		t.Fatal("request to panicking handler succeeded")
+	}
+	// The connection survives.
+	if res, _ := h3Get(t, c, ts.url+"/"); res.ProtoMajor != 3 {
+		t.Errorf("proto after panic = %s; want HTTP/3", res.Proto)
+	}
+}
+
+func TestHTTP3Shutdown(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	ts := newH3TestServer(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		io.WriteString(w, r.Proto)
+	}))
+	tr := newH3TestTransport()
+	defer tr.CloseIdleConnections()
+	h3Upgrade(t, tr, ts)
+
+	type result struct {
+		body string
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		res, err := (&Client{Transport: tr}).Get(ts.url + "/slow")
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		resc <- result{string(body), err}
+	}()
+	<-started
+
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- ts.srv.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdownDone:
+		t.Fatalf("Shutdown returned %v with a request in progress", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	r := <-resc
+	if r.err != nil || r.body != "HTTP/3.0" {
+		t.Errorf("in-flight request: body %q, error %v; want HTTP/3.0", r.body, r.err)
+	}
+	if err := <-shutdownDone; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	<-ts.done
+	if ts.err != ErrServerClosed {
+		t.Errorf("ServeHTTP3 = %v; want ErrServerClosed", ts.err)
+	}
+}
+
+func TestHTTP3FallbackToTCP(t *testing.T) {
+	// Advertise a UDP port nothing listens on.
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadPort := dead.LocalAddr().(*net.UDPAddr).Port
+	dead.Close()
+
+	ts := newH3TestServer(t, HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Alt-Svc", fmt.Sprintf(`h3=":%d"; ma=60`, deadPort))
+		io.WriteString(w, r.Proto)
+	}))
+	defer ts.close()
+	tr := newH3TestTransport()
+	tr.TLSHandshakeTimeout = 100 * time.Millisecond
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	for i := 0; i < 3; i++ {
+		if _, body := h3Get(t, c, ts.url+"/"); body != "HTTP/1.1" {
+			t.Fatalf("request %d: proto %q; want HTTP/1.1", i, body)
+		}
+	}
+	origin := strings.TrimPrefix(ts.url, "https://")
+	if _, ok := tr.http3Transport().alternative(origin); ok {
+		t.Error("unreachable alternative not marked broken")
+	}
+}
+
+func TestHTTP3AltSvcParse(t *testing.T) {
+	tests := []struct {
+		in        string
+		authority string
+		maxAge    time.Duration
+		clear, ok bool
+	}{
+		{in: `h3=":443"`, authority: ":443", maxAge: http3DefaultAltSvcMaxAge, ok: true},
+		{in: `h3=":8443"; ma=3600`, authority: ":8443", maxAge: time.Hour, ok: true},
+		{in: `h3="alt.example.com:443"; ma="60"; persist=1`, authority: "alt.example.com:443", maxAge: time.Minute, ok: true},
+		{in: `h2=":443", h3="[::1]:4433"`, authority: "[::1]:4433", maxAge: http3DefaultAltSvcMaxAge, ok: true},
+		{in: `h3-29=":443"; ma=10, h3=":443"; ma=20`, authority: ":443", maxAge: 20 * time.Second, ok: true},
+		{in: `h3="a,b;c:1"; ma=5`, authority: "a,b;c:1", maxAge: 5 * time.Second, ok: true},
+		{in: `clear`, clear: true, ok: true},
+		{in: ` clear `, clear: true, ok: true},
+		{in: `h2=":443"`},
+		{in: `h3=":notaport"`},
+		{in: `h3=noquotes:443`},
+		{in: ``},
+	}
+	for _, tt := range tests {
+		authority, maxAge, clear, ok := http3AltSvc(tt.in)
+		if authority != tt.authority || maxAge != tt.maxAge || clear != tt.clear || ok != tt.ok {
+			t.Errorf("http3AltSvc(%q) = %q, %v, %v, %v; want %q, %v, %v, %v",
+				tt.in, authority, maxAge, clear, ok, tt.authority, tt.maxAge, tt.clear, tt.ok)
+		}
+	}
+}
+
+func TestHTTP3AltSvcCache(t *testing.T) {
+	h3 := &http3Transport{t: &Transport{}}
+	note := func(v string) {
+		h3.noteAltSvc("example.com:443", &Response{
+			Header: Header{"Alt-Svc": {v}},
+			TLS:    &tls.ConnectionState{},
+		})
+	}
+	note(`h3=":8443"`)
+	if addr, ok := h3.alternative("example.com:443"); !ok || addr != "example.com:8443" {
+		t.Errorf("after advertisement: %q, %v; want example.com:8443", addr, ok)
+	}
+	note(`h3="other.example.com:443"`)
+	if addr, _ := h3.alternative("example.com:443"); addr != "other.example.com:443" {
+		t.Errorf("after new advertisement: %q; want other.example.com:443", addr)
+	}
+	h3.markBroken("example.com:443")
+	if _, ok := h3.alternative("example.com:443"); ok {
+		t.Error("broken alternative used")
+	}
+	note(`clear`)
+	if _, ok := h3.alts["example.com:443"]; ok {
+		t.Error("alternative not cleared")
+	}
+	note(`h3=":443"; ma=0`)
+	if _, ok := h3.alternative("example.com:443"); ok {
+		t.Error("alternative with ma=0 used")
+	}
+}
+
+func TestHTTP3Varint(t *testing.T) {
+	for _, v := range []uint64{0, 63, 64, 16383, 16384, 1<<30 - 1, 1 << 30, 1<<62 - 1} {
+		b := http3AppendVarint(nil, v)
+		got, n := http3ConsumeVarint(b)
+		if got != v || n != len(b) {
+			t.Errorf("http3ConsumeVarint(%x) = %d, %d; want %d, %d", b, got, n, v, len(b))
+		}
+		got, err := http3ReadVarint(bytes.NewReader(b))
+		if err != nil || got != v {
+			t.Errorf("http3ReadVarint(%x) = %d, %v; want %d", b, got, err, v)
+		}
+		if _, n := http3ConsumeVarint(b[:len(b)-1]); len(b) > 1 && n != 0 {
+			t.Errorf("http3ConsumeVarint(%x) consumed a truncated varint", b[:len(b)-1])
+		}
+	}
+}
@@ -0,0 +1,969 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTTP/3 client. See h3.go.
+
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"internal/x/net/http/httpguts"
+	"net/http/internal/qpack"
+	"net/http/internal/quic"
+)
+
+// http3DefaultUserAgent is the User-Agent sent in HTTP/3 requests
+// which do not set one.
+const http3DefaultUserAgent = "Go-http-client/3.0"
+
+// http3DefaultDialTimeout limits the QUIC handshake when the Transport
+// has no TLSHandshakeTimeout.
+const http3DefaultDialTimeout = 10 * time.Second
+
+// http3BrokenPeriod is how long an HTTP/3 alternative that could not
+// be reached is left unused. See RFC 7838, Section 2.4.
+const http3BrokenPeriod = 5 * time.Minute
+
+// http3MaxAttempts limits how often a request is sent again after the
+// server did not process it.
+const http3MaxAttempts = 3
+
+var (
+	// errHTTP3Unavailable is returned by http3Transport.roundTrip
+	// when the request is to be sent over TCP instead.
+	errHTTP3Unavailable = errors.New("http3: no usable HTTP/3 alternative")
+
+	// errHTTP3NotSent is returned when a connection could not take a
+	// request, which was left untouched.
+	errHTTP3NotSent = errors.New("http3: connection unusable for new requests")
+
+	// errHTTP3Rejected is returned when the server reset a request
+	// without processing it. See RFC 9114, Section 4.1.1.
+	errHTTP3Rejected = errors.New("http3: request rejected by server")
+
+	errHTTP3RequestHeaderTooLarge = errors.New("http3: request header larger than peer's advertised limit")
+	errHTTP3ClosedResponseBody    = errors.New("http3: response body closed")
+	errHTTP3NoResponse            = errors.New("http3: server closed stream without sending a response")
+)
+
+// http3Transport sends the requests of a Transport with EnableHTTP3 set
+// to origins which advertised HTTP/3 in an Alt-Svc header.
+type http3Transport struct {
+	t *Transport
+
+	mu    sync.Mutex
+	ep    *quic.Endpoint              // nil until first dial
+	alts  map[string]*http3AltService // by origin "host:port"
+	conns map[string]*http3clientConn // by alternative "host:port"
+	dials map[string]*http3dialCall   // in progress, by alternative
+}
+
+// An http3AltService is an origin's HTTP/3 alternative service, as
+// advertised in an Alt-Svc header.
+type http3AltService struct {
+	addr        string // "host:port"
+	expires     time.Time
+	brokenUntil time.Time
+}
+
+// An http3dialCall is a connection attempt shared by the requests
+// waiting for it.
+type http3dialCall struct {
+	done chan struct{} // closed when the dial completes
+	cc   *http3clientConn
+	err  error
+}
+
+// http3Transport returns the HTTP/3 transport used when EnableHTTP3
+// is set, creating it on first use.
+func (t *Transport) http3Transport() *http3Transport {
+	t.h3Once.Do(func() {
+		t.h3Transport = &http3Transport{t: t}
+	})
+	return t.h3Transport
+}
+
+// useHTTP3 reports whether the request for cm may be sent over
+// HTTP/3.
+func (t *Transport) useHTTP3(cm connectMethod) bool {
+	return t.EnableHTTP3 && cm.proxyURL == nil && cm.targetScheme == "https"
+}
+
+// noteAltSvc records the HTTP/3 alternative advertised by the Alt-Svc
+// header of a response from origin. See RFC 7838, Section 3.
+func (h3 *http3Transport) noteAltSvc(origin string, res *Response) {
+	vv := res.Header["Alt-Svc"]
+	if len(vv) == 0 || res.TLS == nil {
+		return
+	}
+	authority, maxAge, clear, ok := http3AltSvc(strings.Join(vv, ","))
+	h3.mu.Lock()
+	defer h3.mu.Unlock()
+	if clear || !ok || maxAge <= 0 {
+		delete(h3.alts, origin)
+		return
+	}
+	host, port, _ := splitHostPortLoose(authority)
+	if host == "" {
+		host, _, _ = net.SplitHostPort(origin)
+	}
+	addr := net.JoinHostPort(host, port)
+	alt := h3.alts[origin]
+	if alt == nil || alt.addr != addr {
+		alt = &http3AltService{addr: addr}
+		if h3.alts == nil {
+			h3.alts = make(map[string]*http3AltService)
+		}
+		h3.alts[origin] = alt
+	}
+	alt.expires = time.Now().Add(maxAge)
+}
+
+// alternative returns the address of origin's HTTP/3 alternative, if it
+// has a fresh one that is not known to be broken.
+func (h3 *http3Transport) alternative(origin string) (addr string, ok bool) {
+	h3.mu.Lock()
+	defer h3.mu.Unlock()
+	alt := h3.alts[origin]
+	if alt == nil {
+		return "", false
+	}
+	now := time.Now()
+	if now.After(alt.expires) {
+		delete(h3.alts, origin)
+		return "", false
+	}
+	if now.Before(alt.brokenUntil) {
+		return "", false
+	}
+	return alt.addr, true
+}
+
+// markBroken stops the use of origin's HTTP/3 alternative for a while.
+func (h3 *http3Transport) markBroken(origin string) {
+	h3.mu.Lock()
+	defer h3.mu.Unlock()
+	if alt := h3.alts[origin]; alt != nil {
+		alt.brokenUntil = time.Now().Add(http3BrokenPeriod)
+	}
+}
+
+// roundTrip sends req over HTTP/3 if the origin of cm has advertised
+// an alternative. It returns errHTTP3Unavailable, without touching
+// req.Body, if the request is to be sent over TCP.
+func (h3 *http3Transport) roundTrip(req *Request, cm connectMethod) (*Response, error) {
+	origin := cm.targetAddr
+	addr, ok := h3.alternative(origin)
+	if !ok {
+		return nil, errHTTP3Unavailable
+	}
+	serverName, _, err := net.SplitHostPort(origin)
+	if err != nil {
+		return nil, errHTTP3Unavailable
+	}
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		cc, err := h3.getConn(ctx, addr, serverName)
+		if err == nil {
+			var res *Response
+			res, err = cc.roundTrip(req)
+			if err == nil {
+				return res, nil
+			}
+		} else if err != errHTTP3NotSent {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				req.closeBody()
+				return nil, ctxErr
+			}
+			h3.markBroken(origin)
+			return nil, errHTTP3Unavailable
+		}
+		switch {
+		case err == errHTTP3NotSent:
+			if attempt >= http3MaxAttempts {
+				return nil, errHTTP3Unavailable
+			}
+		case err == errHTTP3Rejected:
+			if attempt >= http3MaxAttempts {
+				return nil, err
+			}
+			if req.Body != nil && req.Body != NoBody {
+				if req.GetBody == nil {
+					return nil, err
+				}
+				newReq := *req
+				newReq.Body, err = req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req = &newReq
+			}
+		default:
+			return nil, err
+		}
+	}
+}
+
+// getConn returns a connection to addr with a request slot reserved,
+// dialing one if there is none.
+func (h3 *http3Transport) getConn(ctx context.Context, addr, serverName string) (*http3clientConn, error) {
+	h3.mu.Lock()
+	if cc := h3.conns[addr]; cc != nil && cc.reserve() {
+		h3.mu.Unlock()
+		return cc, nil
+	}
+	call := h3.dials[addr]
+	if call == nil {
+		call = &http3dialCall{done: make(chan struct{})}
+		if h3.dials == nil {
+			h3.dials = make(map[string]*http3dialCall)
+		}
+		h3.dials[addr] = call
+		go h3.dial(call, addr, serverName)
+	}
+	h3.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	if !call.cc.reserve() {
+		return nil, errHTTP3NotSent
+	}
+	return call.cc, nil
+}
+
+// dial runs call, adding the connection made to the pool.
+func (h3 *http3Transport) dial(call *http3dialCall, addr, serverName string) {
+	cc, err := h3.dialConn(addr, serverName)
+	h3.mu.Lock()
+	delete(h3.dials, addr)
+	if err == nil {
+		if h3.conns == nil {
+			h3.conns = make(map[string]*http3clientConn)
+		}
+		h3.conns[addr] = cc
+	}
+	h3.mu.Unlock()
+	call.cc, call.err = cc, err
+	close(call.done)
+}
+
+func (h3 *http3Transport) dialConn(addr, serverName string) (*http3clientConn, error) {
+	h3.mu.Lock()
+	if h3.ep == nil {
+		ep, err := quic.Listen("udp", ":0", nil)
+		if err != nil {
+			h3.mu.Unlock()
+			return nil, err
+		}
+		h3.ep = ep
+	}
+	ep := h3.ep
+	h3.mu.Unlock()
+
+	t := h3.t
+	tlsConfig := cloneTLSConfig(t.TLSClientConfig)
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName
+	}
+	tlsConfig.NextProtos = []string{http3NextProto}
+	timeout := t.TLSHandshakeTimeout
+	if timeout == 0 {
+		timeout = http3DefaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	qconn, err := ep.Dial(ctx, "udp", addr, &quic.Config{
+		TLSConfig:      tlsConfig,
+		MaxIdleTimeout: t.IdleConnTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	maxFieldSection := t.MaxResponseHeaderBytes
+	if maxFieldSection <= 0 {
+		maxFieldSection = 10 << 20 // as for HTTP/2
+	}
+	state := qconn.ConnectionState()
+	cc := &http3clientConn{
+		h3:       h3,
+		addr:     addr,
+		tlsState: &state,
+	}
+	cc.init(qconn, false, maxFieldSection)
+	cc.onGoAway = cc.goAway
+	if err := cc.openControlStream(ctx); err != nil {
+		qconn.Close()
+		return nil, err
+	}
+	go cc.acceptStreams()
+	return cc, nil
+}
+
+// forget removes cc from the pool.
+func (h3 *http3Transport) forget(cc *http3clientConn) {
+	h3.mu.Lock()
+	defer h3.mu.Unlock()
+	if h3.conns[cc.addr] == cc {
+		delete(h3.conns, cc.addr)
+	}
+}
+
+// closeIdleConns closes the connections with no requests in progress,
+// and the QUIC endpoint once no connections remain.
+func (h3 *http3Transport) closeIdleConns() {
+	h3.mu.Lock()
+	defer h3.mu.Unlock()
+	for addr, cc := range h3.conns {
+		if cc.closeIfIdle() {
+			delete(h3.conns, addr)
+		}
+	}
+	if h3.ep != nil && len(h3.conns) == 0 && len(h3.dials) == 0 {
+		h3.ep.Close(context.Background())
+		h3.ep = nil
+	}
+}
+
+// An http3clientConn is a client's HTTP/3 connection.
+type http3clientConn struct {
+	http3Conn
+	h3       *http3Transport
+	addr     string
+	tlsState *tls.ConnectionState
+
+	mu        sync.Mutex
+	active    int  // requests in progress, including reserved slots
+	goingAway bool // no new requests may start
+}
+
+// reserve reserves a slot for a new request, reporting whether the
+// connection may take one.
+func (cc *http3clientConn) reserve() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.goingAway || cc.qconn.Err() != nil {
+		return false
+	}
+	cc.active++
+	return true
+}
+
+// release releases a request slot, closing the connection if it is
+// going away and this was the last request.
+func (cc *http3clientConn) release() {
+	cc.mu.Lock()
+	cc.active--
+	idle := cc.goingAway && cc.active == 0
+	cc.mu.Unlock()
+	if idle {
+		cc.abort(http3ErrNoError, "")
+	}
+}
+
+// closeIfIdle closes the connection if it has no requests in progress,
+// reporting whether it did.
+func (cc *http3clientConn) closeIfIdle() bool {
+	cc.mu.Lock()
+	idle := cc.active == 0
+	if idle {
+		cc.goingAway = true
+	}
+	cc.mu.Unlock()
+	if idle {
+		cc.abort(http3ErrNoError, "")
+	}
+	return idle
+}
+
+// goAway handles a GOAWAY frame from the server. Requests on streams
+// the server will not process are reset with H3_REQUEST_REJECTED, and
+// sent again on another connection.
+func (cc *http3clientConn) goAway(id int64) {
+	cc.mu.Lock()
+	cc.goingAway = true
+	idle := cc.active == 0
+	cc.mu.Unlock()
+	cc.h3.forget(cc)
+	if idle {
+		cc.abort(http3ErrNoError, "")
+	}
+}
+
+// acceptStreams serves the streams the server opens until the
+// connection closes.
+func (cc *http3clientConn) acceptStreams() {
+	defer cc.h3.forget(cc)
+	for {
+		st, err := cc.qconn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		if st.ID()&2 == 0 {
+			// Servers may not open bidirectional streams.
+			// See RFC 9114, Section 6.1.
+			cc.abort(http3ErrStreamCreation, "bidirectional stream opened by server")
+			return
+		}
+		go cc.handleUniStream(st)
+	}
+}
+
+// An http3clientStream is the state of a request sent over HTTP/3.
+type http3clientStream struct {
+	cc            *http3clientConn
+	s             *http3Stream
+	req           *Request
+	trace         *httptrace.ClientTrace
+	requestedGzip bool
+
+	on100     chan struct{} // closed by a 100 Continue; nil unless waiting for one
+	on100Once sync.Once
+	wrotec    chan struct{} // closed when the request body is written
+	bodyErr   error         // error writing the body; valid after wrotec closes
+	donec     chan struct{} // closed when the request is done
+	doneOnce  sync.Once
+
+	mu       sync.Mutex
+	abortErr error // returned by reads after the request is aborted
+}
+
+type http3resAndError struct {
+	res *Response
+	err error
+}
+
+// roundTrip sends req on a new stream of cc, which has a request slot
+// reserved for it. It returns errHTTP3NotSent if it could not open
+// the stream, and errHTTP3Rejected if the server rejected the request.
+func (cc *http3clientConn) roundTrip(req *Request) (*Response, error) {
+	trailers, err := http2commaSeparatedTrailers(req)
+	if err == nil {
+		err = http2checkConnHeaders(req)
+	}
+	if err != nil {
+		cc.release()
+		req.closeBody()
+		return nil, err
+	}
+	contentLen := http2actualContentLength(req)
+	requestedGzip := !cc.h3.t.DisableCompression &&
+		req.Header.Get("Accept-Encoding") == "" &&
+		req.Header.Get("Range") == "" &&
+		req.Method != "HEAD"
+	fields, err := cc.encodeHeaders(req, requestedGzip, trailers, contentLen)
+	if err == nil {
+		if limit := cc.peerFieldSectionLimit(); limit >= 0 && http3FieldSectionSize(fields) > limit {
+			err = errHTTP3RequestHeaderTooLarge
+		}
+	}
+	if err != nil {
+		cc.release()
+		req.closeBody()
+		return nil, err
+	}
+
+	ctx := req.Context()
+	st, err := cc.qconn.NewStream(ctx)
+	if err != nil {
+		cc.release()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			req.closeBody()
+			return nil, ctxErr
+		}
+		return nil, errHTTP3NotSent
+	}
+	cs := &http3clientStream{
+		cc:            cc,
+		s:             newHTTP3Stream(st, cc.maxFieldSection),
+		req:           req,
+		trace:         httptrace.ContextClientTrace(ctx),
+		requestedGzip: requestedGzip,
+		wrotec:        make(chan struct{}),
+		donec:         make(chan struct{}),
+	}
+	if err := cs.s.writeHeaders(fields); err != nil {
+		cs.abort(err)
+		req.closeBody()
+		return nil, err
+	}
+	http2traceWroteHeaders(cs.trace)
+	if contentLen != 0 || trailers != "" {
+		if req.Header.Get("Expect") == "100-continue" && cc.h3.t.ExpectContinueTimeout > 0 {
+			cs.on100 = make(chan struct{})
+		}
+		go cs.writeRequestBody(contentLen)
+	} else {
+		st.CloseWrite()
+		http2traceWroteRequest(cs.trace, nil)
+		close(cs.wrotec)
+	}
+
+	resc := make(chan http3resAndError, 1)
+	go func() {
+		res, err := cs.readResponse()
+		resc <- http3resAndError{res, err}
+	}()
+
+	wrotec := cs.wrotec
+	var respHeaderTimer <-chan time.Time
+	for {
+		select {
+		case re := <-resc:
+			if re.err != nil {
+				cs.abort(re.err)
+				return nil, re.err
+			}
+			return re.res, nil
+		case <-wrotec:
+			wrotec = nil
+			if cs.bodyErr != nil {
+				// Prefer the response, if there is one.
+				select {
+				case re := <-resc:
+					if re.err == nil {
+						return re.res, nil
+					}
+				default:
+				}
+				cs.abort(cs.bodyErr)
+				return nil, cs.bodyErr
+			}
+			if d := cc.h3.t.ResponseHeaderTimeout; d != 0 {
+				timer := time.NewTimer(d)
+				defer timer.Stop()
+				respHeaderTimer = timer.C
+			}
+		case <-respHeaderTimer:
+			cs.abort(errTimeout)
+			return nil, errTimeout
+		case <-ctx.Done():
+			cs.abort(ctx.Err())
+			return nil, ctx.Err()
+		case <-req.Cancel:
+			cs.abort(errRequestCanceled)
+			return nil, errRequestCanceled
+		}
+	}
+}
+
+// encodeHeaders returns the fields of the HEADERS frame for req.
+// It follows the HTTP/2 Transport's method of the same name.
+func (cc *http3clientConn) encodeHeaders(req *Request, addGzipHeader bool, trailers string, contentLength int64) ([]qpack.HeaderField, error) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	host, err := httpguts.PunycodeHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+
+	var path string
+	if req.Method != "CONNECT" {
+		path = req.URL.RequestURI()
+		if !http2validPseudoPath(path) {
+			orig := path
+			path = strings.TrimPrefix(path, req.URL.Scheme+"://"+host)
+			if !http2validPseudoPath(path) {
+				if req.URL.Opaque != "" {
+					return nil, fmt.Errorf("invalid request :path %q from URL.Opaque = %q", orig, req.URL.Opaque)
+				}
+				return nil, fmt.Errorf("invalid request :path %q", orig)
+			}
+		}
+	}
+
+	for k, vv := range req.Header {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return nil, fmt.Errorf("invalid HTTP header name %q", k)
+		}
+		for _, v := range vv {
+			if !httpguts.ValidHeaderFieldValue(v) {
+				return nil, fmt.Errorf("invalid HTTP header value %q for header %q", v, k)
+			}
+		}
+	}
+
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	fields := []qpack.HeaderField{
+		{Name: ":authority", Value: host},
+		{Name: ":method", Value: method},
+	}
+	if method != "CONNECT" {
+		fields = append(fields,
+			qpack.HeaderField{Name: ":path", Value: path},
+			qpack.HeaderField{Name: ":scheme", Value: req.URL.Scheme})
+	}
+	if trailers != "" {
+		fields = append(fields, qpack.HeaderField{Name: "trailer", Value: trailers})
+	}
+	var didUA bool
+	fields = http3AppendHeader(fields, req.Header, func(k string) bool {
+		switch {
+		case strings.EqualFold(k, "host"), strings.EqualFold(k, "content-length"):
+			// Host is :authority, already sent.
+			// Content-Length is automatic, set below.
+			return true
+		case strings.EqualFold(k, "user-agent"):
+			// Match Go's http1 behavior: at most one
+			// User-Agent. If set to nil or empty string,
+			// then omit it. Otherwise if not mentioned,
+			// include the default (below).
+			didUA = true
+			vv := req.Header[k]
+			if len(vv) > 0 && vv[0] != "" {
+				fields = append(fields, qpack.HeaderField{Name: "user-agent", Value: vv[0]})
+			}
+			return true
+		}
+		return false
+	})
+	if http2shouldSendReqContentLength(method, contentLength) {
+		fields = append(fields, qpack.HeaderField{Name: "content-length", Value: strconv.FormatInt(contentLength, 10)})
+	}
+	if addGzipHeader {
+		fields = append(fields, qpack.HeaderField{Name: "accept-encoding", Value: "gzip"})
+	}
+	if !didUA {
+		fields = append(fields, qpack.HeaderField{Name: "user-agent", Value: http3DefaultUserAgent})
+	}
+
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.WroteHeaderField != nil {
+		for _, f := range fields {
+			trace.WroteHeaderField(f.Name, []string{f.Value})
+		}
+	}
+	return fields, nil
+}
+
+// writeRequestBody sends the request body and trailers, then closes
+// the send side of the stream.
+func (cs *http3clientStream) writeRequestBody(contentLen int64) {
+	err := cs.copyRequestBody(contentLen)
+	cs.req.closeBody()
+	switch code, ok := err.(quic.StreamErrorCode); {
+	case err == nil:
+		cs.s.st.CloseWrite()
+	case ok:
+		// The server asked us to stop sending the body, typically
+		// because it has responded without reading it. That is
+		// not an error unless the response says so.
+		cs.s.st.Reset(uint64(code))
+		err = nil
+	default:
+		cs.s.st.Reset(uint64(http3ErrRequestCancelled))
+	}
+	http2traceWroteRequest(cs.trace, err)
+	cs.bodyErr = err
+	close(cs.wrotec)
+}
+
+func (cs *http3clientStream) copyRequestBody(contentLen int64) error {
+	if cs.on100 != nil {
+		http2traceWait100Continue(cs.trace)
+		timer := time.NewTimer(cs.cc.h3.t.ExpectContinueTimeout)
+		select {
+		case <-cs.on100:
+		case <-timer.C:
+		case <-cs.donec:
+			timer.Stop()
+			return errRequestCanceled
+		}
+		timer.Stop()
+	}
+	if body := cs.req.Body; body != nil && body != NoBody {
+		buf := make([]byte, 16<<10)
+		var written int64
+		for {
+			n, err := body.Read(buf)
+			written += int64(n)
+			if contentLen >= 0 && written > contentLen {
+				return errors.New("http3: request body larger than specified content length")
+			}
+			if n > 0 {
+				if werr := cs.s.writeData(buf[:n]); werr != nil {
+					return werr
+				}
+			}
+			if err == io.EOF {
+				if contentLen >= 0 && written < contentLen {
+					return errors.New("http3: request body shorter than specified content length")
+				}
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(cs.req.Trailer) > 0 {
+		var fields []qpack.HeaderField
+		for k, vv := range cs.req.Trailer {
+			name := strings.ToLower(k)
+			for _, v := range vv {
+				if !httpguts.ValidHeaderFieldValue(v) {
+					return fmt.Errorf("invalid HTTP trailer value %q for trailer %q", v, k)
+				}
+				fields = append(fields, qpack.HeaderField{Name: name, Value: v})
+			}
+		}
+		return cs.s.writeHeaders(fields)
+	}
+	return nil
+}
+
+// readResponse reads the response header, skipping informational
+// responses.
+func (cs *http3clientStream) readResponse() (*Response, error) {
+	num1xx := 0
+	for {
+		fields, err := cs.s.readHeaders()
+		if err != nil {
+			return nil, cs.readError(err)
+		}
+		res, err := cs.newResponse(fields)
+		if err != nil {
+			cs.cc.handleError(cs.s, err)
+			return nil, err
+		}
+		if res != nil {
+			return res, nil
+		}
+		num1xx++
+		const max1xxResponses = 5 // arbitrary bound on number of informational responses, same as net/http
+		if num1xx > max1xxResponses {
+			return nil, errors.New("http3: too many 1xx informational responses")
+		}
+	}
+}
+
+// readError converts an error reading the response stream into the
+// error to return, taking any action it calls for.
+func (cs *http3clientStream) readError(err error) error {
+	cs.mu.Lock()
+	abortErr := cs.abortErr
+	cs.mu.Unlock()
+	if abortErr != nil {
+		return abortErr
+	}
+	switch e := err.(type) {
+	case http3ConnError, http3StreamError:
+		cs.cc.handleError(cs.s, err)
+		return err
+	case quic.StreamErrorCode:
+		if http3ErrCode(e) == http3ErrRequestRejected {
+			return errHTTP3Rejected
+		}
+		return http3PeerStreamError(e)
+	}
+	if err == io.EOF {
+		return errHTTP3NoResponse
+	}
+	return err
+}
+
+// newResponse creates the Response for the fields of a HEADERS frame,
+// or returns nil for an informational response.
+// It follows the HTTP/2 Transport's handleResponse.
+func (cs *http3clientStream) newResponse(fields []qpack.HeaderField) (*Response, error) {
+	malformed := func(msg string) error {
+		return http3StreamError{http3ErrMessage, errors.New(msg)}
+	}
+	var status string
+	header := make(Header)
+	var trailer Header
+	for i, f := range fields {
+		if err := http3ValidateField(f); err != nil {
+			return nil, http3StreamError{http3ErrMessage, err}
+		}
+		if strings.HasPrefix(f.Name, ":") {
+			if f.Name != ":status" || status != "" || i > 0 {
+				return nil, malformed("malformed response from server: invalid pseudo header")
+			}
+			status = f.Value
+			continue
+		}
+		key := CanonicalHeaderKey(f.Name)
+		if key == "Trailer" {
+			if trailer == nil {
+				trailer = make(Header)
+			}
+			foreachHeaderElement(f.Value, func(v string) {
+				trailer[CanonicalHeaderKey(v)] = nil
+			})
+		} else {
+			header[key] = append(header[key], f.Value)
+		}
+	}
+	if status == "" {
+		return nil, malformed("malformed response from server: missing status pseudo header")
+	}
+	statusCode, err := strconv.Atoi(status)
+	if err != nil || len(status) != 3 {
+		return nil, malformed("malformed response from server: malformed non-numeric status pseudo header")
+	}
+
+	if statusCode >= 100 && statusCode <= 199 {
+		if statusCode == 101 {
+			// See RFC 9114, Section 4.5.
+			return nil, malformed("malformed response from server: 101 Switching Protocols")
+		}
+		if fn := http2traceGot1xxResponseFunc(cs.trace); fn != nil {
+			if err := fn(statusCode, textproto.MIMEHeader(header)); err != nil {
+				return nil, http3StreamError{http3ErrRequestCancelled, err}
+			}
+		}
+		if statusCode == 100 {
+			http2traceGot100Continue(cs.trace)
+			if cs.on100 != nil {
+				cs.on100Once.Do(func() { close(cs.on100) })
+			}
+		}
+		return nil, nil
+	}
+
+	res := &Response{
+		Proto:         "HTTP/3.0",
+		ProtoMajor:    3,
+		Header:        header,
+		Trailer:       trailer,
+		StatusCode:    statusCode,
+		Status:        status + " " + StatusText(statusCode),
+		ContentLength: -1,
+		Request:       cs.req,
+		TLS:           cs.cc.tlsState,
+	}
+	if clens := header["Content-Length"]; len(clens) == 1 {
+		if n, err := strconv.ParseInt(clens[0], 10, 64); err == nil && n >= 0 {
+			res.ContentLength = n
+		}
+	}
+	if cs.req.Method == "HEAD" || !bodyAllowedForStatus(statusCode) {
+		res.Body = NoBody
+		if cs.req.Method != "HEAD" {
+			res.ContentLength = 0
+		}
+		cs.s.st.StopSending(uint64(http3ErrNoError))
+		cs.finish()
+		return res, nil
+	}
+
+	res.Body = &http3responseBody{cs: cs, res: res, remaining: res.ContentLength}
+	go cs.awaitRequestCancel()
+
+	if cs.requestedGzip && res.Header.Get("Content-Encoding") == "gzip" {
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+		res.Body = &http2gzipReader{body: res.Body}
+		res.Uncompressed = true
+	}
+	return res, nil
+}
+
+// awaitRequestCancel aborts the request if it is canceled before the
+// response body is done.
+func (cs *http3clientStream) awaitRequestCancel() {
+	ctx := cs.req.Context()
+	select {
+	case <-ctx.Done():
+		cs.abort(ctx.Err())
+	case <-cs.req.Cancel:
+		cs.abort(errRequestCanceled)
+	case <-cs.donec:
+	}
+}
+
+// abort resets the stream of the request and ends it. Reads which
+// have not already failed return err.
+func (cs *http3clientStream) abort(err error) {
+	cs.mu.Lock()
+	if cs.abortErr == nil {
+		cs.abortErr = err
+	}
+	cs.mu.Unlock()
+	cs.cc.abortStream(cs.s, http3ErrRequestCancelled)
+	cs.finish()
+}
+
+// finish releases the request's slot on the connection.
+func (cs *http3clientStream) finish() {
+	cs.doneOnce.Do(func() {
+		close(cs.donec)
+		cs.cc.release()
+	})
+}
+
+// http3responseBody is the Body of a response received over HTTP/3.
+type http3responseBody struct {
+	cs        *http3clientStream
+	res       *Response
+	remaining int64 // declared content left to read, or -1
+	err       error // sticky Read error
+}
+
+func (b *http3responseBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	cs := b.cs
+	n, trailer, err := cs.s.readData(p)
+	if b.remaining >= 0 {
+		if int64(n) > b.remaining || (err == io.EOF && int64(n) < b.remaining) {
+			err = http3StreamError{http3ErrMessage, errors.New("response body length does not match Content-Length")}
+		} else {
+			b.remaining -= int64(n)
+		}
+	}
+	if err == io.EOF && trailer != nil {
+		if b.res.Trailer == nil {
+			b.res.Trailer = make(Header)
+		}
+		if terr := http3TrailerHeader(b.res.Trailer, trailer); terr != nil {
+			err = http3StreamError{http3ErrMessage, terr}
+		}
+	}
+	switch {
+	case err == io.EOF:
+		cs.finish()
+	case err != nil:
+		err = cs.readError(err)
+		cs.finish()
+	}
+	b.err = err
+	return n, err
+}
+
+func (b *http3responseBody) Close() error {
+	cs := b.cs
+	select {
+	case <-cs.donec:
+	default:
+		cs.abort(errHTTP3ClosedResponseBody)
+	}
+	b.err = errHTTP3ClosedResponseBody
+	return nil
+}
@@ -0,0 +1,418 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Recording and replaying of HTTP interactions
+
+package httptest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Redacted is the value that replaces the values of a Recording's
+// RedactHeaders in its golden file.
+const Redacted = "REDACTED"
+
+// A Recording records HTTP interactions with a real server to a golden
+// file and replays them in later runs, so that tests of code talking
+// to that server are fast, deterministic and work offline.
+//
+// A Recording is used either as the http.RoundTripper of the client
+// under test, or, for code that cannot be given a client, through the
+// http.Handler returned by its Handler method, served with NewServer.
+//
+// The golden file holds each request, with an absolute URL, followed
+// by its response, both in HTTP/1.1 wire format, with a blank line
+// between interactions. It may be edited by hand.
+//
+// A Recording is safe for concurrent use by multiple goroutines.
+type Recording struct {
+	// Transport makes the real requests while recording.
+	// If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// Match reports whether req, a request being made, matches
+	// recorded, a request from the golden file. The bodies of
+	// both requests may be read. The Host field of recorded is
+	// that of its URL.
+	//
+	// If nil, requests match if they have the same method, URL
+	// and body.
+	Match func(req, recorded *http.Request) bool
+
+	// RedactHeaders lists the headers, such as "Authorization",
+	// "Cookie" or "Set-Cookie", whose values are replaced by
+	// Redacted in the golden file. The requests and responses seen
+	// while recording are not modified.
+	RedactHeaders []string
+
+	file   string
+	record bool
+
+	mu           sync.Mutex
+	interactions []*interaction
+	closed       bool
+}
+
+// An interaction is a request and its response, with their bodies
+// read into memory.
+type interaction struct {
+	req     *http.Request
+	reqBody []byte
+	res     *http.Response
+	resBody []byte
+	used    bool // replayed at least once
+}
+
+// NewRecording returns a Recording backed by the golden file name.
+//
+// If record is false and the file exists, the Recording replays the
+// interactions in the file and never makes real requests. Otherwise
+// it makes real requests and records them, and its Close method
+// writes them to the file, replacing any previous contents. Tests
+// typically pass the value of a command-line flag such as -update
+// as record.
+func NewRecording(name string, record bool) (*Recording, error) {
+	rec := &Recording{file: name, record: record}
+	if record {
+		return rec, nil
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		rec.record = true
+		return rec, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rec.interactions, err = readInteractions(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("httptest: reading %s: %v", name, err)
+	}
+	return rec, nil
+}
+
+// Replaying reports whether rec replays the interactions in its golden
+// file, as opposed to recording real ones.
+func (rec *Recording) Replaying() bool {
+	return !rec.record
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+//
+// When replaying, it returns a copy of the response to the first
+// matching recorded request that has not been replayed yet, or, if all
+// matching requests have been, of the last of them. It returns an error
+// if no recorded request matches.
+func (rec *Recording) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rec.record {
+		return rec.roundTripRecord(req, body)
+	}
+	return rec.replay(req, body)
+}
+
+func (rec *Recording) roundTripRecord(req *http.Request, body []byte) (*http.Response, error) {
+	rt := rec.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	outreq := new(http.Request)
+	*outreq = *req
+	outreq.Body = bodyReader(body)
+	res, err := rt.RoundTrip(outreq)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	recreq := new(http.Request)
+	*recreq = *req
+	recreq.Header = cloneHeader(req.Header)
+	recreq.Body = nil
+
+	rec.mu.Lock()
+	closed := rec.closed
+	if !closed {
+		rec.interactions = append(rec.interactions, &interaction{
+			req:     recreq,
+			reqBody: body,
+			res:     res,
+			resBody: resBody,
+		})
+	}
+	rec.mu.Unlock()
+	if closed {
+		return nil, errors.New("httptest: RoundTrip on closed Recording")
+	}
+	return replayedResponse(res, resBody, req), nil
+}
+
+func (rec *Recording) replay(req *http.Request, body []byte) (*http.Response, error) {
+	match := rec.Match
+	if match == nil {
+		match = defaultMatch
+	}
+	// Match against a copy of req, so that Match can read its body.
+	mreq := new(http.Request)
+	*mreq = *req
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var last *interaction
+	for _, in := range rec.interactions {
+		mreq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		in.req.Body = ioutil.NopCloser(bytes.NewReader(in.reqBody))
+		ok := match(mreq, in.req)
+		in.req.Body = nil
+		if !ok {
+			continue
+		}
+		if !in.used {
+			last = in
+			break
+		}
+		last = in
+	}
+	if last == nil {
+		return nil, fmt.Errorf("httptest: no recorded response for %s %s in %s", req.Method, req.URL, rec.file)
+	}
+	last.used = true
+	return replayedResponse(last.res, last.resBody, req), nil
+}
+
+// defaultMatch is the default value of Recording.Match.
+func defaultMatch(req, recorded *http.Request) bool {
+	if req.Method != recorded.Method || req.URL.String() != recorded.URL.String() {
+		return false
+	}
+	b1, _ := ioutil.ReadAll(req.Body)
+	b2, _ := ioutil.ReadAll(recorded.Body)
+	return bytes.Equal(b1, b2)
+}
+
+// replayedResponse returns a copy of res, with body as its body, as the
+// response to req.
+func replayedResponse(res *http.Response, body []byte, req *http.Request) *http.Response {
+	res2 := new(http.Response)
+	*res2 = *res
+	res2.Header = cloneHeader(res.Header)
+	if res.Trailer != nil {
+		res2.Trailer = cloneHeader(res.Trailer)
+	}
+	res2.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res2.Request = req
+	if req.Method != "HEAD" {
+		res2.ContentLength = int64(len(body))
+		res2.TransferEncoding = nil
+	}
+	return res2
+}
+
+// Handler returns a handler serving the requests it receives as
+// requests to target, a URL such as "https://api.example.com", through
+// rec: when recording, they are forwarded to target, and when
+// replaying, they are answered from the golden file. The handler
+// replies with a 502 Bad Gateway error to requests it cannot serve.
+func (rec *Recording) Handler(target string) http.Handler {
+	target = strings.TrimSuffix(target, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outreq, err := http.NewRequest(r.Method, target+r.URL.RequestURI(), r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		outreq = outreq.WithContext(r.Context())
+		outreq.ContentLength = r.ContentLength
+		outreq.Header = cloneHeader(r.Header)
+		removeHopHeaders(outreq.Header)
+		res, err := rec.RoundTrip(outreq)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		h := w.Header()
+		for k, vv := range res.Header {
+			h[k] = vv
+		}
+		removeHopHeaders(h)
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+	})
+}
+
+// hopHeaders are the hop-by-hop headers, which the handler returned by
+// Recording.Handler does not forward.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, k := range h["Connection"] {
+		for _, f := range strings.Split(k, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				h.Del(f)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+// Close writes the recorded interactions to the golden file if rec
+// is recording. It does nothing if rec is replaying.
+func (rec *Recording) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return nil
+	}
+	rec.closed = true
+	if !rec.record {
+		return nil
+	}
+	var buf bytes.Buffer
+	for i, in := range rec.interactions {
+		if i > 0 {
+			buf.WriteString("\r\n")
+		}
+		if err := rec.writeInteraction(&buf, in); err != nil {
+			return fmt.Errorf("httptest: writing %s: %v", rec.file, err)
+		}
+	}
+	return ioutil.WriteFile(rec.file, buf.Bytes(), 0666)
+}
+
+// writeInteraction writes in to w in the golden file format.
+func (rec *Recording) writeInteraction(w io.Writer, in *interaction) error {
+	req := new(http.Request)
+	*req = *in.req
+	req.Header = rec.redact(in.req.Header)
+	req.Trailer = nil
+	req.TransferEncoding = nil
+	req.Close = false
+	req.ContentLength = int64(len(in.reqBody))
+	req.Body = bodyReader(in.reqBody)
+	if err := req.WriteProxy(w); err != nil {
+		return err
+	}
+	if len(in.reqBody) > 0 {
+		// Start the response on a line of its own.
+		if _, err := io.WriteString(w, "\r\n"); err != nil {
+			return err
+		}
+	}
+
+	res := new(http.Response)
+	*res = *in.res
+	res.Header = rec.redact(in.res.Header)
+	res.Trailer = nil
+	res.TransferEncoding = nil
+	res.Close = false
+	res.Request = req
+	res.Body = nil
+	if req.Method != "HEAD" {
+		res.ContentLength = int64(len(in.resBody))
+		res.Body = bodyReader(in.resBody)
+	}
+	return res.Write(w)
+}
+
+// redact returns a copy of h with the values of rec.RedactHeaders
+// replaced by Redacted.
+func (rec *Recording) redact(h http.Header) http.Header {
+	h = cloneHeader(h)
+	for _, k := range rec.RedactHeaders {
+		k = http.CanonicalHeaderKey(k)
+		for i := range h[k] {
+			h[k][i] = Redacted
+		}
+	}
+	return h
+}
+
+// readInteractions parses a golden file.
+func readInteractions(br *bufio.Reader) ([]*interaction, error) {
+	var ins []*interaction
+	for {
+		if err := skipBlankLines(br); err == io.EOF {
+			return ins, nil
+		} else if err != nil {
+			return nil, err
+		}
+		in := new(interaction)
+		var err error
+		if in.req, err = http.ReadRequest(br); err != nil {
+			return nil, err
+		}
+		if in.reqBody, err = ioutil.ReadAll(in.req.Body); err != nil {
+			return nil, err
+		}
+		in.req.RequestURI = ""
+		if err := skipBlankLines(br); err != nil {
+			return nil, err
+		}
+		if in.res, err = http.ReadResponse(br, in.req); err != nil {
+			return nil, err
+		}
+		if in.resBody, err = ioutil.ReadAll(in.res.Body); err != nil {
+			return nil, err
+		}
+		in.res.Body = nil
+		ins = append(ins, in)
+	}
+}
+
+// skipBlankLines skips the blank lines separating messages in a
+// golden file. It returns io.EOF at the end of the file.
+func skipBlankLines(br *bufio.Reader) error {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] != '\r' && b[0] != '\n' {
+			return nil
+		}
+		br.ReadByte()
+	}
+}
+
+// bodyReader returns a body reading b, or nil if b is empty.
+func bodyReader(b []byte) io.ReadCloser {
+	if len(b) == 0 {
+		return nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(b))
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httptest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newCountingServer returns a server whose responses describe the
+// request and number it.
+func newCountingServer() *Server {
+	var n int32
+	return NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=s3cret")
+		fmt.Fprintf(w, "%d %s %s %s", atomic.AddInt32(&n, 1), r.Method, r.URL.RequestURI(), body)
+	}))
+}
+
+func tempGolden(t *testing.T) (name string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "httptest-recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "golden.txt"), func() { os.RemoveAll(dir) }
+}
+
+// do makes a request through c and returns its response body, or an
+// error message.
+func do(c *http.Client, method, url, body string) string {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer t0ken")
+	res, err := c.Do(req)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+	return res.Header.Get("Set-Cookie") + " " + string(b)
+}
+
+func TestRecording(t *testing.T) {
+	golden, cleanup := tempGolden(t)
+	defer cleanup()
+	ts := newCountingServer()
+
+	rec, err := NewRecording(golden, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Replaying() {
+		t.Fatal("Replaying with no golden file")
+	}
+	rec.RedactHeaders = []string{"authorization", "Set-Cookie"}
+	c := &http.Client{Transport: rec}
+	var recorded []string
+	for _, r := range []struct{ method, path, body string }{
+		{"GET", "/a", ""},
+		{"POST", "/b", "payload"},
+		{"GET", "/a", ""},
+	} {
+		recorded = append(recorded, do(c, r.method, ts.URL+r.path, r.body))
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	want := []string{
+		"session=s3cret 1 GET /a ",
+		"session=s3cret 2 POST /b payload",
+		"session=s3cret 3 GET /a ",
+	}
+	for i := range want {
+		if recorded[i] != want[i] {
+			t.Errorf("recording: response %d = %q; want %q", i, recorded[i], want[i])
+		}
+	}
+	file, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(file), "s3cret") || strings.Contains(string(file), "t0ken") {
+		t.Errorf("golden file contains secrets:\n%s", file)
+	}
+	if !strings.Contains(string(file), "Authorization: REDACTED") {
+		t.Errorf("golden file lacks redacted Authorization header:\n%s", file)
+	}
+
+	rec, err = NewRecording(golden, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Replaying() {
+		t.Fatal("not replaying with a golden file")
+	}
+	c = &http.Client{Transport: rec}
+	for i, tt := range []struct{ method, path, body, want string }{
+		{"POST", "/b", "payload", "REDACTED 2 POST /b payload"},
+		{"GET", "/a", "", "REDACTED 1 GET /a "},
+		{"GET", "/a", "", "REDACTED 3 GET /a "},
+		{"GET", "/a", "", "REDACTED 3 GET /a "}, // last match again
+		{"POST", "/b", "other", "error: "},
+		{"GET", "/c", "", "error: "},
+	} {
+		got := do(c, tt.method, ts.URL+tt.path, tt.body)
+		if strings.HasPrefix(got, "error: ") && tt.want == "error: " {
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("replay %d: %s %s = %q; want %q", i, tt.method, tt.path, got, tt.want)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if file2, _ := ioutil.ReadFile(golden); string(file2) != string(file) {
+		t.Error("replaying Recording rewrote the golden file")
+	}
+}
+
+func TestRecordingMatch(t *testing.T) {
+	golden, cleanup := tempGolden(t)
+	defer cleanup()
+	ts := newCountingServer()
+	defer ts.Close()
+
+	rec, _ := NewRecording(golden, true)
+	do(&http.Client{Transport: rec}, "GET", ts.URL+"/search?q=go&nonce=1", "")
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := NewRecording(golden, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Match = func(req, recorded *http.Request) bool {
+		return req.URL.Path == recorded.URL.Path &&
+			req.URL.Query().Get("q") == recorded.URL.Query().Get("q")
+	}
+	c := &http.Client{Transport: rec}
+	if got, want := do(c, "GET", ts.URL+"/search?q=go&nonce=2", ""), "session=s3cret 1 GET /search?q=go&nonce=1 "; got != want {
+		t.Errorf("matching request = %q; want %q", got, want)
+	}
+	if got := do(c, "GET", ts.URL+"/search?q=rust&nonce=2", ""); !strings.HasPrefix(got, "error: ") {
+		t.Errorf("non-matching request = %q; want error", got)
+	}
+}
+
+func TestRecordingHandler(t *testing.T) {
+	golden, cleanup := tempGolden(t)
+	defer cleanup()
+	upstream := newCountingServer()
+
+	rec, _ := NewRecording(golden, false)
+	ts := NewServer(rec.Handler(upstream.URL))
+	if got, want := do(ts.Client(), "PUT", ts.URL+"/x?y=z", "body"), "session=s3cret 1 PUT /x?y=z body"; got != want {
+		t.Errorf("recording through handler = %q; want %q", got, want)
+	}
+	ts.Close()
+	rec.Close()
+	upstream.Close()
+
+	rec, err := NewRecording(golden, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts = NewServer(rec.Handler(upstream.URL))
+	defer ts.Close()
+	if got, want := do(ts.Client(), "PUT", ts.URL+"/x?y=z", "body"), "session=s3cret 1 PUT /x?y=z body"; got != want {
+		t.Errorf("replaying through handler = %q; want %q", got, want)
+	}
+	res, err := ts.Client().Get(ts.URL + "/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadGateway {
+		t.Errorf("unmatched request status = %d; want 502", res.StatusCode)
+	}
+}
+
+func TestRecordingBadGoldenFile(t *testing.T) {
+	golden, cleanup := tempGolden(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(golden, []byte("not HTTP\r\n\r\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRecording(golden, false); err == nil {
+		t.Error("NewRecording succeeded with a malformed golden file")
+	}
+	// Re-recording does not read the file.
+	if _, err := NewRecording(golden, true); err != nil {
+		t.Errorf("NewRecording(record=true): %v", err)
+	}
+}
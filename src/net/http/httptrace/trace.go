@@ -161,6 +161,11 @@ type ClientTrace struct {
 	// request and any body. It may be called multiple times
 	// in the case of retried requests.
 	WroteRequest func(WroteRequestInfo)
+
+	// GotCachedResponse is called when a caching RoundTripper,
+	// such as http.CachingTransport, answers the request with a
+	// stored response instead of one read from the network.
+	GotCachedResponse func(CachedResponseInfo)
 }
 
 // WroteRequestInfo contains information provided to the WroteRequest
@@ -170,6 +175,22 @@ type WroteRequestInfo struct {
 	Err error
 }
 
+// CachedResponseInfo contains information provided to the
+// GotCachedResponse hook.
+type CachedResponseInfo struct {
+	// Revalidated is whether the stored response was confirmed
+	// by the server in reply to a conditional request.
+	Revalidated bool
+
+	// Stale is whether the stored response was past its
+	// freshness lifetime, as permitted by the request's
+	// max-stale directive.
+	Stale bool
+
+	// Age is the age of the stored response.
+	Age time.Duration
+}
+
 // compose modifies t such that it respects the previously-registered hooks in old,
 // subject to the composition policy requested in t.Compose.
 func (t *ClientTrace) compose(old *ClientTrace) {
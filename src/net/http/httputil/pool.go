@@ -0,0 +1,659 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Load-balancing reverse proxy.
+
+package httputil
+
+import (
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errNoBackend = errors.New("httputil: no backend available")
+
+// A Backend is one of the servers a PooledProxy forwards requests to.
+//
+// A Backend's fields must not be modified once the PooledProxy using it
+// has begun serving requests.
+type Backend struct {
+	// URL is the backend's scheme, host and base path, as for the
+	// target of NewSingleHostReverseProxy.
+	URL *url.URL
+
+	// Weight is the backend's share of traffic relative to the
+	// other backends. Zero and negative weights are treated as 1.
+	Weight int
+
+	active int64 // in-flight requests; accessed atomically
+
+	mu          sync.Mutex
+	requests    int64
+	failures    int64
+	consecFails int       // consecutive connection errors
+	ejectedTill time.Time // passive ejection deadline
+	checkDown   bool      // failing active health checks
+	checkStreak int       // consecutive check results contradicting checkDown
+	lastErr     error
+	lastChecked time.Time
+}
+
+// BackendStats is a snapshot of a Backend's state and counters.
+type BackendStats struct {
+	Healthy     bool      // whether the backend is eligible for new requests
+	Active      int64     // requests in flight
+	Requests    int64     // requests sent, including retries
+	Failures    int64     // requests and health checks that failed
+	LastError   error     // most recent failure, if any
+	LastChecked time.Time // time of the most recent active health check
+}
+
+// Stats returns a snapshot of b's state and counters.
+func (b *Backend) Stats() BackendStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BackendStats{
+		Healthy:     b.healthyLocked(time.Now()),
+		Active:      atomic.LoadInt64(&b.active),
+		Requests:    b.requests,
+		Failures:    b.failures,
+		LastError:   b.lastErr,
+		LastChecked: b.lastChecked,
+	}
+}
+
+// ActiveRequests returns the number of requests currently in flight
+// to b. It is intended for use by Balancer implementations.
+func (b *Backend) ActiveRequests() int64 {
+	return atomic.LoadInt64(&b.active)
+}
+
+func (b *Backend) weight() int {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
+}
+
+func (b *Backend) healthy(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.healthyLocked(now)
+}
+
+func (b *Backend) healthyLocked(now time.Time) bool {
+	return !b.checkDown && !now.Before(b.ejectedTill)
+}
+
+// noteResult records the outcome of a proxied request. A nil err
+// resets the backend's run of consecutive failures; a non-nil err
+// ejects the backend for failTimeout once maxFails is reached.
+func (b *Backend) noteResult(err error, maxFails int, failTimeout time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests++
+	if err == nil {
+		b.consecFails = 0
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	b.consecFails++
+	if maxFails > 0 && b.consecFails >= maxFails {
+		b.ejectedTill = time.Now().Add(failTimeout)
+		b.consecFails = 0
+	}
+}
+
+// noteCheck records the outcome of an active health check.
+func (b *Backend) noteCheck(err error, healthyThreshold, unhealthyThreshold int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastChecked = time.Now()
+	if err != nil {
+		b.failures++
+		b.lastErr = err
+	}
+	if (err != nil) == b.checkDown {
+		b.checkStreak = 0
+		return
+	}
+	b.checkStreak++
+	threshold := unhealthyThreshold
+	if b.checkDown {
+		threshold = healthyThreshold
+	}
+	if b.checkStreak >= threshold {
+		b.checkDown = !b.checkDown
+		b.checkStreak = 0
+		if !b.checkDown {
+			b.ejectedTill = time.Time{}
+		}
+	}
+}
+
+// A Balancer chooses the backend for each request sent through a
+// PooledProxy.
+//
+// Pick is called with the request and the backends currently eligible
+// for it, which is never empty; it must return one of them. On a
+// retry, req is the outgoing request and backends excludes those
+// already tried. Pick must be safe for concurrent use.
+type Balancer interface {
+	Pick(req *http.Request, backends []*Backend) *Backend
+}
+
+// RoundRobin returns a Balancer that cycles through the backends in
+// proportion to their weights.
+func RoundRobin() Balancer {
+	return &roundRobin{current: make(map[*Backend]int)}
+}
+
+// roundRobin implements smooth weighted round robin: each pick, every
+// candidate's current weight grows by its weight, and the largest is
+// chosen and reduced by the total.
+type roundRobin struct {
+	mu      sync.Mutex
+	current map[*Backend]int
+}
+
+func (rr *roundRobin) Pick(req *http.Request, backends []*Backend) *Backend {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	var best *Backend
+	total := 0
+	for _, b := range backends {
+		w := b.weight()
+		total += w
+		rr.current[b] += w
+		if best == nil || rr.current[b] > rr.current[best] {
+			best = b
+		}
+	}
+	rr.current[best] -= total
+	return best
+}
+
+// LeastConnections returns a Balancer that picks the backend with the
+// fewest requests in flight relative to its weight. Ties are broken
+// in rotation.
+func LeastConnections() Balancer {
+	return new(leastConns)
+}
+
+type leastConns struct {
+	next uint32 // accessed atomically
+}
+
+func (lc *leastConns) Pick(req *http.Request, backends []*Backend) *Backend {
+	n := len(backends)
+	start := int(atomic.AddUint32(&lc.next, 1) % uint32(n))
+	var best *Backend
+	var bestActive int64
+	for i := 0; i < n; i++ {
+		b := backends[(start+i)%n]
+		active := b.ActiveRequests()
+		// Compare active/weight without dividing.
+		if best == nil || active*int64(best.weight()) < bestActive*int64(b.weight()) {
+			best, bestActive = b, active
+		}
+	}
+	return best
+}
+
+// ConsistentHash returns a Balancer that maps each request to a
+// backend by hashing the string returned by key, so that requests
+// with the same key go to the same backend while it remains eligible.
+// When a backend is added or removed from the eligible set, only the
+// keys mapped to it move. If key is nil, the client's IP address, taken
+// from Request.RemoteAddr, is used.
+func ConsistentHash(key func(*http.Request) string) Balancer {
+	if key == nil {
+		key = clientIP
+	}
+	return &consistentHash{key: key}
+}
+
+func clientIP(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// hashReplicas is the number of points each unit of weight
+// contributes to a consistent hash ring.
+const hashReplicas = 100
+
+type hashPoint struct {
+	hash uint32
+	b    *Backend
+}
+
+// consistentHash keeps one ring for every backend it has been offered
+// and walks past ineligible ones, so that ejecting a backend moves only
+// its own keys and does not force a rebuild.
+type consistentHash struct {
+	key func(*http.Request) string
+
+	mu      sync.Mutex
+	members []*Backend  // backends on the ring
+	ring    []hashPoint // sorted by hash
+}
+
+func (ch *consistentHash) Pick(req *http.Request, backends []*Backend) *Backend {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	for _, b := range backends {
+		if !containsBackend(ch.members, b) {
+			ch.add(b)
+		}
+	}
+	h := crc32.ChecksumIEEE([]byte(ch.key(req)))
+	i := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i].hash >= h })
+	for n := 0; n < len(ch.ring); n++ {
+		b := ch.ring[(i+n)%len(ch.ring)].b
+		if containsBackend(backends, b) {
+			return b
+		}
+	}
+	return backends[0] // unreachable
+}
+
+func (ch *consistentHash) add(b *Backend) {
+	ch.members = append(ch.members, b)
+	name := b.URL.String() + "#"
+	for i := 0; i < hashReplicas*b.weight(); i++ {
+		h := crc32.ChecksumIEEE([]byte(name + strconv.Itoa(i)))
+		ch.ring = append(ch.ring, hashPoint{h, b})
+	}
+	sort.Slice(ch.ring, func(i, j int) bool { return ch.ring[i].hash < ch.ring[j].hash })
+}
+
+// HealthCheck configures the active health checks of a PooledProxy.
+//
+// A check is a GET request for Path relative to the backend's URL. It
+// passes if the backend responds with a 2xx or 3xx status within
+// Timeout.
+type HealthCheck struct {
+	// Path is the path checked on each backend, such as "/healthz".
+	// If empty, the backend's URL itself is checked.
+	Path string
+
+	// Interval is the time between checks.
+	// If zero, checks run every 10 seconds.
+	Interval time.Duration
+
+	// Timeout bounds each check.
+	// If zero, Interval is used.
+	Timeout time.Duration
+
+	// HealthyThreshold and UnhealthyThreshold are the number of
+	// consecutive passing or failing checks needed to mark a
+	// backend healthy or unhealthy. If zero, 1 is used.
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// PooledProxy is an HTTP Handler that forwards each request to one of
+// several backends, using ReverseProxy.
+//
+// The embedded ReverseProxy configures how requests are forwarded. Its
+// Director or Rewrite function, if set, is called after the outgoing
+// request's URL has been pointed at the chosen backend. Its Transport, if non-nil, is
+// used for both proxied requests and health checks.
+//
+// A backend is ejected, and receives no new requests, while it fails
+// active health checks or for FailTimeout after MaxFails consecutive
+// connection errors. If every backend has been ejected, the proxy
+// ignores health rather than refuse all requests.
+//
+// Idempotent requests without a body that fail with a connection
+// error are retried on another backend. Only the scheme, host and base
+// path of the request's URL change on a retry.
+//
+// A PooledProxy's fields must not be modified once it has begun
+// serving requests. Close stops its health checks.
+type PooledProxy struct {
+	ReverseProxy
+
+	// Backends are the servers requests are forwarded to.
+	Backends []*Backend
+
+	// Balancer chooses the backend for each request.
+	// If nil, RoundRobin is used.
+	Balancer Balancer
+
+	// HealthCheck, if non-nil, enables active health checks. They
+	// begin when the proxy serves its first request.
+	HealthCheck *HealthCheck
+
+	// MaxFails is the number of consecutive connection errors after
+	// which a backend is ejected. If zero, 3 is used; if negative,
+	// backends are never ejected for connection errors.
+	MaxFails int
+
+	// FailTimeout is how long a backend stays ejected after
+	// MaxFails connection errors. If zero, 10 seconds is used.
+	FailTimeout time.Duration
+
+	// MaxRetries is the number of other backends an idempotent
+	// request is tried on after a connection error. If zero, 2 is
+	// used; if negative, requests are not retried.
+	MaxRetries int
+
+	startOnce sync.Once
+	balancer  Balancer
+	cancel    context.CancelFunc // stops health checks
+	checksWG  sync.WaitGroup
+	closeMu   sync.Mutex
+	closed    bool
+}
+
+// NewPooledProxy returns a PooledProxy that balances requests across
+// targets in round robin. As with NewSingleHostReverseProxy, a target's
+// path is prepended to the path of each request forwarded to it, and
+// the Host header is not rewritten.
+func NewPooledProxy(targets ...*url.URL) *PooledProxy {
+	p := new(PooledProxy)
+	for _, u := range targets {
+		p.Backends = append(p.Backends, &Backend{URL: u})
+	}
+	return p
+}
+
+func (p *PooledProxy) maxFails() int {
+	if p.MaxFails == 0 {
+		return 3
+	}
+	return p.MaxFails
+}
+
+func (p *PooledProxy) failTimeout() time.Duration {
+	if p.FailTimeout == 0 {
+		return 10 * time.Second
+	}
+	return p.FailTimeout
+}
+
+func (p *PooledProxy) maxRetries() int {
+	if p.MaxRetries == 0 {
+		return 2
+	}
+	return p.MaxRetries
+}
+
+func (p *PooledProxy) transport() http.RoundTripper {
+	if p.Transport != nil {
+		return p.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (p *PooledProxy) start() {
+	p.balancer = p.Balancer
+	if p.balancer == nil {
+		p.balancer = RoundRobin()
+	}
+	hc := p.HealthCheck
+	if hc == nil {
+		return
+	}
+	p.closeMu.Lock()
+	defer p.closeMu.Unlock()
+	if p.closed {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.checksWG.Add(1)
+	go p.runHealthChecks(ctx, *hc)
+}
+
+// Close stops the proxy's active health checks, waiting for any in
+// progress to finish. It does not affect requests being served.
+func (p *PooledProxy) Close() error {
+	p.closeMu.Lock()
+	p.closed = true
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.closeMu.Unlock()
+	p.checksWG.Wait()
+	return nil
+}
+
+func (p *PooledProxy) runHealthChecks(ctx context.Context, hc HealthCheck) {
+	defer p.checksWG.Done()
+	if hc.Interval <= 0 {
+		hc.Interval = 10 * time.Second
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = hc.Interval
+	}
+	if hc.HealthyThreshold <= 0 {
+		hc.HealthyThreshold = 1
+	}
+	if hc.UnhealthyThreshold <= 0 {
+		hc.UnhealthyThreshold = 1
+	}
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, b := range p.Backends {
+			wg.Add(1)
+			go func(b *Backend) {
+				defer wg.Done()
+				err := p.check(ctx, &hc, b)
+				if ctx.Err() != nil {
+					return
+				}
+				b.noteCheck(err, hc.HealthyThreshold, hc.UnhealthyThreshold)
+			}(b)
+		}
+		wg.Wait()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *PooledProxy) check(ctx context.Context, hc *HealthCheck, b *Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	defer cancel()
+	u := *b.URL
+	if hc.Path != "" {
+		u = url.URL{Path: hc.Path}
+		rewriteURL(&u, b.URL)
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.transport().RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4<<10))
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return errors.New("httputil: health check got status " + res.Status)
+	}
+	return nil
+}
+
+// pick chooses a backend for req, excluding those in tried. If no
+// untried backend is healthy and none have been tried, health is
+// ignored.
+func (p *PooledProxy) pick(req *http.Request, tried []*Backend) *Backend {
+	now := time.Now()
+	var eligible, untried []*Backend
+	for _, b := range p.Backends {
+		if containsBackend(tried, b) {
+			continue
+		}
+		untried = append(untried, b)
+		if b.healthy(now) {
+			eligible = append(eligible, b)
+		}
+	}
+	if len(eligible) == 0 {
+		if len(tried) > 0 || len(untried) == 0 {
+			return nil
+		}
+		eligible = untried
+	}
+	return p.balancer.Pick(req, eligible)
+}
+
+func containsBackend(s []*Backend, b *Backend) bool {
+	for _, x := range s {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PooledProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	p.startOnce.Do(p.start)
+	b := p.pick(req, nil)
+	if b == nil {
+		p.getErrorHandler()(rw, req, errNoBackend)
+		return
+	}
+	rp := p.ReverseProxy
+	if rewrite := p.Rewrite; rewrite != nil {
+		rp.Rewrite = func(pr *ProxyRequest) {
+			rewriteURL(pr.Out.URL, b.URL)
+			rewrite(pr)
+		}
+	} else {
+		director := p.Director
+		rp.Director = func(out *http.Request) {
+			rewriteURL(out.URL, b.URL)
+			if _, ok := out.Header["User-Agent"]; !ok {
+				// explicitly disable User-Agent so it's not set to default value
+				out.Header.Set("User-Agent", "")
+			}
+			if director != nil {
+				director(out)
+			}
+		}
+	}
+	rp.Transport = &poolTransport{p: p, backend: b}
+	rp.ServeHTTP(rw, req)
+}
+
+// poolTransport forwards one proxied request, tracking the backends
+// it is sent to and retrying it on connection errors.
+type poolTransport struct {
+	p       *PooledProxy
+	backend *Backend // first backend chosen
+}
+
+func (t *poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.p
+	b := t.backend
+	var tried []*Backend
+	for {
+		atomic.AddInt64(&b.active, 1)
+		res, err := p.transport().RoundTrip(req)
+		if err == nil {
+			b.noteResult(nil, 0, 0)
+			if res.StatusCode == http.StatusSwitchingProtocols {
+				// The body must remain an io.ReadWriteCloser for
+				// the upgrade; stop counting the request here.
+				atomic.AddInt64(&b.active, -1)
+			} else {
+				res.Body = &poolBody{ReadCloser: res.Body, b: b}
+			}
+			return res, nil
+		}
+		atomic.AddInt64(&b.active, -1)
+		if req.Context().Err() != nil {
+			// The client went away; not the backend's fault.
+			return nil, err
+		}
+		b.noteResult(err, p.maxFails(), p.failTimeout())
+		tried = append(tried, b)
+		if len(tried) > p.maxRetries() || !canRetry(req) {
+			return nil, err
+		}
+		next := p.pick(req, tried)
+		if next == nil {
+			return nil, err
+		}
+		r2 := new(http.Request)
+		*r2 = *req
+		u := *req.URL
+		retargetURL(&u, b.URL, next.URL)
+		r2.URL = &u
+		req, b = r2, next
+	}
+}
+
+// retargetURL points u, the URL of a request for the backend at from,
+// at the backend at to instead. The path and query that the Director
+// or Rewrite left are kept; only from's base path and query are
+// replaced with to's.
+func retargetURL(u, from, to *url.URL) {
+	if base := strings.TrimSuffix(from.Path, "/"); base != "" && strings.HasPrefix(u.Path, base) &&
+		(len(u.Path) == len(base) || u.Path[len(base)] == '/') {
+		u.Path = u.Path[len(base):]
+		rawBase := strings.TrimSuffix(from.EscapedPath(), "/")
+		if u.RawPath != "" && strings.HasPrefix(u.RawPath, rawBase) {
+			u.RawPath = u.RawPath[len(rawBase):]
+		} else {
+			u.RawPath = ""
+		}
+	}
+	if q := from.RawQuery; q != "" {
+		if u.RawQuery == q {
+			u.RawQuery = ""
+		} else if strings.HasPrefix(u.RawQuery, q+"&") {
+			u.RawQuery = u.RawQuery[len(q)+1:]
+		}
+	}
+	rewriteURL(u, to)
+}
+
+// canRetry reports whether req may be sent again after a connection
+// error: its method must be idempotent and it must have no body.
+func canRetry(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// poolBody counts a request as active until its response body is
+// closed.
+type poolBody struct {
+	io.ReadCloser
+	b    *Backend
+	once sync.Once
+}
+
+func (pb *poolBody) Close() error {
+	pb.once.Do(func() { atomic.AddInt64(&pb.b.active, -1) })
+	return pb.ReadCloser.Close()
+}
@@ -0,0 +1,344 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newNamedBackend starts a server that responds with its name.
+func newNamedBackend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	}))
+}
+
+// newDeadBackend starts a listener that hangs up on every connection.
+func newDeadBackend(t *testing.T) (u *url.URL, close func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return &url.URL{Scheme: "http", Host: ln.Addr().String()}, func() { ln.Close() }
+}
+
+func getBody(t *testing.T, c *http.Client, method, url string) (int, string) {
+	req, _ := http.NewRequest(method, url, nil)
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	slurp, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(slurp)
+}
+
+func TestPooledProxyRoundRobin(t *testing.T) {
+	var urls []*url.URL
+	for _, name := range []string{"a", "b", "c"} {
+		s := newNamedBackend(name)
+		defer s.Close()
+		urls = append(urls, mustParseURL(s.URL))
+	}
+	p := NewPooledProxy(urls...)
+	p.Backends[2].Weight = 2
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	var got []string
+	for i := 0; i < 8; i++ {
+		_, body := getBody(t, front.Client(), "GET", front.URL)
+		got = append(got, body)
+	}
+	if g, w := strings.Join(got, ""), "cabccabc"; g != w {
+		t.Errorf("backends = %q; want %q", g, w)
+	}
+	for i, want := range []int64{2, 2, 4} {
+		st := p.Backends[i].Stats()
+		if st.Requests != want || st.Failures != 0 || !st.Healthy {
+			t.Errorf("backend %d stats = %+v; want %d requests, healthy", i, st, want)
+		}
+	}
+}
+
+func TestPooledProxyBasePath(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.RequestURI())
+	}))
+	defer backend.Close()
+	p := NewPooledProxy(mustParseURL(backend.URL + "/base?x=1"))
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	_, body := getBody(t, front.Client(), "GET", front.URL+"/dir?y=2")
+	if want := "/base/dir?x=1&y=2"; body != want {
+		t.Errorf("backend got %q; want %q", body, want)
+	}
+}
+
+func TestLeastConnectionsBalancer(t *testing.T) {
+	bs := []*Backend{
+		{URL: &url.URL{Host: "a"}, active: 3},
+		{URL: &url.URL{Host: "b"}, active: 1},
+		{URL: &url.URL{Host: "c"}, active: 4, Weight: 2},
+	}
+	lc := LeastConnections()
+	if got := lc.Pick(nil, bs); got != bs[1] {
+		t.Errorf("Pick = %s; want b", got.URL.Host)
+	}
+	bs[1].active = 5
+	if got := lc.Pick(nil, bs); got != bs[2] {
+		t.Errorf("Pick = %s; want c (4 active at weight 2)", got.URL.Host)
+	}
+	// Ties rotate.
+	for _, b := range bs {
+		b.active, b.Weight = 0, 0
+	}
+	seen := make(map[*Backend]bool)
+	for i := 0; i < len(bs); i++ {
+		seen[lc.Pick(nil, bs)] = true
+	}
+	if len(seen) != len(bs) {
+		t.Errorf("tied backends picked %d distinct times in %d picks; want %d", len(seen), len(bs), len(bs))
+	}
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	var bs []*Backend
+	for i := 0; i < 4; i++ {
+		bs = append(bs, &Backend{URL: &url.URL{Scheme: "http", Host: fmt.Sprintf("backend%d", i)}})
+	}
+	ch := ConsistentHash(func(r *http.Request) string { return r.Header.Get("Key") })
+	req := func(key string) *http.Request {
+		return &http.Request{Header: http.Header{"Key": {key}}}
+	}
+	assigned := make(map[string]*Backend)
+	counts := make(map[*Backend]int)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key", i)
+		b := ch.Pick(req(key), bs)
+		if again := ch.Pick(req(key), bs); again != b {
+			t.Fatalf("key %q mapped to %s, then %s", key, b.URL.Host, again.URL.Host)
+		}
+		assigned[key] = b
+		counts[b]++
+	}
+	for _, b := range bs {
+		if counts[b] < 100 {
+			t.Errorf("%s got %d of 1000 keys; want a rough quarter", b.URL.Host, counts[b])
+		}
+	}
+
+	// Dropping a backend moves only its keys.
+	eligible := []*Backend{bs[0], bs[2], bs[3]}
+	for key, was := range assigned {
+		now := ch.Pick(req(key), eligible)
+		if was != bs[1] && now != was {
+			t.Errorf("key %q moved from %s to %s", key, was.URL.Host, now.URL.Host)
+		}
+		if now == bs[1] {
+			t.Errorf("key %q picked ineligible backend", key)
+		}
+	}
+
+	// The default key is the client IP.
+	ch = ConsistentHash(nil)
+	r1 := &http.Request{RemoteAddr: "192.0.2.1:1234"}
+	r2 := &http.Request{RemoteAddr: "192.0.2.1:5678"}
+	if ch.Pick(r1, bs) != ch.Pick(r2, bs) {
+		t.Error("same client IP on different ports picked different backends")
+	}
+}
+
+func TestPooledProxyRetry(t *testing.T) {
+	good := newNamedBackend("good")
+	defer good.Close()
+	deadURL, closeDead := newDeadBackend(t)
+	defer closeDead()
+	p := NewPooledProxy(deadURL, mustParseURL(good.URL))
+	p.ErrorLog = log.New(ioutil.Discard, "", 0)
+	p.MaxFails = 2
+	p.FailTimeout = time.Hour
+	front := httptest.NewServer(p)
+	defer front.Close()
+	dead := p.Backends[0]
+
+	// Round robin tries the dead backend first; the GET is retried.
+	code, body := getBody(t, front.Client(), "GET", front.URL)
+	if code != 200 || body != "good" {
+		t.Fatalf("GET = %d %q; want 200 \"good\"", code, body)
+	}
+	if st := dead.Stats(); st.Failures != 1 || st.LastError == nil || !st.Healthy {
+		t.Errorf("dead backend after one failure: %+v", st)
+	}
+
+	// A POST with a body is not retried.
+	getBody(t, front.Client(), "GET", front.URL) // round robin lands on good
+	res, err := front.Client().Post(front.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadGateway {
+		t.Errorf("POST status = %d; want %d", res.StatusCode, http.StatusBadGateway)
+	}
+
+	// Two consecutive failures eject the dead backend.
+	if dead.Stats().Healthy {
+		t.Fatal("dead backend still healthy after MaxFails connection errors")
+	}
+	before := dead.Stats().Requests
+	for i := 0; i < 4; i++ {
+		if code, body := getBody(t, front.Client(), "GET", front.URL); code != 200 || body != "good" {
+			t.Fatalf("GET = %d %q; want 200 \"good\"", code, body)
+		}
+	}
+	if after := dead.Stats().Requests; after != before {
+		t.Errorf("ejected backend got %d requests", after-before)
+	}
+}
+
+func TestPooledProxyRetryKeepsRewrittenURL(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.RequestURI())
+	}))
+	defer good.Close()
+	deadURL, closeDead := newDeadBackend(t)
+	defer closeDead()
+	deadURL.Path = "/old"
+	deadURL.RawQuery = "b=dead"
+	goodURL := mustParseURL(good.URL + "/v1?b=good")
+	p := NewPooledProxy(deadURL, goodURL)
+	p.ErrorLog = log.New(ioutil.Discard, "", 0)
+	p.Director = func(out *http.Request) {
+		out.URL.Path = strings.Replace(out.URL.Path, "/api/", "/", 1)
+		q := out.URL.Query()
+		q.Set("d", "1")
+		out.URL.RawQuery = q.Encode()
+	}
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	// Round robin tries the dead backend first; the retry must keep
+	// the Director's changes.
+	code, body := getBody(t, front.Client(), "GET", front.URL+"/api/x")
+	if want := "/v1/x?b=good&d=1"; code != 200 || body != want {
+		t.Errorf("GET = %d %q; want 200 %q", code, body, want)
+	}
+}
+
+func TestPooledProxyAllEjected(t *testing.T) {
+	good := newNamedBackend("good")
+	defer good.Close()
+	p := NewPooledProxy(mustParseURL(good.URL))
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	// An ejected backend is still used if it is the only one left.
+	p.Backends[0].noteResult(fmt.Errorf("boom"), 1, time.Hour)
+	if p.Backends[0].Stats().Healthy {
+		t.Fatal("backend not ejected")
+	}
+	if code, body := getBody(t, front.Client(), "GET", front.URL); code != 200 || body != "good" {
+		t.Errorf("GET = %d %q; want 200 \"good\"", code, body)
+	}
+
+	p2 := &PooledProxy{}
+	p2.ErrorLog = log.New(ioutil.Discard, "", 0)
+	rec := httptest.NewRecorder()
+	p2.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("empty pool status = %d; want %d", rec.Code, http.StatusBadGateway)
+	}
+}
+
+func TestPooledProxyHealthCheck(t *testing.T) {
+	var sick int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/base/healthz" {
+			if atomic.LoadInt32(&sick) != 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		fmt.Fprint(w, "flaky")
+	}))
+	defer flaky.Close()
+	good := newNamedBackend("good")
+	defer good.Close()
+
+	p := NewPooledProxy(mustParseURL(flaky.URL+"/base"), mustParseURL(good.URL))
+	p.HealthCheck = &HealthCheck{
+		Path:     "/healthz",
+		Interval: 5 * time.Millisecond,
+	}
+	defer p.Close()
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	waitHealthy := func(b *Backend, want bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for b.Stats().Healthy != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("backend %s Healthy never became %v", b.URL, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	getBody(t, front.Client(), "GET", front.URL) // starts the checks
+	atomic.StoreInt32(&sick, 1)
+	waitHealthy(p.Backends[0], false)
+	st := p.Backends[0].Stats()
+	if st.LastChecked.IsZero() || st.LastError == nil {
+		t.Errorf("unhealthy backend stats = %+v; want LastChecked and LastError set", st)
+	}
+	for i := 0; i < 4; i++ {
+		if _, body := getBody(t, front.Client(), "GET", front.URL); body != "good" {
+			t.Errorf("request %d went to %q while it was unhealthy", i, body)
+		}
+	}
+
+	atomic.StoreInt32(&sick, 0)
+	waitHealthy(p.Backends[0], true)
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		_, body := getBody(t, front.Client(), "GET", front.URL)
+		seen[body] = true
+	}
+	if !seen["flaky"] {
+		t.Error("recovered backend received no requests")
+	}
+
+	p.Close()
+	checked := p.Backends[0].Stats().LastChecked
+	time.Sleep(20 * time.Millisecond)
+	if p.Backends[0].Stats().LastChecked != checked {
+		t.Error("health checks continued after Close")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -1163,3 +1164,160 @@ func TestSingleJoinSlash(t *testing.T) {
 		}
 	}
 }
+
+func TestJoinURLPath(t *testing.T) {
+	tests := []struct {
+		a        *url.URL
+		b        *url.URL
+		wantPath string
+		wantRaw  string
+	}{
+		{&url.URL{Path: "/a/b"}, &url.URL{Path: "/c"}, "/a/b/c", ""},
+		{&url.URL{Path: "/a/b", RawPath: "badpath"}, &url.URL{Path: "c"}, "/a/b/c", "/a/b/c"},
+		{&url.URL{Path: "/a/b", RawPath: "/a%2Fb"}, &url.URL{Path: "/c"}, "/a/b/c", "/a%2Fb/c"},
+		{&url.URL{Path: "/a/b/", RawPath: "/a%2Fb%2F"}, &url.URL{Path: "c"}, "/a/b//c", "/a%2Fb%2F/c"},
+		{&url.URL{Path: "/a/b/", RawPath: "/a%2Fb/"}, &url.URL{Path: "/c/d", RawPath: "/c%2Fd"}, "/a/b/c/d", "/a%2Fb/c%2Fd"},
+	}
+	for _, tt := range tests {
+		p, rp := joinURLPath(tt.a, tt.b)
+		if p != tt.wantPath || rp != tt.wantRaw {
+			t.Errorf("joinURLPath(URL(%q,%q),URL(%q,%q)) want (%q,%q) got (%q,%q)",
+				tt.a.Path, tt.a.RawPath,
+				tt.b.Path, tt.b.RawPath,
+				tt.wantPath, tt.wantRaw,
+				p, rp)
+		}
+	}
+}
+
+func TestReverseProxyRewrite(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host, "|", r.URL.EscapedPath(), "|",
+			r.Header.Get("X-Forwarded-For"), "|",
+			r.Header.Get("X-Forwarded-Host"), "|",
+			r.Header.Get("X-Forwarded-Proto"), "|",
+			r.Header.Get("Forwarded"), "|",
+			r.Header.Get("X-Header-From-Rewrite"), "|",
+			r.Header.Get("X-Header"))
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL + "/base%2Fpath")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxyHandler := &ReverseProxy{
+		Rewrite: func(r *ProxyRequest) {
+			r.SetURL(backendURL)
+			r.SetXForwarded()
+			r.SetForwarded()
+			r.Out.Header.Set("X-Header-From-Rewrite", "yes")
+			// X-Header is named in the inbound Connection header,
+			// which Rewrite runs after.
+			r.Out.Header.Set("X-Header", "from rewrite")
+		},
+	}
+	frontend := httptest.NewServer(proxyHandler)
+	defer frontend.Close()
+
+	getReq, _ := http.NewRequest("GET", frontend.URL+"/a%2Fb", nil)
+	getReq.Host = "some-name"
+	getReq.Header.Set("Connection", "X-Header")
+	getReq.Header.Set("X-Header", "from client")
+	getReq.Header.Set("X-Forwarded-For", "1.2.3.4")
+	getReq.Header.Set("X-Forwarded-Host", "spoofed")
+	getReq.Header.Set("X-Forwarded-Proto", "https")
+	getReq.Header.Set("Forwarded", "for=1.2.3.4")
+	res, err := frontend.Client().Do(getReq)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	got := strings.Split(string(body), "|")
+	want := []string{
+		backendURL.Host,
+		"/base%2Fpath/a%2Fb",
+		"127.0.0.1",
+		"some-name",
+		"http",
+		"for=127.0.0.1;host=some-name;proto=http",
+		"yes",
+		"from rewrite",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("backend saw:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestReverseProxyRewriteAndDirector(t *testing.T) {
+	proxyHandler := &ReverseProxy{
+		Director: func(*http.Request) {},
+		Rewrite:  func(*ProxyRequest) {},
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}
+	rec := httptest.NewRecorder()
+	proxyHandler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d; want %d", rec.Code, http.StatusBadGateway)
+	}
+}
+
+func TestProxyRequestSetXForwarded(t *testing.T) {
+	in := httptest.NewRequest("GET", "https://example.com/", nil)
+	in.RemoteAddr = "[2001:db8::1]:1234"
+	in.Header.Set("X-Forwarded-For", "192.0.2.1")
+	out := in.WithContext(in.Context())
+	out.Header = http.Header{"X-Forwarded-For": in.Header["X-Forwarded-For"]}
+	pr := &ProxyRequest{In: in, Out: out}
+	pr.SetXForwarded()
+	for k, want := range map[string]string{
+		"X-Forwarded-For":   "192.0.2.1, 2001:db8::1",
+		"X-Forwarded-Host":  "example.com",
+		"X-Forwarded-Proto": "https",
+	} {
+		if got := out.Header.Get(k); got != want {
+			t.Errorf("%s = %q; want %q", k, got, want)
+		}
+	}
+
+	in.RemoteAddr = "not an address"
+	pr.SetXForwarded()
+	if _, ok := out.Header["X-Forwarded-For"]; ok {
+		t.Errorf("X-Forwarded-For = %q for unparsable RemoteAddr; want none", out.Header["X-Forwarded-For"])
+	}
+}
+
+func TestProxyRequestSetForwarded(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		host       string
+		tls        bool
+		prior      []string
+		want       string
+	}{
+		{"192.0.2.1:80", "example.com", false, nil, "for=192.0.2.1;host=example.com;proto=http"},
+		{"[2001:db8::1]:80", "example.com:8443", true, nil, `for="[2001:db8::1]";host="example.com:8443";proto=https`},
+		{"garbage", "", false, nil, "for=unknown;proto=http"},
+		{"192.0.2.1:80", `ex"ample`, false, []string{"for=198.51.100.7", "for=203.0.113.9"},
+			`for=198.51.100.7, for=203.0.113.9, for=192.0.2.1;host="ex\"ample";proto=http`},
+	}
+	for _, tt := range tests {
+		in := httptest.NewRequest("GET", "/", nil)
+		in.RemoteAddr = tt.remoteAddr
+		in.Host = tt.host
+		if !tt.tls {
+			in.TLS = nil
+		} else {
+			in.TLS = new(tls.ConnectionState)
+		}
+		out := &http.Request{Header: http.Header{}}
+		if tt.prior != nil {
+			out.Header["Forwarded"] = tt.prior
+		}
+		(&ProxyRequest{In: in, Out: out}).SetForwarded()
+		if got := out.Header.Get("Forwarded"); got != tt.want {
+			t.Errorf("Forwarded for %q, %q = %q; want %q", tt.remoteAddr, tt.host, got, tt.want)
+		}
+	}
+}
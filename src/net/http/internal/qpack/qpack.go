@@ -0,0 +1,213 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package qpack implements the QPACK field compression format for
+// HTTP/3, as specified in RFC 9204.
+//
+// Only the static table is used: the encoder never inserts into the
+// dynamic table, and the decoder advertises a dynamic table capacity
+// of zero, so neither endpoint needs the encoder or decoder streams.
+package qpack
+
+import (
+	"errors"
+
+	"internal/x/net/http2/hpack"
+)
+
+// A HeaderField is a name-value pair. Names are lower case.
+type HeaderField struct {
+	Name, Value string
+}
+
+// Size returns the size of the field as defined by RFC 9204,
+// Section 3.2.1, used to limit header list sizes.
+func (f HeaderField) Size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+var (
+	errTruncated = errors.New("qpack: truncated field section")
+	errDynamic   = errors.New("qpack: reference to dynamic table")
+	errIndex     = errors.New("qpack: invalid static table index")
+	errInteger   = errors.New("qpack: integer overflow")
+)
+
+// AppendFieldSection appends the encoded field section for fields to b.
+func AppendFieldSection(b []byte, fields []HeaderField) []byte {
+	// Required Insert Count and Delta Base are both zero.
+	// See RFC 9204, Section 4.5.1.
+	b = append(b, 0, 0)
+	for _, f := range fields {
+		if i, ok := staticByField[f]; ok {
+			// Indexed Field Line referring to the static table.
+			// See RFC 9204, Section 4.5.2.
+			b = appendInt(b, 0xc0, 6, uint64(i))
+			continue
+		}
+		if i, ok := staticByName[f.Name]; ok {
+			// Literal Field Line with Name Reference to the static
+			// table. See RFC 9204, Section 4.5.4.
+			b = appendInt(b, 0x50, 4, uint64(i))
+			b = appendString(b, 0, 7, f.Value)
+			continue
+		}
+		// Literal Field Line with Literal Name.
+		// See RFC 9204, Section 4.5.6.
+		b = appendString(b, 0x20, 3, f.Name)
+		b = appendString(b, 0, 7, f.Value)
+	}
+	return b
+}
+
+// DecodeFieldSection decodes the encoded field section in b,
+// calling f for each field.
+func DecodeFieldSection(b []byte, f func(HeaderField) error) error {
+	ric, n, err := readInt(b, 8)
+	if err != nil {
+		return err
+	}
+	b = b[n:]
+	if ric != 0 {
+		return errDynamic
+	}
+	// With a Required Insert Count of zero, the Base is unused.
+	_, n, err = readInt(b, 7)
+	if err != nil {
+		return err
+	}
+	b = b[n:]
+	for len(b) > 0 {
+		var hf HeaderField
+		switch c := b[0]; {
+		case c&0x80 != 0:
+			// Indexed Field Line.
+			if c&0x40 == 0 {
+				return errDynamic
+			}
+			i, n, err := readInt(b, 6)
+			if err != nil {
+				return err
+			}
+			b = b[n:]
+			if i >= uint64(len(staticTable)) {
+				return errIndex
+			}
+			hf = staticTable[i]
+		case c&0x40 != 0:
+			// Literal Field Line with Name Reference.
+			if c&0x10 == 0 {
+				return errDynamic
+			}
+			i, n, err := readInt(b, 4)
+			if err != nil {
+				return err
+			}
+			b = b[n:]
+			if i >= uint64(len(staticTable)) {
+				return errIndex
+			}
+			hf.Name = staticTable[i].Name
+			if hf.Value, n, err = readString(b, 7); err != nil {
+				return err
+			}
+			b = b[n:]
+		case c&0x20 != 0:
+			// Literal Field Line with Literal Name.
+			var n int
+			if hf.Name, n, err = readString(b, 3); err != nil {
+				return err
+			}
+			b = b[n:]
+			if hf.Value, n, err = readString(b, 7); err != nil {
+				return err
+			}
+			b = b[n:]
+		default:
+			// Post-base references are always to the dynamic table.
+			return errDynamic
+		}
+		if err := f(hf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendInt appends v encoded as an integer with an n-bit prefix,
+// with the bits of first above the prefix. See RFC 7541, Section 5.1.
+func appendInt(b []byte, first byte, n uint, v uint64) []byte {
+	max := uint64(1)<<n - 1
+	if v < max {
+		return append(b, first|byte(v))
+	}
+	b = append(b, first|byte(max))
+	v -= max
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// readInt reads an integer with an n-bit prefix from the start of b.
+func readInt(b []byte, n uint) (v uint64, size int, err error) {
+	if len(b) == 0 {
+		return 0, 0, errTruncated
+	}
+	max := uint64(1)<<n - 1
+	v = uint64(b[0]) & max
+	if v < max {
+		return v, 1, nil
+	}
+	var shift uint
+	for i := 1; i < len(b); i++ {
+		c := b[i]
+		if shift > 56 {
+			return 0, 0, errInteger
+		}
+		v += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, i + 1, nil
+		}
+		shift += 7
+	}
+	return 0, 0, errTruncated
+}
+
+// appendString appends s as a string literal whose length has an
+// n-bit prefix, Huffman-encoding it if that is shorter. The Huffman
+// flag is the bit above the prefix. See RFC 9204, Section 4.1.2.
+func appendString(b []byte, first byte, n uint, s string) []byte {
+	if l := hpack.HuffmanEncodeLength(s); l < uint64(len(s)) {
+		b = appendInt(b, first|1<<n, n, l)
+		return hpack.AppendHuffmanString(b, s)
+	}
+	b = appendInt(b, first, n, uint64(len(s)))
+	return append(b, s...)
+}
+
+// readString reads a string literal whose length has an n-bit prefix.
+func readString(b []byte, n uint) (s string, size int, err error) {
+	if len(b) == 0 {
+		return "", 0, errTruncated
+	}
+	huffman := b[0]&(1<<n) != 0
+	l, m, err := readInt(b, n)
+	if err != nil {
+		return "", 0, err
+	}
+	if l > uint64(len(b)-m) {
+		return "", 0, errTruncated
+	}
+	end := m + int(l)
+	if !huffman {
+		return string(b[m:end]), end, nil
+	}
+	s, err = hpack.HuffmanDecodeToString(b[m:end])
+	if err != nil {
+		return "", 0, err
+	}
+	return s, end, nil
+}
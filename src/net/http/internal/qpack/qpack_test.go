@@ -0,0 +1,89 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qpack
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeAll(b []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	err := DecodeFieldSection(b, func(f HeaderField) error {
+		fields = append(fields, f)
+		return nil
+	})
+	return fields, err
+}
+
+func TestStaticTable(t *testing.T) {
+	if len(staticTable) != 99 {
+		t.Fatalf("static table has %v entries; want 99", len(staticTable))
+	}
+	if f := staticTable[17]; f != (HeaderField{":method", "GET"}) {
+		t.Errorf("static entry 17 = %v; want :method GET", f)
+	}
+	if f := staticTable[98]; f != (HeaderField{"x-frame-options", "sameorigin"}) {
+		t.Errorf("static entry 98 = %v; want x-frame-options sameorigin", f)
+	}
+}
+
+func TestDecodeRFCExample(t *testing.T) {
+	// RFC 9204, Appendix B.1.
+	b, _ := hex.DecodeString("0000510b2f696e6465782e68746d6c")
+	fields, err := decodeAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HeaderField{{":path", "/index.html"}}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("decoded %v; want %v", fields, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	fields := []HeaderField{
+		{":method", "GET"},
+		{":scheme", "https"},
+		{":authority", "example.com"},
+		{":path", "/a/b?c=d"},
+		{"user-agent", "Go-http-client/3"},
+		{"x-custom", ""},
+		{"x-long", strings.Repeat("v", 300)},
+		{"content-type", "text/html; charset=utf-8"},
+		{"x-bytes", "\x00\xff\x7f"},
+	}
+	b := AppendFieldSection(nil, fields)
+	got, err := decodeAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fields) {
+		t.Errorf("round trip = %q; want %q", got, fields)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		enc  string
+	}{
+		{"dynamic insert count", "0100"},
+		{"dynamic indexed", "000080"},
+		{"dynamic name reference", "0000400161"},
+		{"post-base", "000010"},
+		{"bad static index", "0000ff25"},
+		{"truncated string", "0000510b2f"},
+		{"truncated integer", "0000ff"},
+	}
+	for _, tt := range tests {
+		b, _ := hex.DecodeString(tt.enc)
+		if _, err := decodeAll(b); err == nil {
+			t.Errorf("%s: decoding %s succeeded", tt.name, tt.enc)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+// A recvBuffer reassembles data received at arbitrary offsets of a
+// stream into the ordered data read by the application.
+type recvBuffer struct {
+	off  int64    // offset of buf[0]: the next byte to read
+	buf  []byte   // received data starting at off; gaps are zero
+	have rangeset // ranges of received data at or after off
+}
+
+// write records data received at offset off.
+func (r *recvBuffer) write(off int64, b []byte) {
+	if end := off + int64(len(b)); end <= r.off {
+		return
+	}
+	if off < r.off {
+		b = b[r.off-off:]
+		off = r.off
+	}
+	end := off - r.off + int64(len(b))
+	if int64(len(r.buf)) < end {
+		r.buf = append(r.buf, make([]byte, int(end)-len(r.buf))...)
+	}
+	copy(r.buf[off-r.off:], b)
+	r.have.add(off, off+int64(len(b)))
+}
+
+// readable returns the number of contiguous bytes available to read.
+func (r *recvBuffer) readable() int {
+	if len(r.have) == 0 || r.have[0].start > r.off {
+		return 0
+	}
+	return int(r.have[0].end - r.off)
+}
+
+// read reads contiguous data into p.
+func (r *recvBuffer) read(p []byte) int {
+	n := r.readable()
+	if n > len(p) {
+		n = len(p)
+	}
+	copy(p, r.buf[:n])
+	r.discard(n)
+	return n
+}
+
+// discard discards n contiguous bytes.
+func (r *recvBuffer) discard(n int) {
+	r.buf = r.buf[n:]
+	if len(r.buf) == 0 {
+		r.buf = nil
+	}
+	r.have.sub(r.off, r.off+int64(n))
+	r.off += int64(n)
+}
+
+// A sendBuffer holds data written to a stream until the peer
+// acknowledges it.
+type sendBuffer struct {
+	base  int64    // offset of buf[0]; all data before it has been acked
+	buf   []byte   // unacknowledged data starting at base
+	next  int64    // offset of the first byte never sent
+	lost  rangeset // ranges sent and declared lost, to resend
+	acked rangeset // acknowledged ranges after base
+}
+
+// end returns the offset after the last byte written.
+func (s *sendBuffer) end() int64 {
+	return s.base + int64(len(s.buf))
+}
+
+func (s *sendBuffer) write(b []byte) {
+	s.buf = append(s.buf, b...)
+}
+
+// hasData reports whether there is data to send or resend.
+func (s *sendBuffer) hasData() bool {
+	return !s.lost.isEmpty() || s.next < s.end()
+}
+
+// nextRange returns the next range of at most max bytes to send,
+// preferring lost data, and whether the range is a retransmission.
+func (s *sendBuffer) nextRange(max int) (start, end int64, resend bool) {
+	if !s.lost.isEmpty() {
+		sp := s.lost[0]
+		if sp.end-sp.start > int64(max) {
+			sp.end = sp.start + int64(max)
+		}
+		return sp.start, sp.end, true
+	}
+	end = s.end()
+	if end-s.next > int64(max) {
+		end = s.next + int64(max)
+	}
+	return s.next, end, false
+}
+
+// data returns the buffered data in [start, end).
+func (s *sendBuffer) data(start, end int64) []byte {
+	return s.buf[start-s.base : end-s.base]
+}
+
+// markSent records that [start, end) has been sent.
+func (s *sendBuffer) markSent(start, end int64) {
+	s.lost.sub(start, end)
+	if end > s.next {
+		s.next = end
+	}
+}
+
+// markLost records that [start, end) must be resent.
+func (s *sendBuffer) markLost(start, end int64) {
+	if start < s.base {
+		start = s.base
+	}
+	s.lost.add(start, end)
+	for _, sp := range s.acked {
+		s.lost.sub(sp.start, sp.end)
+	}
+}
+
+// markAcked records that [start, end) has been acknowledged.
+func (s *sendBuffer) markAcked(start, end int64) {
+	if end <= s.base {
+		return
+	}
+	s.acked.add(start, end)
+	s.lost.sub(start, end)
+	if len(s.acked) > 0 && s.acked[0].start <= s.base {
+		newBase := s.acked[0].end
+		s.buf = s.buf[newBase-s.base:]
+		if len(s.buf) == 0 {
+			s.buf = nil
+		}
+		s.base = newBase
+		s.acked = s.acked[1:]
+	}
+}
+
+// unacked returns the number of bytes buffered and not acknowledged.
+func (s *sendBuffer) unacked() int {
+	return len(s.buf)
+}
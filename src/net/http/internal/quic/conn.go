@@ -0,0 +1,657 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// A numberSpace is a packet number space. See RFC 9000, Section 12.3.
+type numberSpace int
+
+const (
+	initialSpace numberSpace = iota
+	handshakeSpace
+	appDataSpace
+	numberSpaceCount
+)
+
+func (s numberSpace) level() tls.QUICEncryptionLevel {
+	switch s {
+	case initialSpace:
+		return tls.QUICEncryptionLevelInitial
+	case handshakeSpace:
+		return tls.QUICEncryptionLevelHandshake
+	}
+	return tls.QUICEncryptionLevelApplication
+}
+
+// maxCryptoBuffer limits the amount of out-of-order CRYPTO data buffered.
+const maxCryptoBuffer = 64 << 10
+
+// maxAckRanges limits the number of received packet number ranges
+// tracked and acknowledged per number space.
+const maxAckRanges = 32
+
+// A pnSpace holds the state of one packet number space.
+type pnSpace struct {
+	// Keys. For the application data space, these are in appKeys.
+	rkey, wkey packetKey
+	discarded  bool
+
+	// Receiving.
+	recvd        rangeset // packet numbers received
+	recvdFloor   int64    // packets below this are treated as duplicates
+	largestRecvd int64
+	recvdTime    time.Time // when largestRecvd was received
+	ackEliciting int       // ack-eliciting packets received since the last ACK
+	ackNow       bool      // an ACK must be sent now
+	ackDeadline  time.Time // when an ACK must be sent, if ackEliciting > 0
+
+	// Sending.
+	nextPN           int64
+	largestAcked     int64
+	sent             []*sentPacket // in packet number order
+	elicitingInFlt   int           // ack-eliciting packets in sent
+	lossTime         time.Time
+	lastAckEliciting time.Time
+	probes           int // probe packets to send after a PTO
+
+	cryptoSend sendBuffer
+	cryptoRecv recvBuffer
+}
+
+func (s *pnSpace) init() {
+	s.largestRecvd = -1
+	s.largestAcked = -1
+}
+
+// appKeys are the 1-RTT packet protection keys, which change with
+// each key update. See RFC 9001, Section 6.
+type appKeys struct {
+	suite            uint16
+	r, w             packetKey
+	rSecret, wSecret []byte
+	phase            byte // current key phase bit: 0 or keyPhaseBit
+}
+
+// Connection close states.
+const (
+	stateOpen     = iota
+	stateClosing  // we sent CONNECTION_CLOSE; see RFC 9000, Section 10.2.1
+	stateDraining // the peer sent CONNECTION_CLOSE; see RFC 9000, Section 10.2.2
+	stateDone
+)
+
+// A Conn is a QUIC connection.
+//
+// Multiple goroutines may invoke methods on a Conn simultaneously.
+type Conn struct {
+	endpoint *Endpoint
+	config   *Config
+	isClient bool
+	peerAddr net.Addr
+	tls      *tls.QUICConn
+
+	recvc      chan []byte   // datagrams from the endpoint
+	wakec      chan struct{} // wakes the loop when there is data to send
+	closedc    chan struct{} // closed when the connection starts closing
+	donec      chan struct{} // closed when the loop exits
+	handshakec chan struct{} // closed when the handshake completes
+
+	mu sync.Mutex // guards all following fields
+
+	origDstID   []byte // destination ID of the client's first Initial
+	localID     []byte
+	peerID      []byte
+	peerIDSet   bool
+	spaces      [numberSpaceCount]pnSpace
+	app         appKeys
+	rtt         rttState
+	cc          congestion
+	ptoCount    uint
+	localParams transportParameters
+	peerParams  transportParameters
+
+	handshakeDone      bool // the TLS handshake is complete
+	handshakeConfirmed bool // see RFC 9001, Section 4.1.2
+	sendHandshakeDone  bool
+	addrValidated      bool
+	bytesRecvd         int64
+	bytesSent          int64
+	pathResponses      [][8]byte
+	pingPending        bool
+
+	state        int
+	closeErr     error // error returned by operations after closing
+	closeIsApp   bool
+	closeCode    uint64
+	closeReason  string
+	sendClose    bool
+	exitNow      bool // exit as soon as the close is sent
+	stateTimer   time.Time
+	idleDeadline time.Time
+	lastActivity time.Time
+
+	// Streams and flow control.
+	streams         map[int64]*Stream
+	sendQueue       []*Stream
+	localOpened     [2]int64 // streams we opened, by [bidi, uni]
+	peerOpened      [2]int64 // streams the peer opened
+	peerMaxStreams  [2]int64 // limits on streams we may open
+	localMaxStreams [2]int64 // limits on streams the peer may open
+	sendMaxStreams  [2]bool
+	acceptq         []*Stream
+	acceptWake      chan struct{}
+	streamsWake     chan struct{}
+	peerMaxData     int64 // flow control limit for our data
+	sentData        int64 // new stream data sent
+	localMaxData    int64 // flow control limit we advertised
+	recvdData       int64 // highest offsets received, summed over streams
+	consumedData    int64 // data read or discarded
+	sendMaxData     bool
+	connWindow      int64
+	streamWindow    int64
+}
+
+func newConn(e *Endpoint, config *Config, isClient bool, peerAddr net.Addr, origDstID, peerSrcID []byte) (*Conn, error) {
+	c := &Conn{
+		endpoint:    e,
+		config:      config,
+		isClient:    isClient,
+		peerAddr:    peerAddr,
+		recvc:       make(chan []byte, 64),
+		wakec:       make(chan struct{}, 1),
+		closedc:     make(chan struct{}),
+		donec:       make(chan struct{}),
+		handshakec:  make(chan struct{}),
+		acceptWake:  make(chan struct{}, 1),
+		streamsWake: make(chan struct{}, 1),
+		streams:     make(map[int64]*Stream),
+	}
+	for i := range c.spaces {
+		c.spaces[i].init()
+	}
+	c.rtt.init()
+	c.cc.init()
+	c.localID = make([]byte, connIDLen)
+	if _, err := rand.Read(c.localID); err != nil {
+		return nil, err
+	}
+	if isClient {
+		c.origDstID = make([]byte, connIDLen)
+		if _, err := rand.Read(c.origDstID); err != nil {
+			return nil, err
+		}
+		c.peerID = c.origDstID
+		c.addrValidated = true
+	} else {
+		c.origDstID = cloneBytes(origDstID)
+		c.peerID = cloneBytes(peerSrcID)
+		c.peerIDSet = true
+	}
+	clientKey, serverKey := initialKeys(c.origDstID)
+	if isClient {
+		c.spaces[initialSpace].wkey, c.spaces[initialSpace].rkey = clientKey, serverKey
+	} else {
+		c.spaces[initialSpace].wkey, c.spaces[initialSpace].rkey = serverKey, clientKey
+	}
+
+	c.connWindow = config.maxConnReadBufferSize()
+	c.streamWindow = config.maxStreamReadBufferSize()
+	c.localMaxData = c.connWindow
+	c.localMaxStreams = [2]int64{config.maxBidiRemoteStreams(), config.maxUniRemoteStreams()}
+	c.localParams = defaultTransportParameters()
+	c.localParams.maxIdleTimeout = config.maxIdleTimeout()
+	c.localParams.maxUDPPayloadSize = maxRecvDatagramSize
+	c.localParams.initialMaxData = c.localMaxData
+	c.localParams.initialMaxStreamDataBidiLocal = c.streamWindow
+	c.localParams.initialMaxStreamDataBidiRemote = c.streamWindow
+	c.localParams.initialMaxStreamDataUni = c.streamWindow
+	c.localParams.initialMaxStreamsBidi = c.localMaxStreams[0]
+	c.localParams.initialMaxStreamsUni = c.localMaxStreams[1]
+	c.localParams.maxAckDelay = maxAckDelay
+	c.localParams.ackDelayExponent = ackDelayExponent
+	c.localParams.disableActiveMigration = true
+	c.localParams.initialSrcConnID = c.localID
+	if !isClient {
+		c.localParams.originalDstConnID = c.origDstID
+	}
+
+	tlsConfig := config.TLSConfig.Clone()
+	tlsConfig.MinVersion = tls.VersionTLS13
+	qconfig := &tls.QUICConfig{TLSConfig: tlsConfig}
+	if isClient {
+		c.tls = tls.QUICClient(qconfig)
+	} else {
+		c.tls = tls.QUICServer(qconfig)
+	}
+	c.tls.SetTransportParameters(c.localParams.marshal())
+	if err := c.tls.Start(context.Background()); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	c.lastActivity = now
+	c.idleDeadline = now.Add(c.config.maxIdleTimeout())
+	if err := c.handleTLSEvents(now); err != nil {
+		c.tls.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.endpoint.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.peerAddr
+}
+
+// ConnectionState returns basic TLS details about the connection.
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	return c.tls.ConnectionState()
+}
+
+// Close closes the connection without an error, sending a
+// CONNECTION_CLOSE frame with the NO_ERROR code to the peer.
+// Pending stream data may be lost.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	c.enterClosing(time.Now(), false, uint64(errNo), "", errConnClosed)
+	c.mu.Unlock()
+	c.wake()
+	return nil
+}
+
+// CloseWithError closes the connection with an application error code,
+// sent to the peer in a CONNECTION_CLOSE frame.
+func (c *Conn) CloseWithError(code uint64, reason string) error {
+	c.mu.Lock()
+	c.enterClosing(time.Now(), true, code, reason, errConnClosed)
+	c.mu.Unlock()
+	c.wake()
+	return nil
+}
+
+// Done returns a channel that is closed when the connection is closed
+// or starts closing.
+func (c *Conn) Done() <-chan struct{} {
+	return c.closedc
+}
+
+// Err returns the reason the connection closed, or nil if it is open.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeErr
+}
+
+// waitHandshake waits for the handshake to complete.
+func (c *Conn) waitHandshake(ctx context.Context) error {
+	select {
+	case <-c.handshakec:
+		return nil
+	case <-c.closedc:
+		return c.Err()
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
+	}
+}
+
+func (c *Conn) wake() {
+	select {
+	case c.wakec <- struct{}{}:
+	default:
+	}
+}
+
+// notify signals ch, a channel with a buffer of 1, without blocking.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// loop runs the connection, processing datagrams and timers and
+// sending packets, until the connection is done.
+func (c *Conn) loop() {
+	defer c.exit()
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		c.handleTimers(now)
+		if c.state != stateDone {
+			c.sendDatagrams(now)
+		}
+		if c.exitNow && !c.sendClose {
+			c.state = stateDone
+		}
+		if c.state == stateDone {
+			c.mu.Unlock()
+			return
+		}
+		next := c.nextDeadline()
+		c.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(time.Until(next))
+		select {
+		case b := <-c.recvc:
+			c.mu.Lock()
+			c.handleDatagram(time.Now(), b)
+			// Process datagrams which have already arrived before
+			// sending, to coalesce acknowledgements.
+			for i := 0; i < 16; i++ {
+				select {
+				case b := <-c.recvc:
+					c.handleDatagram(time.Now(), b)
+					continue
+				default:
+				}
+				break
+			}
+			c.mu.Unlock()
+		case <-c.wakec:
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Conn) exit() {
+	c.mu.Lock()
+	if c.closeErr == nil {
+		c.closeErr = errConnClosed
+	}
+	c.setClosed()
+	c.mu.Unlock()
+	c.tls.Close()
+	c.endpoint.removeConn(c)
+	close(c.donec)
+}
+
+// setClosed marks the connection as no longer usable.
+func (c *Conn) setClosed() {
+	select {
+	case <-c.closedc:
+	default:
+		close(c.closedc)
+	}
+}
+
+// nextDeadline returns the time the loop must next wake.
+func (c *Conn) nextDeadline() time.Time {
+	next := c.idleDeadline
+	if c.state != stateOpen {
+		return c.stateTimer
+	}
+	earlier := func(t time.Time) {
+		if !t.IsZero() && t.Before(next) {
+			next = t
+		}
+	}
+	earlier(c.lossDetectionDeadline())
+	for i := range c.spaces {
+		if c.spaces[i].ackEliciting > 0 {
+			earlier(c.spaces[i].ackDeadline)
+		}
+	}
+	if c.config.KeepAlivePeriod > 0 && c.handshakeDone {
+		earlier(c.lastActivity.Add(c.config.KeepAlivePeriod))
+	}
+	return next
+}
+
+func (c *Conn) handleTimers(now time.Time) {
+	switch c.state {
+	case stateClosing, stateDraining:
+		if !now.Before(c.stateTimer) {
+			c.state = stateDone
+		}
+		return
+	}
+	if !now.Before(c.idleDeadline) {
+		// Close silently. See RFC 9000, Section 10.1.
+		c.closeErr = errIdleTimeout
+		c.state = stateDone
+		return
+	}
+	if t := c.lossDetectionDeadline(); !t.IsZero() && !now.Before(t) {
+		c.onLossDetectionTimeout(now)
+	}
+	if c.config.KeepAlivePeriod > 0 && c.handshakeDone && !now.Before(c.lastActivity.Add(c.config.KeepAlivePeriod)) {
+		c.pingPending = true
+		c.lastActivity = now
+	}
+}
+
+// idleTimeout returns the negotiated idle timeout.
+func (c *Conn) idleTimeout() time.Duration {
+	d := c.config.maxIdleTimeout()
+	if p := c.peerParams.maxIdleTimeout; p > 0 && p < d {
+		d = p
+	}
+	if min := 3 * c.rtt.pto(); d < min {
+		d = min
+	}
+	return d
+}
+
+// enterClosing starts closing the connection, sending a CONNECTION_CLOSE
+// frame to the peer. err is returned by subsequent operations.
+func (c *Conn) enterClosing(now time.Time, isApp bool, code uint64, reason string, err error) {
+	if c.state != stateOpen {
+		return
+	}
+	c.state = stateClosing
+	c.closeErr = err
+	c.closeIsApp = isApp
+	c.closeCode = code
+	c.closeReason = reason
+	c.sendClose = true
+	c.stateTimer = now.Add(3 * c.rtt.pto())
+	c.setClosed()
+	c.wakeStreams()
+}
+
+// closeWithError closes the connection because of a local error.
+func (c *Conn) closeWithError(now time.Time, err error) {
+	switch err := err.(type) {
+	case localTransportError:
+		c.enterClosing(now, false, uint64(err.code), err.reason, err)
+	case *tls.AlertError:
+		code := errTLSBase + transportError(err.Alert)
+		c.enterClosing(now, false, uint64(code), "", localTransportError{code, err.Err.Error()})
+	default:
+		c.enterClosing(now, false, uint64(errInternal), "", localTransportError{errInternal, err.Error()})
+	}
+}
+
+// enterDraining handles the peer closing the connection.
+func (c *Conn) enterDraining(now time.Time, err error) {
+	if c.state == stateDraining || c.state == stateDone {
+		return
+	}
+	if c.state == stateOpen {
+		c.closeErr = err
+	}
+	c.state = stateDraining
+	c.sendClose = false
+	c.stateTimer = now.Add(3 * c.rtt.pto())
+	c.setClosed()
+	c.wakeStreams()
+}
+
+// closeForEndpoint closes the connection because its endpoint is closing.
+func (c *Conn) closeForEndpoint() {
+	c.mu.Lock()
+	now := time.Now()
+	c.enterClosing(now, false, uint64(errNo), "", errEndpointClose)
+	c.exitNow = true
+	c.mu.Unlock()
+	c.wake()
+}
+
+// wakeStreams wakes all goroutines blocked on the connection's streams.
+func (c *Conn) wakeStreams() {
+	for _, s := range c.streams {
+		notify(s.readWake)
+		notify(s.writeWake)
+	}
+	notify(c.acceptWake)
+	notify(c.streamsWake)
+}
+
+// handleTLSEvents processes the events produced by the TLS handshake.
+func (c *Conn) handleTLSEvents(now time.Time) error {
+	for {
+		e := c.tls.NextEvent()
+		switch e.Kind {
+		case tls.QUICNoEvent:
+			return nil
+		case tls.QUICSetReadSecret:
+			if err := c.setSecret(e.Level, e.Suite, e.Data, false); err != nil {
+				return err
+			}
+		case tls.QUICSetWriteSecret:
+			if err := c.setSecret(e.Level, e.Suite, e.Data, true); err != nil {
+				return err
+			}
+		case tls.QUICWriteData:
+			space := &c.spaces[spaceForLevel(e.Level)]
+			space.cryptoSend.write(e.Data)
+		case tls.QUICTransportParameters:
+			p, err := unmarshalTransportParameters(e.Data)
+			if err != nil {
+				return err
+			}
+			if err := p.checkConnIDs(c.isClient, c.peerID, c.origDstID); err != nil {
+				return err
+			}
+			c.setPeerParams(p)
+		case tls.QUICHandshakeDone:
+			c.onHandshakeDone(now)
+		}
+	}
+}
+
+func spaceForLevel(level tls.QUICEncryptionLevel) numberSpace {
+	switch level {
+	case tls.QUICEncryptionLevelInitial:
+		return initialSpace
+	case tls.QUICEncryptionLevelHandshake:
+		return handshakeSpace
+	}
+	return appDataSpace
+}
+
+func (c *Conn) setSecret(level tls.QUICEncryptionLevel, suite uint16, secret []byte, write bool) error {
+	k, err := newPacketKey(suite, secret)
+	if err != nil {
+		return localTransportError{errInternal, err.Error()}
+	}
+	space := spaceForLevel(level)
+	if space == appDataSpace {
+		c.app.suite = suite
+		if write {
+			c.app.w, c.app.wSecret = k, cloneBytes(secret)
+		} else {
+			c.app.r, c.app.rSecret = k, cloneBytes(secret)
+		}
+		return nil
+	}
+	if write {
+		c.spaces[space].wkey = k
+	} else {
+		c.spaces[space].rkey = k
+	}
+	return nil
+}
+
+func (c *Conn) setPeerParams(p transportParameters) {
+	c.peerParams = p
+	c.peerMaxData = p.initialMaxData
+	c.peerMaxStreams = [2]int64{p.initialMaxStreamsBidi, p.initialMaxStreamsUni}
+	notify(c.streamsWake)
+}
+
+func (c *Conn) onHandshakeDone(now time.Time) {
+	c.handshakeDone = true
+	c.idleDeadline = now.Add(c.idleTimeout())
+	if !c.isClient {
+		// The server confirms the handshake when it completes.
+		// See RFC 9001, Section 4.1.2.
+		c.sendHandshakeDone = true
+		c.confirmHandshake(now)
+	}
+	close(c.handshakec)
+	if !c.isClient && !c.endpoint.enqueueAccept(c) {
+		c.enterClosing(now, false, uint64(errConnectionRefused), "", errConnClosed)
+	}
+}
+
+func (c *Conn) confirmHandshake(now time.Time) {
+	if c.handshakeConfirmed {
+		return
+	}
+	c.handshakeConfirmed = true
+	c.discardKeys(now, handshakeSpace)
+}
+
+// discardKeys discards the keys and state of a number space.
+// See RFC 9001, Section 4.9.
+func (c *Conn) discardKeys(now time.Time, space numberSpace) {
+	s := &c.spaces[space]
+	if s.discarded {
+		return
+	}
+	s.discarded = true
+	for _, p := range s.sent {
+		if p.inFlight {
+			c.cc.discard(p)
+		}
+	}
+	s.sent = nil
+	s.elicitingInFlt = 0
+	s.lossTime = time.Time{}
+	s.ackEliciting = 0
+	s.ackNow = false
+	s.probes = 0
+	s.rkey, s.wkey = packetKey{}, packetKey{}
+	s.cryptoSend = sendBuffer{}
+	c.ptoCount = 0
+}
+
+// canRead reports whether packets in space can be read.
+func (c *Conn) canRead(space numberSpace) bool {
+	if space == appDataSpace {
+		return c.app.r.isSet()
+	}
+	return !c.spaces[space].discarded && c.spaces[space].rkey.isSet()
+}
+
+// canWrite reports whether packets in space can be written.
+func (c *Conn) canWrite(space numberSpace) bool {
+	if space == appDataSpace {
+		return c.app.w.isSet()
+	}
+	return !c.spaces[space].discarded && c.spaces[space].wkey.isSet()
+}
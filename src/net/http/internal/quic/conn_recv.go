@@ -0,0 +1,744 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"time"
+)
+
+// handleDatagram processes a datagram received from the peer.
+func (c *Conn) handleDatagram(now time.Time, b []byte) {
+	if c.state == stateDraining || c.state == stateDone {
+		return
+	}
+	c.bytesRecvd += int64(len(b))
+	for len(b) > 0 && c.state != stateDone {
+		n := c.handlePacket(now, b)
+		if n <= 0 {
+			break
+		}
+		b = b[n:]
+	}
+}
+
+// handlePacket processes the packet at the start of datagram b,
+// returning its length, or -1 if the rest of the datagram must be
+// dropped.
+func (c *Conn) handlePacket(now time.Time, b []byte) int {
+	if isLongHeader(b[0]) {
+		h, ok := parseLongHeader(b)
+		if !ok {
+			return -1
+		}
+		space := initialSpace
+		if h.typ == packetTypeHandshake {
+			space = handshakeSpace
+		}
+		if !bytes.Equal(h.dstID, c.localID) && !(!c.isClient && bytes.Equal(h.dstID, c.origDstID)) {
+			return h.length
+		}
+		if !c.canRead(space) {
+			return h.length
+		}
+		p := b[:h.length]
+		key := &c.spaces[space].rkey
+		truncated, pnLen, ok := key.unprotectHeader(p, h.pnOff)
+		if !ok {
+			return h.length
+		}
+		pn := decodePacketNumber(c.spaces[space].largestRecvd, truncated, pnLen)
+		payload, err := key.open(p, h.pnOff+pnLen, pn)
+		if err != nil {
+			return h.length
+		}
+		if p[0]&reservedBitsLng != 0 {
+			c.closeWithError(now, localTransportError{errProtocolViolation, "reserved header bits set"})
+			return -1
+		}
+		if c.isClient && space == initialSpace && !c.peerIDSet {
+			// The server chooses its connection ID in its first
+			// Initial packet. See RFC 9000, Section 7.2.
+			c.peerID = cloneBytes(h.srcID)
+			c.peerIDSet = true
+		}
+		c.handlePayload(now, space, pn, payload)
+		if space == handshakeSpace && !c.isClient {
+			// Receiving a Handshake packet validates the client's
+			// address and ends the use of Initial keys.
+			// See RFC 9000, Section 8.1 and RFC 9001, Section 4.9.1.
+			c.addrValidated = true
+			c.discardKeys(now, initialSpace)
+		}
+		return h.length
+	}
+
+	// Short header: the packet extends to the end of the datagram.
+	pnOff := 1 + connIDLen
+	if len(b) < pnOff || !bytes.Equal(b[1:pnOff], c.localID) || !c.canRead(appDataSpace) {
+		return -1
+	}
+	truncated, pnLen, ok := c.app.r.unprotectHeader(b, pnOff)
+	if !ok {
+		return -1
+	}
+	pn := decodePacketNumber(c.spaces[appDataSpace].largestRecvd, truncated, pnLen)
+	var payload []byte
+	var err error
+	if b[0]&keyPhaseBit == c.app.phase {
+		payload, err = c.app.r.open(b, pnOff+pnLen, pn)
+	} else {
+		payload, err = c.openKeyUpdate(b, pnOff+pnLen, pn)
+	}
+	if err != nil {
+		return -1
+	}
+	if b[0]&reservedBitsSht != 0 {
+		c.closeWithError(now, localTransportError{errProtocolViolation, "reserved header bits set"})
+		return -1
+	}
+	c.handlePayload(now, appDataSpace, pn, payload)
+	return len(b)
+}
+
+// openKeyUpdate decrypts a packet protected with the next 1-RTT keys,
+// and if that succeeds, updates the keys. See RFC 9001, Section 6.
+func (c *Conn) openKeyUpdate(b []byte, hdrLen int, pn int64) ([]byte, error) {
+	rSecret := nextTrafficSecret(c.app.suite, c.app.rSecret)
+	r, err := newPacketKey(c.app.suite, rSecret)
+	if err != nil {
+		return nil, err
+	}
+	// Header protection keys do not change.
+	r.hp = c.app.r.hp
+	payload, err := r.open(b, hdrLen, pn)
+	if err != nil {
+		return nil, err
+	}
+	if pn < c.spaces[appDataSpace].largestRecvd {
+		// A reordered packet from before the last key update;
+		// we do not keep the old keys, so drop it.
+		return nil, errKeyUpdate
+	}
+	wSecret := nextTrafficSecret(c.app.suite, c.app.wSecret)
+	w, err := newPacketKey(c.app.suite, wSecret)
+	if err != nil {
+		return nil, err
+	}
+	w.hp = c.app.w.hp
+	c.app.r, c.app.rSecret = r, rSecret
+	c.app.w, c.app.wSecret = w, wSecret
+	c.app.phase ^= keyPhaseBit
+	return payload, nil
+}
+
+var errKeyUpdate = errorString("quic: packet from previous key phase")
+
+// handlePayload processes the frames in a decrypted packet.
+func (c *Conn) handlePayload(now time.Time, space numberSpace, pn int64, payload []byte) {
+	s := &c.spaces[space]
+	if pn < s.recvdFloor || s.recvd.contains(pn) {
+		return // duplicate
+	}
+	if c.state == stateClosing {
+		// Respond to any packet with another CONNECTION_CLOSE.
+		// See RFC 9000, Section 10.2.1.
+		c.sendClose = true
+		c.processClosingFrames(now, payload)
+		return
+	}
+	ackEliciting, err := c.handleFrames(now, space, payload)
+	if err != nil {
+		c.closeWithError(now, err)
+		return
+	}
+	s.recvd.add(pn, pn+1)
+	if len(s.recvd) > maxAckRanges {
+		s.recvd = s.recvd[len(s.recvd)-maxAckRanges:]
+		s.recvdFloor = s.recvd.min()
+	}
+	outOfOrder := pn < s.largestRecvd
+	if pn > s.largestRecvd {
+		s.largestRecvd = pn
+		s.recvdTime = now
+	}
+	c.lastActivity = now
+	c.idleDeadline = now.Add(c.idleTimeout())
+	if !ackEliciting {
+		return
+	}
+	s.ackEliciting++
+	switch {
+	case space != appDataSpace, outOfOrder, s.ackEliciting >= 2:
+		s.ackNow = true
+	case s.ackEliciting == 1:
+		s.ackDeadline = now.Add(maxAckDelay)
+	}
+}
+
+// processClosingFrames looks for CONNECTION_CLOSE frames in a packet
+// received while closing, which move the connection to draining.
+func (c *Conn) processClosingFrames(now time.Time, payload []byte) {
+	for len(payload) > 0 {
+		typ, n := consumeVarint(payload)
+		if n < 0 {
+			return
+		}
+		if typ == frameTypeConnectionCloseTransport || typ == frameTypeConnectionCloseApplication {
+			c.enterDraining(now, nil)
+			return
+		}
+		n = frameLength(payload)
+		if n < 0 {
+			return
+		}
+		payload = payload[n:]
+	}
+}
+
+// frameLength returns the length of the frame at the start of b,
+// or -1 if it is malformed or of a type not sent by a peer
+// before closing.
+func frameLength(b []byte) int {
+	typ, n := consumeVarint(b)
+	switch typ {
+	case frameTypePadding, frameTypePing, frameTypeHandshakeDone:
+		return n
+	case frameTypeAck, frameTypeAckECN:
+		_, _, m := parseAckFrame(b)
+		return m
+	case frameTypeCrypto:
+		m := skipVarints(b[n:], 1)
+		if m < 0 {
+			return -1
+		}
+		_, m2 := consumeVarintBytes(b[n+m:])
+		if m2 < 0 {
+			return -1
+		}
+		return n + m + m2
+	}
+	if typ&^0x07 == frameTypeStreamBase {
+		_, _, _, _, m := parseStreamFrame(b)
+		return m
+	}
+	return -1
+}
+
+// skipVarints returns the length of count varints at the start of b.
+func skipVarints(b []byte, count int) int {
+	off := 0
+	for i := 0; i < count; i++ {
+		_, n := consumeVarint(b[off:])
+		if n < 0 {
+			return -1
+		}
+		off += n
+	}
+	return off
+}
+
+// parseStreamFrame parses the STREAM frame at the start of b.
+func parseStreamFrame(b []byte) (id, off int64, data []byte, fin bool, n int) {
+	typ := b[0]
+	n = 1
+	id, m := consumeVarintInt64(b[n:])
+	if m < 0 {
+		return 0, 0, nil, false, -1
+	}
+	n += m
+	if typ&streamOffBit != 0 {
+		off, m = consumeVarintInt64(b[n:])
+		if m < 0 {
+			return 0, 0, nil, false, -1
+		}
+		n += m
+	}
+	if typ&streamLenBit != 0 {
+		data, m = consumeVarintBytes(b[n:])
+		if m < 0 {
+			return 0, 0, nil, false, -1
+		}
+		n += m
+	} else {
+		data = b[n:]
+		n = len(b)
+	}
+	if off+int64(len(data)) > maxVarint {
+		return 0, 0, nil, false, -1
+	}
+	return id, off, data, typ&streamFinBit != 0, n
+}
+
+func errFrame(reason string) error {
+	return localTransportError{errFrameEncoding, reason}
+}
+
+// handleFrames processes the frames in a packet payload, reporting
+// whether the packet was ack-eliciting.
+func (c *Conn) handleFrames(now time.Time, space numberSpace, b []byte) (ackEliciting bool, err error) {
+	if len(b) == 0 {
+		return false, localTransportError{errProtocolViolation, "packet with no frames"}
+	}
+	for len(b) > 0 {
+		typ, n := consumeVarint(b)
+		if n < 0 {
+			return false, errFrame("malformed frame type")
+		}
+		if space != appDataSpace && !frameAllowedBeforeOneRTT(typ) {
+			return false, localTransportError{errProtocolViolation, "frame not allowed before 1-RTT"}
+		}
+		switch typ {
+		case frameTypePadding, frameTypeAck, frameTypeAckECN,
+			frameTypeConnectionCloseTransport, frameTypeConnectionCloseApplication:
+		default:
+			ackEliciting = true
+		}
+		switch {
+		case typ == frameTypePadding, typ == frameTypePing:
+		case typ == frameTypeAck, typ == frameTypeAckECN:
+			acked, delay, m := parseAckFrame(b)
+			if m < 0 {
+				return false, errFrame("malformed ACK frame")
+			}
+			n = m
+			if err := c.handleAck(now, space, acked, delay); err != nil {
+				return false, err
+			}
+		case typ == frameTypeCrypto:
+			off, m := consumeVarintInt64(b[n:])
+			if m < 0 {
+				return false, errFrame("malformed CRYPTO frame")
+			}
+			n += m
+			data, m := consumeVarintBytes(b[n:])
+			if m < 0 {
+				return false, errFrame("malformed CRYPTO frame")
+			}
+			n += m
+			if err := c.handleCrypto(now, space, off, data); err != nil {
+				return false, err
+			}
+		case typ&^0x07 == frameTypeStreamBase:
+			id, off, data, fin, m := parseStreamFrame(b)
+			if m < 0 {
+				return false, errFrame("malformed STREAM frame")
+			}
+			n = m
+			if err := c.handleStreamFrame(id, off, data, fin); err != nil {
+				return false, err
+			}
+		case typ == frameTypeResetStream:
+			var v [3]int64
+			m := consumeVarintsInt64(b[n:], v[:])
+			if m < 0 {
+				return false, errFrame("malformed RESET_STREAM frame")
+			}
+			n += m
+			if err := c.handleResetStream(v[0], uint64(v[1]), v[2]); err != nil {
+				return false, err
+			}
+		case typ == frameTypeStopSending:
+			var v [2]int64
+			m := consumeVarintsInt64(b[n:], v[:])
+			if m < 0 {
+				return false, errFrame("malformed STOP_SENDING frame")
+			}
+			n += m
+			if err := c.handleStopSending(v[0], uint64(v[1])); err != nil {
+				return false, err
+			}
+		case typ == frameTypeMaxData:
+			max, m := consumeVarintInt64(b[n:])
+			if m < 0 {
+				return false, errFrame("malformed MAX_DATA frame")
+			}
+			n += m
+			if max > c.peerMaxData {
+				c.peerMaxData = max
+				c.queueAllStreams()
+			}
+		case typ == frameTypeMaxStreamData:
+			var v [2]int64
+			m := consumeVarintsInt64(b[n:], v[:])
+			if m < 0 {
+				return false, errFrame("malformed MAX_STREAM_DATA frame")
+			}
+			n += m
+			if err := c.handleMaxStreamData(v[0], v[1]); err != nil {
+				return false, err
+			}
+		case typ == frameTypeMaxStreamsBidi, typ == frameTypeMaxStreamsUni:
+			max, m := consumeVarintInt64(b[n:])
+			if m < 0 || max > 1<<60 {
+				return false, errFrame("malformed MAX_STREAMS frame")
+			}
+			n += m
+			dir := int(typ - frameTypeMaxStreamsBidi)
+			if max > c.peerMaxStreams[dir] {
+				c.peerMaxStreams[dir] = max
+				notify(c.streamsWake)
+			}
+		case typ == frameTypeDataBlocked, typ == frameTypeRetireConnectionID,
+			typ == frameTypeStreamsBlockedBidi, typ == frameTypeStreamsBlockedUni:
+			m := skipVarints(b[n:], 1)
+			if m < 0 {
+				return false, errFrame("malformed frame")
+			}
+			n += m
+		case typ == frameTypeStreamDataBlocked:
+			m := skipVarints(b[n:], 2)
+			if m < 0 {
+				return false, errFrame("malformed STREAM_DATA_BLOCKED frame")
+			}
+			n += m
+		case typ == frameTypeNewConnectionID:
+			// We use only the peer's first connection ID.
+			m := skipVarints(b[n:], 2)
+			if m < 0 {
+				return false, errFrame("malformed NEW_CONNECTION_ID frame")
+			}
+			n += m
+			id, m := consumeUint8Bytes(b[n:])
+			if m < 0 || len(id) < 1 || len(id) > 20 || len(b) < n+m+16 {
+				return false, errFrame("malformed NEW_CONNECTION_ID frame")
+			}
+			n += m + 16
+		case typ == frameTypeNewToken:
+			if !c.isClient {
+				return false, localTransportError{errProtocolViolation, "NEW_TOKEN sent by client"}
+			}
+			_, m := consumeVarintBytes(b[n:])
+			if m < 0 {
+				return false, errFrame("malformed NEW_TOKEN frame")
+			}
+			n += m
+		case typ == frameTypePathChallenge, typ == frameTypePathResponse:
+			if len(b) < n+8 {
+				return false, errFrame("malformed PATH_CHALLENGE frame")
+			}
+			if typ == frameTypePathChallenge {
+				var data [8]byte
+				copy(data[:], b[n:])
+				c.pathResponses = append(c.pathResponses, data)
+			}
+			n += 8
+		case typ == frameTypeConnectionCloseTransport, typ == frameTypeConnectionCloseApplication:
+			code, m := consumeVarint(b[n:])
+			if m < 0 {
+				return false, errFrame("malformed CONNECTION_CLOSE frame")
+			}
+			n += m
+			if typ == frameTypeConnectionCloseTransport {
+				m = skipVarints(b[n:], 1) // frame type
+				if m < 0 {
+					return false, errFrame("malformed CONNECTION_CLOSE frame")
+				}
+				n += m
+			}
+			reason, m := consumeVarintBytes(b[n:])
+			if m < 0 {
+				return false, errFrame("malformed CONNECTION_CLOSE frame")
+			}
+			n += m
+			if typ == frameTypeConnectionCloseTransport {
+				c.enterDraining(now, &PeerTransportError{Code: code, Reason: string(reason)})
+			} else {
+				c.enterDraining(now, &ApplicationError{Code: code, Reason: string(reason)})
+			}
+			return ackEliciting, nil
+		case typ == frameTypeHandshakeDone:
+			if !c.isClient {
+				return false, localTransportError{errProtocolViolation, "HANDSHAKE_DONE sent by client"}
+			}
+			c.confirmHandshake(now)
+		default:
+			return false, errFrame("unknown frame type")
+		}
+		b = b[n:]
+	}
+	return ackEliciting, nil
+}
+
+// consumeVarintsInt64 parses len(v) varints at the start of b.
+func consumeVarintsInt64(b []byte, v []int64) int {
+	off := 0
+	for i := range v {
+		x, n := consumeVarintInt64(b[off:])
+		if n < 0 {
+			return -1
+		}
+		v[i] = x
+		off += n
+	}
+	return off
+}
+
+// handleCrypto processes CRYPTO frame data, passing it to TLS
+// in order.
+func (c *Conn) handleCrypto(now time.Time, space numberSpace, off int64, data []byte) error {
+	s := &c.spaces[space]
+	if off+int64(len(data))-s.cryptoRecv.off > maxCryptoBuffer {
+		return localTransportError{transportError(0x0d), "too much buffered CRYPTO data"}
+	}
+	s.cryptoRecv.write(off, data)
+	n := s.cryptoRecv.readable()
+	if n == 0 {
+		return nil
+	}
+	buf := make([]byte, n)
+	s.cryptoRecv.read(buf)
+	if err := c.tls.HandleData(space.level(), buf); err != nil {
+		return err
+	}
+	return c.handleTLSEvents(now)
+}
+
+// handleAck processes the acknowledgement of sent packets.
+// See RFC 9002, Section 6.
+func (c *Conn) handleAck(now time.Time, space numberSpace, acked rangeset, delay uint64) error {
+	s := &c.spaces[space]
+	largest := acked.max()
+	if largest >= s.nextPN {
+		return localTransportError{errProtocolViolation, "acknowledgement of unsent packet"}
+	}
+	var newlyAcked []*sentPacket
+	kept := s.sent[:0]
+	for _, p := range s.sent {
+		if acked.contains(p.pn) {
+			newlyAcked = append(newlyAcked, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	for i := len(kept); i < len(s.sent); i++ {
+		s.sent[i] = nil
+	}
+	s.sent = kept
+	if len(newlyAcked) == 0 {
+		return nil
+	}
+	if largest > s.largestAcked {
+		s.largestAcked = largest
+	}
+	last := newlyAcked[len(newlyAcked)-1]
+	eliciting := false
+	for _, p := range newlyAcked {
+		eliciting = eliciting || p.ackEliciting
+	}
+	if last.pn == largest && eliciting {
+		var ackDelay time.Duration
+		if space == appDataSpace {
+			ackDelay = time.Duration(delay<<uint(c.peerParams.ackDelayExponent)) * time.Microsecond
+			if c.handshakeConfirmed && ackDelay > c.peerParams.maxAckDelay {
+				ackDelay = c.peerParams.maxAckDelay
+			}
+		}
+		c.rtt.update(now.Sub(last.time), ackDelay)
+	}
+	for _, p := range newlyAcked {
+		if p.ackEliciting {
+			s.elicitingInFlt--
+		}
+		if p.inFlight {
+			c.cc.onAcked(p)
+		}
+		c.onPacketAcked(space, p)
+	}
+	c.detectLostPackets(now, space)
+	c.ptoCount = 0
+	return nil
+}
+
+func (c *Conn) onPacketAcked(space numberSpace, p *sentPacket) {
+	for _, f := range p.frames {
+		switch f.typ {
+		case frameTypeCrypto:
+			c.spaces[space].cryptoSend.markAcked(f.start, f.end)
+		case frameTypeStreamBase:
+			if s := c.streams[f.id]; s != nil {
+				s.onDataAcked(f.start, f.end, f.fin)
+			}
+		case frameTypeResetStream:
+			if s := c.streams[f.id]; s != nil {
+				s.resetAcked = true
+				c.maybeRemoveStream(s)
+			}
+		case frameTypeAck:
+			// The peer has our acknowledgement of packets up to
+			// f.largest, so we need not acknowledge them again.
+			s := &c.spaces[space]
+			if s.recvd.min() <= f.largest && f.largest < s.largestRecvd {
+				s.recvd.sub(s.recvd.min(), f.largest+1)
+				s.recvdFloor = f.largest + 1
+			}
+		}
+	}
+}
+
+// detectLostPackets declares packets lost by the packet and time
+// thresholds. See RFC 9002, Section 6.1.
+func (c *Conn) detectLostPackets(now time.Time, space numberSpace) {
+	s := &c.spaces[space]
+	s.lossTime = time.Time{}
+	lossDelay := c.rtt.lossDelay()
+	kept := s.sent[:0]
+	var lost []*sentPacket
+	for _, p := range s.sent {
+		if p.pn > s.largestAcked {
+			kept = append(kept, p)
+			continue
+		}
+		if p.pn <= s.largestAcked-packetThreshold || !now.Before(p.time.Add(lossDelay)) {
+			lost = append(lost, p)
+			continue
+		}
+		if t := p.time.Add(lossDelay); s.lossTime.IsZero() || t.Before(s.lossTime) {
+			s.lossTime = t
+		}
+		kept = append(kept, p)
+	}
+	for i := len(kept); i < len(s.sent); i++ {
+		s.sent[i] = nil
+	}
+	s.sent = kept
+	for _, p := range lost {
+		if p.ackEliciting {
+			s.elicitingInFlt--
+		}
+		if p.inFlight {
+			c.cc.onLost(p, now)
+		}
+		c.requeueFrames(space, p)
+	}
+}
+
+// requeueFrames arranges for the information in the frames of a
+// lost packet to be sent again. See RFC 9000, Section 13.3.
+func (c *Conn) requeueFrames(space numberSpace, p *sentPacket) {
+	for _, f := range p.frames {
+		switch f.typ {
+		case frameTypeCrypto:
+			c.spaces[space].cryptoSend.markLost(f.start, f.end)
+		case frameTypeStreamBase:
+			if s := c.streams[f.id]; s != nil {
+				s.onDataLost(f.start, f.end, f.fin)
+			}
+		case frameTypeResetStream:
+			if s := c.streams[f.id]; s != nil && !s.resetAcked {
+				s.resetPending = true
+				c.queueStream(s)
+			}
+		case frameTypeStopSending:
+			if s := c.streams[f.id]; s != nil && !s.recvDone() {
+				s.stopPending = true
+				c.queueStream(s)
+			}
+		case frameTypeMaxStreamData:
+			if s := c.streams[f.id]; s != nil && !s.recvDone() {
+				s.maxDataPending = true
+				c.queueStream(s)
+			}
+		case frameTypeMaxData:
+			c.sendMaxData = true
+		case frameTypeMaxStreamsBidi:
+			c.sendMaxStreams[0] = true
+		case frameTypeMaxStreamsUni:
+			c.sendMaxStreams[1] = true
+		case frameTypeHandshakeDone:
+			c.sendHandshakeDone = true
+		}
+	}
+}
+
+// lossDetectionDeadline returns the time of the next loss detection
+// timer event, or the zero time if none is set. See RFC 9002,
+// Appendix A.8.
+func (c *Conn) lossDetectionDeadline() time.Time {
+	if c.state != stateOpen {
+		return time.Time{}
+	}
+	var t time.Time
+	for i := range c.spaces {
+		if lt := c.spaces[i].lossTime; !lt.IsZero() && (t.IsZero() || lt.Before(t)) {
+			t = lt
+		}
+	}
+	if !t.IsZero() {
+		return t
+	}
+	t, _ = c.ptoDeadline()
+	return t
+}
+
+// ptoDeadline returns the time the probe timeout expires, and the
+// space to send probes in.
+func (c *Conn) ptoDeadline() (time.Time, numberSpace) {
+	if !c.isClient && !c.addrValidated && c.bytesSent >= 3*c.bytesRecvd {
+		// Blocked by the anti-amplification limit.
+		return time.Time{}, 0
+	}
+	d := c.rtt.pto() << c.ptoCount
+	var t time.Time
+	var space numberSpace
+	inFlight := false
+	for i := range c.spaces {
+		s := &c.spaces[i]
+		if s.elicitingInFlt == 0 || !c.canWrite(numberSpace(i)) {
+			continue
+		}
+		inFlight = true
+		if numberSpace(i) == appDataSpace {
+			if !c.handshakeConfirmed {
+				continue
+			}
+			d += c.peerParams.maxAckDelay << c.ptoCount
+		}
+		if pt := s.lastAckEliciting.Add(d); t.IsZero() || pt.Before(t) {
+			t, space = pt, numberSpace(i)
+		}
+	}
+	if !inFlight && c.isClient && !c.handshakeConfirmed {
+		// The client must keep probing until the handshake is
+		// confirmed, to avoid deadlock if the server is blocked by
+		// the anti-amplification limit. See RFC 9002, Section 6.2.2.1.
+		space = initialSpace
+		if c.canWrite(handshakeSpace) {
+			space = handshakeSpace
+		}
+		last := c.spaces[space].lastAckEliciting
+		if last.IsZero() {
+			last = c.lastActivity
+		}
+		t = last.Add(d)
+	}
+	return t, space
+}
+
+// onLossDetectionTimeout handles the expiry of the loss detection
+// timer. See RFC 9002, Appendix A.9.
+func (c *Conn) onLossDetectionTimeout(now time.Time) {
+	for i := range c.spaces {
+		if lt := c.spaces[i].lossTime; !lt.IsZero() && !now.Before(lt) {
+			c.detectLostPackets(now, numberSpace(i))
+			return
+		}
+	}
+	_, space := c.ptoDeadline()
+	s := &c.spaces[space]
+	c.ptoCount++
+	s.probes = 2
+	// Send unacknowledged data in the probes, if there is any.
+	n := 0
+	for _, p := range s.sent {
+		if p.ackEliciting && n < 2 {
+			c.requeueFrames(space, p)
+			n++
+		}
+	}
+	// Record the probe time so the next deadline moves forward even
+	// if the probes cannot be sent.
+	s.lastAckEliciting = now
+}
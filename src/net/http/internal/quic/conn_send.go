@@ -0,0 +1,393 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import "time"
+
+// A packetBuild records a packet being assembled in a datagram.
+// Packets are protected once the datagram is complete, since the last
+// packet may need padding.
+type packetBuild struct {
+	space      numberSpace
+	start      int // offset of the packet in the datagram
+	lenOff     int // offset of the Length field, or -1 for a short header
+	pnOff      int
+	pnLen      int
+	payloadEnd int
+	pn         int64
+	key        *packetKey
+	sent       *sentPacket
+}
+
+// sendDatagrams sends as many datagrams as the connection's state,
+// congestion control and flow control allow.
+func (c *Conn) sendDatagrams(now time.Time) {
+	for {
+		b := c.buildDatagram(now)
+		if b == nil {
+			return
+		}
+		c.endpoint.writeTo(b, c.peerAddr)
+	}
+}
+
+// buildDatagram returns the next datagram to send, or nil if there
+// is nothing to send.
+func (c *Conn) buildDatagram(now time.Time) []byte {
+	if c.state == stateDraining || (c.state == stateClosing && !c.sendClose) {
+		return nil
+	}
+	limit := maxDatagramSize
+	if !c.isClient && !c.addrValidated {
+		// Limit the data sent to an unvalidated address to three
+		// times the data received. See RFC 9000, Section 8.
+		if room := 3*c.bytesRecvd - c.bytesSent; room < int64(limit) {
+			limit = int(room)
+		}
+	}
+	if limit < 64 {
+		return nil
+	}
+	allowData := c.cc.canSend(maxDatagramSize)
+	b := make([]byte, 0, maxDatagramSize)
+	var pkts [numberSpaceCount]packetBuild
+	n := 0
+	pad := false
+	for space := initialSpace; space < numberSpaceCount; space++ {
+		if !c.canWrite(space) {
+			continue
+		}
+		var ok bool
+		b, pkts[n], ok = c.appendPacket(b, now, space, limit, allowData)
+		if !ok {
+			continue
+		}
+		if space == initialSpace && (c.isClient || pkts[n].sent.ackEliciting) {
+			// Datagrams carrying Initial packets must be padded.
+			// See RFC 9000, Section 14.1.
+			pad = true
+		}
+		n++
+	}
+	if c.state == stateClosing {
+		c.sendClose = false
+	}
+	if n == 0 {
+		return nil
+	}
+	if pad && len(b) < maxDatagramSize && maxDatagramSize <= limit {
+		// Add PADDING frames to the last packet, before its AEAD tag.
+		extra := maxDatagramSize - len(b)
+		last := &pkts[n-1]
+		b = b[:maxDatagramSize]
+		for i := last.payloadEnd; i < len(b); i++ {
+			b[i] = 0
+		}
+		last.payloadEnd += extra
+		last.sent.inFlight = true
+	}
+	sentHandshake := false
+	for i := 0; i < n; i++ {
+		pb := &pkts[i]
+		end := pb.payloadEnd + aeadTagLen
+		if pb.lenOff >= 0 {
+			appendVarint2(b[pb.lenOff:pb.lenOff], uint64(end-pb.pnOff))
+		}
+		pb.key.protect(b[pb.start:pb.payloadEnd], pb.pnOff-pb.start, pb.pnLen, pb.pn)
+		p := pb.sent
+		p.time = now
+		p.size = end - pb.start
+		s := &c.spaces[pb.space]
+		if p.ackEliciting {
+			p.inFlight = true
+			s.elicitingInFlt++
+			s.lastAckEliciting = now
+		}
+		if p.inFlight {
+			c.cc.onSent(p.size)
+		}
+		if p.inFlight || len(p.frames) > 0 {
+			s.sent = append(s.sent, p)
+		}
+		if pb.space == handshakeSpace {
+			sentHandshake = true
+		}
+	}
+	c.bytesSent += int64(len(b))
+	if sentHandshake && c.isClient {
+		// The client stops using Initial keys when it first sends a
+		// Handshake packet. See RFC 9001, Section 4.9.1.
+		c.discardKeys(now, initialSpace)
+	}
+	return b
+}
+
+// appendPacket appends an unprotected packet in space to b,
+// which must not grow beyond limit bytes.
+func (c *Conn) appendPacket(b []byte, now time.Time, space numberSpace, limit int, allowData bool) ([]byte, packetBuild, bool) {
+	s := &c.spaces[space]
+	pn := s.nextPN
+	pnLen := packetNumberLength(pn, s.largestAcked)
+	pb := packetBuild{
+		space: space,
+		start: len(b),
+		pn:    pn,
+		pnLen: pnLen,
+	}
+	if space == appDataSpace {
+		b = append(b, fixedBit|c.app.phase|byte(pnLen-1))
+		b = append(b, c.peerID...)
+		pb.lenOff = -1
+		pb.key = &c.app.w
+	} else {
+		typ := byte(packetTypeInitial)
+		if space == handshakeSpace {
+			typ = packetTypeHandshake
+		}
+		b = append(b, headerFormLong|fixedBit|typ<<4|byte(pnLen-1))
+		b = append(b, 0, 0, 0, version1)
+		b = append(b, byte(len(c.peerID)))
+		b = append(b, c.peerID...)
+		b = append(b, byte(len(c.localID)))
+		b = append(b, c.localID...)
+		if space == initialSpace {
+			b = appendVarint(b, 0) // token
+		}
+		pb.lenOff = len(b)
+		b = append(b, 0, 0)
+		pb.key = &s.wkey
+	}
+	pb.pnOff = len(b)
+	b = appendPacketNumber(b, pn, pnLen)
+	payloadStart := len(b)
+	max := limit - aeadTagLen
+	if max-payloadStart < 32 {
+		return b[:pb.start], pb, false
+	}
+	p := &sentPacket{pn: pn}
+	b = c.appendFrames(b, now, space, max, allowData, p)
+	if len(b) == payloadStart {
+		return b[:pb.start], pb, false
+	}
+	// Header protection samples 16 bytes starting 4 bytes after the
+	// start of the packet number. See RFC 9001, Section 5.4.2.
+	for len(b)-pb.pnOff < 4 {
+		b = append(b, frameTypePadding)
+	}
+	pb.payloadEnd = len(b)
+	b = append(b, make([]byte, aeadTagLen)...)
+	s.nextPN++
+	pb.sent = p
+	return b, pb, true
+}
+
+// appendFrames appends the frames to send in space to b, up to max
+// bytes, recording them in p.
+func (c *Conn) appendFrames(b []byte, now time.Time, space numberSpace, max int, allowData bool, p *sentPacket) []byte {
+	s := &c.spaces[space]
+	ackSent := false
+	if s.ackEliciting > 0 && (s.ackNow || !now.Before(s.ackDeadline)) {
+		b, ackSent = c.appendAck(b, now, space, max, p)
+	}
+	if c.state == stateClosing {
+		if space == appDataSpace || !c.closeIsApp {
+			b = appendConnectionCloseFrame(b, c.closeIsApp, c.closeCode, c.closeReason)
+		} else {
+			// Application errors are not sent before the handshake
+			// completes. See RFC 9000, Section 10.2.3.
+			b = appendConnectionCloseFrame(b, false, 0x0c, "")
+		}
+		return b
+	}
+	if !allowData && s.probes == 0 {
+		return b
+	}
+
+	for s.cryptoSend.hasData() {
+		room := max - len(b) - 1 - 8 - 4
+		if room <= 0 {
+			break
+		}
+		start, end, _ := s.cryptoSend.nextRange(room)
+		b = appendCryptoFrameHeader(b, start, int(end-start))
+		b = append(b, s.cryptoSend.data(start, end)...)
+		s.cryptoSend.markSent(start, end)
+		p.frames = append(p.frames, sentFrame{typ: frameTypeCrypto, start: start, end: end})
+		p.ackEliciting = true
+	}
+
+	if space == appDataSpace {
+		if c.sendHandshakeDone && len(b) < max {
+			b = append(b, frameTypeHandshakeDone)
+			p.frames = append(p.frames, sentFrame{typ: frameTypeHandshakeDone})
+			p.ackEliciting = true
+			c.sendHandshakeDone = false
+		}
+		for len(c.pathResponses) > 0 && max-len(b) >= 9 {
+			b = append(b, frameTypePathResponse)
+			b = append(b, c.pathResponses[0][:]...)
+			c.pathResponses = c.pathResponses[1:]
+			p.ackEliciting = true
+		}
+		if c.sendMaxData && max-len(b) >= 9 {
+			b = append(b, frameTypeMaxData)
+			b = appendVarint(b, uint64(c.localMaxData))
+			p.frames = append(p.frames, sentFrame{typ: frameTypeMaxData})
+			p.ackEliciting = true
+			c.sendMaxData = false
+		}
+		for dir := 0; dir < 2; dir++ {
+			if c.sendMaxStreams[dir] && max-len(b) >= 9 {
+				typ := byte(frameTypeMaxStreamsBidi + dir)
+				b = append(b, typ)
+				b = appendVarint(b, uint64(c.localMaxStreams[dir]))
+				p.frames = append(p.frames, sentFrame{typ: typ})
+				p.ackEliciting = true
+				c.sendMaxStreams[dir] = false
+			}
+		}
+		b = c.appendStreamFrames(b, max, p)
+		if c.pingPending && !p.ackEliciting {
+			b = append(b, frameTypePing)
+			p.ackEliciting = true
+		}
+		c.pingPending = false
+	}
+
+	if s.probes > 0 {
+		if !p.ackEliciting {
+			b = append(b, frameTypePing)
+			p.ackEliciting = true
+		}
+		s.probes--
+	}
+	if !ackSent && p.ackEliciting && s.ackEliciting > 0 {
+		b, _ = c.appendAck(b, now, space, max, p)
+	}
+	return b
+}
+
+// appendAck appends an ACK frame for the packets received in space,
+// if it fits within max bytes.
+func (c *Conn) appendAck(b []byte, now time.Time, space numberSpace, max int, p *sentPacket) ([]byte, bool) {
+	s := &c.spaces[space]
+	if s.recvd.isEmpty() {
+		return b, false
+	}
+	var delay time.Duration
+	if space == appDataSpace {
+		delay = now.Sub(s.recvdTime)
+	}
+	n := len(b)
+	b = appendAckFrame(b, s.recvd, delay)
+	if len(b) > max {
+		return b[:n], false
+	}
+	p.frames = append(p.frames, sentFrame{typ: frameTypeAck, largest: s.largestRecvd})
+	s.ackEliciting = 0
+	s.ackNow = false
+	s.ackDeadline = time.Time{}
+	return b, true
+}
+
+// appendStreamFrames appends STREAM and stream control frames for
+// the streams with data to send, in round-robin order.
+func (c *Conn) appendStreamFrames(b []byte, max int, p *sentPacket) []byte {
+	for n := len(c.sendQueue); n > 0 && len(c.sendQueue) > 0; n-- {
+		s := c.sendQueue[0]
+		var full bool
+		b, full = c.appendStream(b, max, s, p)
+		if full {
+			break
+		}
+		c.sendQueue = c.sendQueue[1:]
+		if s.hasSendWork() {
+			c.sendQueue = append(c.sendQueue, s)
+		} else {
+			s.queued = false
+		}
+	}
+	return b
+}
+
+// appendStream appends the frames to send for s, reporting whether
+// the packet is full.
+func (c *Conn) appendStream(b []byte, max int, s *Stream, p *sentPacket) ([]byte, bool) {
+	const ctlSize = 1 + 8 + 8 + 8
+	if s.resetPending {
+		if max-len(b) < ctlSize {
+			return b, true
+		}
+		b = append(b, frameTypeResetStream)
+		b = appendVarint(b, uint64(s.id))
+		b = appendVarint(b, s.resetCode)
+		b = appendVarint(b, uint64(s.resetSize))
+		p.frames = append(p.frames, sentFrame{typ: frameTypeResetStream, id: s.id})
+		p.ackEliciting = true
+		s.resetPending = false
+	}
+	if s.stopPending {
+		if max-len(b) < ctlSize {
+			return b, true
+		}
+		b = append(b, frameTypeStopSending)
+		b = appendVarint(b, uint64(s.id))
+		b = appendVarint(b, s.stopCode)
+		p.frames = append(p.frames, sentFrame{typ: frameTypeStopSending, id: s.id})
+		p.ackEliciting = true
+		s.stopPending = false
+	}
+	if s.maxDataPending {
+		if max-len(b) < ctlSize {
+			return b, true
+		}
+		b = append(b, frameTypeMaxStreamData)
+		b = appendVarint(b, uint64(s.id))
+		b = appendVarint(b, uint64(s.recvMax))
+		p.frames = append(p.frames, sentFrame{typ: frameTypeMaxStreamData, id: s.id})
+		p.ackEliciting = true
+		s.maxDataPending = false
+	}
+	if s.sendReset {
+		return b, false
+	}
+	start, end, resend := s.send.nextRange(1 << 30)
+	if !resend {
+		// New data is limited by stream and connection flow control.
+		limit := s.sendMax
+		if connLimit := s.send.next + c.peerMaxData - c.sentData; connLimit < limit {
+			limit = connLimit
+		}
+		if end > limit {
+			end = limit
+		}
+		if end < start {
+			end = start
+		}
+	}
+	room := max - len(b) - streamFrameOverhead(s.id, start, int(end-start))
+	if room <= 0 {
+		return b, s.send.hasData() || (s.finQueued && !s.finSent)
+	}
+	if end-start > int64(room) {
+		end = start + int64(room)
+	}
+	fin := s.finQueued && !s.finSent && end == s.send.end()
+	if end == start && !fin {
+		return b, false
+	}
+	b = appendStreamFrameHeader(b, s.id, start, int(end-start), fin)
+	b = append(b, s.send.data(start, end)...)
+	if end > s.send.next {
+		c.sentData += end - s.send.next
+	}
+	s.send.markSent(start, end)
+	if fin {
+		s.finSent = true
+	}
+	p.frames = append(p.frames, sentFrame{typ: frameTypeStreamBase, id: s.id, start: start, end: end, fin: fin})
+	p.ackEliciting = true
+	return b, false
+}
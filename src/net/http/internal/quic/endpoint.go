@@ -0,0 +1,241 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// An Endpoint handles QUIC traffic on a network address.
+// It can accept inbound connections or create outbound ones.
+//
+// Multiple goroutines may invoke methods on an Endpoint simultaneously.
+type Endpoint struct {
+	pc     net.PacketConn
+	config *Config // for accepted connections; nil if not listening
+
+	acceptq  chan *Conn
+	closec   chan struct{} // closed when Close is called
+	readDone chan struct{} // closed when the read loop exits
+
+	mu      sync.Mutex
+	conns   map[string]*Conn // by connection ID
+	all     map[*Conn]bool
+	closing bool
+}
+
+// Listen listens on a local network address. The config is used for
+// connections accepted by the endpoint; if it is nil, the endpoint
+// only dials outbound connections.
+func Listen(network, address string, config *Config) (*Endpoint, error) {
+	if config != nil && config.TLSConfig == nil {
+		return nil, errors.New("quic: Config.TLSConfig is nil")
+	}
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewEndpoint(pc, config), nil
+}
+
+// NewEndpoint returns an endpoint handling QUIC traffic on pc, which
+// it takes ownership of. The config is used as in Listen; it must have
+// a non-nil TLSConfig if it is not nil.
+func NewEndpoint(pc net.PacketConn, config *Config) *Endpoint {
+	e := &Endpoint{
+		pc:       pc,
+		config:   config,
+		acceptq:  make(chan *Conn, 32),
+		closec:   make(chan struct{}),
+		readDone: make(chan struct{}),
+		conns:    make(map[string]*Conn),
+		all:      make(map[*Conn]bool),
+	}
+	go e.readLoop()
+	return e
+}
+
+// LocalAddr returns the local network address.
+func (e *Endpoint) LocalAddr() net.Addr {
+	return e.pc.LocalAddr()
+}
+
+// Accept waits for and returns the next connection to the endpoint,
+// after its handshake completes.
+func (e *Endpoint) Accept(ctx context.Context) (*Conn, error) {
+	select {
+	case c := <-e.acceptq:
+		return c, nil
+	case <-e.closec:
+		return nil, errEndpointClose
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Dial creates and returns a connection to a network address,
+// after its handshake completes.
+func (e *Endpoint) Dial(ctx context.Context, network, address string, config *Config) (*Conn, error) {
+	if config == nil || config.TLSConfig == nil {
+		return nil, errors.New("quic: Config.TLSConfig is nil")
+	}
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newConn(e, config, true, addr, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.addConn(c, c.localID); err != nil {
+		c.tls.Close()
+		return nil, err
+	}
+	go c.loop()
+	if err := c.waitHandshake(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the endpoint. Its connections are closed with the
+// NO_ERROR code, without waiting for the peers to acknowledge that.
+// Close waits for the connections to finish, or for ctx to be done.
+func (e *Endpoint) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closing {
+		e.closing = true
+		close(e.closec)
+	}
+	conns := make([]*Conn, 0, len(e.all))
+	for c := range e.all {
+		conns = append(conns, c)
+	}
+	e.mu.Unlock()
+	var err error
+	for _, c := range conns {
+		c.closeForEndpoint()
+	}
+	for _, c := range conns {
+		select {
+		case <-c.donec:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+	}
+	if cerr := e.pc.Close(); err == nil {
+		err = cerr
+	}
+	<-e.readDone
+	return err
+}
+
+func (e *Endpoint) addConn(c *Conn, ids ...[]byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closing {
+		return errEndpointClose
+	}
+	for _, id := range ids {
+		e.conns[string(id)] = c
+	}
+	e.all[c] = true
+	return nil
+}
+
+func (e *Endpoint) removeConn(c *Conn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, cc := range e.conns {
+		if cc == c {
+			delete(e.conns, id)
+		}
+	}
+	delete(e.all, c)
+}
+
+// enqueueAccept makes a connection whose handshake has completed
+// available to Accept. It reports false if too many are waiting.
+func (e *Endpoint) enqueueAccept(c *Conn) bool {
+	select {
+	case e.acceptq <- c:
+		return true
+	default:
+		return false
+	}
+}
+
+func (e *Endpoint) writeTo(b []byte, addr net.Addr) {
+	// Write errors are treated as packet loss.
+	e.pc.WriteTo(b, addr)
+}
+
+func (e *Endpoint) readLoop() {
+	defer close(e.readDone)
+	for {
+		buf := make([]byte, maxRecvDatagramSize)
+		n, addr, err := e.pc.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		e.handleDatagram(buf[:n], addr)
+	}
+}
+
+func (e *Endpoint) handleDatagram(b []byte, addr net.Addr) {
+	id, ok := dstConnID(b)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	c := e.conns[string(id)]
+	closing := e.closing
+	e.mu.Unlock()
+	if c == nil {
+		if closing {
+			return
+		}
+		c = e.newServerConn(b, addr)
+		if c == nil {
+			return
+		}
+	}
+	select {
+	case c.recvc <- b:
+	default:
+		// The connection is not keeping up; drop the datagram.
+	}
+}
+
+// newServerConn creates a connection for a client's first Initial
+// packet. See RFC 9000, Section 14.1.
+func (e *Endpoint) newServerConn(b []byte, addr net.Addr) *Conn {
+	if e.config == nil || len(b) < maxDatagramSize {
+		return nil
+	}
+	h, ok := parseLongHeader(b)
+	if !ok || h.typ != packetTypeInitial || len(h.dstID) < 8 {
+		return nil
+	}
+	c, err := newConn(e, e.config, false, addr, h.dstID, h.srcID)
+	if err != nil {
+		return nil
+	}
+	if err := e.addConn(c, c.localID, c.origDstID); err != nil {
+		c.tls.Close()
+		return nil
+	}
+	go c.loop()
+	return c
+}
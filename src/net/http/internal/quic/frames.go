@@ -0,0 +1,175 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import "time"
+
+// Frame types. See RFC 9000, Section 19.
+const (
+	frameTypePadding                    = 0x00
+	frameTypePing                       = 0x01
+	frameTypeAck                        = 0x02
+	frameTypeAckECN                     = 0x03
+	frameTypeResetStream                = 0x04
+	frameTypeStopSending                = 0x05
+	frameTypeCrypto                     = 0x06
+	frameTypeNewToken                   = 0x07
+	frameTypeStreamBase                 = 0x08 // 0x08-0x0f
+	frameTypeMaxData                    = 0x10
+	frameTypeMaxStreamData              = 0x11
+	frameTypeMaxStreamsBidi             = 0x12
+	frameTypeMaxStreamsUni              = 0x13
+	frameTypeDataBlocked                = 0x14
+	frameTypeStreamDataBlocked          = 0x15
+	frameTypeStreamsBlockedBidi         = 0x16
+	frameTypeStreamsBlockedUni          = 0x17
+	frameTypeNewConnectionID            = 0x18
+	frameTypeRetireConnectionID         = 0x19
+	frameTypePathChallenge              = 0x1a
+	frameTypePathResponse               = 0x1b
+	frameTypeConnectionCloseTransport   = 0x1c
+	frameTypeConnectionCloseApplication = 0x1d
+	frameTypeHandshakeDone              = 0x1e
+)
+
+// Bits in the STREAM frame type.
+const (
+	streamFinBit = 0x01
+	streamLenBit = 0x02
+	streamOffBit = 0x04
+)
+
+func appendAckFrame(b []byte, recvd rangeset, delay time.Duration) []byte {
+	b = append(b, frameTypeAck)
+	last := len(recvd) - 1
+	largest := recvd[last].end - 1
+	b = appendVarint(b, uint64(largest))
+	b = appendVarint(b, uint64(delay/time.Microsecond)>>ackDelayExponent)
+	b = appendVarint(b, uint64(last))
+	b = appendVarint(b, uint64(largest-recvd[last].start))
+	for i := last - 1; i >= 0; i-- {
+		// gap is the number of unacknowledged packets between the
+		// smallest in the previous range and the largest in this one,
+		// minus one. See RFC 9000, Section 19.3.1.
+		gap := recvd[i+1].start - recvd[i].end - 1
+		b = appendVarint(b, uint64(gap))
+		b = appendVarint(b, uint64(recvd[i].end-1-recvd[i].start))
+	}
+	return b
+}
+
+// parseAckFrame parses the ACK frame at the start of b, returning the
+// acknowledged packet number ranges in ascending order.
+func parseAckFrame(b []byte) (acked rangeset, delay uint64, n int) {
+	off := 1
+	largest, m := consumeVarintInt64(b[off:])
+	if m < 0 {
+		return nil, 0, -1
+	}
+	off += m
+	delay, m = consumeVarint(b[off:])
+	if m < 0 {
+		return nil, 0, -1
+	}
+	off += m
+	count, m := consumeVarint(b[off:])
+	if m < 0 {
+		return nil, 0, -1
+	}
+	off += m
+	first, m := consumeVarintInt64(b[off:])
+	if m < 0 || first > largest {
+		return nil, 0, -1
+	}
+	off += m
+	end := largest + 1
+	start := largest - first
+	acked = append(acked, span{start, end})
+	for i := uint64(0); i < count; i++ {
+		gap, m := consumeVarintInt64(b[off:])
+		if m < 0 {
+			return nil, 0, -1
+		}
+		off += m
+		length, m := consumeVarintInt64(b[off:])
+		if m < 0 {
+			return nil, 0, -1
+		}
+		off += m
+		end = start - gap - 1
+		start = end - 1 - length
+		if start < 0 || end <= start {
+			return nil, 0, -1
+		}
+		acked = append(acked, span{start, end})
+	}
+	if b[0] == frameTypeAckECN {
+		for i := 0; i < 3; i++ {
+			_, m := consumeVarint(b[off:])
+			if m < 0 {
+				return nil, 0, -1
+			}
+			off += m
+		}
+	}
+	// Reverse into ascending order.
+	for i, j := 0, len(acked)-1; i < j; i, j = i+1, j-1 {
+		acked[i], acked[j] = acked[j], acked[i]
+	}
+	return acked, delay, off
+}
+
+// appendStreamFrameHeader appends the header of a STREAM frame carrying
+// size bytes of data at offset off.
+func appendStreamFrameHeader(b []byte, id, off int64, size int, fin bool) []byte {
+	typ := byte(frameTypeStreamBase | streamLenBit)
+	if off > 0 {
+		typ |= streamOffBit
+	}
+	if fin {
+		typ |= streamFinBit
+	}
+	b = append(b, typ)
+	b = appendVarint(b, uint64(id))
+	if off > 0 {
+		b = appendVarint(b, uint64(off))
+	}
+	return appendVarint(b, uint64(size))
+}
+
+// streamFrameOverhead returns the maximum size of a STREAM frame header.
+func streamFrameOverhead(id, off int64, size int) int {
+	return 1 + sizeVarint(uint64(id)) + sizeVarint(uint64(off)) + sizeVarint(uint64(size))
+}
+
+func appendCryptoFrameHeader(b []byte, off int64, size int) []byte {
+	b = append(b, frameTypeCrypto)
+	b = appendVarint(b, uint64(off))
+	return appendVarint(b, uint64(size))
+}
+
+func appendConnectionCloseFrame(b []byte, isApp bool, code uint64, reason string) []byte {
+	if isApp {
+		b = append(b, frameTypeConnectionCloseApplication)
+		b = appendVarint(b, code)
+	} else {
+		b = append(b, frameTypeConnectionCloseTransport)
+		b = appendVarint(b, code)
+		b = appendVarint(b, 0) // frame type
+	}
+	b = appendVarint(b, uint64(len(reason)))
+	return append(b, reason...)
+}
+
+// frameAllowedBeforeOneRTT reports whether a frame of type typ may be
+// sent in an Initial or Handshake packet. See RFC 9000, Section 12.4.
+func frameAllowedBeforeOneRTT(typ uint64) bool {
+	switch typ {
+	case frameTypePadding, frameTypePing, frameTypeAck, frameTypeAckECN,
+		frameTypeCrypto, frameTypeConnectionCloseTransport:
+		return true
+	}
+	return false
+}
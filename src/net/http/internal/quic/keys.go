@@ -0,0 +1,268 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"hash"
+
+	"internal/x/crypto/chacha20poly1305"
+	"internal/x/crypto/hkdf"
+)
+
+// initialSalt is the salt used to derive Initial packet protection keys.
+// See RFC 9001, Section 5.2.
+var initialSalt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+
+const aeadTagLen = 16
+
+// headerProtectionSampleLen is the length of the ciphertext sample used
+// for header protection. See RFC 9001, Section 5.4.2.
+const headerProtectionSampleLen = 16
+
+// A packetKey protects packets at one encryption level in one direction.
+type packetKey struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   headerProtection
+}
+
+func (k *packetKey) isSet() bool {
+	return k.aead != nil
+}
+
+// A headerProtection computes header protection masks.
+type headerProtection interface {
+	mask(sample []byte) [5]byte
+}
+
+type aesHeaderProtection struct {
+	block cipher.Block
+}
+
+func (h aesHeaderProtection) mask(sample []byte) (m [5]byte) {
+	var buf [aes.BlockSize]byte
+	h.block.Encrypt(buf[:], sample)
+	copy(m[:], buf[:])
+	return m
+}
+
+type chachaHeaderProtection struct {
+	key [8]uint32
+}
+
+func (h chachaHeaderProtection) mask(sample []byte) (m [5]byte) {
+	var nonce [3]uint32
+	for i := range nonce {
+		nonce[i] = binary.LittleEndian.Uint32(sample[4+4*i:])
+	}
+	block := chachaBlock(&h.key, binary.LittleEndian.Uint32(sample), &nonce)
+	copy(m[:], block[:])
+	return m
+}
+
+// A cipherSuite describes the packet protection for a TLS 1.3 cipher suite.
+type cipherSuite struct {
+	hash   crypto.Hash
+	keyLen int
+}
+
+func suiteByID(id uint16) (cipherSuite, bool) {
+	switch id {
+	case tls.TLS_AES_128_GCM_SHA256:
+		return cipherSuite{crypto.SHA256, 16}, true
+	case tls.TLS_AES_256_GCM_SHA384:
+		return cipherSuite{crypto.SHA384, 32}, true
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		return cipherSuite{crypto.SHA256, 32}, true
+	}
+	return cipherSuite{}, false
+}
+
+func (s cipherSuite) newHash() func() hash.Hash {
+	if s.hash == crypto.SHA384 {
+		return sha512.New384
+	}
+	return sha256.New
+}
+
+// newPacketKey derives a packet protection key from a traffic secret.
+// See RFC 9001, Section 5.1.
+func newPacketKey(suiteID uint16, secret []byte) (packetKey, error) {
+	s, ok := suiteByID(suiteID)
+	if !ok {
+		return packetKey{}, errors.New("quic: unsupported cipher suite")
+	}
+	h := s.newHash()
+	key := hkdfExpandLabel(h, secret, "quic key", s.keyLen)
+	iv := hkdfExpandLabel(h, secret, "quic iv", 12)
+	hpKey := hkdfExpandLabel(h, secret, "quic hp", s.keyLen)
+	var k packetKey
+	k.iv = iv
+	if suiteID == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return packetKey{}, err
+		}
+		k.aead = aead
+		var hp chachaHeaderProtection
+		for i := range hp.key {
+			hp.key[i] = binary.LittleEndian.Uint32(hpKey[4*i:])
+		}
+		k.hp = hp
+		return k, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return packetKey{}, err
+	}
+	k.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return packetKey{}, err
+	}
+	hpBlock, err := aes.NewCipher(hpKey)
+	if err != nil {
+		return packetKey{}, err
+	}
+	k.hp = aesHeaderProtection{hpBlock}
+	return k, nil
+}
+
+// nextTrafficSecret returns the secret for the next key phase.
+// See RFC 9001, Section 6.1.
+func nextTrafficSecret(suiteID uint16, secret []byte) []byte {
+	s, _ := suiteByID(suiteID)
+	return hkdfExpandLabel(s.newHash(), secret, "quic ku", len(secret))
+}
+
+// initialKeys returns the client and server Initial packet protection
+// keys for the client's original destination connection ID.
+// See RFC 9001, Section 5.2.
+func initialKeys(connID []byte) (client, server packetKey) {
+	initialSecret := hkdf.Extract(sha256.New, connID, initialSalt)
+	clientSecret := hkdfExpandLabel(sha256.New, initialSecret, "client in", sha256.Size)
+	serverSecret := hkdfExpandLabel(sha256.New, initialSecret, "server in", sha256.Size)
+	client, _ = newPacketKey(tls.TLS_AES_128_GCM_SHA256, clientSecret)
+	server, _ = newPacketKey(tls.TLS_AES_128_GCM_SHA256, serverSecret)
+	return client, server
+}
+
+// hkdfExpandLabel implements HKDF-Expand-Label from RFC 8446, Section 7.1,
+// with an empty context.
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	const prefix = "tls13 "
+	info := make([]byte, 0, 4+len(prefix)+len(label))
+	info = append(info, byte(length>>8), byte(length))
+	info = append(info, byte(len(prefix)+len(label)))
+	info = append(info, prefix...)
+	info = append(info, label...)
+	info = append(info, 0)
+	out := make([]byte, length)
+	if _, err := hkdf.Expand(h, secret, info).Read(out); err != nil {
+		panic("quic: HKDF-Expand-Label invocation failed unexpectedly")
+	}
+	return out
+}
+
+func (k *packetKey) nonce(pn int64) []byte {
+	nonce := make([]byte, len(k.iv))
+	copy(nonce, k.iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * uint(i)))
+	}
+	return nonce
+}
+
+// protect encrypts the payload of the packet in b, whose header (including
+// the packet number) ends at pnOff+pnLen, and applies header protection.
+// b must have room for the AEAD tag, which protect appends.
+func (k *packetKey) protect(b []byte, pnOff, pnLen int, pn int64) []byte {
+	hdr := b[:pnOff+pnLen]
+	payload := b[pnOff+pnLen:]
+	b = k.aead.Seal(hdr, k.nonce(pn), payload, hdr)
+	sample := b[pnOff+4:][:headerProtectionSampleLen]
+	mask := k.hp.mask(sample)
+	if isLongHeader(b[0]) {
+		b[0] ^= mask[0] & 0x0f
+	} else {
+		b[0] ^= mask[0] & 0x1f
+	}
+	for i := 0; i < pnLen; i++ {
+		b[pnOff+i] ^= mask[1+i]
+	}
+	return b
+}
+
+// unprotectHeader removes header protection from the packet in b, whose
+// packet number starts at pnOff, returning the truncated packet number
+// and its length.
+func (k *packetKey) unprotectHeader(b []byte, pnOff int) (truncated int64, pnLen int, ok bool) {
+	if len(b) < pnOff+4+headerProtectionSampleLen {
+		return 0, 0, false
+	}
+	mask := k.hp.mask(b[pnOff+4:][:headerProtectionSampleLen])
+	if isLongHeader(b[0]) {
+		b[0] ^= mask[0] & 0x0f
+	} else {
+		b[0] ^= mask[0] & 0x1f
+	}
+	pnLen = int(b[0]&0x03) + 1
+	for i := 0; i < pnLen; i++ {
+		b[pnOff+i] ^= mask[1+i]
+		truncated = truncated<<8 | int64(b[pnOff+i])
+	}
+	return truncated, pnLen, true
+}
+
+// open decrypts the payload of the packet in b, whose header protection
+// has been removed, in place.
+func (k *packetKey) open(b []byte, hdrLen int, pn int64) ([]byte, error) {
+	return k.aead.Open(b[hdrLen:hdrLen], k.nonce(pn), b[hdrLen:], b[:hdrLen])
+}
+
+// chachaBlock returns a ChaCha20 key stream block. See RFC 8439, Section 2.3.
+func chachaBlock(key *[8]uint32, counter uint32, nonce *[3]uint32) (out [64]byte) {
+	s := [16]uint32{
+		0x61707865, 0x3320646e, 0x79622d32, 0x6b206574,
+		key[0], key[1], key[2], key[3], key[4], key[5], key[6], key[7],
+		counter, nonce[0], nonce[1], nonce[2],
+	}
+	x := s
+	qr := func(a, b, c, d int) {
+		x[a] += x[b]
+		x[d] ^= x[a]
+		x[d] = x[d]<<16 | x[d]>>16
+		x[c] += x[d]
+		x[b] ^= x[c]
+		x[b] = x[b]<<12 | x[b]>>20
+		x[a] += x[b]
+		x[d] ^= x[a]
+		x[d] = x[d]<<8 | x[d]>>24
+		x[c] += x[d]
+		x[b] ^= x[c]
+		x[b] = x[b]<<7 | x[b]>>25
+	}
+	for i := 0; i < 10; i++ {
+		qr(0, 4, 8, 12)
+		qr(1, 5, 9, 13)
+		qr(2, 6, 10, 14)
+		qr(3, 7, 11, 15)
+		qr(0, 5, 10, 15)
+		qr(1, 6, 11, 12)
+		qr(2, 7, 8, 13)
+		qr(3, 4, 9, 14)
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[4*i:], x[i]+s[i])
+	}
+	return out
+}
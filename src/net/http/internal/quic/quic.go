@@ -0,0 +1,239 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package quic implements the QUIC transport protocol, version 1,
+// as specified in RFC 9000, RFC 9001 and RFC 9002, to the extent
+// needed by net/http's HTTP/3 support.
+//
+// An Endpoint sends and receives datagrams on a net.PacketConn and
+// demultiplexes them to the connections it has dialed or accepted.
+// A Conn carries any number of Streams, which are ordered, reliable
+// byte streams with their own flow control.
+//
+// The implementation is intentionally minimal: it uses a fixed
+// datagram size of 1200 bytes, issues a single connection ID per
+// connection, and does not support connection migration, 0-RTT data,
+// Retry, stateless resets or version negotiation.
+package quic
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+)
+
+// version1 is QUIC version 1. See RFC 9000, Section 15.
+const version1 = 0x00000001
+
+const (
+	// maxDatagramSize is the size of the datagrams we send. It is the
+	// smallest maximum datagram size every QUIC path must support.
+	// See RFC 9000, Section 14.
+	maxDatagramSize = 1200
+
+	// maxRecvDatagramSize is the size of the largest datagram we
+	// accept, advertised in the max_udp_payload_size parameter.
+	maxRecvDatagramSize = 1500
+
+	// connIDLen is the length of the connection IDs we issue.
+	connIDLen = 8
+
+	// maxAckDelay is the maximum time we delay acknowledgements
+	// of ack-eliciting 1-RTT packets.
+	maxAckDelay = 25 * time.Millisecond
+
+	// ackDelayExponent scales the ACK Delay field of the ACK frames we
+	// send. See RFC 9000, Section 18.2.
+	ackDelayExponent = 3
+
+	// maxStreamWriteBuffer is the amount of unacknowledged data
+	// a Stream buffers before Write blocks.
+	maxStreamWriteBuffer = 512 << 10
+)
+
+// A Config structure is used to configure a QUIC endpoint or connection.
+// A Config may be reused; the quic package will not modify it.
+type Config struct {
+	// TLSConfig is the TLS configuration for connections. It must be
+	// non-nil, and must include the ALPN protocols in NextProtos.
+	// Connections always use TLS 1.3.
+	TLSConfig *tls.Config
+
+	// MaxBidiRemoteStreams limits the number of simultaneous
+	// bidirectional streams a peer may open. If zero, a default of
+	// 100 is used.
+	MaxBidiRemoteStreams int64
+
+	// MaxUniRemoteStreams limits the number of simultaneous
+	// unidirectional streams a peer may open. If zero, a default of
+	// 100 is used.
+	MaxUniRemoteStreams int64
+
+	// MaxStreamReadBufferSize is the maximum amount of data sent by a
+	// peer on a stream that is buffered before the application reads it.
+	// If zero, a default of 1 MiB is used.
+	MaxStreamReadBufferSize int64
+
+	// MaxConnReadBufferSize is the maximum amount of data sent by a
+	// peer on a connection that is buffered before the application
+	// reads it. If zero, a default of 1 MiB is used.
+	MaxConnReadBufferSize int64
+
+	// MaxIdleTimeout is the maximum time after which an idle connection
+	// is closed. If zero, a default of 30 seconds is used.
+	MaxIdleTimeout time.Duration
+
+	// KeepAlivePeriod is the time after which a packet is sent to keep
+	// an idle connection alive. If zero, no keep-alive packets are sent.
+	KeepAlivePeriod time.Duration
+}
+
+func (c *Config) maxBidiRemoteStreams() int64 {
+	if c.MaxBidiRemoteStreams > 0 {
+		return c.MaxBidiRemoteStreams
+	}
+	return 100
+}
+
+func (c *Config) maxUniRemoteStreams() int64 {
+	if c.MaxUniRemoteStreams > 0 {
+		return c.MaxUniRemoteStreams
+	}
+	return 100
+}
+
+func (c *Config) maxStreamReadBufferSize() int64 {
+	if c.MaxStreamReadBufferSize > 0 {
+		return c.MaxStreamReadBufferSize
+	}
+	return 1 << 20
+}
+
+func (c *Config) maxConnReadBufferSize() int64 {
+	if c.MaxConnReadBufferSize > 0 {
+		return c.MaxConnReadBufferSize
+	}
+	return 1 << 20
+}
+
+func (c *Config) maxIdleTimeout() time.Duration {
+	if c.MaxIdleTimeout > 0 {
+		return c.MaxIdleTimeout
+	}
+	return 30 * time.Second
+}
+
+// A transportError is a QUIC transport error code.
+// See RFC 9000, Section 20.1.
+type transportError uint64
+
+const (
+	errNo                 = transportError(0x00)
+	errInternal           = transportError(0x01)
+	errConnectionRefused  = transportError(0x02)
+	errFlowControl        = transportError(0x03)
+	errStreamLimit        = transportError(0x04)
+	errStreamState        = transportError(0x05)
+	errFinalSize          = transportError(0x06)
+	errFrameEncoding      = transportError(0x07)
+	errTransportParameter = transportError(0x08)
+	errProtocolViolation  = transportError(0x0a)
+	errKeyUpdateError     = transportError(0x0e)
+	errTLSBase            = transportError(0x0100) // 0x0100-0x01ff; base + TLS alert code
+)
+
+func (e transportError) String() string {
+	switch e {
+	case errNo:
+		return "NO_ERROR"
+	case errInternal:
+		return "INTERNAL_ERROR"
+	case errConnectionRefused:
+		return "CONNECTION_REFUSED"
+	case errFlowControl:
+		return "FLOW_CONTROL_ERROR"
+	case errStreamLimit:
+		return "STREAM_LIMIT_ERROR"
+	case errStreamState:
+		return "STREAM_STATE_ERROR"
+	case errFinalSize:
+		return "FINAL_SIZE_ERROR"
+	case errFrameEncoding:
+		return "FRAME_ENCODING_ERROR"
+	case errTransportParameter:
+		return "TRANSPORT_PARAMETER_ERROR"
+	case errProtocolViolation:
+		return "PROTOCOL_VIOLATION"
+	case errKeyUpdateError:
+		return "KEY_UPDATE_ERROR"
+	}
+	if e >= errTLSBase && e < errTLSBase+0x100 {
+		return fmt.Sprintf("CRYPTO_ERROR(%v)", uint64(e-errTLSBase))
+	}
+	return fmt.Sprintf("ERROR %d", uint64(e))
+}
+
+// A localTransportError is a transport error detected locally,
+// which closes the connection.
+type localTransportError struct {
+	code   transportError
+	reason string
+}
+
+func (e localTransportError) Error() string {
+	if e.reason == "" {
+		return "quic: " + e.code.String()
+	}
+	return "quic: " + e.code.String() + ": " + e.reason
+}
+
+// A PeerTransportError is a transport error closing the connection,
+// reported by the peer.
+type PeerTransportError struct {
+	Code   uint64
+	Reason string
+}
+
+func (e *PeerTransportError) Error() string {
+	return fmt.Sprintf("quic: peer closed connection: %v: %q", transportError(e.Code), e.Reason)
+}
+
+// An ApplicationError is an application protocol error code closing
+// a connection, sent in a CONNECTION_CLOSE frame. It is returned by
+// Conn and Stream methods when the peer closes the connection, and is
+// sent by Conn.CloseWithError to close it with a specific code.
+type ApplicationError struct {
+	Code   uint64
+	Reason string
+}
+
+func (e *ApplicationError) Error() string {
+	return fmt.Sprintf("quic: application error %#x: %q", e.Code, e.Reason)
+}
+
+// A StreamErrorCode is an application protocol error code sent in a
+// RESET_STREAM or STOP_SENDING frame. Stream reads return it when the
+// peer resets the stream, and writes return it when the peer asks us
+// to stop sending.
+type StreamErrorCode uint64
+
+func (e StreamErrorCode) Error() string {
+	return fmt.Sprintf("quic: stream error code %#x", uint64(e))
+}
+
+var (
+	errIdleTimeout   = timeoutError{}
+	errConnClosed    = errorString("quic: connection closed")
+	errEndpointClose = errorString("quic: endpoint closed")
+)
+
+type errorString string
+
+func (e errorString) Error() string { return string(e) }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "quic: idle timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,485 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/internal"
+	"sync"
+	"testing"
+	"time"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Test vectors from RFC 9001, Appendix A.
+
+func TestInitialKeys(t *testing.T) {
+	client, server := initialKeys(unhex("8394c8f03e515708"))
+	tests := []struct {
+		name   string
+		k      packetKey
+		iv     string
+		sample string
+		mask   string
+	}{
+		{"client", client, "fa044b2f42a3fd3b46fb255c", "d1b1c98dd7689fb8ec11d242b123dc9b", "437b9aec36"},
+		{"server", server, "0ac1493ca1905853b0bba03e", "2cd0991cd25b0aac406a5816b6394100", "2ec0d8356a"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(tt.k.iv); got != tt.iv {
+			t.Errorf("%s iv = %s; want %s", tt.name, got, tt.iv)
+		}
+		m := tt.k.hp.mask(unhex(tt.sample))
+		if got := hex.EncodeToString(m[:]); got != tt.mask {
+			t.Errorf("%s header protection mask = %s; want %s", tt.name, got, tt.mask)
+		}
+	}
+}
+
+func TestChaCha20PacketProtection(t *testing.T) {
+	secret := unhex("9ac312a7f877468ebe69422748ad00a15443f18203a07d6060f688f30f21632b")
+	k, err := newPacketKey(tls.TLS_CHACHA20_POLY1305_SHA256, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(k.iv), "e0459b3474bdd0e44a41c144"; got != want {
+		t.Errorf("iv = %s; want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(nextTrafficSecret(tls.TLS_CHACHA20_POLY1305_SHA256, secret)),
+		"1223504755036d556342ee9361d253421a826c9ecdf3c7148684b36b714881f9"; got != want {
+		t.Errorf("next secret = %s; want %s", got, want)
+	}
+
+	const pn = 654360564
+	b := make([]byte, 0, 5+aeadTagLen)
+	b = append(b, 0x42, 0x00, 0xbf, 0xf4, 0x01)
+	b = k.protect(b, 1, 3, pn)
+	if got, want := hex.EncodeToString(b), "4cfe4189655e5cd55c41f69080575d7999c25a5bfb"; got != want {
+		t.Fatalf("protected packet = %s; want %s", got, want)
+	}
+	truncated, pnLen, ok := k.unprotectHeader(b, 1)
+	if !ok || pnLen != 3 || decodePacketNumber(pn-1, truncated, pnLen) != pn {
+		t.Fatalf("unprotectHeader = %#x, %v, %v; want packet number %#x", truncated, pnLen, ok, pn)
+	}
+	payload, err := k.open(b, 1+pnLen, pn)
+	if err != nil || !bytes.Equal(payload, []byte{0x01}) {
+		t.Fatalf("open = %x, %v; want 01", payload, err)
+	}
+}
+
+func TestVarint(t *testing.T) {
+	tests := []struct {
+		enc string
+		v   uint64
+	}{
+		{"c2197c5eff14e88c", 151288809941952652},
+		{"9d7f3e7d", 494878333},
+		{"7bbd", 15293},
+		{"25", 37},
+	}
+	for _, tt := range tests {
+		b := unhex(tt.enc)
+		v, n := consumeVarint(b)
+		if v != tt.v || n != len(b) {
+			t.Errorf("consumeVarint(%s) = %v, %v; want %v, %v", tt.enc, v, n, tt.v, len(b))
+		}
+		if got := hex.EncodeToString(appendVarint(nil, tt.v)); got != tt.enc {
+			t.Errorf("appendVarint(%v) = %s; want %s", tt.v, got, tt.enc)
+		}
+		if _, n := consumeVarint(b[:len(b)-1]); n >= 0 {
+			t.Errorf("consumeVarint(truncated %s) succeeded", tt.enc)
+		}
+	}
+}
+
+func TestDecodePacketNumber(t *testing.T) {
+	tests := []struct {
+		largest, truncated int64
+		n                  int
+		want               int64
+	}{
+		{0xa82f30ea, 0x9b32, 2, 0xa82f9b32}, // RFC 9000, Appendix A.3
+		{-1, 0, 1, 0},
+		{0xff, 0x01, 1, 0x101},
+		{0x100, 0xff, 1, 0xff},
+	}
+	for _, tt := range tests {
+		if got := decodePacketNumber(tt.largest, tt.truncated, tt.n); got != tt.want {
+			t.Errorf("decodePacketNumber(%#x, %#x, %v) = %#x; want %#x", tt.largest, tt.truncated, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestRangeset(t *testing.T) {
+	var s rangeset
+	s.add(10, 20)
+	s.add(30, 40)
+	s.add(20, 25)
+	s.add(0, 5)
+	s.sub(32, 35)
+	want := rangeset{{0, 5}, {10, 25}, {30, 32}, {35, 40}}
+	if len(s) != len(want) {
+		t.Fatalf("rangeset = %v; want %v", s, want)
+	}
+	for i := range s {
+		if s[i] != want[i] {
+			t.Fatalf("rangeset = %v; want %v", s, want)
+		}
+	}
+	if !s.contains(24) || s.contains(25) || s.contains(33) {
+		t.Errorf("contains gave wrong results for %v", s)
+	}
+	if s.min() != 0 || s.max() != 39 {
+		t.Errorf("min, max = %v, %v; want 0, 39", s.min(), s.max())
+	}
+}
+
+func TestAckFrame(t *testing.T) {
+	recvd := rangeset{{0, 3}, {5, 6}, {10, 20}}
+	b := appendAckFrame(nil, recvd, 8*time.Microsecond<<ackDelayExponent)
+	acked, delay, n := parseAckFrame(b)
+	if n != len(b) || delay != 8 {
+		t.Fatalf("parseAckFrame = _, %v, %v; want _, 8, %v", delay, n, len(b))
+	}
+	if len(acked) != len(recvd) {
+		t.Fatalf("acked = %v; want %v", acked, recvd)
+	}
+	for i := range acked {
+		if acked[i] != recvd[i] {
+			t.Fatalf("acked = %v; want %v", acked, recvd)
+		}
+	}
+}
+
+func TestTransportParameters(t *testing.T) {
+	p := defaultTransportParameters()
+	p.originalDstConnID = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	p.initialSrcConnID = []byte{9, 10}
+	p.maxIdleTimeout = 5 * time.Second
+	p.initialMaxData = 1 << 20
+	p.initialMaxStreamsBidi = 10
+	p.disableActiveMigration = true
+	got, err := unmarshalTransportParameters(p.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.originalDstConnID, p.originalDstConnID) || !bytes.Equal(got.initialSrcConnID, p.initialSrcConnID) ||
+		got.maxIdleTimeout != p.maxIdleTimeout || got.initialMaxData != p.initialMaxData ||
+		got.initialMaxStreamsBidi != p.initialMaxStreamsBidi || !got.disableActiveMigration {
+		t.Errorf("round trip = %+v; want %+v", got, p)
+	}
+	if _, err := unmarshalTransportParameters([]byte{paramMaxUDPPayloadSize, 2, 0x40, 100}); err == nil {
+		t.Error("max_udp_payload_size of 100 accepted")
+	}
+}
+
+func testTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	cert, err := tls.X509KeyPair(internal.LocalhostCert, internal.LocalhostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"test"},
+		MinVersion:   tls.VersionTLS13,
+	}
+	client = &tls.Config{
+		RootCAs:    pool,
+		ServerName: "example.com",
+		NextProtos: []string{"test"},
+		MinVersion: tls.VersionTLS13,
+	}
+	return server, client
+}
+
+// lossyConn is a net.PacketConn which drops some datagrams it sends.
+type lossyConn struct {
+	net.PacketConn
+	mu    sync.Mutex
+	n     int
+	every int
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	c.n++
+	drop := c.n%c.every == 0
+	c.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func newTestEndpoints(t *testing.T, config *Config, dropEvery int) (server, client *Endpoint) {
+	listen := func(config *Config) *Endpoint {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dropEvery > 0 {
+			pc = &lossyConn{PacketConn: pc, every: dropEvery}
+		}
+		return NewEndpoint(pc, config)
+	}
+	return listen(config), listen(nil)
+}
+
+func closeEndpoints(t *testing.T, endpoints ...*Endpoint) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, e := range endpoints {
+		if err := e.Close(ctx); err != nil {
+			t.Errorf("Endpoint.Close: %v", err)
+		}
+	}
+}
+
+// testConnPair returns a connected client and server.
+func testConnPair(t *testing.T, config *Config, dropEvery int) (client, server *Conn, closeAll func()) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	sconfig := *config
+	sconfig.TLSConfig = serverTLS
+	cconfig := *config
+	cconfig.TLSConfig = clientTLS
+	se, ce := newTestEndpoints(t, &sconfig, dropEvery)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := ce.Dial(ctx, "udp", se.LocalAddr().String(), &cconfig)
+	if err != nil {
+		closeEndpoints(t, se, ce)
+		t.Fatalf("Dial: %v", err)
+	}
+	server, err = se.Accept(ctx)
+	if err != nil {
+		closeEndpoints(t, se, ce)
+		t.Fatalf("Accept: %v", err)
+	}
+	return client, server, func() { closeEndpoints(t, ce, se) }
+}
+
+func TestConnHandshake(t *testing.T) {
+	client, server, closeAll := testConnPair(t, &Config{}, 0)
+	defer closeAll()
+	for _, c := range []*Conn{client, server} {
+		cs := c.ConnectionState()
+		if cs.Version != tls.VersionTLS13 || cs.NegotiatedProtocol != "test" || !cs.HandshakeComplete {
+			t.Errorf("ConnectionState = %+v; want TLS 1.3 with protocol \"test\"", cs)
+		}
+	}
+}
+
+// echo copies each stream accepted by c back to its sender.
+func echo(c *Conn) {
+	for {
+		s, err := c.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(s, s)
+			s.CloseWrite()
+		}()
+	}
+}
+
+func testEcho(t *testing.T, client *Conn, streams, size int) {
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		s, err := client.NewStream(context.Background())
+		if err != nil {
+			t.Fatalf("NewStream: %v", err)
+		}
+		data := make([]byte, size)
+		for j := range data {
+			data[j] = byte(i + j)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Write(data); err != nil {
+				t.Errorf("Write: %v", err)
+			}
+			s.CloseWrite()
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := ioutil.ReadAll(s)
+			if err != nil {
+				t.Errorf("stream %v: ReadAll: %v", s.ID(), err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("stream %v: read %v bytes, not the %v written", s.ID(), len(got), len(data))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConnStreams(t *testing.T) {
+	client, server, closeAll := testConnPair(t, &Config{}, 0)
+	defer closeAll()
+	go echo(server)
+	testEcho(t, client, 5, 300<<10)
+}
+
+func TestConnFlowControl(t *testing.T) {
+	// Windows smaller than the data force flow control updates,
+	// and a low stream limit forces MAX_STREAMS updates.
+	config := &Config{
+		MaxStreamReadBufferSize: 8 << 10,
+		MaxConnReadBufferSize:   16 << 10,
+		MaxBidiRemoteStreams:    2,
+	}
+	client, server, closeAll := testConnPair(t, config, 0)
+	defer closeAll()
+	go echo(server)
+	testEcho(t, client, 6, 100<<10)
+}
+
+func TestConnPacketLoss(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	client, server, closeAll := testConnPair(t, &Config{}, 7)
+	defer closeAll()
+	go echo(server)
+	testEcho(t, client, 3, 100<<10)
+}
+
+func TestStreamReset(t *testing.T) {
+	client, server, closeAll := testConnPair(t, &Config{}, 0)
+	defer closeAll()
+	ctx := context.Background()
+	s, err := client.NewStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write([]byte("hello"))
+	ss, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Reset(42)
+	for {
+		_, err := ss.Read(make([]byte, 10))
+		if err == nil {
+			continue
+		}
+		if err != StreamErrorCode(42) {
+			t.Errorf("Read after reset: %v; want StreamErrorCode(42)", err)
+		}
+		break
+	}
+
+	// STOP_SENDING is answered with RESET_STREAM, failing writes.
+	s.StopSending(7)
+	var werr error
+	for i := 0; i < 1000 && werr == nil; i++ {
+		_, werr = ss.Write(make([]byte, 1024))
+		time.Sleep(time.Millisecond)
+	}
+	if werr != StreamErrorCode(7) {
+		t.Errorf("Write after STOP_SENDING: %v; want StreamErrorCode(7)", werr)
+	}
+}
+
+func TestStreamWaitAckedAndPeerAborted(t *testing.T) {
+	client, server, closeAll := testConnPair(t, &Config{}, 3)
+	defer closeAll()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, err := client.NewStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write(make([]byte, 100<<10))
+	s.CloseWrite()
+	ss, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, ss); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WaitAcked(ctx); err != nil {
+		t.Fatalf("WaitAcked: %v", err)
+	}
+
+	select {
+	case <-ss.PeerAborted():
+		t.Fatal("PeerAborted closed before any reset")
+	default:
+	}
+	s.StopSending(5)
+	select {
+	case <-ss.PeerAborted():
+	case <-ctx.Done():
+		t.Fatal("PeerAborted not closed after STOP_SENDING")
+	}
+	if err := ss.WaitAcked(ctx); err != StreamErrorCode(5) {
+		t.Errorf("WaitAcked after STOP_SENDING: %v; want StreamErrorCode(5)", err)
+	}
+}
+
+func TestConnClose(t *testing.T) {
+	client, server, closeAll := testConnPair(t, &Config{}, 0)
+	defer closeAll()
+	ctx := context.Background()
+	client.CloseWithError(0x10c, "going away")
+	_, err := server.AcceptStream(ctx)
+	if ae, ok := err.(*ApplicationError); !ok || ae.Code != 0x10c || ae.Reason != "going away" {
+		t.Errorf("server AcceptStream after close: %v; want ApplicationError 0x10c", err)
+	}
+	if _, err := client.NewStream(ctx); err != errConnClosed {
+		t.Errorf("client NewStream after close: %v; want %v", err, errConnClosed)
+	}
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	client, server, closeAll := testConnPair(t, &Config{MaxIdleTimeout: 100 * time.Millisecond}, 0)
+	defer closeAll()
+	select {
+	case <-client.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("client connection did not time out")
+	}
+	<-server.Done()
+	if err, ok := client.Err().(net.Error); !ok || !err.Timeout() {
+		t.Errorf("client.Err() = %v; want timeout", client.Err())
+	}
+}
+
+func TestConnKeepAlive(t *testing.T) {
+	config := &Config{MaxIdleTimeout: 200 * time.Millisecond, KeepAlivePeriod: 50 * time.Millisecond}
+	client, _, closeAll := testConnPair(t, config, 0)
+	defer closeAll()
+	select {
+	case <-client.Done():
+		t.Fatalf("connection closed despite keep-alives: %v", client.Err())
+	case <-time.After(600 * time.Millisecond):
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+// A span is the half-open range [start, end).
+type span struct {
+	start, end int64
+}
+
+// A rangeset is a sorted set of non-overlapping, non-adjacent spans.
+// It records received packet numbers and sent, acknowledged or lost
+// ranges of stream data.
+type rangeset []span
+
+// add adds [start, end) to the set.
+func (s *rangeset) add(start, end int64) {
+	if start >= end {
+		return
+	}
+	r := *s
+	// Find the first span that ends at or after start.
+	i := 0
+	for i < len(r) && r[i].end < start {
+		i++
+	}
+	// Merge all spans that overlap or touch [start, end).
+	j := i
+	for j < len(r) && r[j].start <= end {
+		if r[j].start < start {
+			start = r[j].start
+		}
+		if r[j].end > end {
+			end = r[j].end
+		}
+		j++
+	}
+	if i == j {
+		r = append(r, span{})
+		copy(r[i+1:], r[i:])
+		r[i] = span{start, end}
+	} else {
+		r[i] = span{start, end}
+		r = append(r[:i+1], r[j:]...)
+	}
+	*s = r
+}
+
+// sub removes [start, end) from the set.
+func (s *rangeset) sub(start, end int64) {
+	if start >= end {
+		return
+	}
+	r := *s
+	var out rangeset
+	for _, sp := range r {
+		if sp.end <= start || sp.start >= end {
+			out = append(out, sp)
+			continue
+		}
+		if sp.start < start {
+			out = append(out, span{sp.start, start})
+		}
+		if sp.end > end {
+			out = append(out, span{end, sp.end})
+		}
+	}
+	*s = out
+}
+
+// contains reports whether v is in the set.
+func (s rangeset) contains(v int64) bool {
+	for _, sp := range s {
+		if v < sp.start {
+			return false
+		}
+		if v < sp.end {
+			return true
+		}
+	}
+	return false
+}
+
+// min returns the smallest value in the set, or 0 if it is empty.
+func (s rangeset) min() int64 {
+	if len(s) == 0 {
+		return 0
+	}
+	return s[0].start
+}
+
+// max returns the largest value in the set, or -1 if it is empty.
+func (s rangeset) max() int64 {
+	if len(s) == 0 {
+		return -1
+	}
+	return s[len(s)-1].end - 1
+}
+
+// isEmpty reports whether the set is empty.
+func (s rangeset) isEmpty() bool {
+	return len(s) == 0
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import "time"
+
+// Loss detection and congestion control, following RFC 9002.
+
+const (
+	packetThreshold  = 3                      // kPacketThreshold
+	timerGranularity = time.Millisecond       // kGranularity
+	initialRTT       = 333 * time.Millisecond // kInitialRtt
+
+	initialWindow = 10 * maxDatagramSize
+	minimumWindow = 2 * maxDatagramSize
+)
+
+// rttState estimates the round-trip time. See RFC 9002, Section 5.
+type rttState struct {
+	latest   time.Duration
+	smoothed time.Duration
+	variance time.Duration
+	min      time.Duration
+	sampled  bool
+}
+
+func (r *rttState) init() {
+	r.smoothed = initialRTT
+	r.variance = initialRTT / 2
+}
+
+// update updates the estimate with a new sample. ackDelay is the
+// peer-reported acknowledgement delay, already limited as required.
+func (r *rttState) update(sample, ackDelay time.Duration) {
+	r.latest = sample
+	if !r.sampled {
+		r.sampled = true
+		r.min = sample
+		r.smoothed = sample
+		r.variance = sample / 2
+		return
+	}
+	if sample < r.min {
+		r.min = sample
+	}
+	adjusted := sample
+	if sample >= r.min+ackDelay {
+		adjusted = sample - ackDelay
+	}
+	diff := r.smoothed - adjusted
+	if diff < 0 {
+		diff = -diff
+	}
+	r.variance = (3*r.variance + diff) / 4
+	r.smoothed = (7*r.smoothed + adjusted) / 8
+}
+
+// pto returns the base probe timeout. See RFC 9002, Section 6.2.1.
+func (r *rttState) pto() time.Duration {
+	v := 4 * r.variance
+	if v < timerGranularity {
+		v = timerGranularity
+	}
+	return r.smoothed + v
+}
+
+// lossDelay returns the time threshold for declaring a packet lost.
+// See RFC 9002, Section 6.1.2.
+func (r *rttState) lossDelay() time.Duration {
+	d := r.smoothed
+	if r.latest > d {
+		d = r.latest
+	}
+	d = d * 9 / 8
+	if d < timerGranularity {
+		d = timerGranularity
+	}
+	return d
+}
+
+// congestion implements the NewReno congestion controller from
+// RFC 9002, Section 7.
+type congestion struct {
+	window        int
+	inFlight      int
+	ssthresh      int
+	recoveryStart time.Time
+}
+
+func (c *congestion) init() {
+	c.window = initialWindow
+	c.ssthresh = 1<<31 - 1
+}
+
+// canSend reports whether a packet of size bytes may be sent.
+func (c *congestion) canSend(size int) bool {
+	return c.inFlight+size <= c.window
+}
+
+func (c *congestion) onSent(size int) {
+	c.inFlight += size
+}
+
+func (c *congestion) onAcked(p *sentPacket) {
+	c.inFlight -= p.size
+	if !p.time.After(c.recoveryStart) {
+		return // in recovery
+	}
+	if c.window < c.ssthresh {
+		c.window += p.size
+	} else {
+		c.window += maxDatagramSize * p.size / c.window
+	}
+}
+
+func (c *congestion) onLost(p *sentPacket, now time.Time) {
+	c.inFlight -= p.size
+	if !p.time.After(c.recoveryStart) {
+		return // already in recovery
+	}
+	c.recoveryStart = now
+	c.ssthresh = c.window / 2
+	if c.ssthresh < minimumWindow {
+		c.ssthresh = minimumWindow
+	}
+	c.window = c.ssthresh
+}
+
+// discard removes a packet from the bytes in flight without
+// acknowledging it or declaring it lost, as when its keys are discarded.
+func (c *congestion) discard(p *sentPacket) {
+	c.inFlight -= p.size
+}
+
+// A sentPacket records a packet we sent, until it is acknowledged
+// or declared lost.
+type sentPacket struct {
+	pn           int64
+	time         time.Time
+	size         int
+	ackEliciting bool
+	inFlight     bool
+	frames       []sentFrame
+}
+
+// A sentFrame records a frame whose loss must be repaired.
+type sentFrame struct {
+	typ        byte  // frame type; frameTypeStreamBase for all STREAM frames
+	id         int64 // stream ID
+	start, end int64 // CRYPTO or STREAM data range
+	fin        bool
+	largest    int64 // for ACK frames, the largest packet number acknowledged
+}
@@ -0,0 +1,666 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// A Stream is an ordered byte stream within a QUIC connection.
+// See RFC 9000, Section 2.
+//
+// Streams may be bidirectional or unidirectional. Read and Write
+// may be called concurrently with each other, but not with
+// themselves.
+type Stream struct {
+	conn      *Conn
+	id        int64
+	readWake  chan struct{}
+	writeWake chan struct{}
+	abortc    chan struct{} // closed by peerAborted
+
+	// The remaining fields are guarded by conn.mu.
+
+	// Receive side.
+	noRecv         bool // send-only stream
+	recv           recvBuffer
+	recvMax        int64 // flow control limit we advertised
+	recvHighest    int64 // highest offset received
+	consumedTo     int64 // offset credited to connection flow control
+	finalSize      int64 // -1 until known
+	recvReset      bool
+	recvResetCode  uint64
+	readClosed     bool
+	stopPending    bool
+	stopCode       uint64
+	maxDataPending bool
+
+	// Send side.
+	noSend       bool // receive-only stream
+	send         sendBuffer
+	sendMax      int64 // flow control limit set by the peer
+	finQueued    bool
+	finSent      bool
+	finAcked     bool
+	sendReset    bool
+	resetPending bool
+	resetAcked   bool
+	resetCode    uint64
+	resetSize    int64
+	peerStopped  bool
+	peerStopCode uint64
+
+	queued        bool // in conn.sendQueue
+	removed       bool // removed from conn.streams
+	abortNotified bool // abortc closed
+}
+
+var (
+	errWriteClosed = errors.New("quic: write to closed stream")
+	errWriteReset  = errors.New("quic: write to reset stream")
+	errReadClosed  = errors.New("quic: read from closed stream")
+	errSendOnly    = errors.New("quic: read from send-only stream")
+	errRecvOnly    = errors.New("quic: write to receive-only stream")
+)
+
+// ID returns the stream ID.
+func (s *Stream) ID() int64 {
+	return s.id
+}
+
+// NewStream opens a new bidirectional stream, blocking until the
+// peer's stream limit allows it, the context is done, or the
+// connection closes.
+func (c *Conn) NewStream(ctx context.Context) (*Stream, error) {
+	return c.newLocalStream(ctx, false)
+}
+
+// NewSendOnlyStream opens a new unidirectional stream.
+func (c *Conn) NewSendOnlyStream(ctx context.Context) (*Stream, error) {
+	return c.newLocalStream(ctx, true)
+}
+
+func (c *Conn) newLocalStream(ctx context.Context, uni bool) (*Stream, error) {
+	dir := 0
+	if uni {
+		dir = 1
+	}
+	for {
+		c.mu.Lock()
+		if c.closeErr != nil {
+			err := c.closeErr
+			c.mu.Unlock()
+			return nil, err
+		}
+		if c.handshakeDone && c.localOpened[dir] < c.peerMaxStreams[dir] {
+			id := c.localOpened[dir]<<2 | int64(dir)<<1
+			if !c.isClient {
+				id |= 1
+			}
+			c.localOpened[dir]++
+			if c.localOpened[dir] < c.peerMaxStreams[dir] {
+				notify(c.streamsWake)
+			}
+			s := c.newStream(id)
+			c.mu.Unlock()
+			return s, nil
+		}
+		c.mu.Unlock()
+		select {
+		case <-c.streamsWake:
+		case <-c.handshakec:
+		case <-c.closedc:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// AcceptStream waits for and returns the next stream opened by the peer.
+func (c *Conn) AcceptStream(ctx context.Context) (*Stream, error) {
+	for {
+		c.mu.Lock()
+		if len(c.acceptq) > 0 {
+			s := c.acceptq[0]
+			c.acceptq[0] = nil
+			c.acceptq = c.acceptq[1:]
+			if len(c.acceptq) > 0 {
+				notify(c.acceptWake)
+			}
+			c.mu.Unlock()
+			return s, nil
+		}
+		if c.closeErr != nil {
+			err := c.closeErr
+			c.mu.Unlock()
+			return nil, err
+		}
+		c.mu.Unlock()
+		select {
+		case <-c.acceptWake:
+		case <-c.closedc:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Conn) isLocalStream(id int64) bool {
+	return (id&1 == 0) == c.isClient
+}
+
+func (c *Conn) newStream(id int64) *Stream {
+	s := &Stream{
+		conn:      c,
+		id:        id,
+		readWake:  make(chan struct{}, 1),
+		writeWake: make(chan struct{}, 1),
+		abortc:    make(chan struct{}),
+		finalSize: -1,
+	}
+	p := &c.peerParams
+	uni := id&2 != 0
+	switch local := c.isLocalStream(id); {
+	case local && uni:
+		s.noRecv = true
+		s.sendMax = p.initialMaxStreamDataUni
+	case local:
+		s.sendMax = p.initialMaxStreamDataBidiRemote
+		s.recvMax = c.streamWindow
+	case uni:
+		s.noSend = true
+		s.recvMax = c.streamWindow
+	default:
+		s.sendMax = p.initialMaxStreamDataBidiLocal
+		s.recvMax = c.streamWindow
+	}
+	c.streams[id] = s
+	return s
+}
+
+// streamForFrame returns the stream a received frame refers to,
+// opening peer streams as needed. recvSide reports whether the frame
+// concerns the receive side of the stream. It returns nil if the
+// stream has completed.
+func (c *Conn) streamForFrame(id int64, recvSide bool) (*Stream, error) {
+	uni := id&2 != 0
+	dir := 0
+	if uni {
+		dir = 1
+	}
+	idx := id >> 2
+	if c.isLocalStream(id) {
+		if uni && recvSide {
+			return nil, localTransportError{errStreamState, "frame for send-only stream"}
+		}
+		if idx >= c.localOpened[dir] {
+			return nil, localTransportError{errStreamState, "frame for unopened stream"}
+		}
+		return c.streams[id], nil
+	}
+	if uni && !recvSide {
+		return nil, localTransportError{errStreamState, "frame for receive-only stream"}
+	}
+	if idx >= c.localMaxStreams[dir] {
+		return nil, localTransportError{errStreamLimit, "stream limit exceeded"}
+	}
+	for c.peerOpened[dir] <= idx {
+		sid := c.peerOpened[dir]<<2 | int64(dir)<<1
+		if c.isClient {
+			sid |= 1
+		}
+		c.peerOpened[dir]++
+		c.acceptq = append(c.acceptq, c.newStream(sid))
+		notify(c.acceptWake)
+	}
+	return c.streams[id], nil
+}
+
+func (c *Conn) queueStream(s *Stream) {
+	if !s.queued && !s.removed {
+		s.queued = true
+		c.sendQueue = append(c.sendQueue, s)
+	}
+}
+
+func (c *Conn) queueAllStreams() {
+	for _, s := range c.streams {
+		if s.hasSendWork() {
+			c.queueStream(s)
+		}
+	}
+}
+
+// consume credits n bytes of received data to connection flow control.
+func (c *Conn) consume(n int64) {
+	c.consumedData += n
+	if c.localMaxData-c.consumedData < c.connWindow/2 {
+		c.localMaxData = c.consumedData + c.connWindow
+		c.sendMaxData = true
+	}
+}
+
+// maybeRemoveStream forgets a stream once both of its sides complete,
+// allowing the peer to open another. See RFC 9000, Section 4.6.
+func (c *Conn) maybeRemoveStream(s *Stream) {
+	if s.removed || !s.recvDone() || !s.sendDone() {
+		return
+	}
+	s.removed = true
+	delete(c.streams, s.id)
+	if !c.isLocalStream(s.id) {
+		dir := int(s.id>>1) & 1
+		c.localMaxStreams[dir]++
+		c.sendMaxStreams[dir] = true
+	}
+}
+
+func (s *Stream) recvDone() bool {
+	return s.noRecv || s.recvReset || (s.finalSize >= 0 && (s.recv.off == s.finalSize || s.readClosed))
+}
+
+func (s *Stream) sendDone() bool {
+	return s.noSend || (s.finAcked && s.send.unacked() == 0) || (s.sendReset && s.resetAcked)
+}
+
+// hasSendWork reports whether the stream has frames to send.
+func (s *Stream) hasSendWork() bool {
+	if s.resetPending || s.stopPending || s.maxDataPending {
+		return true
+	}
+	if s.noSend || s.sendReset {
+		return false
+	}
+	if !s.send.lost.isEmpty() {
+		return true
+	}
+	if s.finQueued && !s.finSent && s.send.next == s.send.end() {
+		return true
+	}
+	c := s.conn
+	limit := s.sendMax
+	if connLimit := s.send.next + c.peerMaxData - c.sentData; connLimit < limit {
+		limit = connLimit
+	}
+	return s.send.next < s.send.end() && s.send.next < limit
+}
+
+// creditTo credits data up to offset off to connection flow control.
+func (s *Stream) creditTo(off int64) {
+	if off > s.consumedTo {
+		s.conn.consume(off - s.consumedTo)
+		s.consumedTo = off
+	}
+}
+
+// handleStreamFrame processes a STREAM frame.
+func (c *Conn) handleStreamFrame(id, off int64, data []byte, fin bool) error {
+	s, err := c.streamForFrame(id, true)
+	if s == nil {
+		return err
+	}
+	end := off + int64(len(data))
+	if s.finalSize >= 0 {
+		if end > s.finalSize || (fin && end != s.finalSize) {
+			return localTransportError{errFinalSize, "data beyond final size"}
+		}
+	} else if fin && end < s.recvHighest {
+		return localTransportError{errFinalSize, "final size below data received"}
+	}
+	if end > s.recvMax {
+		return localTransportError{errFlowControl, "stream flow control limit exceeded"}
+	}
+	if end > s.recvHighest {
+		c.recvdData += end - s.recvHighest
+		s.recvHighest = end
+		if c.recvdData > c.localMaxData {
+			return localTransportError{errFlowControl, "connection flow control limit exceeded"}
+		}
+	}
+	if fin {
+		s.finalSize = end
+	}
+	if s.recvReset || s.readClosed {
+		s.creditTo(s.recvHighest)
+		c.maybeRemoveStream(s)
+		return nil
+	}
+	s.recv.write(off, data)
+	notify(s.readWake)
+	return nil
+}
+
+// handleResetStream processes a RESET_STREAM frame.
+func (c *Conn) handleResetStream(id int64, code uint64, finalSize int64) error {
+	s, err := c.streamForFrame(id, true)
+	if s == nil {
+		return err
+	}
+	if (s.finalSize >= 0 && finalSize != s.finalSize) || finalSize < s.recvHighest {
+		return localTransportError{errFinalSize, "invalid final size in RESET_STREAM"}
+	}
+	if finalSize > s.recvMax {
+		return localTransportError{errFlowControl, "stream flow control limit exceeded"}
+	}
+	if s.recvReset {
+		return nil
+	}
+	c.recvdData += finalSize - s.recvHighest
+	if c.recvdData > c.localMaxData {
+		return localTransportError{errFlowControl, "connection flow control limit exceeded"}
+	}
+	s.recvHighest = finalSize
+	s.finalSize = finalSize
+	s.recvReset = true
+	s.recvResetCode = code
+	s.recv = recvBuffer{off: s.recv.off}
+	s.stopPending = false
+	s.maxDataPending = false
+	s.creditTo(finalSize)
+	notify(s.readWake)
+	s.peerAborted()
+	c.maybeRemoveStream(s)
+	return nil
+}
+
+// handleStopSending processes a STOP_SENDING frame, which we answer
+// with RESET_STREAM. See RFC 9000, Section 3.5.
+func (c *Conn) handleStopSending(id int64, code uint64) error {
+	s, err := c.streamForFrame(id, false)
+	if s == nil || s.peerStopped {
+		return err
+	}
+	s.peerStopped = true
+	s.peerStopCode = code
+	s.resetSend(code)
+	s.peerAborted()
+	return nil
+}
+
+// peerAborted closes the channel returned by PeerAborted.
+func (s *Stream) peerAborted() {
+	if !s.abortNotified {
+		s.abortNotified = true
+		close(s.abortc)
+	}
+}
+
+// handleMaxStreamData processes a MAX_STREAM_DATA frame.
+func (c *Conn) handleMaxStreamData(id, max int64) error {
+	s, err := c.streamForFrame(id, false)
+	if s == nil {
+		return err
+	}
+	if max > s.sendMax {
+		s.sendMax = max
+		if s.hasSendWork() {
+			c.queueStream(s)
+		}
+	}
+	return nil
+}
+
+// resetSend abandons the send side of the stream.
+func (s *Stream) resetSend(code uint64) {
+	if s.noSend || s.sendReset || s.sendDone() {
+		return
+	}
+	s.sendReset = true
+	s.resetPending = true
+	s.resetCode = code
+	s.resetSize = s.send.next
+	s.send = sendBuffer{}
+	s.conn.queueStream(s)
+	notify(s.writeWake)
+}
+
+func (s *Stream) onDataAcked(start, end int64, fin bool) {
+	if s.sendReset {
+		return
+	}
+	s.send.markAcked(start, end)
+	if fin {
+		s.finAcked = true
+	}
+	s.conn.maybeRemoveStream(s)
+	notify(s.writeWake)
+}
+
+func (s *Stream) onDataLost(start, end int64, fin bool) {
+	if s.sendReset {
+		return
+	}
+	s.send.markLost(start, end)
+	if fin && !s.finAcked {
+		s.finSent = false
+	}
+	s.conn.queueStream(s)
+}
+
+// windowUpdatePending reports whether flow control updates are waiting
+// to be sent.
+func (c *Conn) windowUpdatePending(s *Stream) bool {
+	return c.sendMaxData || c.sendMaxStreams[0] || c.sendMaxStreams[1] || s.maxDataPending
+}
+
+// Read reads data from the stream. It returns io.EOF after the peer
+// closes its side of the stream, and a StreamErrorCode if the peer
+// resets it.
+func (s *Stream) Read(b []byte) (int, error) {
+	if s.noRecv {
+		return 0, errSendOnly
+	}
+	c := s.conn
+	for {
+		c.mu.Lock()
+		if s.readClosed {
+			c.mu.Unlock()
+			return 0, errReadClosed
+		}
+		if s.recvReset {
+			c.mu.Unlock()
+			return 0, StreamErrorCode(s.recvResetCode)
+		}
+		if s.recv.readable() > 0 && len(b) > 0 {
+			n := s.recv.read(b)
+			s.creditTo(s.recv.off)
+			if s.finalSize < 0 && s.recvMax-s.recv.off < c.streamWindow/2 {
+				s.recvMax = s.recv.off + c.streamWindow
+				s.maxDataPending = true
+				c.queueStream(s)
+			}
+			c.maybeRemoveStream(s)
+			wake := c.windowUpdatePending(s)
+			c.mu.Unlock()
+			if wake {
+				c.wake()
+			}
+			return n, nil
+		}
+		if s.finalSize >= 0 && s.recv.off == s.finalSize {
+			c.maybeRemoveStream(s)
+			wake := c.windowUpdatePending(s)
+			c.mu.Unlock()
+			if wake {
+				c.wake()
+			}
+			return 0, io.EOF
+		}
+		if len(b) == 0 {
+			c.mu.Unlock()
+			return 0, nil
+		}
+		if err := c.closeErr; err != nil {
+			c.mu.Unlock()
+			return 0, err
+		}
+		c.mu.Unlock()
+		select {
+		case <-s.readWake:
+		case <-c.closedc:
+		}
+	}
+}
+
+// Write writes data to the stream, blocking while the stream's send
+// buffer is full.
+func (s *Stream) Write(b []byte) (int, error) {
+	if s.noSend {
+		return 0, errRecvOnly
+	}
+	c := s.conn
+	n := 0
+	for {
+		c.mu.Lock()
+		if err := s.writeErr(); err != nil {
+			c.mu.Unlock()
+			return n, err
+		}
+		if room := maxStreamWriteBuffer - s.send.unacked(); room > 0 && len(b) > 0 {
+			m := len(b)
+			if m > room {
+				m = room
+			}
+			s.send.write(b[:m])
+			b = b[m:]
+			n += m
+			if s.hasSendWork() {
+				c.queueStream(s)
+			}
+		}
+		c.mu.Unlock()
+		c.wake()
+		if len(b) == 0 {
+			return n, nil
+		}
+		select {
+		case <-s.writeWake:
+		case <-c.closedc:
+		}
+	}
+}
+
+func (s *Stream) writeErr() error {
+	switch {
+	case s.peerStopped:
+		return StreamErrorCode(s.peerStopCode)
+	case s.sendReset:
+		return errWriteReset
+	case s.finQueued:
+		return errWriteClosed
+	}
+	return s.conn.closeErr
+}
+
+// CloseWrite closes the send side of the stream, sending a FIN to the
+// peer after any buffered data.
+func (s *Stream) CloseWrite() error {
+	if s.noSend {
+		return nil
+	}
+	c := s.conn
+	c.mu.Lock()
+	if !s.sendReset && !s.finQueued {
+		s.finQueued = true
+		c.queueStream(s)
+	}
+	c.mu.Unlock()
+	c.wake()
+	return nil
+}
+
+// WaitAcked waits until the peer has acknowledged all data written
+// to the stream and the FIN sent by CloseWrite. It returns early with
+// an error if the send side is reset, the connection closes, or ctx
+// is done.
+func (s *Stream) WaitAcked(ctx context.Context) error {
+	if s.noSend {
+		return nil
+	}
+	c := s.conn
+	for {
+		c.mu.Lock()
+		switch {
+		case s.finAcked && s.send.unacked() == 0:
+			c.mu.Unlock()
+			return nil
+		case s.peerStopped:
+			c.mu.Unlock()
+			return StreamErrorCode(s.peerStopCode)
+		case s.sendReset:
+			c.mu.Unlock()
+			return errWriteReset
+		case c.closeErr != nil:
+			err := c.closeErr
+			c.mu.Unlock()
+			return err
+		}
+		c.mu.Unlock()
+		select {
+		case <-s.writeWake:
+		case <-c.closedc:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// PeerAborted returns a channel that is closed when the peer resets
+// the stream or asks us to stop sending on it. A server uses it to
+// notice that a client has abandoned a request while the handler is
+// neither reading nor writing.
+func (s *Stream) PeerAborted() <-chan struct{} {
+	return s.abortc
+}
+
+// Reset abandons the send side of the stream, sending RESET_STREAM
+// with the given application error code. Data not yet acknowledged by
+// the peer may not be delivered.
+func (s *Stream) Reset(code uint64) {
+	c := s.conn
+	c.mu.Lock()
+	s.resetSend(code)
+	c.mu.Unlock()
+	c.wake()
+}
+
+// StopSending closes the receive side of the stream, discarding
+// buffered data and, unless the peer has already finished sending,
+// asking it to stop with STOP_SENDING carrying the given application
+// error code.
+func (s *Stream) StopSending(code uint64) {
+	if s.noRecv {
+		return
+	}
+	c := s.conn
+	c.mu.Lock()
+	if s.readClosed {
+		c.mu.Unlock()
+		return
+	}
+	s.readClosed = true
+	if s.finalSize < 0 && !s.recvReset {
+		s.stopPending = true
+		s.stopCode = code
+		c.queueStream(s)
+	}
+	s.maxDataPending = false
+	s.recv = recvBuffer{off: s.recv.off}
+	s.creditTo(s.recvHighest)
+	c.maybeRemoveStream(s)
+	notify(s.readWake)
+	c.mu.Unlock()
+	c.wake()
+}
+
+// Close closes both sides of the stream: it sends a FIN after any
+// buffered data and discards unread data with STOP_SENDING code 0.
+func (s *Stream) Close() error {
+	s.CloseWrite()
+	s.StopSending(0)
+	return nil
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+import (
+	"bytes"
+	"time"
+)
+
+// transportParameters are the QUIC transport parameters exchanged in
+// the TLS handshake. See RFC 9000, Section 18.
+type transportParameters struct {
+	originalDstConnID              []byte
+	maxIdleTimeout                 time.Duration
+	statelessResetToken            []byte
+	maxUDPPayloadSize              int64
+	initialMaxData                 int64
+	initialMaxStreamDataBidiLocal  int64
+	initialMaxStreamDataBidiRemote int64
+	initialMaxStreamDataUni        int64
+	initialMaxStreamsBidi          int64
+	initialMaxStreamsUni           int64
+	ackDelayExponent               int64
+	maxAckDelay                    time.Duration
+	disableActiveMigration         bool
+	activeConnIDLimit              int64
+	initialSrcConnID               []byte
+	retrySrcConnID                 []byte
+}
+
+// Transport parameter IDs. See RFC 9000, Section 18.2.
+const (
+	paramOriginalDstConnID              = 0x00
+	paramMaxIdleTimeout                 = 0x01
+	paramStatelessResetToken            = 0x02
+	paramMaxUDPPayloadSize              = 0x03
+	paramInitialMaxData                 = 0x04
+	paramInitialMaxStreamDataBidiLocal  = 0x05
+	paramInitialMaxStreamDataBidiRemote = 0x06
+	paramInitialMaxStreamDataUni        = 0x07
+	paramInitialMaxStreamsBidi          = 0x08
+	paramInitialMaxStreamsUni           = 0x09
+	paramAckDelayExponent               = 0x0a
+	paramMaxAckDelay                    = 0x0b
+	paramDisableActiveMigration         = 0x0c
+	paramPreferredAddress               = 0x0d
+	paramActiveConnIDLimit              = 0x0e
+	paramInitialSrcConnID               = 0x0f
+	paramRetrySrcConnID                 = 0x10
+)
+
+func defaultTransportParameters() transportParameters {
+	return transportParameters{
+		maxUDPPayloadSize: 65527,
+		ackDelayExponent:  3,
+		maxAckDelay:       25 * time.Millisecond,
+		activeConnIDLimit: 2,
+	}
+}
+
+func appendParamVarint(b []byte, id, v uint64) []byte {
+	b = appendVarint(b, id)
+	b = appendVarint(b, uint64(sizeVarint(v)))
+	return appendVarint(b, v)
+}
+
+func appendParamBytes(b []byte, id uint64, v []byte) []byte {
+	b = appendVarint(b, id)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func (p *transportParameters) marshal() []byte {
+	var b []byte
+	if p.originalDstConnID != nil {
+		b = appendParamBytes(b, paramOriginalDstConnID, p.originalDstConnID)
+	}
+	if p.maxIdleTimeout > 0 {
+		b = appendParamVarint(b, paramMaxIdleTimeout, uint64(p.maxIdleTimeout/time.Millisecond))
+	}
+	b = appendParamVarint(b, paramMaxUDPPayloadSize, uint64(p.maxUDPPayloadSize))
+	b = appendParamVarint(b, paramInitialMaxData, uint64(p.initialMaxData))
+	b = appendParamVarint(b, paramInitialMaxStreamDataBidiLocal, uint64(p.initialMaxStreamDataBidiLocal))
+	b = appendParamVarint(b, paramInitialMaxStreamDataBidiRemote, uint64(p.initialMaxStreamDataBidiRemote))
+	b = appendParamVarint(b, paramInitialMaxStreamDataUni, uint64(p.initialMaxStreamDataUni))
+	b = appendParamVarint(b, paramInitialMaxStreamsBidi, uint64(p.initialMaxStreamsBidi))
+	b = appendParamVarint(b, paramInitialMaxStreamsUni, uint64(p.initialMaxStreamsUni))
+	b = appendParamVarint(b, paramAckDelayExponent, uint64(p.ackDelayExponent))
+	b = appendParamVarint(b, paramMaxAckDelay, uint64(p.maxAckDelay/time.Millisecond))
+	if p.disableActiveMigration {
+		b = appendParamBytes(b, paramDisableActiveMigration, nil)
+	}
+	b = appendParamVarint(b, paramActiveConnIDLimit, uint64(p.activeConnIDLimit))
+	b = appendParamBytes(b, paramInitialSrcConnID, p.initialSrcConnID)
+	return b
+}
+
+// unmarshalTransportParameters parses the transport parameters in b,
+// returning a TRANSPORT_PARAMETER_ERROR if they are invalid.
+func unmarshalTransportParameters(b []byte) (transportParameters, error) {
+	p := defaultTransportParameters()
+	invalid := func(reason string) (transportParameters, error) {
+		return p, localTransportError{errTransportParameter, reason}
+	}
+	seen := make(map[uint64]bool)
+	for len(b) > 0 {
+		id, n := consumeVarint(b)
+		if n < 0 {
+			return invalid("malformed parameters")
+		}
+		b = b[n:]
+		val, n := consumeVarintBytes(b)
+		if n < 0 {
+			return invalid("malformed parameters")
+		}
+		b = b[n:]
+		if seen[id] {
+			return invalid("duplicate parameter")
+		}
+		seen[id] = true
+
+		var v uint64
+		switch id {
+		case paramMaxIdleTimeout, paramMaxUDPPayloadSize, paramInitialMaxData,
+			paramInitialMaxStreamDataBidiLocal, paramInitialMaxStreamDataBidiRemote,
+			paramInitialMaxStreamDataUni, paramInitialMaxStreamsBidi,
+			paramInitialMaxStreamsUni, paramAckDelayExponent, paramMaxAckDelay,
+			paramActiveConnIDLimit:
+			var m int
+			v, m = consumeVarint(val)
+			if m != len(val) {
+				return invalid("malformed integer parameter")
+			}
+		}
+		switch id {
+		case paramOriginalDstConnID:
+			p.originalDstConnID = cloneBytes(val)
+		case paramMaxIdleTimeout:
+			p.maxIdleTimeout = time.Duration(v) * time.Millisecond
+		case paramStatelessResetToken:
+			if len(val) != 16 {
+				return invalid("invalid stateless_reset_token")
+			}
+			p.statelessResetToken = cloneBytes(val)
+		case paramMaxUDPPayloadSize:
+			if v < 1200 {
+				return invalid("max_udp_payload_size too small")
+			}
+			p.maxUDPPayloadSize = int64(v)
+		case paramInitialMaxData:
+			p.initialMaxData = int64(v)
+		case paramInitialMaxStreamDataBidiLocal:
+			p.initialMaxStreamDataBidiLocal = int64(v)
+		case paramInitialMaxStreamDataBidiRemote:
+			p.initialMaxStreamDataBidiRemote = int64(v)
+		case paramInitialMaxStreamDataUni:
+			p.initialMaxStreamDataUni = int64(v)
+		case paramInitialMaxStreamsBidi:
+			if v > 1<<60 {
+				return invalid("initial_max_streams_bidi too large")
+			}
+			p.initialMaxStreamsBidi = int64(v)
+		case paramInitialMaxStreamsUni:
+			if v > 1<<60 {
+				return invalid("initial_max_streams_uni too large")
+			}
+			p.initialMaxStreamsUni = int64(v)
+		case paramAckDelayExponent:
+			if v > 20 {
+				return invalid("ack_delay_exponent too large")
+			}
+			p.ackDelayExponent = int64(v)
+		case paramMaxAckDelay:
+			if v >= 1<<14 {
+				return invalid("max_ack_delay too large")
+			}
+			p.maxAckDelay = time.Duration(v) * time.Millisecond
+		case paramDisableActiveMigration:
+			if len(val) != 0 {
+				return invalid("invalid disable_active_migration")
+			}
+			p.disableActiveMigration = true
+		case paramActiveConnIDLimit:
+			if v < 2 {
+				return invalid("active_connection_id_limit too small")
+			}
+			p.activeConnIDLimit = int64(v)
+		case paramInitialSrcConnID:
+			p.initialSrcConnID = cloneBytes(val)
+		case paramRetrySrcConnID:
+			p.retrySrcConnID = cloneBytes(val)
+		default:
+			// Ignore unknown parameters, including preferred_address,
+			// since we never migrate.
+		}
+	}
+	return p, nil
+}
+
+// checkConnIDs validates the connection ID parameters sent by the peer.
+// See RFC 9000, Section 7.3.
+func (p *transportParameters) checkConnIDs(isClient bool, peerSrcID, origDstID []byte) error {
+	if p.initialSrcConnID == nil || !bytes.Equal(p.initialSrcConnID, peerSrcID) {
+		return localTransportError{errTransportParameter, "initial_source_connection_id mismatch"}
+	}
+	if isClient {
+		if !bytes.Equal(p.originalDstConnID, origDstID) {
+			return localTransportError{errTransportParameter, "original_destination_connection_id mismatch"}
+		}
+		if p.retrySrcConnID != nil {
+			return localTransportError{errTransportParameter, "unexpected retry_source_connection_id"}
+		}
+	} else if p.originalDstConnID != nil || p.statelessResetToken != nil || p.retrySrcConnID != nil {
+		return localTransportError{errTransportParameter, "client sent server-only parameter"}
+	}
+	return nil
+}
+
+func cloneBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
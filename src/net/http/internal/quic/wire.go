@@ -0,0 +1,218 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package quic
+
+// maxVarint is the largest value encodable as a variable-length integer.
+const maxVarint = 1<<62 - 1
+
+// sizeVarint returns the size of the variable-length integer encoding of v.
+// See RFC 9000, Section 16.
+func sizeVarint(v uint64) int {
+	switch {
+	case v < 1<<6:
+		return 1
+	case v < 1<<14:
+		return 2
+	case v < 1<<30:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// appendVarint appends the variable-length integer encoding of v to b.
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// appendVarint2 appends v as a two-byte variable-length integer,
+// for fields whose value is filled in later.
+func appendVarint2(b []byte, v uint64) []byte {
+	return append(b, 0x40|byte(v>>8), byte(v))
+}
+
+// consumeVarint parses a variable-length integer at the start of b,
+// returning the value and its length, or a negative length on error.
+func consumeVarint(b []byte) (v uint64, n int) {
+	if len(b) < 1 {
+		return 0, -1
+	}
+	n = 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, -1
+	}
+	v = uint64(b[0] & 0x3f)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	return v, n
+}
+
+// consumeVarintInt64 is like consumeVarint, returning an int64.
+func consumeVarintInt64(b []byte) (v int64, n int) {
+	u, n := consumeVarint(b)
+	return int64(u), n
+}
+
+// consumeVarintBytes parses a variable-length integer length
+// followed by that many bytes.
+func consumeVarintBytes(b []byte) ([]byte, int) {
+	size, n := consumeVarint(b)
+	if n < 0 || size > uint64(len(b)-n) {
+		return nil, -1
+	}
+	return b[n : n+int(size)], n + int(size)
+}
+
+// consumeUint8Bytes parses a one-byte length followed by that many bytes.
+func consumeUint8Bytes(b []byte) ([]byte, int) {
+	if len(b) < 1 || int(b[0]) > len(b)-1 {
+		return nil, -1
+	}
+	return b[1 : 1+int(b[0])], 1 + int(b[0])
+}
+
+// packetNumberLength returns the number of bytes used to encode
+// packet number pn, given the largest packet number acknowledged by
+// the peer. See RFC 9000, Section 17.1.
+func packetNumberLength(pn, largestAcked int64) int {
+	d := pn - largestAcked
+	switch {
+	case d < 1<<7:
+		return 1
+	case d < 1<<15:
+		return 2
+	case d < 1<<23:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// appendPacketNumber appends the low n bytes of pn to b.
+func appendPacketNumber(b []byte, pn int64, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(pn>>(8*uint(i))))
+	}
+	return b
+}
+
+// decodePacketNumber decodes a truncated packet number of n bytes,
+// given the largest packet number received so far.
+// See RFC 9000, Appendix A.3.
+func decodePacketNumber(largest, truncated int64, n int) int64 {
+	expected := largest + 1
+	win := int64(1) << (8 * uint(n))
+	hwin := win / 2
+	mask := win - 1
+	candidate := (expected &^ mask) | truncated
+	if candidate <= expected-hwin && candidate < (1<<62)-win {
+		return candidate + win
+	}
+	if candidate > expected+hwin && candidate >= win {
+		return candidate - win
+	}
+	return candidate
+}
+
+// Long header packet types. See RFC 9000, Section 17.2.
+const (
+	packetTypeInitial   = 0x0
+	packetType0RTT      = 0x1
+	packetTypeHandshake = 0x2
+	packetTypeRetry     = 0x3
+)
+
+const (
+	headerFormLong  = 0x80
+	fixedBit        = 0x40
+	keyPhaseBit     = 0x04
+	reservedBitsLng = 0x0c
+	reservedBitsSht = 0x18
+)
+
+func isLongHeader(b byte) bool {
+	return b&headerFormLong != 0
+}
+
+// longHeader is the parsed form of a long header packet, up to
+// the protected packet number.
+type longHeader struct {
+	typ    byte
+	dstID  []byte
+	srcID  []byte
+	token  []byte // Initial packets only
+	pnOff  int    // offset of the packet number
+	length int    // total length of the packet within the datagram
+}
+
+// parseLongHeader parses the long header packet at the start of b.
+// It returns ok == false if the packet is malformed or not version 1.
+func parseLongHeader(b []byte) (h longHeader, ok bool) {
+	if len(b) < 7 || !isLongHeader(b[0]) {
+		return h, false
+	}
+	h.typ = (b[0] >> 4) & 0x3
+	if v := uint32(b[1])<<24 | uint32(b[2])<<16 | uint32(b[3])<<8 | uint32(b[4]); v != version1 {
+		return h, false
+	}
+	off := 5
+	var n int
+	if h.dstID, n = consumeUint8Bytes(b[off:]); n < 0 || len(h.dstID) > 20 {
+		return h, false
+	}
+	off += n
+	if h.srcID, n = consumeUint8Bytes(b[off:]); n < 0 || len(h.srcID) > 20 {
+		return h, false
+	}
+	off += n
+	switch h.typ {
+	case packetTypeInitial:
+		if h.token, n = consumeVarintBytes(b[off:]); n < 0 {
+			return h, false
+		}
+		off += n
+	case packetTypeHandshake:
+	default:
+		return h, false
+	}
+	length, n := consumeVarint(b[off:])
+	if n < 0 || length > uint64(len(b)-off-n) {
+		return h, false
+	}
+	off += n
+	h.pnOff = off
+	h.length = off + int(length)
+	return h, true
+}
+
+// dstConnID returns the destination connection ID of the packet
+// at the start of datagram b, for routing it to a connection.
+func dstConnID(b []byte) ([]byte, bool) {
+	if len(b) < 1 {
+		return nil, false
+	}
+	if !isLongHeader(b[0]) {
+		if len(b) < 1+connIDLen {
+			return nil, false
+		}
+		return b[1 : 1+connIDLen], true
+	}
+	if len(b) < 6 {
+		return nil, false
+	}
+	id, n := consumeUint8Bytes(b[5:])
+	return id, n >= 0
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func getTestTree() *routingNode {
+	root := &routingNode{}
+	for _, p := range []string{
+		"/a",
+		"/a/b",
+		"/a/{x}",
+		"/g/h/i",
+		"/g/{x}/j",
+		"/a/b/{x...}",
+		"/a/b/{y}",
+		"/a/b/{$}",
+		"GET /a/b",
+		"HEAD /a/b",
+		"POST /a",
+		"/h/",
+		"/item/",
+		"/item/{user}",
+		"/item/{user}/{id}",
+		"/item/{user}/{id...}",
+		"GET /item/{user}/{id}/{$}",
+		"example.com/",
+		"example.com/a",
+		"example.com/{x}/b",
+		"GET example.com/c/{$}",
+		"POST example.com/c/",
+	} {
+		pat, err := parsePattern(p)
+		if err != nil {
+			panic(err)
+		}
+		root.addPattern(pat, nil)
+	}
+	return root
+}
+
+func TestRoutingMatch(t *testing.T) {
+	root := getTestTree()
+	for _, test := range []struct {
+		host, method, path string
+		wantPat            string // "" for no match
+		wantMatches        []string
+	}{
+		{"", "GET", "/a", "/a", nil},
+		{"", "POST", "/a", "POST /a", nil},
+		{"", "HEAD", "/a", "/a", nil},
+		{"", "GET", "/b", "", nil},
+		{"", "GET", "/a/b", "GET /a/b", nil},
+		{"", "HEAD", "/a/b", "HEAD /a/b", nil},
+		{"", "DELETE", "/a/b", "/a/b", nil},
+		{"", "GET", "/a/c", "/a/{x}", []string{"c"}},
+		{"", "GET", "/a/b/", "/a/b/{$}", nil},
+		{"", "GET", "/a/b/c", "/a/b/{y}", []string{"c"}},
+		{"", "GET", "/a/b/c/d", "/a/b/{x...}", []string{"c/d"}},
+		{"", "GET", "/g/h/i", "/g/h/i", nil},
+		{"", "GET", "/g/h/j", "/g/{x}/j", []string{"h"}},
+		{"", "GET", "/h", "", nil},
+		{"", "GET", "/h/", "/h/", nil},
+		{"", "GET", "/h/x/y", "/h/", nil},
+		{"", "GET", "/item/", "/item/", nil},
+		{"", "GET", "/item/jba", "/item/{user}", []string{"jba"}},
+		{"", "GET", "/item/jba/17", "/item/{user}/{id}", []string{"jba", "17"}},
+		{"", "GET", "/item/jba/17/", "GET /item/{user}/{id}/{$}", []string{"jba", "17"}},
+		{"", "POST", "/item/jba/17/", "/item/{user}/{id...}", []string{"jba", "17/"}},
+		{"", "GET", "/item/jba/17/x", "/item/{user}/{id...}", []string{"jba", "17/x"}},
+		{"", "GET", "/item/a%2Fb/c%2fd", "/item/{user}/{id}", []string{"a/b", "c/d"}},
+		{"example.com", "GET", "/", "example.com/", nil},
+		{"example.com", "GET", "/a", "example.com/a", nil},
+		{"example.com", "GET", "/z/b", "example.com/{x}/b", []string{"z"}},
+		{"example.com", "GET", "/c/", "GET example.com/c/{$}", nil},
+		{"example.com", "POST", "/c/d", "POST example.com/c/", nil},
+		// Patterns with a host take precedence, but others still match.
+		{"example.com", "GET", "/g/h/i", "example.com/", nil},
+		{"other.com", "GET", "/g/h/i", "/g/h/i", nil},
+	} {
+		n, matches := root.match(test.host, test.method, test.path)
+		got := ""
+		if n != nil {
+			got = n.pattern.String()
+		}
+		if got != test.wantPat {
+			t.Errorf("%s %s %s: got pattern %q, want %q", test.host, test.method, test.path, got, test.wantPat)
+			continue
+		}
+		if !reflect.DeepEqual(matches, test.wantMatches) {
+			t.Errorf("%s %s %s: got matches %q, want %q", test.host, test.method, test.path, matches, test.wantMatches)
+		}
+	}
+}
+
+func TestMatchingMethods(t *testing.T) {
+	hostTree := &routingNode{}
+	for _, p := range []string{"GET a.com/", "PUT b.com/", "POST /foo/{x}"} {
+		hostTree.addPattern(mustParsePattern(t, p), nil)
+	}
+	for _, test := range []struct {
+		name       string
+		tree       *routingNode
+		host, path string
+		want       []string
+	}{
+		{"post", getTestTree(), "", "/a", []string{"POST"}},
+		{"get-head", getTestTree(), "", "/a/b", []string{"GET", "HEAD"}},
+		{"item", getTestTree(), "", "/item/jba/17/", []string{"GET", "HEAD"}},
+		{"host", hostTree, "a.com", "/", []string{"GET", "HEAD"}},
+		{"host-other", hostTree, "b.com", "/", []string{"PUT"}},
+		{"no-host", hostTree, "", "/foo/bar", []string{"POST"}},
+		{"none", hostTree, "c.com", "/", nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ms := map[string]bool{}
+			test.tree.matchingMethods(test.host, test.path, ms)
+			var got []string
+			for m := range ms {
+				got = append(got, m)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -396,6 +396,158 @@ func TestServeMuxHandleFuncWithNilHandler(t *testing.T) {
 	mux.HandleFunc("/", nil)
 }
 
+func TestServeMuxMethodsAndWildcards(t *testing.T) {
+	setParallel(t)
+	mux := NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "get user %s", r.PathValue("id"))
+	})
+	mux.HandleFunc("DELETE /users/{id}", func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "delete user %s", r.PathValue("id"))
+	})
+	mux.HandleFunc("GET /users/me", func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "me %q", r.PathValue("id"))
+	})
+	mux.HandleFunc("/files/{path...}", func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "file %s", r.PathValue("path"))
+	})
+	mux.HandleFunc("/{$}", func(w ResponseWriter, r *Request) {
+		fmt.Fprint(w, "root")
+	})
+
+	for _, tt := range []struct {
+		method, path string
+		code         int
+		body         string
+		allow        string
+	}{
+		{"GET", "/users/17", 200, "get user 17", ""},
+		{"HEAD", "/users/17", 200, "get user 17", ""},
+		{"DELETE", "/users/17", 200, "delete user 17", ""},
+		{"POST", "/users/17", 405, "Method Not Allowed\n", "DELETE, GET, HEAD"},
+		{"GET", "/users/me", 200, `me ""`, ""},
+		{"GET", "/users/a%2Fb", 200, "get user a/b", ""},
+		{"GET", "/files/a/b/c.txt", 200, "file a/b/c.txt", ""},
+		{"GET", "/files", 301, "<a href=\"/files/\">Moved Permanently</a>.\n\n", ""},
+		{"GET", "/files/", 200, "file ", ""},
+		{"GET", "/", 200, "root", ""},
+		{"GET", "/other", 404, "404 page not found\n", ""},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != tt.code || rr.Body.String() != tt.body || rr.Header().Get("Allow") != tt.allow {
+			t.Errorf("%s %s = %d, %q, Allow %q; want %d, %q, Allow %q",
+				tt.method, tt.path, rr.Code, rr.Body.String(), rr.Header().Get("Allow"),
+				tt.code, tt.body, tt.allow)
+		}
+	}
+}
+
+func TestServeMux121(t *testing.T) {
+	defer SetUse121(true)()
+
+	mux := NewServeMux()
+	for _, p := range []string{"/{x}", "/{y}", "/a/x{id}", "/a/b", "/images/", "GET /x"} {
+		p := p
+		mux.HandleFunc(p, func(w ResponseWriter, r *Request) {
+			fmt.Fprintf(w, "%s %q", p, r.PathValue("x"))
+		})
+	}
+	for _, tt := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/{x}", 200, `/{x} ""`},
+		{"/foo", 404, "404 page not found\n"},
+		{"/a/x{id}", 200, `/a/x{id} ""`},
+		{"/a%2Fb", 200, `/a/b ""`},
+		{"/images/thumbnails/1.png", 200, `/images/ ""`},
+		{"/x", 404, "404 page not found\n"},
+	} {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != tt.code || rr.Body.String() != tt.body {
+			t.Errorf("GET %s = %d, %q; want %d, %q", tt.path, rr.Code, rr.Body.String(), tt.code, tt.body)
+		}
+	}
+
+	defer func() {
+		if err := recover(); err == nil || !strings.Contains(fmt.Sprint(err), "multiple registrations") {
+			t.Errorf("registering a pattern twice: panic = %v; want multiple registrations", err)
+		}
+	}()
+	mux.Handle("/{x}", NotFoundHandler())
+}
+
+func TestServeMuxConflicts(t *testing.T) {
+	setParallel(t)
+	for _, tt := range []struct {
+		p1, p2 string
+		want   string // "" if no conflict
+	}{
+		{"/a", "/a", "matches the same requests"},
+		{"/users/{id}", "/users/{name}", "matches the same requests"},
+		{"/a/{x}", "/{y}/b", "both match some paths"},
+		{"GET /", "/index.html", "matches more methods than GET /"},
+		{"/a/{x}", "/a/b", ""},
+		{"GET /a", "POST /a", ""},
+		{"example.com/a", "/a", ""},
+	} {
+		mux := NewServeMux()
+		mux.Handle(tt.p1, serve(200))
+		err := func() (err interface{}) {
+			defer func() { err = recover() }()
+			mux.Handle(tt.p2, serve(200))
+			return nil
+		}()
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%q then %q: unexpected panic: %v", tt.p1, tt.p2, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(fmt.Sprint(err), tt.want) {
+			t.Errorf("%q then %q: got panic %v, want error containing %q", tt.p1, tt.p2, err, tt.want)
+		}
+	}
+}
+
+func TestServeMuxInvalidPattern(t *testing.T) {
+	setParallel(t)
+	defer func() {
+		err := recover()
+		if err == nil || !strings.Contains(fmt.Sprint(err), "bad wildcard") {
+			t.Errorf("got panic %v, want bad wildcard error", err)
+		}
+	}()
+	mux := NewServeMux()
+	mux.HandleFunc("/a/x{id}", func(ResponseWriter, *Request) {})
+}
+
+func TestSetPathValue(t *testing.T) {
+	setParallel(t)
+	mux := NewServeMux()
+	mux.HandleFunc("/a/{b}/c/{d...}", func(_ ResponseWriter, r *Request) {
+		kvs := map[string]string{
+			"b": "X",
+			"d": "Y",
+			"a": "Z",
+		}
+		for k, v := range kvs {
+			r.SetPathValue(k, v)
+		}
+		for k, w := range kvs {
+			if g := r.PathValue(k); g != w {
+				t.Errorf("got %q, want %q", g, w)
+			}
+		}
+	})
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a/b/c/d/e", nil))
+}
+
 var serveMuxTests2 = []struct {
 	method  string
 	host    string
@@ -3791,6 +3943,38 @@ func TestHTTP10ConnectionHeader(t *testing.T) {
 	}
 }
 
+// Tests that 1xx informational responses are not sent to HTTP/1.0
+// clients, which cannot parse them (RFC 7231, section 6.2).
+func TestServerInformationalHTTP10(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(StatusEarlyHints)
+		io.WriteString(w, "done")
+	}))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "GET / HTTP/1.0\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := ReadResponse(bufio.NewReader(conn), &Request{Method: "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != StatusOK {
+		t.Errorf("first response status = %d; want 200", res.StatusCode)
+	}
+	if got := res.Header.Get("Link"); got == "" {
+		t.Error("final response lost the Link header")
+	}
+}
+
 // See golang.org/issue/5660
 func TestServerReaderFromOrder_h1(t *testing.T) { testServerReaderFromOrder(t, h1Mode) }
 func TestServerReaderFromOrder_h2(t *testing.T) { testServerReaderFromOrder(t, h2Mode) }
@@ -3841,7 +4025,7 @@ func testServerReaderFromOrder(t *testing.T, h2 bool) {
 
 // Issue 6157, Issue 6685
 func TestCodesPreventingContentTypeAndBody(t *testing.T) {
-	for _, code := range []int{StatusNotModified, StatusNoContent, StatusContinue} {
+	for _, code := range []int{StatusNotModified, StatusNoContent} {
 		ht := newHandlerTest(HandlerFunc(func(w ResponseWriter, r *Request) {
 			if r.URL.Path == "/header" {
 				w.Header().Set("Content-Length", "123")
@@ -4904,6 +5088,48 @@ func testServerContext_LocalAddrContextKey(t *testing.T, h2 bool) {
 	}
 }
 
+func TestServerContexts_h1(t *testing.T) { testServerContexts(t, h1Mode) }
+func TestServerContexts_h2(t *testing.T) { testServerContexts(t, h2Mode) }
+func testServerContexts(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	type baseKey struct{}
+	type connKey struct{}
+	ch := make(chan context.Context, 1)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(rw ResponseWriter, r *Request) {
+		ch <- r.Context()
+	}), func(ts *httptest.Server) {
+		ts.Config.BaseContext = func(ln net.Listener) context.Context {
+			if strings.Contains(reflect.TypeOf(ln).String(), "onceClose") {
+				t.Errorf("unexpected onceClose listener type %T", ln)
+			}
+			return context.WithValue(context.Background(), baseKey{}, "base")
+		}
+		ts.Config.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+			if got, want := ctx.Value(baseKey{}), "base"; got != want {
+				t.Errorf("in ConnContext, base context key = %#v; want %q", got, want)
+			}
+			return context.WithValue(ctx, connKey{}, "conn")
+		}
+	})
+	defer cst.close()
+	res, err := cst.c.Get(cst.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	ctx := <-ch
+	if got, want := ctx.Value(baseKey{}), "base"; got != want {
+		t.Errorf("base context key = %#v; want %q", got, want)
+	}
+	if got, want := ctx.Value(connKey{}), "conn"; got != want {
+		t.Errorf("conn context key = %#v; want %q", got, want)
+	}
+	if _, ok := ctx.Value(ServerContextKey).(*Server); !ok {
+		t.Errorf("ServerContextKey value = %T; want *http.Server", ctx.Value(ServerContextKey))
+	}
+}
+
 // https://golang.org/issue/15960
 func TestHandlerSetTransferEncodingChunked(t *testing.T) {
 	setParallel(t)
@@ -253,10 +253,38 @@ type Transport struct {
 	// Zero means to use a default limit.
 	MaxResponseHeaderBytes int64
 
+	// UseUnencryptedHTTP2 optionally specifies a function that
+	// reports whether requests for "http" URLs to the given address
+	// ("host:port") are sent using HTTP/2 with prior knowledge
+	// ("h2c") instead of HTTP/1.1. The server at that address must
+	// support h2c; see Server.UnencryptedHTTP2.
+	// Requests sent through a proxy always use HTTP/1.1.
+	// h2c connections are dialed with DialContext or Dial and the
+	// request's context, and are subject to the Transport's
+	// IdleConnTimeout and other connection settings.
+	// If UseUnencryptedHTTP2 is nil, h2c is never used.
+	UseUnencryptedHTTP2 func(addr string) bool
+
+	// EnableHTTP3, if true, makes the Transport send requests for
+	// "https" URLs over HTTP/3 once the server has advertised HTTP/3
+	// support in an Alt-Svc response header (RFC 7838) and the
+	// advertisement has not expired. Until then, and whenever a QUIC
+	// connection to the advertised address cannot be established
+	// within TLSHandshakeTimeout (or 10 seconds if that is zero),
+	// requests are sent over TCP. Requests sent through a proxy
+	// never use HTTP/3.
+	EnableHTTP3 bool
+
 	// nextProtoOnce guards initialization of TLSNextProto and
 	// h2transport (via onceSetNextProtoDefaults)
 	nextProtoOnce sync.Once
 	h2transport   h2Transport // non-nil if http2 wired up
+
+	h2cOnce      sync.Once // guards h2cTransport init
+	h2cTransport *http2Transport
+
+	h3Once      sync.Once // guards h3Transport init
+	h3Transport *http3Transport
 }
 
 // h2Transport is the interface we expect to be able to call from
@@ -459,6 +487,15 @@ func (t *Transport) roundTrip(req *Request) (*Response, error) {
 			req.closeBody()
 			return nil, err
 		}
+		if t.useUnencryptedHTTP2(cm) {
+			return t.unencryptedHTTP2Transport().RoundTrip(req)
+		}
+		if t.useHTTP3(cm) {
+			resp, err := t.http3Transport().roundTrip(req, cm)
+			if err != errHTTP3Unavailable {
+				return resp, err
+			}
+		}
 
 		// Get the cached or newly-created connection to either the
 		// host (for http or https), the http proxy, or the http proxy
@@ -481,6 +518,9 @@ func (t *Transport) roundTrip(req *Request) (*Response, error) {
 			resp, err = pconn.roundTrip(treq)
 		}
 		if err == nil {
+			if t.useHTTP3(cm) {
+				t.http3Transport().noteAltSvc(cm.targetAddr, resp)
+			}
 			return resp, nil
 		}
 		if !pconn.shouldRetryRequest(req, err) {
@@ -605,6 +645,12 @@ func (t *Transport) CloseIdleConnections() {
 	if t2 := t.h2transport; t2 != nil {
 		t2.CloseIdleConnections()
 	}
+	if t.UseUnencryptedHTTP2 != nil {
+		t.unencryptedHTTP2Transport().CloseIdleConnections()
+	}
+	if t.EnableHTTP3 {
+		t.http3Transport().closeIdleConns()
+	}
 }
 
 // CancelRequest cancels an in-flight request by closing its connection.
@@ -0,0 +1,287 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"internal/x/net/http/httpguts"
+)
+
+// A Dialer contains options for connecting to a WebSocket server.
+// The zero value is usable. Connections through HTTP proxies are not
+// supported.
+type Dialer struct {
+	// NetDialContext specifies the dial function for creating TCP
+	// connections. If it is nil, net.Dialer.DialContext is used.
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TLSClientConfig specifies the TLS configuration to use with
+	// wss URLs. If it is nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// HandshakeTimeout, if non-zero, limits the time spent dialing
+	// and completing the opening handshake.
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize specify the sizes in bytes
+	// of the read buffer and of the payload of the frames in which
+	// messages are sent; messages larger than WriteBufferSize are
+	// sent as multiple frames. If zero, a size of 4096 is used.
+	ReadBufferSize, WriteBufferSize int
+
+	// Subprotocols lists the client's requested subprotocols.
+	Subprotocols []string
+
+	// EnableCompression specifies whether the client should offer
+	// per-message compression to the server.
+	EnableCompression bool
+}
+
+// DefaultDialer is a Dialer with all fields set to their default values,
+// except for a handshake timeout of 45 seconds.
+var DefaultDialer = &Dialer{
+	HandshakeTimeout: 45 * time.Second,
+}
+
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Dial connects to the WebSocket server at the given ws or wss URL.
+// See DialContext.
+func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	return d.DialContext(context.Background(), urlStr, requestHeader)
+}
+
+// DialContext connects to the WebSocket server at the given ws or wss
+// URL using ctx, which bounds the opening handshake but not the use of
+// the returned connection.
+//
+// The requestHeader argument specifies additional headers for the
+// handshake request, such as cookies or an Origin; a Host header in it
+// sets the request's Host. The headers managed by Dialer, such as
+// Sec-WebSocket-Key, may not be specified.
+//
+// If the server's response is not a valid handshake response,
+// DialContext returns ErrBadHandshake together with the response, whose
+// Body holds at most the first kilobyte of the response body.
+func (d *Dialer) DialContext(ctx context.Context, urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	challengeKey, err := generateChallengeKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return nil, nil, errors.New("websocket: bad URL scheme " + u.Scheme)
+	}
+	if u.Fragment != "" {
+		return nil, nil, errors.New("websocket: URL has a fragment")
+	}
+
+	req := &http.Request{
+		Method:     "GET",
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	for k, vs := range requestHeader {
+		switch k = http.CanonicalHeaderKey(k); {
+		case k == "Host":
+			if len(vs) > 0 {
+				req.Host = vs[0]
+			}
+		case k == "Upgrade",
+			k == "Connection",
+			k == "Sec-Websocket-Key",
+			k == "Sec-Websocket-Version",
+			k == "Sec-Websocket-Extensions",
+			k == "Sec-Websocket-Protocol" && len(d.Subprotocols) > 0:
+			return nil, nil, errors.New("websocket: header not allowed in request: " + k)
+		default:
+			req.Header[k] = vs
+		}
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", challengeKey)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	if d.EnableCompression {
+		req.Header.Set("Sec-WebSocket-Extensions", deflateParams)
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), password)
+		u.User = nil
+	}
+
+	if d.HandshakeTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+	netDial := d.NetDialContext
+	if netDial == nil {
+		var nd net.Dialer
+		netDial = nd.DialContext
+	}
+	hostPort, hostNoPort := hostPortNoPort(u)
+	netConn, err := netDial(ctx, "tcp", hostPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if netConn != nil {
+			netConn.Close()
+		}
+	}()
+
+	// Abort the handshake if ctx is done before it completes.
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+	stopc := make(chan struct{})
+	watchDone := make(chan struct{})
+	go func(c net.Conn) {
+		defer close(watchDone)
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(aLongTimeAgo)
+		case <-stopc:
+		}
+	}(netConn)
+	stopWatching := func(err error) error {
+		if stopc != nil {
+			close(stopc)
+			<-watchDone
+			stopc = nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+			return ctxErr
+		}
+		return err
+	}
+	defer stopWatching(nil)
+
+	if u.Scheme == "https" {
+		cfg := d.TLSClientConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = hostNoPort
+		}
+		tlsConn := tls.Client(netConn, cfg)
+		netConn = tlsConn
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, nil, stopWatching(err)
+		}
+	}
+
+	if err := req.Write(netConn); err != nil {
+		return nil, nil, stopWatching(err)
+	}
+	readBufferSize := d.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	br := bufio.NewReaderSize(netConn, readBufferSize)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil, stopWatching(err)
+	}
+
+	subprotocol := resp.Header.Get("Sec-Websocket-Protocol")
+	offered := d.Subprotocols
+	if len(offered) == 0 {
+		offered = headerList(req.Header, "Sec-Websocket-Protocol")
+	}
+	compress, extOK := d.checkExtensions(resp.Header)
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!httpguts.HeaderValuesContainsToken(resp.Header["Upgrade"], "websocket") ||
+		!httpguts.HeaderValuesContainsToken(resp.Header["Connection"], "upgrade") ||
+		resp.Header.Get("Sec-Websocket-Accept") != computeAcceptKey(challengeKey) ||
+		subprotocol != "" && !contains(offered, subprotocol) ||
+		!extOK {
+		// Keep some of the response body to aid debugging; the
+		// connection is closed on return.
+		buf := make([]byte, 1024)
+		n, _ := io.ReadFull(resp.Body, buf)
+		resp.Body = ioutil.NopCloser(bytes.NewReader(buf[:n]))
+		return nil, resp, stopWatching(ErrBadHandshake)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(nil))
+
+	stopWatching(nil)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+	c := newConn(netConn, false, br, d.WriteBufferSize)
+	c.subprotocol = subprotocol
+	c.compressionNegotiated = compress
+	netConn = nil // don't close it on return
+	return c, resp, nil
+}
+
+// checkExtensions reports whether the extensions accepted by the
+// server in its handshake response h are among those offered by d,
+// and whether compression was negotiated.
+func (d *Dialer) checkExtensions(h http.Header) (compress, ok bool) {
+	for _, ext := range parseExtensions(h) {
+		if ext.name != deflateExtension || !d.EnableCompression || compress || !acceptDeflateResponse(ext) {
+			return false, false
+		}
+		compress = true
+	}
+	return compress, true
+}
+
+// hostPortNoPort returns the host:port address to dial for u, whose
+// scheme is http or https, and the host without the port.
+func hostPortNoPort(u *url.URL) (hostPort, hostNoPort string) {
+	hostNoPort = u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(hostNoPort, port), hostNoPort
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,379 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "net/http/websocket"
+)
+
+// echoHandler echoes the messages it receives until the client closes
+// the connection.
+type echoHandler struct {
+	t        *testing.T
+	upgrader Upgrader
+}
+
+func (h *echoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, http.Header{"Set-Cookie": {"session=1"}})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		op, p, err := conn.ReadMessage()
+		if err != nil {
+			if !IsCloseError(err, CloseNormalClosure) {
+				h.t.Errorf("server ReadMessage: %v", err)
+			}
+			return
+		}
+		if err := conn.WriteMessage(op, p); err != nil {
+			h.t.Errorf("server WriteMessage: %v", err)
+			return
+		}
+	}
+}
+
+func wsURL(s string) string {
+	return "ws" + strings.TrimPrefix(s, "http")
+}
+
+// checkEcho sends messages over conn, checks that they are echoed
+// back, and closes the connection.
+func checkEcho(t *testing.T, conn *Conn) {
+	t.Helper()
+	messages := []struct {
+		op int
+		p  string
+	}{
+		{TextMessage, "Hello"},
+		{BinaryMessage, "\x00\x01\x02"},
+		{TextMessage, strings.Repeat("long message ", 1000)},
+		{TextMessage, ""},
+	}
+	for _, m := range messages {
+		if err := conn.WriteMessage(m.op, []byte(m.p)); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+		op, p, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if op != m.op || string(p) != m.p {
+			t.Errorf("echo of %d %.20q = %d %.20q", m.op, m.p, op, p)
+		}
+	}
+
+	// Close handshake: the server echoes the close message.
+	if err := conn.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("WriteControl(CloseMessage): %v", err)
+	}
+	if _, _, err := conn.ReadMessage(); !IsCloseError(err, CloseNormalClosure) {
+		t.Errorf("ReadMessage after close = %v; want close 1000", err)
+	}
+	conn.Close()
+}
+
+func TestDial(t *testing.T) {
+	ts := httptest.NewServer(&echoHandler{t: t})
+	defer ts.Close()
+
+	conn, resp, err := DefaultDialer.Dial(wsURL(ts.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("status = %v; want 101", resp.Status)
+	}
+	if got := resp.Header.Get("Set-Cookie"); got != "session=1" {
+		t.Errorf("Set-Cookie = %q; want %q", got, "session=1")
+	}
+	if got := conn.Subprotocol(); got != "" {
+		t.Errorf("Subprotocol = %q; want none", got)
+	}
+	checkEcho(t, conn)
+}
+
+func TestDialTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(&echoHandler{t: t})
+	defer ts.Close()
+
+	d := &Dialer{TLSClientConfig: ts.Client().Transport.(*http.Transport).TLSClientConfig}
+	conn, _, err := d.Dial(wsURL(ts.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	checkEcho(t, conn)
+}
+
+func TestDialCompression(t *testing.T) {
+	for _, tt := range []struct {
+		server, client, want bool
+	}{
+		{false, false, false},
+		{true, false, false},
+		{false, true, false},
+		{true, true, true},
+	} {
+		h := &echoHandler{t: t, upgrader: Upgrader{EnableCompression: tt.server}}
+		ts := httptest.NewServer(h)
+		d := &Dialer{EnableCompression: tt.client}
+		conn, resp, err := d.Dial(wsURL(ts.URL), nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		got := strings.HasPrefix(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+		if got != tt.want {
+			t.Errorf("server %v, client %v: compression negotiated = %v; want %v", tt.server, tt.client, got, tt.want)
+		}
+		checkEcho(t, conn)
+		ts.Close()
+	}
+}
+
+func TestSubprotocols(t *testing.T) {
+	h := &echoHandler{t: t, upgrader: Upgrader{Subprotocols: []string{"v2", "v1"}}}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		requested []string
+		want      string
+	}{
+		{nil, ""},
+		{[]string{"v1"}, "v1"},
+		{[]string{"v1", "v2"}, "v2"},
+		{[]string{"v3"}, ""},
+	} {
+		d := &Dialer{Subprotocols: tt.requested}
+		conn, _, err := d.Dial(wsURL(ts.URL), nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		if got := conn.Subprotocol(); got != tt.want {
+			t.Errorf("requested %q: Subprotocol = %q; want %q", tt.requested, got, tt.want)
+		}
+		checkEcho(t, conn)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	ts := httptest.NewServer(&echoHandler{t: t})
+	defer ts.Close()
+
+	// Same origin.
+	conn, _, err := DefaultDialer.Dial(wsURL(ts.URL), http.Header{"Origin": {ts.URL}})
+	if err != nil {
+		t.Fatalf("Dial from same origin: %v", err)
+	}
+	checkEcho(t, conn)
+
+	// Another origin.
+	_, resp, err := DefaultDialer.Dial(wsURL(ts.URL), http.Header{"Origin": {"http://example.com"}})
+	if err != ErrBadHandshake {
+		t.Fatalf("Dial from other origin: err = %v; want %v", err, ErrBadHandshake)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %v; want 403", resp.Status)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "Forbidden") {
+		t.Errorf("body = %q; want it to contain %q", body, "Forbidden")
+	}
+}
+
+func TestUpgradeErrors(t *testing.T) {
+	ts := httptest.NewServer(&echoHandler{t: t})
+	defer ts.Close()
+
+	upgrade := http.Header{
+		"Connection":            {"Upgrade"},
+		"Upgrade":               {"websocket"},
+		"Sec-Websocket-Version": {"13"},
+		"Sec-Websocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+	}
+	tests := []struct {
+		name       string
+		method     string
+		header     map[string]string // changes to upgrade
+		wantStatus int
+	}{
+		{"POST", "POST", nil, http.StatusMethodNotAllowed},
+		{"no upgrade", "GET", map[string]string{"Upgrade": ""}, http.StatusBadRequest},
+		{"version 8", "GET", map[string]string{"Sec-Websocket-Version": "8"}, http.StatusUpgradeRequired},
+		{"short key", "GET", map[string]string{"Sec-Websocket-Key": "c2hvcnQ="}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, ts.URL, nil)
+		for k, v := range upgrade {
+			req.Header[k] = v
+		}
+		for k, v := range tt.header {
+			if v == "" {
+				req.Header.Del(k)
+			} else {
+				req.Header.Set(k, v)
+			}
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: status = %v; want %d", tt.name, resp.Status, tt.wantStatus)
+		}
+		if tt.wantStatus == http.StatusUpgradeRequired && resp.Header.Get("Sec-Websocket-Version") != "13" {
+			t.Errorf("%s: Sec-WebSocket-Version = %q; want 13", tt.name, resp.Header.Get("Sec-Websocket-Version"))
+		}
+	}
+}
+
+func TestDialBadHandshake(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no websockets here", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	_, resp, err := DefaultDialer.Dial(wsURL(ts.URL), nil)
+	if err != ErrBadHandshake {
+		t.Fatalf("Dial error = %v; want %v", err, ErrBadHandshake)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusNotFound || string(body) != "no websockets here\n" {
+		t.Errorf("response = %v %q; want 404 %q", resp.Status, body, "no websockets here\n")
+	}
+}
+
+func TestDialErrors(t *testing.T) {
+	for _, tt := range []struct {
+		url    string
+		header http.Header
+	}{
+		{"http://example.com/", nil},
+		{"ws://example.com/#frag", nil},
+		{"ws://example.com/", http.Header{"Sec-Websocket-Key": {"x"}}},
+	} {
+		d := &Dialer{NetDialContext: func(context.Context, string, string) (net.Conn, error) {
+			t.Errorf("%s: unexpected dial", tt.url)
+			return nil, net.ErrWriteToConnected
+		}}
+		if _, _, err := d.Dial(tt.url, tt.header); err == nil {
+			t.Errorf("Dial(%q, %v) succeeded", tt.url, tt.header)
+		}
+	}
+}
+
+func TestDialContextCancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		// Accept connections and never reply.
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, _, err = DefaultDialer.DialContext(ctx, "ws://"+ln.Addr().String(), nil)
+	if err != context.Canceled {
+		t.Errorf("DialContext error = %v; want %v", err, context.Canceled)
+	}
+
+	d := &Dialer{HandshakeTimeout: 10 * time.Millisecond}
+	_, _, err = d.Dial("ws://"+ln.Addr().String(), nil)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Dial with HandshakeTimeout error = %v; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// TestReverseProxy checks that a WebSocket connection can be made
+// through httputil.ReverseProxy, which forwards upgrade requests.
+func TestReverseProxy(t *testing.T) {
+	backend := httptest.NewServer(&echoHandler{t: t})
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	proxy := httptest.NewServer(httputil.NewSingleHostReverseProxy(backendURL))
+	defer proxy.Close()
+
+	conn, _, err := DefaultDialer.Dial(wsURL(proxy.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	checkEcho(t, conn)
+}
+
+// TestUpgradeWrappedResponseWriter checks that Upgrade can hijack the
+// connection through a ResponseWriter that wraps the server's.
+func TestUpgradeWrappedResponseWriter(t *testing.T) {
+	h := &echoHandler{t: t}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(wrappedWriter{w}, r)
+	}))
+	defer ts.Close()
+
+	conn, _, err := DefaultDialer.Dial(wsURL(ts.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	checkEcho(t, conn)
+}
+
+type wrappedWriter struct {
+	http.ResponseWriter
+}
+
+func (w wrappedWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func TestPingPong(t *testing.T) {
+	ts := httptest.NewServer(&echoHandler{t: t})
+	defer ts.Close()
+
+	conn, _, err := DefaultDialer.Dial(wsURL(ts.URL), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	pongc := make(chan string, 1)
+	conn.SetPongHandler(func(s string) error {
+		pongc <- s
+		return nil
+	})
+	if err := conn.WriteControl(PingMessage, []byte("ping"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// The pong is read while waiting for the echo of a message.
+	conn.WriteMessage(TextMessage, []byte("x"))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-pongc:
+		if s != "ping" {
+			t.Errorf("pong = %q; want %q", s, "ping")
+		}
+	default:
+		t.Errorf("no pong received")
+	}
+	checkEcho(t, conn)
+}
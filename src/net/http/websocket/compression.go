@@ -0,0 +1,209 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The permessage-deflate extension (RFC 7692) is always negotiated
+// without context takeover in either direction, so that every message
+// is compressed with a fresh flate.Writer and decompressed with a fresh
+// flate reader, both of which are pooled.
+
+const (
+	deflateExtension = "permessage-deflate"
+
+	// deflateParams is both the extension offered by Dialer and the
+	// one accepted by Upgrader.
+	deflateParams = deflateExtension + "; server_no_context_takeover; client_no_context_takeover"
+
+	minCompressionLevel     = flate.HuffmanOnly
+	maxCompressionLevel     = flate.BestCompression
+	defaultCompressionLevel = flate.BestSpeed
+
+	// deflateTail is appended to a compressed message before
+	// decompressing it: the empty stored block that the sender strips
+	// from the end of the message (RFC 7692, section 7.2.2), followed
+	// by a final empty stored block to end the flate stream.
+	deflateTail = "\x00\x00\xff\xff" + "\x01\x00\x00\xff\xff"
+)
+
+var (
+	flateWriterPools [maxCompressionLevel - minCompressionLevel + 1]sync.Pool
+	flateReaderPool  sync.Pool
+)
+
+func isValidCompressionLevel(level int) bool {
+	return minCompressionLevel <= level && level <= maxCompressionLevel
+}
+
+// acceptDeflateOffer reports whether Upgrader can accept the
+// permessage-deflate offer ext, replying with deflateParams.
+func acceptDeflateOffer(ext extension) bool {
+	for k, v := range ext.params {
+		switch k {
+		case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			// Context takeover is never used, and the window size
+			// a client compresses with is its own choice.
+		case "server_max_window_bits":
+			// compress/flate always uses a 32 KB window.
+			if v != "15" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// acceptDeflateResponse reports whether Dialer can use the
+// permessage-deflate extension ext accepted by the server in
+// response to deflateParams.
+func acceptDeflateResponse(ext extension) bool {
+	if _, ok := ext.params["server_no_context_takeover"]; !ok {
+		return false
+	}
+	for k := range ext.params {
+		switch k {
+		case "server_no_context_takeover", "client_no_context_takeover", "server_max_window_bits":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// A compressWriter compresses a message into a messageWriter.
+type compressWriter struct {
+	w     *messageWriter
+	tw    truncWriter
+	fw    *flate.Writer
+	level int
+}
+
+func newCompressWriter(w *messageWriter, level int) *compressWriter {
+	cw := &compressWriter{w: w, level: level}
+	cw.tw.w = w
+	p := &flateWriterPools[level-minCompressionLevel]
+	fw, _ := p.Get().(*flate.Writer)
+	if fw == nil {
+		fw, _ = flate.NewWriter(&cw.tw, level)
+	} else {
+		fw.Reset(&cw.tw)
+	}
+	cw.fw = fw
+	return cw
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.fw == nil {
+		return 0, errWriteClosed
+	}
+	return w.fw.Write(p)
+}
+
+func (w *compressWriter) Close() error {
+	if w.fw == nil {
+		return errWriteClosed
+	}
+	err := w.fw.Flush()
+	flateWriterPools[w.level-minCompressionLevel].Put(w.fw)
+	w.fw = nil
+	if err != nil {
+		w.w.Close()
+		return err
+	}
+	if w.tw.n != len(w.tw.tail) || string(w.tw.tail[:]) != deflateTail[:4] {
+		w.w.Close()
+		return errWriteClosed
+	}
+	return w.w.Close()
+}
+
+// A truncWriter passes on all but the last four bytes written to it.
+// Those are the empty stored block written by flate.Writer.Flush,
+// which a compressed message must not end with.
+type truncWriter struct {
+	w    io.Writer
+	tail [4]byte
+	n    int
+}
+
+func (w *truncWriter) Write(p []byte) (int, error) {
+	if w.n+len(p) <= len(w.tail) {
+		w.n += copy(w.tail[w.n:], p)
+		return len(p), nil
+	}
+
+	// Pass on all but the last len(w.tail) bytes of the held bytes
+	// followed by p, taking from the held bytes first.
+	emit := w.n + len(p) - len(w.tail)
+	k := emit
+	if k > w.n {
+		k = w.n
+	}
+	if _, err := w.w.Write(w.tail[:k]); err != nil {
+		return 0, err
+	}
+	w.n = copy(w.tail[:], w.tail[k:w.n])
+	m := emit - k
+	if _, err := w.w.Write(p[:m]); err != nil {
+		return 0, err
+	}
+	w.n += copy(w.tail[w.n:], p[m:])
+	return len(p), nil
+}
+
+// A decompressReader decompresses a message read from a messageReader,
+// enforcing the read limit of the connection on the decompressed size.
+type decompressReader struct {
+	c   *Conn
+	fr  io.ReadCloser
+	n   int64 // decompressed bytes read so far
+	err error
+}
+
+func newDecompressReader(c *Conn, r io.Reader) io.Reader {
+	r = io.MultiReader(r, strings.NewReader(deflateTail))
+	fr, _ := flateReaderPool.Get().(io.ReadCloser)
+	if fr == nil {
+		fr = flate.NewReader(r)
+	} else {
+		fr.(flate.Resetter).Reset(r, nil)
+	}
+	return &decompressReader{c: c, fr: fr}
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.fr.Read(p)
+	r.n += int64(n)
+	if c := r.c; c.readLimit > 0 && r.n > c.readLimit {
+		c.WriteControl(CloseMessage, FormatCloseMessage(CloseMessageTooBig, ""), time.Now().Add(writeWait))
+		c.readErr = ErrReadLimit
+		n, err = 0, ErrReadLimit
+	}
+	if err != nil {
+		if _, ok := err.(flate.CorruptInputError); ok {
+			err = r.c.failConnection(CloseInvalidFramePayloadData, "invalid compressed data")
+			r.c.readErr = err
+		}
+		// The reader's state is no longer needed: either the message
+		// has been read in full or the connection is broken.
+		r.err = err
+		r.fr.Close()
+		flateReaderPool.Put(r.fr)
+		r.fr = nil
+	}
+	return n, err
+}
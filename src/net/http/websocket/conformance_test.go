@@ -0,0 +1,601 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The conformance tests feed a server Conn a sequence of frames, as a
+// client would send them, and check the messages the Conn reads and the
+// frames it sends in reply. They follow the structure of the Autobahn
+// test suite: framing, pings and pongs, reserved bits and opcodes,
+// fragmentation, UTF-8 handling, close handling and compression.
+
+// fakeNetConn is a net.Conn reading from r and writing to w.
+type fakeNetConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (c fakeNetConn) Close() error                       { return nil }
+func (c fakeNetConn) LocalAddr() net.Addr                { return fakeAddr{} }
+func (c fakeNetConn) RemoteAddr() net.Addr               { return fakeAddr{} }
+func (c fakeNetConn) SetDeadline(t time.Time) error      { return nil }
+func (c fakeNetConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c fakeNetConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type fakeAddr struct{}
+
+func (fakeAddr) Network() string { return "net" }
+func (fakeAddr) String() string  { return "str" }
+
+// A testFrame describes a frame to encode.
+type testFrame struct {
+	b0      byte // FIN, RSV and opcode bits
+	payload string
+	unmask  bool // send the frame unmasked, as a server would
+}
+
+func fin(frameType int, payload string) testFrame {
+	return testFrame{b0: finalBit | byte(frameType), payload: payload}
+}
+
+func nofin(frameType int, payload string) testFrame {
+	return testFrame{b0: byte(frameType), payload: payload}
+}
+
+func closeFrame(code int, text string) testFrame {
+	return fin(CloseMessage, string(FormatCloseMessage(code, text)))
+}
+
+// encodeFrames encodes frames with a fixed masking key.
+func encodeFrames(frames ...testFrame) []byte {
+	var b []byte
+	for _, f := range frames {
+		b1 := byte(0)
+		if !f.unmask {
+			b1 = maskBit
+		}
+		switch n := len(f.payload); {
+		case n <= 125:
+			b = append(b, f.b0, b1|byte(n))
+		case n <= 0xffff:
+			b = append(b, f.b0, b1|126, byte(n>>8), byte(n))
+		default:
+			b = append(b, f.b0, b1|127, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.BigEndian.PutUint64(b[len(b)-8:], uint64(n))
+		}
+		if f.unmask {
+			b = append(b, f.payload...)
+			continue
+		}
+		key := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+		b = append(b, key[:]...)
+		b = append(b, f.payload...)
+		maskBytes(key, 0, b[len(b)-len(f.payload):])
+	}
+	return b
+}
+
+// describeFrames parses the frames in b and describes each as its
+// opcode name and payload; close frames are described by their code
+// and reason.
+func describeFrames(t *testing.T, b []byte) []string {
+	var frames []string
+	for len(b) > 0 {
+		if len(b) < 2 {
+			t.Fatalf("truncated frame header %q", b)
+		}
+		b0, b1 := b[0], b[1]
+		b = b[2:]
+		n := int(b1 & 0x7f)
+		switch n {
+		case 126:
+			n = int(binary.BigEndian.Uint16(b))
+			b = b[2:]
+		case 127:
+			n = int(binary.BigEndian.Uint64(b))
+			b = b[8:]
+		}
+		var key [4]byte
+		if b1&maskBit != 0 {
+			copy(key[:], b)
+			b = b[4:]
+		}
+		payload := append([]byte(nil), b[:n]...)
+		b = b[n:]
+		maskBytes(key, 0, payload)
+
+		var d string
+		switch op := int(b0 & 0xf); op {
+		case CloseMessage:
+			d = "close"
+			if len(payload) >= 2 {
+				d += fmt.Sprintf(" %d", binary.BigEndian.Uint16(payload))
+			}
+		case PingMessage:
+			d = "ping " + string(payload)
+		case PongMessage:
+			d = "pong " + string(payload)
+		case TextMessage:
+			d = "text " + string(payload)
+		case BinaryMessage:
+			d = "binary " + string(payload)
+		case continuationFrame:
+			d = "cont " + string(payload)
+		default:
+			d = fmt.Sprintf("op%d %s", op, payload)
+		}
+		if b0&finalBit == 0 {
+			d += " (nofin)"
+		}
+		if b0&rsv1Bit != 0 {
+			d += " (rsv1)"
+		}
+		frames = append(frames, d)
+	}
+	return frames
+}
+
+// readAll reads messages from c until it returns an error, describing
+// each message and the final error.
+func readAll(c *Conn) []string {
+	var got []string
+	for {
+		op, p, err := c.ReadMessage()
+		if err != nil {
+			if e, ok := err.(*CloseError); ok {
+				d := fmt.Sprintf("close %d", e.Code)
+				if e.Text != "" {
+					d += " " + e.Text
+				}
+				got = append(got, d)
+			} else {
+				got = append(got, "error")
+			}
+			return got
+		}
+		switch op {
+		case TextMessage:
+			got = append(got, "text "+string(p))
+		case BinaryMessage:
+			got = append(got, "binary "+string(p))
+		}
+	}
+}
+
+// deflate compresses s as the payload of a compressed message.
+func deflate(s string) string {
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	fw.Write([]byte(s))
+	fw.Flush()
+	return strings.TrimSuffix(buf.String(), "\x00\x00\xff\xff")
+}
+
+func compressed(f testFrame) testFrame {
+	f.b0 |= rsv1Bit
+	return f
+}
+
+var (
+	long125   = strings.Repeat("*", 125)
+	long126   = strings.Repeat("*", 126)
+	long65535 = strings.Repeat("x", 65535)
+	long65536 = strings.Repeat("y", 65536)
+
+	// "κόσμε", as in the Autobahn test suite, and the same with its
+	// last rune replaced by a UTF-16 surrogate.
+	kosme       = "\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5"
+	invalidUTF8 = "\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xed\xa0\x80"
+)
+
+var conformanceTests = []struct {
+	name     string
+	compress bool
+	in       []testFrame
+	wantRead []string // messages and final error read by the server
+	wantSent []string // frames sent by the server
+}{
+	// Framing.
+	{
+		name:     "text",
+		in:       []testFrame{fin(TextMessage, "Hello"), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"text Hello", "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "binary",
+		in:       []testFrame{fin(BinaryMessage, "\x00\xff"), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"binary \x00\xff", "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "empty text",
+		in:       []testFrame{fin(TextMessage, ""), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"text ", "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "16-bit length",
+		in:       []testFrame{fin(BinaryMessage, long65535), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"binary " + long65535, "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "64-bit length",
+		in:       []testFrame{fin(BinaryMessage, long65536), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"binary " + long65536, "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "unmasked client frame",
+		in:       []testFrame{{b0: finalBit | TextMessage, payload: "Hello", unmask: true}},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "connection ends without close",
+		in:       []testFrame{fin(TextMessage, "Hello")},
+		wantRead: []string{"text Hello", "close 1006 unexpected EOF"},
+	},
+
+	// Pings and pongs.
+	{
+		name:     "ping",
+		in:       []testFrame{fin(PingMessage, "hi"), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"close 1000"},
+		wantSent: []string{"pong hi", "close 1000"},
+	},
+	{
+		name:     "ping with 125 byte payload",
+		in:       []testFrame{fin(PingMessage, long125), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"close 1000"},
+		wantSent: []string{"pong " + long125, "close 1000"},
+	},
+	{
+		name:     "ping with 126 byte payload",
+		in:       []testFrame{fin(PingMessage, long126)},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "fragmented ping",
+		in:       []testFrame{nofin(PingMessage, "a"), fin(continuationFrame, "b")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "unsolicited pong",
+		in:       []testFrame{fin(PongMessage, "x"), fin(TextMessage, "Hello"), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"text Hello", "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+
+	// Reserved bits and opcodes.
+	{
+		name:     "RSV1 without compression",
+		in:       []testFrame{compressed(fin(TextMessage, "Hello"))},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "RSV2",
+		in:       []testFrame{{b0: finalBit | rsv2Bit | TextMessage, payload: "Hello"}},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "RSV3 on ping",
+		in:       []testFrame{{b0: finalBit | rsv3Bit | PingMessage}},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "reserved data opcode",
+		in:       []testFrame{fin(3, "")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "reserved control opcode",
+		in:       []testFrame{fin(11, "")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+
+	// Fragmentation.
+	{
+		name: "fragmented text",
+		in: []testFrame{
+			nofin(TextMessage, "Hel"), nofin(continuationFrame, "l"), fin(continuationFrame, "o"),
+			closeFrame(CloseNormalClosure, ""),
+		},
+		wantRead: []string{"text Hello", "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name: "fragmented text with empty fragments",
+		in: []testFrame{
+			nofin(TextMessage, ""), nofin(continuationFrame, "Hello"), fin(continuationFrame, ""),
+			closeFrame(CloseNormalClosure, ""),
+		},
+		wantRead: []string{"text Hello", "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name: "ping between fragments",
+		in: []testFrame{
+			nofin(TextMessage, "Hel"), fin(PingMessage, "p"), fin(continuationFrame, "lo"),
+			closeFrame(CloseNormalClosure, ""),
+		},
+		wantRead: []string{"text Hello", "close 1000"},
+		wantSent: []string{"pong p", "close 1000"},
+	},
+	{
+		name:     "continuation without message",
+		in:       []testFrame{fin(continuationFrame, "x")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "continuation after final frame",
+		in:       []testFrame{fin(TextMessage, "a"), fin(continuationFrame, "b")},
+		wantRead: []string{"text a", "error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "text within fragmented message",
+		in:       []testFrame{nofin(TextMessage, "a"), fin(TextMessage, "b")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+
+	// UTF-8 handling.
+	{
+		name:     "valid UTF-8",
+		in:       []testFrame{fin(TextMessage, kosme), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"text " + kosme, "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name: "valid UTF-8 split within runes",
+		in: []testFrame{
+			nofin(TextMessage, "\xce"), nofin(continuationFrame, "\xba\xe1\xbd"), fin(continuationFrame, "\xb9\xcf\x83\xce\xbc\xce\xb5"),
+			closeFrame(CloseNormalClosure, ""),
+		},
+		wantRead: []string{"text " + kosme, "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "invalid UTF-8",
+		in:       []testFrame{fin(TextMessage, invalidUTF8)},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1007"},
+	},
+	{
+		name:     "invalid UTF-8 in first fragment",
+		in:       []testFrame{nofin(TextMessage, "\xff"), fin(continuationFrame, "ok")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1007"},
+	},
+	{
+		name:     "truncated UTF-8",
+		in:       []testFrame{fin(TextMessage, kosme[:len(kosme)-1])},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1007"},
+	},
+	{
+		name:     "invalid UTF-8 in binary message",
+		in:       []testFrame{fin(BinaryMessage, invalidUTF8), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"binary " + invalidUTF8, "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+
+	// Close handling.
+	{
+		name:     "close without payload",
+		in:       []testFrame{fin(CloseMessage, "")},
+		wantRead: []string{"close 1005"},
+		wantSent: []string{"close"},
+	},
+	{
+		name:     "close with reason",
+		in:       []testFrame{closeFrame(CloseGoingAway, "bye")},
+		wantRead: []string{"close 1001 bye"},
+		wantSent: []string{"close 1001"},
+	},
+	{
+		name:     "close with 1 byte payload",
+		in:       []testFrame{fin(CloseMessage, "\x03")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "close with invalid UTF-8 reason",
+		in:       []testFrame{closeFrame(CloseNormalClosure, invalidUTF8)},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1007"},
+	},
+	{
+		name:     "close code 3000",
+		in:       []testFrame{closeFrame(3000, "")},
+		wantRead: []string{"close 3000"},
+		wantSent: []string{"close 3000"},
+	},
+	{
+		name:     "close code 4999",
+		in:       []testFrame{closeFrame(4999, "")},
+		wantRead: []string{"close 4999"},
+		wantSent: []string{"close 4999"},
+	},
+	{
+		name:     "close code 999",
+		in:       []testFrame{closeFrame(999, "")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "close code 1004",
+		in:       []testFrame{closeFrame(1004, "")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "close code 1005",
+		in:       []testFrame{fin(CloseMessage, "\x03\xed")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "close code 1006",
+		in:       []testFrame{closeFrame(CloseAbnormalClosure, "")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "close code 1015",
+		in:       []testFrame{closeFrame(CloseTLSHandshake, "")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "close code 5000",
+		in:       []testFrame{closeFrame(5000, "")},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "frames after close",
+		in:       []testFrame{closeFrame(CloseNormalClosure, ""), fin(TextMessage, "late"), fin(PingMessage, "")},
+		wantRead: []string{"close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "close between fragments",
+		in:       []testFrame{nofin(TextMessage, "a"), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+
+	// Compression.
+	{
+		name:     "compressed text",
+		compress: true,
+		in:       []testFrame{compressed(fin(TextMessage, deflate("Hello"))), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"text Hello", "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "uncompressed text with compression negotiated",
+		compress: true,
+		in:       []testFrame{fin(TextMessage, "Hello"), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"text Hello", "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "fragmented compressed binary",
+		compress: true,
+		in: []testFrame{
+			compressed(nofin(BinaryMessage, deflate(long65536)[:10])),
+			fin(continuationFrame, deflate(long65536)[10:]),
+			closeFrame(CloseNormalClosure, ""),
+		},
+		wantRead: []string{"binary " + long65536, "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "compressed empty message",
+		compress: true,
+		in:       []testFrame{compressed(fin(TextMessage, "\x00")), closeFrame(CloseNormalClosure, "")},
+		wantRead: []string{"text ", "close 1000"},
+		wantSent: []string{"close 1000"},
+	},
+	{
+		name:     "RSV1 on continuation",
+		compress: true,
+		in:       []testFrame{compressed(nofin(TextMessage, "a")), compressed(fin(continuationFrame, "b"))},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1002"},
+	},
+	{
+		name:     "compressed invalid UTF-8",
+		compress: true,
+		in:       []testFrame{compressed(fin(TextMessage, deflate(invalidUTF8)))},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1007"},
+	},
+	{
+		name:     "corrupt compressed data",
+		compress: true,
+		in:       []testFrame{compressed(fin(BinaryMessage, "\xff\xff\xff\xff"))},
+		wantRead: []string{"error"},
+		wantSent: []string{"close 1007"},
+	},
+}
+
+func TestConformance(t *testing.T) {
+	for _, tt := range conformanceTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			in := bytes.NewReader(encodeFrames(tt.in...))
+			c := newConn(fakeNetConn{Reader: in, Writer: &out}, true, bufio.NewReader(in), 0)
+			c.compressionNegotiated = tt.compress
+			if got := readAll(c); !reflect.DeepEqual(got, tt.wantRead) {
+				t.Errorf("read %q; want %q", got, tt.wantRead)
+			}
+			if got := describeFrames(t, out.Bytes()); !reflect.DeepEqual(got, tt.wantSent) {
+				t.Errorf("sent %q; want %q", got, tt.wantSent)
+			}
+		})
+	}
+}
+
+// TestClientConformance checks that a client Conn requires unmasked
+// frames from the server.
+func TestClientConformance(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       []testFrame
+		wantRead []string
+		wantSent []string
+	}{
+		{
+			name:     "unmasked",
+			in:       []testFrame{{b0: finalBit | TextMessage, payload: "Hello", unmask: true}},
+			wantRead: []string{"text Hello", "close 1006 unexpected EOF"},
+		},
+		{
+			name:     "masked",
+			in:       []testFrame{fin(TextMessage, "Hello")},
+			wantRead: []string{"error"},
+			wantSent: []string{"close 1002"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			in := bytes.NewReader(encodeFrames(tt.in...))
+			c := newConn(fakeNetConn{Reader: in, Writer: &out}, false, bufio.NewReader(in), 0)
+			if got := readAll(c); !reflect.DeepEqual(got, tt.wantRead) {
+				t.Errorf("read %q; want %q", got, tt.wantRead)
+			}
+			if got := describeFrames(t, out.Bytes()); !reflect.DeepEqual(got, tt.wantSent) {
+				t.Errorf("sent %q; want %q", got, tt.wantSent)
+			}
+		})
+	}
+}
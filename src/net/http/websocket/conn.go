@@ -0,0 +1,778 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Frame header bits, from RFC 6455, section 5.2.
+const (
+	finalBit = 1 << 7
+	rsv1Bit  = 1 << 6
+	rsv2Bit  = 1 << 5
+	rsv3Bit  = 1 << 4
+	maskBit  = 1 << 7
+
+	continuationFrame = 0
+	noFrame           = -1
+
+	maxControlFramePayloadSize = 125
+	maxFrameHeaderSize         = 2 + 8 + 4
+
+	defaultReadBufferSize  = 4096
+	defaultWriteBufferSize = 4096
+
+	// writeWait bounds the time spent writing control messages that
+	// the Conn sends on its own: pongs, close replies and the close
+	// messages sent when the peer violates the protocol.
+	writeWait = time.Second
+)
+
+var (
+	errWriteClosed         = errors.New("websocket: write to closed writer")
+	errBadWriteOpCode      = errors.New("websocket: bad write message type")
+	errInvalidControlFrame = errors.New("websocket: invalid control frame")
+
+	// errUnexpectedEOF is returned by the read methods when the
+	// connection ends without a close message.
+	errUnexpectedEOF = &CloseError{Code: CloseAbnormalClosure, Text: io.ErrUnexpectedEOF.Error()}
+
+	errWriteTimeout = &netError{msg: "websocket: write timeout", timeout: true, temporary: true}
+)
+
+// netError is a net.Error.
+type netError struct {
+	msg       string
+	temporary bool
+	timeout   bool
+}
+
+func (e *netError) Error() string   { return e.msg }
+func (e *netError) Temporary() bool { return e.temporary }
+func (e *netError) Timeout() bool   { return e.timeout }
+
+func isControl(frameType int) bool {
+	return frameType == CloseMessage || frameType == PingMessage || frameType == PongMessage
+}
+
+func isData(frameType int) bool {
+	return frameType == TextMessage || frameType == BinaryMessage
+}
+
+// A Conn is a WebSocket connection, created by Upgrader.Upgrade on the
+// server and by Dialer.Dial on the client.
+type Conn struct {
+	conn        net.Conn
+	isServer    bool
+	subprotocol string
+
+	// Write fields.
+	mu            chan struct{} // 1-element semaphore serializing frame writes
+	writeBuf      []byte        // buffer for the payload of a data frame
+	writeDeadline time.Time
+	writer        io.WriteCloser // the current message writer, or nil
+
+	errMu    sync.Mutex
+	writeErr error // sticky; guarded by errMu
+
+	compressionNegotiated  bool
+	enableWriteCompression bool
+	compressionLevel       int
+
+	// Read fields.
+	br             *bufio.Reader
+	readErr        error          // sticky
+	reader         *messageReader // the current message reader, or nil
+	readRemaining  int64          // payload bytes left in the current frame
+	readFinal      bool           // the current frame ends its message
+	readMasked     bool
+	readMaskKey    [4]byte
+	readMaskPos    int
+	readLength     int64 // payload bytes of the current message so far
+	readLimit      int64
+	readCompressed bool // the current message is compressed
+	readInMessage  bool // a fragmented message awaits its final frame
+
+	handlePing  func(appData string) error
+	handlePong  func(appData string) error
+	handleClose func(code int, text string) error
+}
+
+// newConn returns a Conn reading from br, which must read from conn,
+// and writing frames of at most writeBufferSize bytes to conn.
+func newConn(conn net.Conn, isServer bool, br *bufio.Reader, writeBufferSize int) *Conn {
+	if writeBufferSize <= 0 {
+		writeBufferSize = defaultWriteBufferSize
+	}
+	c := &Conn{
+		conn:                   conn,
+		isServer:               isServer,
+		mu:                     make(chan struct{}, 1),
+		writeBuf:               make([]byte, 0, writeBufferSize),
+		enableWriteCompression: true,
+		compressionLevel:       defaultCompressionLevel,
+		br:                     br,
+	}
+	c.SetPingHandler(nil)
+	c.SetPongHandler(nil)
+	c.SetCloseHandler(nil)
+	return c
+}
+
+// Subprotocol returns the negotiated subprotocol, or the empty string
+// if none was negotiated.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Close closes the underlying network connection without sending or
+// waiting for a close message.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Write methods
+
+func (c *Conn) writeFatal(err error) error {
+	c.errMu.Lock()
+	if c.writeErr == nil {
+		c.writeErr = err
+	}
+	c.errMu.Unlock()
+	return err
+}
+
+func (c *Conn) getWriteErr() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.writeErr
+}
+
+// writeFrame writes a single frame with the given opcode and payload,
+// masking it if c is a client. It waits no later than deadline for
+// other frames being written to finish; a zero deadline means no limit.
+func (c *Conn) writeFrame(frameType int, final, compressed bool, payload []byte, deadline time.Time) error {
+	b0 := byte(frameType)
+	if final {
+		b0 |= finalBit
+	}
+	if compressed {
+		b0 |= rsv1Bit
+	}
+	b1 := byte(0)
+	if !c.isServer {
+		b1 |= maskBit
+	}
+
+	frame := make([]byte, 0, maxFrameHeaderSize+len(payload))
+	switch n := len(payload); {
+	case n <= maxControlFramePayloadSize:
+		frame = append(frame, b0, b1|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, b0, b1|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, b0, b1|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(n))
+	}
+	if !c.isServer {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		frame = append(frame, key[:]...)
+		frame = append(frame, payload...)
+		maskBytes(key, 0, frame[len(frame)-len(payload):])
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	if deadline.IsZero() {
+		c.mu <- struct{}{}
+	} else {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return errWriteTimeout
+		}
+		timer := time.NewTimer(d)
+		select {
+		case c.mu <- struct{}{}:
+			timer.Stop()
+		case <-timer.C:
+			return errWriteTimeout
+		}
+	}
+	defer func() { <-c.mu }()
+
+	if err := c.getWriteErr(); err != nil {
+		return err
+	}
+	c.conn.SetWriteDeadline(deadline)
+	if _, err := c.conn.Write(frame); err != nil {
+		return c.writeFatal(err)
+	}
+	if frameType == CloseMessage {
+		c.writeFatal(ErrCloseSent)
+	}
+	return nil
+}
+
+// WriteControl writes a control message with the given deadline. The
+// allowed message types are CloseMessage, PingMessage and PongMessage.
+// The payload may be at most 125 bytes long.
+//
+// After a close message is sent, further writes return ErrCloseSent.
+func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if !isControl(messageType) {
+		return errBadWriteOpCode
+	}
+	if len(data) > maxControlFramePayloadSize {
+		return errInvalidControlFrame
+	}
+	return c.writeFrame(messageType, true, false, data, deadline)
+}
+
+// NextWriter returns a writer for the next message to send. The
+// message type must be TextMessage or BinaryMessage. The message is
+// complete when the writer is closed.
+//
+// If the writer returned by a previous call to NextWriter has not been
+// closed, NextWriter closes it.
+func (c *Conn) NextWriter(messageType int) (io.WriteCloser, error) {
+	if !isData(messageType) {
+		return nil, errBadWriteOpCode
+	}
+	if c.writer != nil {
+		c.writer.Close()
+		c.writer = nil
+	}
+	if err := c.getWriteErr(); err != nil {
+		return nil, err
+	}
+	mw := &messageWriter{
+		c:         c,
+		frameType: messageType,
+		buf:       c.writeBuf[:0],
+	}
+	if c.compressionNegotiated && c.enableWriteCompression {
+		mw.compressed = true
+		c.writer = newCompressWriter(mw, c.compressionLevel)
+	} else {
+		c.writer = mw
+	}
+	return c.writer, nil
+}
+
+// WriteMessage writes a message of the given type with data as its
+// payload. It is a helper that uses NextWriter for data messages and
+// WriteControl, with the write deadline, for control messages.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if isControl(messageType) {
+		return c.WriteControl(messageType, data, c.writeDeadline)
+	}
+	w, err := c.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// SetWriteDeadline sets the deadline for writing data messages to the
+// connection. After a write has timed out, the connection is broken and
+// all future writes return an error. A zero value for t means writes
+// will not time out.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline = t
+	return nil
+}
+
+// EnableWriteCompression enables or disables compression of
+// subsequent messages written to the connection. It has no effect if
+// compression was not negotiated; if it was, compression is initially
+// enabled.
+func (c *Conn) EnableWriteCompression(enable bool) {
+	c.enableWriteCompression = enable
+}
+
+// SetCompressionLevel sets the flate compression level for subsequent
+// messages written to the connection. See the compress/flate package
+// for a description of compression levels.
+func (c *Conn) SetCompressionLevel(level int) error {
+	if !isValidCompressionLevel(level) {
+		return errors.New("websocket: invalid compression level")
+	}
+	c.compressionLevel = level
+	return nil
+}
+
+// A messageWriter writes a data message as a sequence of frames, each
+// holding at most cap(buf) bytes of payload.
+type messageWriter struct {
+	c          *Conn
+	frameType  int  // opcode of the next frame
+	compressed bool // set RSV1 in the first frame
+	buf        []byte
+	err        error
+}
+
+func (w *messageWriter) flushFrame(final bool) error {
+	compressed := w.compressed && w.frameType != continuationFrame
+	err := w.c.writeFrame(w.frameType, final, compressed, w.buf, w.c.writeDeadline)
+	w.frameType = continuationFrame
+	w.buf = w.buf[:0]
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		// Send a full buffer only once there is more to come, so that
+		// the final frame is never empty unless the message is.
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flushFrame(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		n += m
+		p = p[m:]
+	}
+	return n, nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	err := w.flushFrame(true)
+	w.err = errWriteClosed
+	return err
+}
+
+// Read methods
+
+// failConnection sends a close message with the given code and reason
+// to the peer, as required when the peer violates the protocol, and
+// returns an error describing the violation.
+func (c *Conn) failConnection(code int, reason string) error {
+	c.WriteControl(CloseMessage, FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	return errors.New("websocket: " + reason)
+}
+
+func (c *Conn) protocolError(reason string) error {
+	return c.failConnection(CloseProtocolError, reason)
+}
+
+// readFull fills p from the connection.
+func (c *Conn) readFull(p []byte) error {
+	if _, err := io.ReadFull(c.br, p); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// advanceFrame reads the header of the next frame, skipping whatever
+// remains of the current one. Control frames are read and handled in
+// full. For data frames, advanceFrame records the frame's parameters
+// and leaves its payload to be read by a messageReader.
+// It returns the frame's opcode.
+func (c *Conn) advanceFrame() (int, error) {
+	if c.readRemaining > 0 {
+		if _, err := io.CopyN(ioutil.Discard, c.br, c.readRemaining); err != nil {
+			if err == io.EOF {
+				err = errUnexpectedEOF
+			}
+			return noFrame, err
+		}
+		c.readRemaining = 0
+	}
+
+	var p [8]byte
+	if err := c.readFull(p[:2]); err != nil {
+		return noFrame, err
+	}
+	final := p[0]&finalBit != 0
+	rsv1 := p[0]&rsv1Bit != 0
+	frameType := int(p[0] & 0xf)
+	masked := p[1]&maskBit != 0
+	n := int64(p[1] &^ maskBit)
+
+	if p[0]&(rsv2Bit|rsv3Bit) != 0 {
+		return noFrame, c.protocolError("unexpected reserved bits set")
+	}
+	switch frameType {
+	case CloseMessage, PingMessage, PongMessage:
+		if n > maxControlFramePayloadSize {
+			return noFrame, c.protocolError("control frame length > 125")
+		}
+		if !final {
+			return noFrame, c.protocolError("fragmented control frame")
+		}
+		if rsv1 {
+			return noFrame, c.protocolError("RSV1 set on control frame")
+		}
+	case TextMessage, BinaryMessage:
+		if c.readInMessage {
+			return noFrame, c.protocolError("data frame before final frame of fragmented message")
+		}
+		if rsv1 && !c.compressionNegotiated {
+			return noFrame, c.protocolError("RSV1 set without compression")
+		}
+		c.readInMessage = !final
+		c.readCompressed = rsv1
+		c.readLength = 0
+	case continuationFrame:
+		if !c.readInMessage {
+			return noFrame, c.protocolError("continuation frame without a message")
+		}
+		if rsv1 {
+			return noFrame, c.protocolError("RSV1 set on continuation frame")
+		}
+		c.readInMessage = !final
+	default:
+		return noFrame, c.protocolError("unknown opcode " + strconv.Itoa(frameType))
+	}
+
+	switch n {
+	case 126:
+		if err := c.readFull(p[:2]); err != nil {
+			return noFrame, err
+		}
+		n = int64(binary.BigEndian.Uint16(p[:2]))
+	case 127:
+		if err := c.readFull(p[:8]); err != nil {
+			return noFrame, err
+		}
+		n = int64(binary.BigEndian.Uint64(p[:8]))
+		if n < 0 {
+			return noFrame, c.protocolError("invalid frame length")
+		}
+	}
+
+	if masked != c.isServer {
+		return noFrame, c.protocolError("incorrect mask flag")
+	}
+	c.readMasked = masked
+	if masked {
+		if err := c.readFull(c.readMaskKey[:]); err != nil {
+			return noFrame, err
+		}
+		c.readMaskPos = 0
+	}
+
+	if !isControl(frameType) {
+		c.readRemaining = n
+		c.readFinal = final
+		c.readLength += n
+		if c.readLimit > 0 && c.readLength > c.readLimit {
+			c.WriteControl(CloseMessage, FormatCloseMessage(CloseMessageTooBig, ""), time.Now().Add(writeWait))
+			return noFrame, ErrReadLimit
+		}
+		return frameType, nil
+	}
+
+	payload := make([]byte, n)
+	if err := c.readFull(payload); err != nil {
+		return noFrame, err
+	}
+	if masked {
+		maskBytes(c.readMaskKey, 0, payload)
+	}
+	switch frameType {
+	case PingMessage:
+		if err := c.handlePing(string(payload)); err != nil {
+			return noFrame, err
+		}
+	case PongMessage:
+		if err := c.handlePong(string(payload)); err != nil {
+			return noFrame, err
+		}
+	case CloseMessage:
+		code := CloseNoStatusReceived
+		text := ""
+		if len(payload) == 1 {
+			return noFrame, c.protocolError("invalid close payload")
+		}
+		if len(payload) >= 2 {
+			code = int(binary.BigEndian.Uint16(payload))
+			if !isValidReceivedCloseCode(code) {
+				return noFrame, c.protocolError("invalid close code " + strconv.Itoa(code))
+			}
+			text = string(payload[2:])
+			if !utf8.ValidString(text) {
+				return noFrame, c.failConnection(CloseInvalidFramePayloadData, "invalid UTF-8 in close frame")
+			}
+		}
+		if err := c.handleClose(code, text); err != nil {
+			return noFrame, err
+		}
+		return noFrame, &CloseError{Code: code, Text: text}
+	}
+	return frameType, nil
+}
+
+// NextReader returns the next data message received from the peer.
+// The returned messageType is either TextMessage or BinaryMessage.
+// Control messages received before the data message are passed to
+// their handlers.
+//
+// The reader is valid until the next call to NextReader; NextReader
+// discards whatever remains unread of the previous message.
+//
+// Errors returned by NextReader are permanent: once it returns a
+// non-nil error, all subsequent calls return the same error.
+func (c *Conn) NextReader() (messageType int, r io.Reader, err error) {
+	c.reader = nil
+	for c.readErr == nil {
+		frameType, err := c.advanceFrame()
+		if err != nil {
+			c.readErr = err
+			break
+		}
+		if !isData(frameType) {
+			// A control frame, or a continuation of an abandoned message.
+			continue
+		}
+		mr := &messageReader{c: c}
+		c.reader = mr
+		r = mr
+		if c.readCompressed {
+			r = newDecompressReader(c, r)
+		}
+		if frameType == TextMessage {
+			r = &utf8Reader{c: c, r: r}
+		}
+		return frameType, r, nil
+	}
+	return noFrame, nil, c.readErr
+}
+
+// ReadMessage is a helper that reads the next data message using
+// NextReader.
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	messageType, r, err := c.NextReader()
+	if err != nil {
+		return messageType, nil, err
+	}
+	p, err = ioutil.ReadAll(r)
+	return messageType, p, err
+}
+
+// SetReadDeadline sets the read deadline on the underlying network
+// connection. After a read has timed out, the connection is broken and
+// all future reads return an error. A zero value for t means reads
+// will not time out.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetReadLimit sets the maximum size in bytes of a message read from
+// the peer; zero means no limit. If a message exceeds the limit, the
+// connection sends a close message to the peer and the read methods
+// return ErrReadLimit. For compressed messages the limit applies to
+// both the compressed and the decompressed size.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetPingHandler sets the handler for ping messages received from the
+// peer. The appData argument to h is the ping message payload. The
+// default ping handler sends a pong to the peer.
+//
+// The handler is called from the NextReader, ReadMessage and message
+// reader Read methods. It may return an error to stop reading; that
+// error is then returned by the read method.
+func (c *Conn) SetPingHandler(h func(appData string) error) {
+	if h == nil {
+		h = func(message string) error {
+			err := c.WriteControl(PongMessage, []byte(message), time.Now().Add(writeWait))
+			if err == ErrCloseSent {
+				return nil
+			}
+			if e, ok := err.(net.Error); ok && e.Temporary() {
+				return nil
+			}
+			return err
+		}
+	}
+	c.handlePing = h
+}
+
+// SetPongHandler sets the handler for pong messages received from the
+// peer. The appData argument to h is the pong message payload. The
+// default pong handler does nothing.
+//
+// The handler is called from the same methods as the ping handler.
+func (c *Conn) SetPongHandler(h func(appData string) error) {
+	if h == nil {
+		h = func(string) error { return nil }
+	}
+	c.handlePong = h
+}
+
+// SetCloseHandler sets the handler for close messages received from
+// the peer. The code argument to h is the received close code, or
+// CloseNoStatusReceived if the close message is empty. The default
+// close handler sends a close message with the same code back to the
+// peer, completing the close handshake.
+//
+// After the handler returns, the read methods return a *CloseError
+// describing the received close message.
+func (c *Conn) SetCloseHandler(h func(code int, text string) error) {
+	if h == nil {
+		h = func(code int, text string) error {
+			c.WriteControl(CloseMessage, FormatCloseMessage(code, ""), time.Now().Add(writeWait))
+			return nil
+		}
+	}
+	c.handleClose = h
+}
+
+// A messageReader reads the payload of a data message from the frames
+// that carry it.
+type messageReader struct {
+	c *Conn
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	c := r.c
+	if c.reader != r {
+		return 0, io.EOF
+	}
+	for c.readErr == nil {
+		if c.readRemaining > 0 {
+			if int64(len(p)) > c.readRemaining {
+				p = p[:c.readRemaining]
+			}
+			n, err := c.br.Read(p)
+			c.readRemaining -= int64(n)
+			if c.readMasked {
+				c.readMaskPos = maskBytes(c.readMaskKey, c.readMaskPos, p[:n])
+			}
+			if err == io.EOF {
+				err = errUnexpectedEOF
+			}
+			if err != nil {
+				c.readErr = err
+			}
+			return n, err
+		}
+		if c.readFinal {
+			c.reader = nil
+			return 0, io.EOF
+		}
+		if _, err := c.advanceFrame(); err != nil {
+			c.readErr = err
+		}
+	}
+	return 0, c.readErr
+}
+
+// A utf8Reader reads a text message from r, failing the connection if
+// the text is not valid UTF-8.
+type utf8Reader struct {
+	c *Conn
+	r io.Reader
+	v utf8Validator
+}
+
+func (u *utf8Reader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	if !u.v.valid(p[:n], err == io.EOF) {
+		err = u.c.failConnection(CloseInvalidFramePayloadData, "invalid UTF-8 in text message")
+		u.c.readErr = err
+		return 0, err
+	}
+	return n, err
+}
+
+// A utf8Validator checks that a sequence of byte slices holds valid
+// UTF-8, which may be split at any byte between slices.
+type utf8Validator struct {
+	buf [utf8.UTFMax]byte // incomplete encoding at the end of the last slice
+	n   int
+}
+
+// valid reports whether p continues the text seen so far as valid
+// UTF-8. If final is set, p must end the text.
+func (v *utf8Validator) valid(p []byte, final bool) bool {
+	for len(p) > 0 {
+		if v.n > 0 {
+			// Complete the pending encoding a byte at a time.
+			v.buf[v.n] = p[0]
+			v.n++
+			p = p[1:]
+			if utf8.FullRune(v.buf[:v.n]) {
+				if r, size := utf8.DecodeRune(v.buf[:v.n]); r == utf8.RuneError && size == 1 {
+					return false
+				}
+				v.n = 0
+			}
+			continue
+		}
+		i := 0
+		for i < len(p) {
+			if p[i] < utf8.RuneSelf {
+				i++
+				continue
+			}
+			if !utf8.FullRune(p[i:]) {
+				break
+			}
+			r, size := utf8.DecodeRune(p[i:])
+			if r == utf8.RuneError && size == 1 {
+				return false
+			}
+			i += size
+		}
+		v.n = copy(v.buf[:], p[i:])
+		p = nil
+	}
+	return !final || v.n == 0
+}
+
+// maskBytes applies the masking key to b, starting at position pos of
+// the key, and returns the key position following b.
+func maskBytes(key [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[pos&3]
+		pos++
+	}
+	return pos & 3
+}
@@ -0,0 +1,313 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConnPair returns a client Conn writing to a buffer and a
+// server Conn reading the frames written to it.
+func newTestConnPair(writeBufferSize int) (client, server *Conn) {
+	var buf bytes.Buffer
+	client = newConn(fakeNetConn{Reader: strings.NewReader(""), Writer: &buf}, false, bufio.NewReader(strings.NewReader("")), writeBufferSize)
+	server = newConn(fakeNetConn{Reader: &buf, Writer: ioutil.Discard}, true, bufio.NewReader(&buf), 0)
+	return client, server
+}
+
+func TestWriteFragments(t *testing.T) {
+	for _, isServer := range []bool{false, true} {
+		var out bytes.Buffer
+		c := newConn(fakeNetConn{Reader: strings.NewReader(""), Writer: &out}, isServer, nil, 4)
+		if err := c.WriteMessage(TextMessage, []byte("Hello, world")); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WriteMessage(BinaryMessage, []byte("")); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WriteMessage(PingMessage, []byte("p")); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"text Hell (nofin)", "cont o, w (nofin)", "cont orld", "binary ", "ping p"}
+		if got := describeFrames(t, out.Bytes()); !reflect.DeepEqual(got, want) {
+			t.Errorf("isServer=%v: sent %q; want %q", isServer, got, want)
+		}
+		// Client frames are masked; server frames are not.
+		if masked := out.Bytes()[1]&maskBit != 0; masked == isServer {
+			t.Errorf("isServer=%v: masked = %v", isServer, masked)
+		}
+	}
+}
+
+func TestNextWriter(t *testing.T) {
+	client, server := newTestConnPair(16)
+	w, err := client.NextWriter(BinaryMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		io.WriteString(w, "0123456789")
+	}
+	// Control messages may be sent between the fragments of a message.
+	if err := client.WriteControl(PingMessage, []byte("p"), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// NextWriter closes the previous writer.
+	w2, err := client.NextWriter(TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("x")); err != errWriteClosed {
+		t.Errorf("Write to replaced writer = %v; want %v", err, errWriteClosed)
+	}
+	io.WriteString(w2, "done")
+	w2.Close()
+
+	var pings []string
+	server.SetPingHandler(func(s string) error {
+		pings = append(pings, s)
+		return nil
+	})
+	got := readAll(server)
+	want := []string{"binary " + strings.Repeat("0123456789", 10), "text done", "close 1006 unexpected EOF"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %q; want %q", got, want)
+	}
+	if want := []string{"p"}; !reflect.DeepEqual(pings, want) {
+		t.Errorf("pings = %q; want %q", pings, want)
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	messages := []string{
+		"",
+		"Hello",
+		strings.Repeat("compressible ", 1000),
+		string(encodeFrames(fin(BinaryMessage, long65536))), // masked, so mostly incompressible
+	}
+	for _, level := range []int{flate.HuffmanOnly, flate.NoCompression, flate.BestSpeed, flate.BestCompression} {
+		client, server := newTestConnPair(512)
+		client.compressionNegotiated = true
+		server.compressionNegotiated = true
+		if err := client.SetCompressionLevel(level); err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for _, m := range messages {
+			if err := client.WriteMessage(BinaryMessage, []byte(m)); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, "binary "+m)
+		}
+		client.EnableWriteCompression(false)
+		client.WriteMessage(TextMessage, []byte("plain"))
+		want = append(want, "text plain", "close 1006 unexpected EOF")
+		if got := readAll(server); !reflect.DeepEqual(got, want) {
+			t.Errorf("level %d: messages differ", level)
+		}
+	}
+}
+
+func TestSetCompressionLevel(t *testing.T) {
+	c, _ := newTestConnPair(0)
+	for _, level := range []int{flate.HuffmanOnly - 1, flate.BestCompression + 1} {
+		if err := c.SetCompressionLevel(level); err == nil {
+			t.Errorf("SetCompressionLevel(%d) succeeded", level)
+		}
+	}
+}
+
+func TestTruncWriter(t *testing.T) {
+	const data = "0123456789abcdef"
+	for _, n := range []int{1, 2, 3, 4, 5, 7, 16} {
+		var b bytes.Buffer
+		w := &truncWriter{w: &b}
+		for p := data; len(p) > 0; {
+			m := n
+			if m > len(p) {
+				m = len(p)
+			}
+			if k, err := w.Write([]byte(p[:m])); k != m || err != nil {
+				t.Fatalf("Write returned %d, %v; want %d, nil", k, err, m)
+			}
+			p = p[m:]
+		}
+		if got, want := b.String(), data[:len(data)-4]; got != want {
+			t.Errorf("writes of %d: got %q; want %q", n, got, want)
+		}
+		if got, want := string(w.tail[:w.n]), data[len(data)-4:]; got != want {
+			t.Errorf("writes of %d: held %q; want %q", n, got, want)
+		}
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	client, server := newTestConnPair(8)
+	server.SetReadLimit(16)
+	client.WriteMessage(BinaryMessage, make([]byte, 16))
+	client.WriteMessage(BinaryMessage, make([]byte, 17))
+
+	if _, p, err := server.ReadMessage(); err != nil || len(p) != 16 {
+		t.Fatalf("ReadMessage = %d bytes, %v; want 16 bytes", len(p), err)
+	}
+	if _, _, err := server.ReadMessage(); err != ErrReadLimit {
+		t.Fatalf("ReadMessage error = %v; want %v", err, ErrReadLimit)
+	}
+}
+
+func TestReadLimitCompressed(t *testing.T) {
+	const limit = 64 << 10
+	var in, out bytes.Buffer
+	client := newConn(fakeNetConn{Reader: strings.NewReader(""), Writer: &in}, false, nil, 0)
+	server := newConn(fakeNetConn{Reader: &in, Writer: &out}, true, bufio.NewReader(&in), 0)
+	client.compressionNegotiated = true
+	server.compressionNegotiated = true
+	server.SetReadLimit(limit)
+	client.WriteMessage(BinaryMessage, make([]byte, limit))
+	// A highly compressible message whose compressed size is within
+	// the limit, but which decompresses to far more.
+	client.WriteMessage(BinaryMessage, make([]byte, 16<<20))
+	if in.Len() > limit {
+		t.Fatalf("compressed messages are %d bytes; want at most %d", in.Len(), limit)
+	}
+
+	if _, p, err := server.ReadMessage(); err != nil || len(p) != limit {
+		t.Fatalf("ReadMessage = %d bytes, %v; want %d bytes", len(p), err, limit)
+	}
+	if _, p, err := server.ReadMessage(); err != ErrReadLimit || len(p) > limit {
+		t.Fatalf("ReadMessage = %d bytes, %v; want at most %d bytes, %v", len(p), err, limit, ErrReadLimit)
+	}
+	if _, _, err := server.NextReader(); err != ErrReadLimit {
+		t.Errorf("NextReader error = %v; want %v", err, ErrReadLimit)
+	}
+	want := []string{fmt.Sprintf("close %d", CloseMessageTooBig)}
+	if got := describeFrames(t, out.Bytes()); !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %q; want %q", got, want)
+	}
+}
+
+func TestNextReaderDiscardsUnread(t *testing.T) {
+	client, server := newTestConnPair(4)
+	client.WriteMessage(TextMessage, []byte("first message"))
+	client.WriteMessage(TextMessage, []byte("second"))
+
+	_, r, err := server.NextReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 3)
+	if _, err := io.ReadFull(r, p); err != nil || string(p) != "fir" {
+		t.Fatalf("read %q, %v; want %q", p, err, "fir")
+	}
+	_, r2, err := server.NextReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(p); n != 0 || err != io.EOF {
+		t.Errorf("Read from previous reader = %d, %v; want 0, EOF", n, err)
+	}
+	if b, err := ioutil.ReadAll(r2); err != nil || string(b) != "second" {
+		t.Errorf("second message = %q, %v; want %q", b, err, "second")
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	c, _ := newTestConnPair(0)
+	if err := c.WriteControl(CloseMessage, FormatCloseMessage(CloseNormalClosure, ""), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(TextMessage, []byte("late")); err != ErrCloseSent {
+		t.Errorf("WriteMessage after close = %v; want %v", err, ErrCloseSent)
+	}
+	if err := c.WriteControl(PingMessage, nil, time.Time{}); err != ErrCloseSent {
+		t.Errorf("WriteControl after close = %v; want %v", err, ErrCloseSent)
+	}
+}
+
+func TestWriteControlErrors(t *testing.T) {
+	c, _ := newTestConnPair(0)
+	if err := c.WriteControl(TextMessage, nil, time.Time{}); err != errBadWriteOpCode {
+		t.Errorf("WriteControl(TextMessage) = %v; want %v", err, errBadWriteOpCode)
+	}
+	if err := c.WriteControl(PingMessage, make([]byte, 126), time.Time{}); err != errInvalidControlFrame {
+		t.Errorf("WriteControl with 126 bytes = %v; want %v", err, errInvalidControlFrame)
+	}
+	if _, err := c.NextWriter(PongMessage); err != errBadWriteOpCode {
+		t.Errorf("NextWriter(PongMessage) = %v; want %v", err, errBadWriteOpCode)
+	}
+}
+
+func TestUTF8Validator(t *testing.T) {
+	tests := []struct {
+		s     string
+		valid bool
+	}{
+		{"", true},
+		{"Hello", true},
+		{kosme, true},
+		{"\U0010ffffࠀ\u0080", true},
+		{invalidUTF8, false},
+		{"\xff", false},
+		{"\xc0\xaf", false},         // overlong encoding
+		{"\xf4\x90\x80\x80", false}, // beyond U+10FFFF
+		{kosme[:len(kosme)-1], false},
+	}
+	for _, tt := range tests {
+		// Feed the text in pieces of every size.
+		for n := 1; n <= len(tt.s) || n == 1; n++ {
+			var v utf8Validator
+			valid := true
+			s := tt.s
+			for valid && len(s) > n {
+				valid = v.valid([]byte(s[:n]), false)
+				s = s[n:]
+			}
+			if valid {
+				valid = v.valid([]byte(s), true)
+			}
+			if valid != tt.valid {
+				t.Errorf("%q in pieces of %d: valid = %v; want %v", tt.s, n, valid, tt.valid)
+			}
+		}
+	}
+}
+
+func TestFormatCloseMessage(t *testing.T) {
+	if got, want := FormatCloseMessage(CloseGoingAway, "bye"), []byte("\x03\xe9bye"); !bytes.Equal(got, want) {
+		t.Errorf("FormatCloseMessage(CloseGoingAway, %q) = %q; want %q", "bye", got, want)
+	}
+	if got := FormatCloseMessage(CloseNoStatusReceived, ""); got == nil || len(got) != 0 {
+		t.Errorf("FormatCloseMessage(CloseNoStatusReceived, \"\") = %#v; want empty", got)
+	}
+}
+
+func TestCloseError(t *testing.T) {
+	err := &CloseError{Code: CloseGoingAway, Text: "bye"}
+	if got, want := err.Error(), "websocket: close 1001 (going away): bye"; got != want {
+		t.Errorf("Error() = %q; want %q", got, want)
+	}
+	if !IsCloseError(err, CloseNormalClosure, CloseGoingAway) {
+		t.Errorf("IsCloseError(%v, 1000, 1001) = false", err)
+	}
+	if IsCloseError(err, CloseNormalClosure) || IsCloseError(io.EOF, CloseGoingAway) {
+		t.Errorf("IsCloseError matched wrong code or error")
+	}
+}
+
+func TestComputeAcceptKey(t *testing.T) {
+	// Example from RFC 6455, section 1.3.
+	if got, want := computeAcceptKey("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("computeAcceptKey = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// An Upgrader upgrades HTTP server connections to the WebSocket
+// protocol. The zero value is usable: it uses default buffer sizes,
+// no handshake timeout, no subprotocols and no compression, and
+// accepts only requests from the same origin.
+type Upgrader struct {
+	// HandshakeTimeout, if non-zero, limits the time spent writing
+	// the handshake response.
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize specify the sizes in bytes
+	// of the read buffer and of the payload of the frames in which
+	// messages are sent; messages larger than WriteBufferSize are
+	// sent as multiple frames. If zero, a size of 4096 is used.
+	ReadBufferSize, WriteBufferSize int
+
+	// Subprotocols lists the server's supported subprotocols in
+	// order of preference. If it is set, Upgrade selects the first
+	// of them requested by the client. If it is nil, Upgrade uses the
+	// Sec-WebSocket-Protocol header in its responseHeader argument,
+	// if any.
+	Subprotocols []string
+
+	// CheckOrigin reports whether the request's Origin header is
+	// acceptable. If CheckOrigin is nil, Upgrade accepts requests with
+	// no Origin header and requests whose Origin host is the request's
+	// Host.
+	CheckOrigin func(r *http.Request) bool
+
+	// EnableCompression specifies whether the server should accept
+	// a client's offer of per-message compression.
+	EnableCompression bool
+}
+
+// Upgrade upgrades the HTTP server connection to the WebSocket
+// protocol, using responseHeader for any additional headers in the
+// handshake response, such as cookies. Upgrade takes the connection
+// over from the ResponseWriter, which must support hijacking, directly
+// or through an Unwrap method, as described by
+// http.NewResponseController.
+//
+// If the handshake fails, Upgrade replies to the client with an HTTP
+// error response and returns a non-nil error.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Conn, error) {
+	if r.Method != "GET" {
+		return u.fail(w, http.StatusMethodNotAllowed, "request method is not GET")
+	}
+	if !IsWebSocketUpgrade(r) {
+		return u.fail(w, http.StatusBadRequest, "request is not a WebSocket upgrade")
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-Websocket-Version", "13")
+		return u.fail(w, http.StatusUpgradeRequired, "unsupported version")
+	}
+	if _, ok := responseHeader["Sec-Websocket-Extensions"]; ok {
+		return u.fail(w, http.StatusInternalServerError, "application specific Sec-WebSocket-Extensions headers are unsupported")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(r) {
+		return u.fail(w, http.StatusForbidden, "request origin not allowed")
+	}
+	challengeKey := r.Header.Get("Sec-Websocket-Key")
+	if key, err := base64.StdEncoding.DecodeString(challengeKey); err != nil || len(key) != 16 {
+		return u.fail(w, http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+
+	subprotocol := u.selectSubprotocol(r, responseHeader)
+	compress := false
+	if u.EnableCompression {
+		for _, ext := range parseExtensions(r.Header) {
+			if ext.name == deflateExtension && acceptDeflateOffer(ext) {
+				compress = true
+				break
+			}
+		}
+	}
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return u.fail(w, http.StatusInternalServerError, "connection does not support hijacking: "+err.Error())
+	}
+	if brw.Reader.Buffered() > 0 {
+		netConn.Close()
+		return nil, errors.New("websocket: client sent data before handshake is complete")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	buf.WriteString(computeAcceptKey(challengeKey))
+	buf.WriteString("\r\n")
+	if subprotocol != "" {
+		buf.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	if compress {
+		buf.WriteString("Sec-WebSocket-Extensions: " + deflateParams + "\r\n")
+	}
+	responseHeader.WriteSubset(&buf, map[string]bool{"Sec-Websocket-Protocol": true})
+	buf.WriteString("\r\n")
+
+	// Clear the deadlines set by the server; the handshake
+	// timeout, if any, bounds the write of the response.
+	netConn.SetDeadline(time.Time{})
+	if u.HandshakeTimeout > 0 {
+		netConn.SetWriteDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+	if _, err := netConn.Write(buf.Bytes()); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if u.HandshakeTimeout > 0 {
+		netConn.SetWriteDeadline(time.Time{})
+	}
+
+	readBufferSize := u.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	c := newConn(netConn, true, bufio.NewReaderSize(netConn, readBufferSize), u.WriteBufferSize)
+	c.subprotocol = subprotocol
+	c.compressionNegotiated = compress
+	return c, nil
+}
+
+// fail replies to the request with an HTTP error and returns an error
+// describing the failed handshake.
+func (u *Upgrader) fail(w http.ResponseWriter, status int, reason string) (*Conn, error) {
+	http.Error(w, http.StatusText(status), status)
+	return nil, errors.New("websocket: " + reason)
+}
+
+func (u *Upgrader) selectSubprotocol(r *http.Request, responseHeader http.Header) string {
+	if u.Subprotocols == nil {
+		return responseHeader.Get("Sec-Websocket-Protocol")
+	}
+	requested := headerList(r.Header, "Sec-Websocket-Protocol")
+	for _, p := range u.Subprotocols {
+		for _, q := range requested {
+			if p == q {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// checkSameOrigin reports whether r has no Origin header or an Origin
+// whose host is r.Host.
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header["Origin"]
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin[0])
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,287 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package websocket implements the WebSocket protocol defined in RFC 6455,
+// including the permessage-deflate compression extension defined in
+// RFC 7692.
+//
+// A server upgrades an HTTP request to a WebSocket connection with an
+// Upgrader:
+//
+//	var upgrader websocket.Upgrader
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		conn, err := upgrader.Upgrade(w, r, nil)
+//		if err != nil {
+//			log.Println(err)
+//			return
+//		}
+//		defer conn.Close()
+//		... Use conn to send and receive messages.
+//	}
+//
+// A client connects to a server with a Dialer:
+//
+//	conn, _, err := websocket.DefaultDialer.Dial("ws://example.com/socket", nil)
+//
+// Messages
+//
+// The WebSocket protocol distinguishes between text messages, which hold
+// UTF-8 encoded text, and binary messages. Conn.WriteMessage and
+// Conn.ReadMessage send and receive whole messages; Conn.NextWriter and
+// Conn.NextReader stream a message using an io.WriteCloser and an
+// io.Reader. Messages longer than the connection's write buffer are sent
+// as a sequence of frames (fragments); received fragments are reassembled
+// transparently. The text of received text messages is checked to be
+// valid UTF-8.
+//
+// Control Messages
+//
+// The protocol defines three control messages: close, ping and pong.
+// Conn.WriteControl sends a control message. Received control messages
+// are passed to the handlers set with Conn.SetCloseHandler,
+// Conn.SetPingHandler and Conn.SetPongHandler from within the read
+// methods, so an application must read from the connection to process
+// them. By default, a ping is answered with a pong and a close message
+// is echoed back to the peer, completing the close handshake.
+//
+// To close the connection cleanly, an application sends a close message
+// with WriteControl, waits for the peer's close message, which the read
+// methods return as a *CloseError, and then calls Conn.Close.
+//
+// Concurrency
+//
+// Connections support one concurrent reader and one concurrent writer.
+// The Close and WriteControl methods may be called concurrently with all
+// other methods.
+//
+// Compression
+//
+// Per-message compression is negotiated when both the Upgrader and the
+// Dialer set EnableCompression. Compression is done without context
+// takeover, so each message is compressed independently.
+package websocket
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"internal/x/net/http/httpguts"
+)
+
+// The message types are defined in RFC 6455, section 11.8.
+const (
+	// TextMessage denotes a text data message. The text message payload is
+	// interpreted as UTF-8 encoded text data.
+	TextMessage = 1
+
+	// BinaryMessage denotes a binary data message.
+	BinaryMessage = 2
+
+	// CloseMessage denotes a close control message. The optional message
+	// payload contains a numeric code and text. Use the FormatCloseMessage
+	// function to format a close message payload.
+	CloseMessage = 8
+
+	// PingMessage denotes a ping control message. The optional message
+	// payload is UTF-8 encoded text.
+	PingMessage = 9
+
+	// PongMessage denotes a pong control message. The optional message
+	// payload is UTF-8 encoded text.
+	PongMessage = 10
+)
+
+// Close codes defined in RFC 6455, section 11.7.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+	CloseServiceRestart          = 1012
+	CloseTryAgainLater           = 1013
+	CloseTLSHandshake            = 1015
+)
+
+var (
+	// ErrBadHandshake is returned by Dialer.Dial when the server's
+	// response to the opening handshake is invalid.
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+
+	// ErrCloseSent is returned when the application writes a message to
+	// the connection after sending a close message.
+	ErrCloseSent = errors.New("websocket: close sent")
+
+	// ErrReadLimit is returned when reading a message that is larger
+	// than the read limit set for the connection.
+	ErrReadLimit = errors.New("websocket: read limit exceeded")
+)
+
+// CloseError is returned by the read methods of a Conn when the peer
+// closes the connection. If the connection is closed without a close
+// message, Code is CloseAbnormalClosure.
+type CloseError struct {
+	// Code is defined in RFC 6455, section 11.7.
+	Code int
+
+	// Text is the optional text payload.
+	Text string
+}
+
+var closeCodeText = map[int]string{
+	CloseNormalClosure:           "normal",
+	CloseGoingAway:               "going away",
+	CloseProtocolError:           "protocol error",
+	CloseUnsupportedData:         "unsupported data",
+	CloseNoStatusReceived:        "no status",
+	CloseAbnormalClosure:         "abnormal closure",
+	CloseInvalidFramePayloadData: "invalid payload data",
+	ClosePolicyViolation:         "policy violation",
+	CloseMessageTooBig:           "message too big",
+	CloseMandatoryExtension:      "mandatory extension missing",
+	CloseInternalServerErr:       "internal server error",
+	CloseServiceRestart:          "service restart",
+	CloseTryAgainLater:           "try again later",
+	CloseTLSHandshake:            "TLS handshake error",
+}
+
+func (e *CloseError) Error() string {
+	s := "websocket: close " + strconv.Itoa(e.Code)
+	if t, ok := closeCodeText[e.Code]; ok {
+		s += " (" + t + ")"
+	}
+	if e.Text != "" {
+		s += ": " + e.Text
+	}
+	return s
+}
+
+// IsCloseError reports whether err is a *CloseError with one of the
+// specified codes.
+func IsCloseError(err error, codes ...int) bool {
+	if e, ok := err.(*CloseError); ok {
+		for _, code := range codes {
+			if e.Code == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FormatCloseMessage formats code and text as the payload of a close
+// message. An empty payload is returned for CloseNoStatusReceived.
+func FormatCloseMessage(code int, text string) []byte {
+	if code == CloseNoStatusReceived {
+		// Return empty message because it's illegal to send
+		// CloseNoStatusReceived. Return non-nil value in case application
+		// checks for nil.
+		return []byte{}
+	}
+	buf := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(buf, uint16(code))
+	copy(buf[2:], text)
+	return buf
+}
+
+// isValidReceivedCloseCode reports whether code may appear in a close
+// message received from the peer. See RFC 6455, section 7.4.
+func isValidReceivedCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003,
+		code >= 1007 && code <= 1014,
+		code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// IsWebSocketUpgrade reports whether r asks to upgrade the connection
+// to the WebSocket protocol.
+func IsWebSocketUpgrade(r *http.Request) bool {
+	return httpguts.HeaderValuesContainsToken(r.Header["Connection"], "upgrade") &&
+		httpguts.HeaderValuesContainsToken(r.Header["Upgrade"], "websocket")
+}
+
+var keyGUID = []byte("258EAFA5-E914-47DA-95CA-C5AB0DC85B11")
+
+// computeAcceptKey returns the Sec-WebSocket-Accept value for a
+// handshake with the given Sec-WebSocket-Key.
+func computeAcceptKey(challengeKey string) string {
+	h := sha1.New()
+	h.Write([]byte(challengeKey))
+	h.Write(keyGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// generateChallengeKey returns a new random Sec-WebSocket-Key value.
+func generateChallengeKey() (string, error) {
+	p := make([]byte, 16)
+	if _, err := rand.Read(p); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(p), nil
+}
+
+// headerList returns the comma-separated elements of the values of the
+// header key in h, with surrounding whitespace removed.
+func headerList(h http.Header, key string) []string {
+	var list []string
+	for _, v := range h[key] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+// An extension is an element of a Sec-WebSocket-Extensions header:
+// an extension name and its parameters.
+type extension struct {
+	name   string
+	params map[string]string
+}
+
+// parseExtensions parses the Sec-WebSocket-Extensions header in h.
+// Parameter values may be quoted; quoted values containing commas or
+// semicolons are not supported, as no registered extension uses them.
+func parseExtensions(h http.Header) []extension {
+	var exts []extension
+	for _, s := range headerList(h, "Sec-Websocket-Extensions") {
+		parts := strings.Split(s, ";")
+		ext := extension{
+			name:   strings.TrimSpace(parts[0]),
+			params: make(map[string]string),
+		}
+		for _, p := range parts[1:] {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			k, v := p, ""
+			if i := strings.Index(p, "="); i >= 0 {
+				k = strings.TrimSpace(p[:i])
+				v = strings.Trim(strings.TrimSpace(p[i+1:]), `"`)
+			}
+			ext.params[strings.ToLower(k)] = v
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
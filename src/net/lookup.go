@@ -146,6 +146,25 @@ type Resolver struct {
 	// If nil, the default dialer is used.
 	Dial func(ctx context.Context, network, address string) (Conn, error)
 
+	// Exchange optionally specifies a function used by Go's
+	// built-in DNS resolver to send a query to a DNS service and
+	// return its response, in place of UDP and TCP connections.
+	// Both messages are in the DNS wire format of RFC 1035
+	// section 4, without the length prefix used over TCP. The
+	// server parameter is the address of the name server the
+	// resolver would otherwise use, which Exchange may ignore in
+	// favor of its own, as the DNS-over-TLS and DNS-over-HTTPS
+	// transports of package net/dnstransport do.
+	// Setting Exchange implies PreferGo, so that lookups don't
+	// bypass it through the C library.
+	// If nil, Dial is used.
+	Exchange func(ctx context.Context, server string, query []byte) ([]byte, error)
+
+	// Cache optionally specifies a cache for the responses of
+	// name servers to Go's built-in DNS resolver.
+	// If nil, every query is sent to a name server.
+	Cache *DNSCache
+
 	// lookupGroup merges LookupIPAddr calls together for lookups for the same
 	// host. The lookupGroup key is the LookupIPAddr.host argument.
 	// The return values are ([]IPAddr, error).
@@ -155,7 +174,7 @@ type Resolver struct {
 	// TODO(bradfitz): Timeout time.Duration?
 }
 
-func (r *Resolver) preferGo() bool     { return r != nil && r.PreferGo }
+func (r *Resolver) preferGo() bool     { return r != nil && (r.PreferGo || r.Exchange != nil) }
 func (r *Resolver) strictErrors() bool { return r != nil && r.StrictErrors }
 
 func (r *Resolver) getLookupGroup() *singleflight.Group {
@@ -468,3 +487,23 @@ func LookupAddr(addr string) (names []string, err error) {
 func (r *Resolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
 	return r.lookupAddr(ctx, addr)
 }
+
+// LookupRecords looks up the DNS records of type typ for name, and
+// returns them with the rest of the DNS response.
+//
+// Unlike the other lookups, LookupRecords does not report a name that
+// does not exist, or has no records of type typ, as an error: the
+// response then has an RCode of 3 (NXDOMAIN), or no answers of type
+// typ, and its authority section tells how long that answer may be
+// cached.
+//
+// The queries of LookupRecords, unlike those of the other lookups,
+// set the AD bit (RFC 6840 section 5.7), so that the server reports in
+// the response whether it validated the answer with DNSSEC.
+//
+// LookupRecords always uses Go's DNS resolver, with the name servers
+// and search list of /etc/resolv.conf, and is only supported on Unix
+// systems.
+func (r *Resolver) LookupRecords(ctx context.Context, name string, typ DNSType) (*DNSResponse, error) {
+	return r.lookupRecords(ctx, name, typ)
+}
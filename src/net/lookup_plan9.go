@@ -10,6 +10,7 @@ import (
 	"internal/bytealg"
 	"io"
 	"os"
+	"syscall"
 )
 
 func query(ctx context.Context, filename, query string, bufSize int) (addrs []string, err error) {
@@ -331,6 +332,10 @@ func (*Resolver) lookupAddr(ctx context.Context, addr string) (name []string, er
 	return
 }
 
+func (*Resolver) lookupRecords(ctx context.Context, name string, typ DNSType) (*DNSResponse, error) {
+	return nil, syscall.EPLAN9
+}
+
 // concurrentThreadsLimit returns the number of threads we permit to
 // run concurrently doing DNS lookups.
 func concurrentThreadsLimit() int {
@@ -328,6 +328,10 @@ func (r *Resolver) lookupAddr(ctx context.Context, addr string) ([]string, error
 	return r.goLookupPTR(ctx, addr)
 }
 
+func (r *Resolver) lookupRecords(ctx context.Context, name string, typ DNSType) (*DNSResponse, error) {
+	return r.goLookupRecords(ctx, name, typ)
+}
+
 // concurrentThreadsLimit returns the number of threads we permit to
 // run concurrently doing DNS lookups via cgo. A DNS lookup may use a
 // file descriptor so we limit this to less than the number of
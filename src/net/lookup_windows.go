@@ -326,6 +326,10 @@ func (*Resolver) lookupAddr(ctx context.Context, addr string) ([]string, error)
 	return ptrs, nil
 }
 
+func (*Resolver) lookupRecords(ctx context.Context, name string, typ DNSType) (*DNSResponse, error) {
+	return nil, syscall.EWINDOWS
+}
+
 const dnsSectionMask = 0x0003
 
 // returns only results applicable to name and resolves CNAME entries
@@ -60,12 +60,11 @@ func Getgroups() ([]int, error) {
 // The program terminates immediately; deferred functions are not run.
 // 退出使当前程序以给定的状态码退出。通常，0 表示成功，非 0 表示错误。程序立即终止； 延迟功能无法运行。
 func Exit(code int) {
-	if code == 0 {
-		// Give race detector a chance to fail the program.
-		// Racy programs do not have the right to finish successfully.
-		runtime_beforeExit()
-	}
+	// Run exit hooks, such as writing coverage data.
+	// If code is zero, also give race detector a chance to fail the program.
+	// Racy programs do not have the right to finish successfully.
+	runtime_beforeExit(code)
 	syscall.Exit(code)
 }
 
-func runtime_beforeExit() // implemented in runtime
+func runtime_beforeExit(exitCode int) // implemented in runtime
@@ -0,0 +1,174 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package coverage writes coverage data for programs built
+// with 'go build -cover'.
+//
+// In such programs, the go command instruments the selected packages
+// and registers their counters with this package. When the program
+// exits, either by returning from main.main or by calling os.Exit,
+// the counters are written to a new file in the directory named by the
+// GOCOVERDIR environment variable. Each run adds its own file, named
+// covcounters.<pid>.<time>, so the same directory can collect the data
+// from many runs. The files use the profile format written by
+// 'go test -coverprofile'. See 'go tool covdata' for merging them
+// and reporting on the result.
+//
+// Long-running programs, such as servers that are never stopped
+// cleanly, can use WriteCountersDir to write the counters at other
+// times, and ClearCounters to reset them.
+package coverage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A file holds the counters registered for one source file.
+type file struct {
+	name     string
+	counters []uint32
+	pos      []uint32 // 3 per block: start line, end line, packed columns
+	numStmts []uint16
+}
+
+var (
+	mu    sync.Mutex
+	mode  string
+	files = make(map[string]*file)
+)
+
+// runtime_setExitHook is implemented in package runtime.
+func runtime_setExitHook(f func())
+
+func init() {
+	runtime_setExitHook(writeOnExit)
+}
+
+// RegisterFile registers the coverage counters for a source file.
+// It is called by initialization code that the go command adds to
+// each package built with 'go build -cover', and is not meant
+// to be called directly.
+func RegisterFile(coverMode, fileName string, counter []uint32, pos []uint32, numStmts []uint16) {
+	if 3*len(counter) != len(pos) || len(counter) != len(numStmts) {
+		panic("coverage: mismatched sizes")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if mode == "" {
+		mode = coverMode
+	} else if mode != coverMode {
+		panic("coverage: mismatched modes " + mode + " and " + coverMode)
+	}
+	if files[fileName] != nil {
+		// Already registered.
+		return
+	}
+	files[fileName] = &file{fileName, counter, pos, numStmts}
+}
+
+// writeOnExit writes the counters to $GOCOVERDIR when the program exits.
+func writeOnExit() {
+	mu.Lock()
+	instrumented := mode != ""
+	mu.Unlock()
+	if !instrumented {
+		return
+	}
+	dir := os.Getenv("GOCOVERDIR")
+	if dir == "" {
+		fmt.Fprintf(os.Stderr, "warning: GOCOVERDIR not set, no coverage data emitted\n")
+		return
+	}
+	if err := WriteCountersDir(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "error: coverage data emit failed: %v\n", err)
+	}
+}
+
+// errNotInstrumented is returned when the program does not
+// contain any packages built for coverage.
+var errNotInstrumented = errors.New("program not built with -cover")
+
+// WriteCountersDir writes the current values of the coverage counters
+// to a new file in the directory dir, as is done when the program exits.
+func WriteCountersDir(dir string) error {
+	name := filepath.Join(dir, fmt.Sprintf("covcounters.%d.%d", os.Getpid(), time.Now().UnixNano()))
+
+	// Write to a temporary name first, so that a tool reading
+	// the directory concurrently never sees a partial file.
+	tmp := filepath.Join(filepath.Dir(name), ".tmp."+filepath.Base(name))
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	err = WriteCounters(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// WriteCounters writes the current values of the coverage counters
+// to w, in the profile format written by 'go test -coverprofile'.
+func WriteCounters(w io.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if mode == "" {
+		return errNotInstrumented
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %s\n", mode)
+	for _, name := range names {
+		f := files[name]
+		for i := range f.counters {
+			// Load atomically, for -covermode=atomic
+			// in a program that is still running.
+			count := atomic.LoadUint32(&f.counters[i])
+			fmt.Fprintf(bw, "%s:%d.%d,%d.%d %d %d\n", name,
+				f.pos[3*i+0], uint16(f.pos[3*i+2]),
+				f.pos[3*i+1], uint16(f.pos[3*i+2]>>16),
+				f.numStmts[i],
+				count)
+		}
+	}
+	return bw.Flush()
+}
+
+// ClearCounters resets all coverage counters to zero.
+// Counters updated concurrently may not be cleared reliably
+// unless the program was built with -covermode=atomic.
+func ClearCounters() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if mode == "" {
+		return errNotInstrumented
+	}
+	for _, f := range files {
+		for i := range f.counters {
+			atomic.StoreUint32(&f.counters[i], 0)
+		}
+	}
+	return nil
+}
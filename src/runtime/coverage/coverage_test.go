@@ -0,0 +1,93 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package coverage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// registerTestFile registers counters for a fake source file
+// and returns them, along with a function to unregister them.
+func registerTestFile(t *testing.T) ([]uint32, func()) {
+	counters := []uint32{3, 0}
+	pos := []uint32{
+		3, 5, 10 | 2<<16,
+		7, 9, 4 | 1<<16,
+	}
+	numStmts := []uint16{2, 1}
+	RegisterFile("count", "example.com/m/a.go", counters, pos, numStmts)
+	return counters, func() {
+		mu.Lock()
+		mode = ""
+		files = make(map[string]*file)
+		mu.Unlock()
+	}
+}
+
+func TestWriteCounters(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCounters(&buf); err != errNotInstrumented {
+		t.Fatalf("WriteCounters without registered files: err = %v, want %v", err, errNotInstrumented)
+	}
+
+	_, cleanup := registerTestFile(t)
+	defer cleanup()
+
+	buf.Reset()
+	if err := WriteCounters(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "mode: count\n" +
+		"example.com/m/a.go:3.10,5.2 2 3\n" +
+		"example.com/m/a.go:7.4,9.1 1 0\n"
+	if buf.String() != want {
+		t.Errorf("WriteCounters wrote:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	if err := ClearCounters(); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := WriteCounters(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), " 3\n") {
+		t.Errorf("counters not cleared:\n%s", buf.String())
+	}
+}
+
+func TestWriteCountersDir(t *testing.T) {
+	_, cleanup := registerTestFile(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 2; i++ {
+		if err := WriteCountersDir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("found files %v, want 2 counter files", names)
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(filepath.Base(name), "covcounters.") {
+			t.Errorf("unexpected file %s", name)
+		}
+	}
+}
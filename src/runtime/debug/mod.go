@@ -8,14 +8,14 @@ import (
 	"strings"
 )
 
-// set using cmd/go/internal/modload.ModInfoProg
-var modinfo string
+// exported from runtime
+func modinfo() string
 
 // ReadBuildInfo returns the build information embedded
 // in the running binary. The information is available only
 // in binaries built with module support.
 func ReadBuildInfo() (info *BuildInfo, ok bool) {
-	return readBuildInfo(modinfo)
+	return readBuildInfo(modinfo())
 }
 
 // BuildInfo represents the build information read from
@@ -13,6 +13,9 @@ import (
 
 var buildVersion = sys.TheVersion
 
+// set using cmd/go/internal/modload.ModInfoProg
+var modinfo string
+
 // Goroutine scheduler
 // The scheduler's job is to distribute ready-to-run goroutines over worker threads.\
 // 调度程序的工作是在工作线程上分发准备运行的goroutine。
@@ -256,6 +259,7 @@ func main() {
 	// 执行用户 main 包中的 main 函数，处理为非间接调用，因为链接器在设定运行时不知道 main 包的地址
 	fn = main_main // make an indirect call, as the linker doesn't know the address of the main package when laying down the runtime
 	fn()
+	runExitHook()
 
 	// race 相关
 	if raceenabled {
@@ -292,15 +296,36 @@ func main() {
 	}
 }
 
-// os_beforeExit is called from os.Exit(0).
-// 在 os.Exit(0) 调用os_beforeExit。 src/os/proc.go:Exit
+// os_beforeExit is called from os.Exit.
+// 在 os.Exit 调用os_beforeExit。 src/os/proc.go:Exit
 //go:linkname os_beforeExit os.runtime_beforeExit
-func os_beforeExit() {
-	if raceenabled {
+func os_beforeExit(exitCode int) {
+	runExitHook()
+	if exitCode == 0 && raceenabled {
 		racefini()
 	}
 }
 
+// exitHook, if not nil, is run once when the program exits,
+// either by returning from main.main or by calling os.Exit.
+// It is set by package runtime/coverage in programs built
+// with 'go build -cover', to write out coverage counters.
+var exitHook func()
+
+//go:linkname coverage_setExitHook runtime/coverage.runtime_setExitHook
+func coverage_setExitHook(f func()) {
+	exitHook = f
+}
+
+// runExitHook runs exitHook, if set. A hook that calls os.Exit
+// or panics does not run again.
+func runExitHook() {
+	if f := exitHook; f != nil {
+		exitHook = nil
+		f()
+	}
+}
+
 // start forcegc helper goroutine
 // 启动 forcegc helper goroutine
 func init() {
@@ -698,6 +723,12 @@ func schedinit() {
 		// 该条件永远不会被触发，此处只是为了防止 buildVersion 被编译器优化移除掉。
 		buildVersion = "unknown"
 	}
+	if len(modinfo) == 1 {
+		// Condition should never trigger. This code just serves
+		// to ensure runtime·modinfo is kept in the resulting binary.
+		// 该条件永远不会被触发，此处只是为了防止 modinfo 被编译器优化移除掉。
+		modinfo = ""
+	}
 }
 
 //  dump(转储) G 的状态，就是打印 G 的状态